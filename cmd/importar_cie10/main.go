@@ -0,0 +1,161 @@
+// Comando importar_cie10 carga el catálogo de diagnósticos CIE-10 desde un archivo CSV.
+//
+// El archivo debe tener dos columnas, código y descripción en español, con o sin fila de
+// encabezado. Los códigos se aceptan con o sin punto (A00.0 o A000). Los códigos existentes se
+// actualizan, por lo que el comando puede ejecutarse de nuevo con una versión más reciente.
+//
+//	go run ./cmd/importar_cie10 -archivo cie10.csv [-separador ";"] [-desactivar-faltantes]
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/joho/godotenv"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// tamanoLote es el número de códigos que se envían a la base de datos en cada lote
+const tamanoLote = 1000
+
+func main() {
+	archivo := flag.String("archivo", "", "ruta del archivo CSV (código, descripción)")
+	separador := flag.String("separador", ",", "separador de columnas del CSV")
+	desactivarFaltantes := flag.Bool("desactivar-faltantes", false,
+		"marca como inactivos los códigos del catálogo que no vienen en el archivo")
+	flag.Parse()
+
+	if *archivo == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sep, _ := utf8.DecodeRuneInString(*separador)
+	if sep == utf8.RuneError {
+		log.Fatalf("Separador inválido: %q", *separador)
+	}
+
+	codigos, omitidos, err := leerCatalogo(*archivo, sep)
+	if err != nil {
+		log.Fatalf("Error al leer %s: %v", *archivo, err)
+	}
+	if len(codigos) == 0 {
+		log.Fatalf("El archivo %s no contiene códigos válidos", *archivo)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("Advertencia: No se pudo cargar el archivo .env")
+	}
+	database.ConnectDB()
+	defer database.CloseDB()
+
+	desactivados, err := importarCatalogo(context.Background(), codigos, *desactivarFaltantes)
+	if err != nil {
+		log.Fatalf("Error al importar el catálogo: %v", err)
+	}
+
+	log.Printf("Catálogo CIE-10 importado: %d códigos, %d filas omitidas, %d códigos desactivados",
+		len(codigos), omitidos, desactivados)
+}
+
+// leerCatalogo lee el CSV y devuelve los códigos válidos sin repetir (gana la última fila) y el
+// número de filas omitidas por no tener un código CIE-10 o una descripción
+func leerCatalogo(ruta string, separador rune) ([]models.CodigoCIE10, int, error) {
+	f, err := os.Open(ruta)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	lector := csv.NewReader(f)
+	lector.Comma = separador
+	lector.FieldsPerRecord = -1
+	lector.LazyQuotes = true
+
+	var codigos []models.CodigoCIE10
+	posicion := make(map[string]int)
+	omitidos := 0
+	for fila := 1; ; fila++ {
+		registro, err := lector.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(registro) < 2 {
+			omitidos++
+			continue
+		}
+
+		codigo, valido := models.NormalizarCodigoCIE10(strings.TrimPrefix(registro[0], "\ufeff"))
+		descripcion := strings.TrimSpace(registro[1])
+		if !valido || descripcion == "" {
+			// La primera fila puede ser el encabezado
+			if fila > 1 {
+				log.Printf("Fila %d omitida: código %q inválido o sin descripción", fila, registro[0])
+				omitidos++
+			}
+			continue
+		}
+
+		if i, ok := posicion[codigo]; ok {
+			codigos[i].Descripcion = descripcion
+			continue
+		}
+		posicion[codigo] = len(codigos)
+		codigos = append(codigos, models.CodigoCIE10{Codigo: codigo, Descripcion: descripcion, Activo: true})
+	}
+	return codigos, omitidos, nil
+}
+
+// importarCatalogo inserta o actualiza los códigos en una sola transacción. Si desactivar es
+// true, los códigos que no vienen en el archivo quedan inactivos; no se eliminan porque
+// consultas anteriores pueden hacer referencia a ellos.
+func importarCatalogo(ctx context.Context, codigos []models.CodigoCIE10, desactivar bool) (int64, error) {
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	for inicio := 0; inicio < len(codigos); inicio += tamanoLote {
+		fin := min(inicio+tamanoLote, len(codigos))
+
+		lote := &pgx.Batch{}
+		for _, c := range codigos[inicio:fin] {
+			lote.Queue(
+				`INSERT INTO CatalogoCIE10 (codigo, descripcion, descripcion_busqueda, activo, updated_at)
+				 VALUES ($1, $2, $3, true, LOCALTIMESTAMP)
+				 ON CONFLICT (codigo) DO UPDATE SET descripcion = EXCLUDED.descripcion,
+				 descripcion_busqueda = EXCLUDED.descripcion_busqueda, activo = true, updated_at = LOCALTIMESTAMP`,
+				c.Codigo, c.Descripcion, models.NormalizarBusquedaCIE10(c.Descripcion))
+		}
+		if err := tx.SendBatch(ctx, lote).Close(); err != nil {
+			return 0, err
+		}
+		log.Printf("%d de %d códigos importados", fin, len(codigos))
+	}
+
+	var desactivados int64
+	if desactivar {
+		// LOCALTIMESTAMP es el mismo durante toda la transacción: los códigos que no se tocaron
+		// conservan una fecha anterior
+		result, err := tx.Exec(ctx,
+			`UPDATE CatalogoCIE10 SET activo = false
+			 WHERE activo AND updated_at < LOCALTIMESTAMP`)
+		if err != nil {
+			return 0, err
+		}
+		desactivados = result.RowsAffected()
+	}
+
+	return desactivados, tx.Commit(ctx)
+}
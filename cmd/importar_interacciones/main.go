@@ -0,0 +1,254 @@
+// Comando importar_interacciones carga la tabla de interacciones entre medicamentos desde un
+// archivo CSV.
+//
+// Columnas: nombre genérico A, nombre genérico B, severidad (leve, moderada o grave) y
+// descripción. La fila de encabezado es opcional. Ambos nombres deben existir en el catálogo de
+// medicamentos (cmd/importar_medicamentos); las filas con nombres desconocidos se omiten. Un par
+// repetido actualiza la interacción existente, por lo que el comando puede ejecutarse de nuevo
+// con una versión más reciente.
+//
+//	go run ./cmd/importar_interacciones -archivo interacciones.csv [-separador ";"] [-desactivar-faltantes]
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/joho/godotenv"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// tamanoLote es el número de interacciones que se envían a la base de datos en cada lote
+const tamanoLote = 500
+
+// encabezados son los valores de la primera columna que identifican la fila de encabezado
+var encabezados = map[string]bool{"medicamento_a": true, "medicamento a": true, "medicamento": true, "nombre_a": true}
+
+// interaccion es una fila válida del archivo con el par ya ordenado
+type interaccion struct {
+	models.InteraccionMedicamento
+	nombreA string
+	nombreB string
+}
+
+func main() {
+	archivo := flag.String("archivo", "", "ruta del archivo CSV (medicamento A, medicamento B, severidad, descripción)")
+	separador := flag.String("separador", ",", "separador de columnas del CSV")
+	desactivarFaltantes := flag.Bool("desactivar-faltantes", false,
+		"marca como inactivas las interacciones que no vienen en el archivo")
+	flag.Parse()
+
+	if *archivo == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sep, _ := utf8.DecodeRuneInString(*separador)
+	if sep == utf8.RuneError {
+		log.Fatalf("Separador inválido: %q", *separador)
+	}
+
+	interacciones, omitidos, err := leerInteracciones(*archivo, sep)
+	if err != nil {
+		log.Fatalf("Error al leer %s: %v", *archivo, err)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("Advertencia: No se pudo cargar el archivo .env")
+	}
+	database.ConnectDB()
+	defer database.CloseDB()
+
+	ctx := context.Background()
+	interacciones, desconocidos, err := filtrarPorCatalogo(ctx, interacciones)
+	if err != nil {
+		log.Fatalf("Error al leer el catálogo de medicamentos: %v", err)
+	}
+	omitidos += desconocidos
+	if len(interacciones) == 0 {
+		log.Fatalf("El archivo %s no contiene interacciones válidas", *archivo)
+	}
+
+	desactivadas, err := importarInteracciones(ctx, interacciones, *desactivarFaltantes)
+	if err != nil {
+		log.Fatalf("Error al importar las interacciones: %v", err)
+	}
+
+	log.Printf("Interacciones importadas: %d interacciones, %d filas omitidas, %d desactivadas",
+		len(interacciones), omitidos, desactivadas)
+}
+
+// leerInteracciones lee el CSV y devuelve las interacciones sin repetir y el número de filas omitidas
+func leerInteracciones(ruta string, separador rune) ([]interaccion, int, error) {
+	f, err := os.Open(ruta)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	lector := csv.NewReader(f)
+	lector.Comma = separador
+	lector.FieldsPerRecord = -1
+	lector.LazyQuotes = true
+
+	var interacciones []interaccion
+	posicion := make(map[string]int)
+	omitidos := 0
+	for fila := 1; ; fila++ {
+		registro, err := lector.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := range registro {
+			registro[i] = strings.TrimSpace(strings.TrimPrefix(registro[i], "\ufeff"))
+		}
+		if fila == 1 && encabezados[models.NormalizarBusqueda(registro[0])] {
+			continue
+		}
+		if len(registro) < 3 {
+			log.Printf("Fila %d omitida: se esperan medicamento A, medicamento B, severidad y descripción", fila)
+			omitidos++
+			continue
+		}
+
+		i := interaccion{
+			InteraccionMedicamento: models.InteraccionMedicamento{
+				MedicamentoA: registro[0],
+				MedicamentoB: registro[1],
+				Severidad:    models.NormalizarBusqueda(registro[2]),
+			},
+			nombreA: models.TerminoBusqueda(registro[0]),
+			nombreB: models.TerminoBusqueda(registro[1]),
+		}
+		if len(registro) > 3 {
+			i.Descripcion = registro[3]
+		}
+		if i.nombreA == "" || i.nombreB == "" || i.nombreA == i.nombreB {
+			log.Printf("Fila %d omitida: se esperan dos medicamentos distintos", fila)
+			omitidos++
+			continue
+		}
+		if !models.SeveridadInteraccionValida(i.Severidad) {
+			log.Printf("Fila %d omitida: severidad %q inválida (leve, moderada o grave)", fila, registro[2])
+			omitidos++
+			continue
+		}
+
+		// El par se guarda ordenado para que A-B y B-A sean la misma interacción
+		if i.nombreB < i.nombreA {
+			i.nombreA, i.nombreB = i.nombreB, i.nombreA
+			i.MedicamentoA, i.MedicamentoB = i.MedicamentoB, i.MedicamentoA
+		}
+
+		llave := i.nombreA + "|" + i.nombreB
+		if p, ok := posicion[llave]; ok {
+			interacciones[p] = i
+			continue
+		}
+		posicion[llave] = len(interacciones)
+		interacciones = append(interacciones, i)
+	}
+	return interacciones, omitidos, nil
+}
+
+// filtrarPorCatalogo descarta las interacciones con nombres genéricos que no están en el catálogo
+// y asigna a cada lado el medicamento del catálogo de ese genérico (el de menor id)
+func filtrarPorCatalogo(ctx context.Context, interacciones []interaccion) ([]interaccion, int, error) {
+	rows, err := database.GetDB().Query(ctx,
+		"SELECT nombre_generico, MIN(id_medicamento) FROM Medicamento GROUP BY nombre_generico")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	catalogo := make(map[string]int)
+	for rows.Next() {
+		var nombre string
+		var idMedicamento int
+		if err := rows.Scan(&nombre, &idMedicamento); err != nil {
+			return nil, 0, err
+		}
+		termino := models.TerminoBusqueda(nombre)
+		if actual, ok := catalogo[termino]; !ok || idMedicamento < actual {
+			catalogo[termino] = idMedicamento
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	validas := interacciones[:0]
+	desconocidos := 0
+	for _, i := range interacciones {
+		idA, okA := catalogo[i.nombreA]
+		idB, okB := catalogo[i.nombreB]
+		if !okA || !okB {
+			log.Printf("Interacción %s - %s omitida: no está en el catálogo de medicamentos",
+				i.MedicamentoA, i.MedicamentoB)
+			desconocidos++
+			continue
+		}
+		i.IDMedicamentoA, i.IDMedicamentoB = &idA, &idB
+		validas = append(validas, i)
+	}
+	return validas, desconocidos, nil
+}
+
+// importarInteracciones inserta o actualiza las interacciones en una sola transacción. Si
+// desactivar es true, las que no vienen en el archivo quedan inactivas; no se eliminan porque
+// las omisiones registradas en recetas anteriores hacen referencia a ellas.
+func importarInteracciones(ctx context.Context, interacciones []interaccion, desactivar bool) (int64, error) {
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	for inicio := 0; inicio < len(interacciones); inicio += tamanoLote {
+		fin := min(inicio+tamanoLote, len(interacciones))
+
+		lote := &pgx.Batch{}
+		for _, i := range interacciones[inicio:fin] {
+			lote.Queue(
+				`INSERT INTO InteraccionMedicamento (id_medicamento_a, id_medicamento_b, medicamento_a, medicamento_b,
+				 nombre_a, nombre_b, severidad, descripcion, activa, updated_at)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, true, LOCALTIMESTAMP)
+				 ON CONFLICT (nombre_a, nombre_b) DO UPDATE SET
+				 id_medicamento_a = EXCLUDED.id_medicamento_a, id_medicamento_b = EXCLUDED.id_medicamento_b,
+				 medicamento_a = EXCLUDED.medicamento_a, medicamento_b = EXCLUDED.medicamento_b,
+				 severidad = EXCLUDED.severidad, descripcion = EXCLUDED.descripcion,
+				 activa = true, updated_at = LOCALTIMESTAMP`,
+				i.IDMedicamentoA, i.IDMedicamentoB, i.MedicamentoA, i.MedicamentoB, i.nombreA, i.nombreB,
+				i.Severidad, i.Descripcion)
+		}
+		if err := tx.SendBatch(ctx, lote).Close(); err != nil {
+			return 0, err
+		}
+		log.Printf("%d de %d interacciones importadas", fin, len(interacciones))
+	}
+
+	var desactivadas int64
+	if desactivar {
+		// LOCALTIMESTAMP es el mismo durante toda la transacción: las interacciones que no se
+		// tocaron conservan una fecha anterior
+		result, err := tx.Exec(ctx,
+			`UPDATE InteraccionMedicamento SET activa = false
+			 WHERE activa AND updated_at < LOCALTIMESTAMP`)
+		if err != nil {
+			return 0, err
+		}
+		desactivadas = result.RowsAffected()
+	}
+
+	return desactivadas, tx.Commit(ctx)
+}
@@ -0,0 +1,175 @@
+// Comando importar_medicamentos carga el catálogo de medicamentos desde un archivo CSV.
+//
+// Columnas: nombre genérico, presentación, concentración, vía y, opcionalmente, la clave del
+// cuadro básico. La fila de encabezado es opcional. Un medicamento se identifica por su nombre
+// genérico, presentación, concentración y vía; si ya existe se actualiza su clave y se reactiva,
+// por lo que el comando puede ejecutarse de nuevo con una versión más reciente.
+//
+//	go run ./cmd/importar_medicamentos -archivo medicamentos.csv [-separador ";"] [-desactivar-faltantes]
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/joho/godotenv"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// tamanoLote es el número de medicamentos que se envían a la base de datos en cada lote
+const tamanoLote = 500
+
+// encabezados son los valores de la primera columna que identifican la fila de encabezado
+var encabezados = map[string]bool{"nombre_generico": true, "nombre generico": true, "nombre": true, "medicamento": true}
+
+func main() {
+	archivo := flag.String("archivo", "", "ruta del archivo CSV (nombre genérico, presentación, concentración, vía, clave)")
+	separador := flag.String("separador", ",", "separador de columnas del CSV")
+	desactivarFaltantes := flag.Bool("desactivar-faltantes", false,
+		"marca como inactivos los medicamentos del catálogo que no vienen en el archivo")
+	flag.Parse()
+
+	if *archivo == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sep, _ := utf8.DecodeRuneInString(*separador)
+	if sep == utf8.RuneError {
+		log.Fatalf("Separador inválido: %q", *separador)
+	}
+
+	medicamentos, omitidos, err := leerCatalogo(*archivo, sep)
+	if err != nil {
+		log.Fatalf("Error al leer %s: %v", *archivo, err)
+	}
+	if len(medicamentos) == 0 {
+		log.Fatalf("El archivo %s no contiene medicamentos válidos", *archivo)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("Advertencia: No se pudo cargar el archivo .env")
+	}
+	database.ConnectDB()
+	defer database.CloseDB()
+
+	desactivados, err := importarCatalogo(context.Background(), medicamentos, *desactivarFaltantes)
+	if err != nil {
+		log.Fatalf("Error al importar el catálogo: %v", err)
+	}
+
+	log.Printf("Catálogo de medicamentos importado: %d medicamentos, %d filas omitidas, %d desactivados",
+		len(medicamentos), omitidos, desactivados)
+}
+
+// leerCatalogo lee el CSV y devuelve los medicamentos sin repetir y el número de filas omitidas
+func leerCatalogo(ruta string, separador rune) ([]models.Medicamento, int, error) {
+	f, err := os.Open(ruta)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	lector := csv.NewReader(f)
+	lector.Comma = separador
+	lector.FieldsPerRecord = -1
+	lector.LazyQuotes = true
+
+	var medicamentos []models.Medicamento
+	posicion := make(map[string]int)
+	omitidos := 0
+	for fila := 1; ; fila++ {
+		registro, err := lector.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := range registro {
+			registro[i] = strings.TrimSpace(strings.TrimPrefix(registro[i], "\ufeff"))
+		}
+		if fila == 1 && encabezados[models.NormalizarBusqueda(registro[0])] {
+			continue
+		}
+		if len(registro) < 4 || registro[0] == "" {
+			log.Printf("Fila %d omitida: se esperan nombre genérico, presentación, concentración y vía", fila)
+			omitidos++
+			continue
+		}
+
+		m := models.Medicamento{
+			NombreGenerico: registro[0],
+			Presentacion:   registro[1],
+			Concentracion:  registro[2],
+			Via:            registro[3],
+			Activo:         true,
+		}
+		if len(registro) > 4 {
+			m.Clave = registro[4]
+		}
+
+		llave := strings.Join([]string{models.NormalizarBusqueda(m.NombreGenerico), m.Presentacion, m.Concentracion, m.Via}, "|")
+		if i, ok := posicion[llave]; ok {
+			medicamentos[i] = m
+			continue
+		}
+		posicion[llave] = len(medicamentos)
+		medicamentos = append(medicamentos, m)
+	}
+	return medicamentos, omitidos, nil
+}
+
+// importarCatalogo inserta o actualiza los medicamentos en una sola transacción. Si desactivar
+// es true, los que no vienen en el archivo quedan inactivos; no se eliminan porque recetas
+// anteriores pueden hacer referencia a ellos.
+func importarCatalogo(ctx context.Context, medicamentos []models.Medicamento, desactivar bool) (int64, error) {
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	for inicio := 0; inicio < len(medicamentos); inicio += tamanoLote {
+		fin := min(inicio+tamanoLote, len(medicamentos))
+
+		lote := &pgx.Batch{}
+		for _, m := range medicamentos[inicio:fin] {
+			lote.Queue(
+				`INSERT INTO Medicamento (clave, nombre_generico, nombre_busqueda, presentacion, concentracion, via,
+				 activo, updated_at)
+				 VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, true, LOCALTIMESTAMP)
+				 ON CONFLICT (nombre_busqueda, presentacion, concentracion, via) DO UPDATE SET
+				 clave = COALESCE(EXCLUDED.clave, Medicamento.clave), nombre_generico = EXCLUDED.nombre_generico,
+				 activo = true, updated_at = LOCALTIMESTAMP`,
+				m.Clave, m.NombreGenerico, models.NormalizarBusqueda(m.NombreGenerico), m.Presentacion,
+				m.Concentracion, m.Via)
+		}
+		if err := tx.SendBatch(ctx, lote).Close(); err != nil {
+			return 0, err
+		}
+		log.Printf("%d de %d medicamentos importados", fin, len(medicamentos))
+	}
+
+	var desactivados int64
+	if desactivar {
+		// LOCALTIMESTAMP es el mismo durante toda la transacción: los medicamentos que no se
+		// tocaron conservan una fecha anterior
+		result, err := tx.Exec(ctx,
+			`UPDATE Medicamento SET activo = false
+			 WHERE activo AND updated_at < LOCALTIMESTAMP`)
+		if err != nil {
+			return 0, err
+		}
+		desactivados = result.RowsAffected()
+	}
+
+	return desactivados, tx.Commit(ctx)
+}
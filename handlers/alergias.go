@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// columnasAlergia son las columnas que lee escanearAlergia
+const columnasAlergia = `id_alergia, id_paciente, sustancia, tipo, reaccion, severidad, activa,
+	verificada_por, verificada_at, id_usuario, created_at, updated_at`
+
+// escanearAlergia lee una fila con columnasAlergia
+func escanearAlergia(row pgx.Row, a *models.Alergia) error {
+	return row.Scan(&a.IDAlergia, &a.IDPaciente, &a.Sustancia, &a.Tipo, &a.Reaccion, &a.Severidad, &a.Activa,
+		&a.VerificadaPor, &a.VerificadaAt, &a.IDUsuario, &a.CreatedAt, &a.UpdatedAt)
+}
+
+// alergiasCoincidentes devuelve las alergias activas del paciente que coinciden con alguno de los medicamentos
+func alergiasCoincidentes(ctx context.Context, q consultorFilas, idPaciente int, medicamentos []string) ([]models.Alergia, error) {
+	rows, err := q.Query(ctx,
+		"SELECT "+columnasAlergia+" FROM Alergia WHERE id_paciente = $1 AND activa ORDER BY id_alergia", idPaciente)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var coincidencias []models.Alergia
+	for rows.Next() {
+		var a models.Alergia
+		if err := escanearAlergia(rows, &a); err != nil {
+			return nil, err
+		}
+		for _, medicamento := range medicamentos {
+			if models.CoincideAlergia(medicamento, a.Sustancia) {
+				coincidencias = append(coincidencias, a)
+				break
+			}
+		}
+	}
+	return coincidencias, rows.Err()
+}
+
+// revisarAlergiasReceta compara los medicamentos con las alergias del paciente. Si hay
+// coincidencias, la receta solo procede cuando el médico las omite explícitamente con un motivo;
+// en ese caso devuelve las alergias que deben registrarse como omitidas.
+func revisarAlergiasReceta(ctx context.Context, idPaciente int, medicamentos []string, omision models.OmisionAlergiasRequest) ([]models.Alergia, string, error) {
+	alergias, err := alergiasCoincidentes(ctx, database.GetDB(), idPaciente, medicamentos)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(alergias) == 0 {
+		return nil, "", nil
+	}
+
+	if !omision.OmitirAlergias {
+		return alergias, "", errAlergiasReceta
+	}
+	motivo := strings.TrimSpace(omision.MotivoOmision)
+	if motivo == "" {
+		return nil, "", &errorConsulta{400, "El motivo para omitir las alergias es requerido"}
+	}
+	return alergias, motivo, nil
+}
+
+// errAlergiasReceta indica que el medicamento coincide con alergias y no se pidió omitirlas
+var errAlergiasReceta = errors.New("el medicamento coincide con alergias registradas del paciente")
+
+// responderAlergiasReceta responde a errAlergiasReceta con las alergias encontradas
+func responderAlergiasReceta(c *fiber.Ctx, alergias []models.Alergia) error {
+	return c.Status(409).JSON(fiber.Map{
+		"error":            "El medicamento coincide con alergias registradas del paciente",
+		"alergias":         alergias,
+		"requiere_omision": true,
+	})
+}
+
+// registrarOmisionesAlergia guarda la justificación del médico para cada alergia omitida. Las
+// omisiones solo se agregan: al actualizar la receta se conservan las anteriores con su autor.
+func registrarOmisionesAlergia(ctx context.Context, tx pgx.Tx, idReceta, idMedico int, alergias []models.Alergia, motivo string) error {
+	for _, a := range alergias {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO RecetaAlergiaOmision (id_receta, id_alergia, id_medico, motivo)
+			 VALUES ($1, $2, $3, $4)`, idReceta, a.IDAlergia, idMedico, motivo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validarAlergiaRequest normaliza y valida los datos de una alergia
+func validarAlergiaRequest(req *models.AlergiaRequest) error {
+	req.Sustancia = strings.TrimSpace(req.Sustancia)
+	req.Reaccion = strings.TrimSpace(req.Reaccion)
+	if req.Tipo == "" {
+		req.Tipo = models.TipoAlergiaMedicamento
+	}
+	if req.Severidad == "" {
+		req.Severidad = models.SeveridadDesconocida
+	}
+	if req.Sustancia == "" {
+		return errors.New("La sustancia es requerida")
+	}
+	if !models.TipoAlergiaValido(req.Tipo) {
+		return errors.New("Tipo de alergia inválido (medicamento, alimento, ambiental u otro)")
+	}
+	if !models.SeveridadAlergiaValida(req.Severidad) {
+		return errors.New("Severidad inválida (leve, moderada, grave o desconocida)")
+	}
+	return nil
+}
+
+// esAlergiaDuplicada indica si el error proviene del índice de una alergia activa por sustancia
+func esAlergiaDuplicada(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// ObtenerAlergiasPaciente obtiene las alergias de un paciente (?incluir_inactivas=true)
+func ObtenerAlergiasPaciente(c *fiber.Ctx) error {
+	idPaciente, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "alergias_read", idPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes acceso a las alergias de este paciente",
+		})
+	}
+
+	query := "SELECT " + columnasAlergia + " FROM Alergia WHERE id_paciente = $1"
+	if !c.QueryBool("incluir_inactivas") {
+		query += " AND activa"
+	}
+	query += " ORDER BY activa DESC, sustancia"
+
+	rows, err := database.GetDB().Query(context.Background(), query, idPaciente)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener las alergias",
+		})
+	}
+	defer rows.Close()
+
+	var alergias []models.Alergia
+	for rows.Next() {
+		var a models.Alergia
+		if err := escanearAlergia(rows, &a); err != nil {
+			continue
+		}
+		alergias = append(alergias, a)
+	}
+
+	return c.JSON(fiber.Map{
+		"id_paciente": idPaciente,
+		"alergias":    alergias,
+		"total":       len(alergias),
+	})
+}
+
+// RegistrarAlergia registra una alergia del paciente. Las registradas por quien puede verificar
+// alergias (alergias_verificar) quedan verificadas por él; las demás esperan la verificación.
+func RegistrarAlergia(c *fiber.Ctx) error {
+	idPaciente, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	ctx := context.Background()
+	usuario := actorDe(c)
+	userID := usuario.id
+	if permitido, err := puedeVerPaciente(ctx, usuario, "alergias_create", idPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes registrar alergias de este paciente",
+		})
+	}
+
+	var req models.AlergiaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+	if err := validarAlergiaRequest(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Verificar que el paciente existe y tiene rol de paciente
+	var existePaciente bool
+	err = database.GetDB().QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol
+		 WHERE u.id_usuario = $1 AND r.nombre = 'paciente')`, idPaciente).Scan(&existePaciente)
+	if err != nil || !existePaciente {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Paciente no encontrado",
+		})
+	}
+
+	var verificadaPor interface{}
+	if verifica, err := puedeVerPaciente(ctx, usuario, "alergias_verificar", idPaciente); err == nil && verifica {
+		verificadaPor = userID
+	}
+
+	var alergia models.Alergia
+	err = escanearAlergia(database.GetDB().QueryRow(ctx,
+		`INSERT INTO Alergia (id_paciente, sustancia, sustancia_busqueda, tipo, reaccion, severidad,
+		 verificada_por, verificada_at, id_usuario)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7::int,
+		         CASE WHEN $7::int IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END, $8)
+		 RETURNING `+columnasAlergia,
+		idPaciente, req.Sustancia, models.NormalizarBusqueda(req.Sustancia), req.Tipo, req.Reaccion,
+		req.Severidad, verificadaPor, userID), &alergia)
+	if err != nil {
+		if esAlergiaDuplicada(err) {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "El paciente ya tiene registrada una alergia activa a esta sustancia",
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al registrar la alergia",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje": "Alergia registrada exitosamente",
+		"alergia": alergia,
+	})
+}
+
+// ActualizarAlergia actualiza una alergia. Si quien cambia la sustancia o la severidad puede
+// verificar alergias, la alergia queda verificada por él; si no, vuelve a requerir verificación.
+func ActualizarAlergia(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	usuario := actorDe(c)
+	userID := usuario.id
+
+	var req models.AlergiaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+	if err := validarAlergiaRequest(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx := context.Background()
+	var actual models.Alergia
+	err = escanearAlergia(database.GetDB().QueryRow(ctx,
+		"SELECT "+columnasAlergia+" FROM Alergia WHERE id_alergia = $1", id), &actual)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Alergia no encontrada",
+		})
+	}
+	if permitido, err := puedeVerPaciente(ctx, usuario, "alergias_update", actual.IDPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes actualizar las alergias de este paciente",
+		})
+	}
+
+	activa := actual.Activa
+	if req.Activa != nil {
+		activa = *req.Activa
+	}
+
+	// Los cambios clínicos invalidan la verificación anterior
+	verificadaPor := actual.VerificadaPor
+	verificadaAt := actual.VerificadaAt
+	cambioClinico := models.NormalizarBusqueda(req.Sustancia) != models.NormalizarBusqueda(actual.Sustancia) ||
+		req.Severidad != actual.Severidad
+	if cambioClinico {
+		verificadaPor, verificadaAt = nil, nil
+		if verifica, err := puedeVerPaciente(ctx, usuario, "alergias_verificar", actual.IDPaciente); err == nil && verifica {
+			verificadaPor = &userID
+		}
+	}
+
+	var alergia models.Alergia
+	err = escanearAlergia(database.GetDB().QueryRow(ctx,
+		`UPDATE Alergia SET sustancia = $1, sustancia_busqueda = $2, tipo = $3, reaccion = NULLIF($4, ''),
+		 severidad = $5, activa = $6, verificada_por = $7::int,
+		 verificada_at = CASE WHEN $7::int IS NULL THEN NULL ELSE COALESCE($8::timestamp, CURRENT_TIMESTAMP) END,
+		 updated_at = CURRENT_TIMESTAMP
+		 WHERE id_alergia = $9
+		 RETURNING `+columnasAlergia,
+		req.Sustancia, models.NormalizarBusqueda(req.Sustancia), req.Tipo, req.Reaccion, req.Severidad, activa,
+		verificadaPor, verificadaAt, id), &alergia)
+	if err != nil {
+		if esAlergiaDuplicada(err) {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "El paciente ya tiene registrada una alergia activa a esta sustancia",
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la alergia",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Alergia actualizada exitosamente",
+		"alergia": alergia,
+	})
+}
+
+// VerificarAlergia registra que un médico confirmó la alergia
+func VerificarAlergia(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	ctx := context.Background()
+	usuario := actorDe(c)
+	userID := usuario.id
+
+	var idPaciente int
+	err = database.GetDB().QueryRow(ctx, "SELECT id_paciente FROM Alergia WHERE id_alergia = $1", id).Scan(&idPaciente)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Alergia no encontrada",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al verificar la alergia",
+		})
+	}
+	if permitido, err := puedeVerPaciente(ctx, usuario, "alergias_verificar", idPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes verificar las alergias de este paciente",
+		})
+	}
+
+	var alergia models.Alergia
+	err = escanearAlergia(database.GetDB().QueryRow(ctx,
+		`UPDATE Alergia SET verificada_por = $1, verificada_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		 WHERE id_alergia = $2
+		 RETURNING `+columnasAlergia, userID, id), &alergia)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Alergia no encontrada",
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al verificar la alergia",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Alergia verificada exitosamente",
+		"alergia": alergia,
+	})
+}
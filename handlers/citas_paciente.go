@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// Valores por defecto de los límites de la reserva en línea
+const (
+	maxCitasFuturasPorDefecto        = 3
+	horasMinCancelacionPorDefecto    = 24
+	variableMaxCitasFuturas          = "CITAS_MAX_FUTURAS"
+	variableHorasMinCancelacionCitas = "CITAS_HORAS_MIN_CANCELACION"
+)
+
+// enteroDeEntorno lee un entero no negativo de una variable de entorno o devuelve el valor por defecto
+func enteroDeEntorno(nombre string, porDefecto int) int {
+	valor, err := strconv.Atoi(os.Getenv(nombre))
+	if err != nil || valor < 0 {
+		return porDefecto
+	}
+	return valor
+}
+
+// maxCitasFuturasPaciente es el número máximo de citas activas a futuro que puede tener un paciente
+func maxCitasFuturasPaciente() int {
+	return enteroDeEntorno(variableMaxCitasFuturas, maxCitasFuturasPorDefecto)
+}
+
+// anticipacionCancelacionPaciente es el tiempo mínimo antes de la cita con el que un paciente puede cancelarla
+func anticipacionCancelacionPaciente() time.Duration {
+	return time.Duration(enteroDeEntorno(variableHorasMinCancelacionCitas, horasMinCancelacionPorDefecto)) * time.Hour
+}
+
+// validarLimitesReservaPaciente aplica los límites por paciente antes de reservar el horario:
+// el horario debe tener fecha futura, el paciente no puede exceder el máximo de citas futuras
+// ni tener otra cita activa con el mismo médico ese día
+func validarLimitesReservaPaciente(ctx context.Context, tx pgx.Tx, idPaciente, idHorario int) error {
+	if err := bloquearReservasPaciente(ctx, tx, idPaciente); err != nil {
+		return err
+	}
+
+	var idMedico int
+	var fechaHora *time.Time
+	err := tx.QueryRow(ctx,
+		"SELECT id_medico, fecha_hora FROM Horario WHERE id_horario = $1", idHorario).Scan(&idMedico, &fechaHora)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &errorConsulta{404, "Horario no encontrado"}
+	}
+	if err != nil {
+		return err
+	}
+
+	ahora := horaDePared(time.Now())
+	if fechaHora == nil {
+		return &errorConsulta{400, "El horario no tiene fecha asignada y no puede reservarse en línea"}
+	}
+	if !fechaHora.After(ahora) {
+		return &errorConsulta{400, "No se pueden reservar horarios en el pasado"}
+	}
+
+	return validarCitasPaciente(ctx, tx, idPaciente, idMedico, *fechaHora, 0)
+}
+
+// bloquearReservasPaciente bloquea al paciente para serializar sus reservas y reprogramaciones
+// concurrentes
+func bloquearReservasPaciente(ctx context.Context, tx pgx.Tx, idPaciente int) error {
+	_, err := tx.Exec(ctx, "SELECT 1 FROM Usuario WHERE id_usuario = $1 FOR UPDATE", idPaciente)
+	return err
+}
+
+// validarCitasPaciente revisa que una cita con el médico en fechaHora no exceda el máximo de
+// citas futuras del paciente ni coincida con otra de sus citas activas con el mismo médico ese
+// día. idConsultaExcluida es la consulta que se reprograma (0 en una reserva nueva) y no cuenta.
+// El paciente debe estar bloqueado con bloquearReservasPaciente.
+func validarCitasPaciente(ctx context.Context, tx pgx.Tx, idPaciente, idMedico int, fechaHora time.Time, idConsultaExcluida int) error {
+	var citasFuturas int
+	var mismoMedicoMismoDia bool
+	err := tx.QueryRow(ctx,
+		`SELECT COUNT(*),
+		        COALESCE(BOOL_OR(c.id_medico = $3 AND COALESCE(h.fecha_hora, c.hora)::date = $4::date), false)
+		 FROM Consulta c
+		 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		 WHERE c.id_paciente = $1
+		 AND c.id_consulta <> $5
+		 AND c.estado IN ('programada', 'confirmada')
+		 AND COALESCE(h.fecha_hora, c.hora) > $2`,
+		idPaciente, horaDePared(time.Now()).Format(formatoTimestamp), idMedico, fechaHora.Format(formatoTimestamp),
+		idConsultaExcluida).Scan(&citasFuturas, &mismoMedicoMismoDia)
+	if err != nil {
+		return err
+	}
+
+	if limite := maxCitasFuturasPaciente(); citasFuturas >= limite {
+		return &errorConsulta{409, fmt.Sprintf("No puedes tener más de %d citas programadas", limite)}
+	}
+	if mismoMedicoMismoDia {
+		return &errorConsulta{409, "Ya tienes una cita con este médico ese día"}
+	}
+	return nil
+}
+
+// ReservarCitaPaciente permite a un paciente reservar para sí mismo un horario disponible
+func ReservarCitaPaciente(c *fiber.Ctx) error {
+	var req models.ReservaCitaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	if req.IDHorario == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El horario es requerido",
+		})
+	}
+
+	userID := c.Locals("user_id").(int)
+
+	// La cita siempre es para el paciente autenticado
+	consulta := models.Consulta{
+		Tipo:       req.Tipo,
+		IDPaciente: userID,
+		IDHorario:  req.IDHorario,
+		Motivo:     req.Motivo,
+	}
+	if consulta.Tipo == "" {
+		consulta.Tipo = "general"
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al reservar la cita")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := validarLimitesReservaPaciente(ctx, tx, userID, req.IDHorario); err != nil {
+		return responderErrorConsulta(c, err, "Error al reservar la cita")
+	}
+
+	if err := crearConsultaEnHorario(ctx, tx, &consulta, userID); err != nil {
+		return responderErrorConsulta(c, err, "Error al reservar la cita")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al reservar la cita")
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje":     "Cita reservada exitosamente",
+		"id_consulta": consulta.ID,
+		"id_horario":  consulta.IDHorario,
+		"id_medico":   consulta.IDMedico,
+		"hora":        consulta.Hora,
+		"estado":      consulta.Estado,
+	})
+}
+
+// ObtenerMisCitas obtiene las citas activas a futuro del paciente autenticado
+func ObtenerMisCitas(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	rows, err := database.GetDB().Query(context.Background(),
+		`SELECT c.id_consulta, c.tipo, c.id_medico, c.id_horario, COALESCE(h.fecha_hora, c.hora), c.estado,
+		        COALESCE(c.motivo, ''), u.nombre as medico_nombre, co.nombre_numero as consultorio_nombre
+		 FROM Consulta c
+		 JOIN Usuario u ON c.id_medico = u.id_usuario
+		 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		 LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
+		 WHERE c.id_paciente = $1
+		 AND c.estado IN ('programada', 'confirmada')
+		 AND COALESCE(h.fecha_hora, c.hora) > $2
+		 ORDER BY COALESCE(h.fecha_hora, c.hora)`,
+		userID, horaDePared(time.Now()).Format(formatoTimestamp))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener tus citas",
+		})
+	}
+	defer rows.Close()
+
+	type CitaDetalle struct {
+		IDConsulta        int       `json:"id_consulta"`
+		Tipo              string    `json:"tipo"`
+		IDMedico          int       `json:"id_medico"`
+		IDHorario         int       `json:"id_horario"`
+		Hora              time.Time `json:"hora"`
+		Estado            string    `json:"estado"`
+		Motivo            string    `json:"motivo"`
+		MedicoNombre      string    `json:"medico_nombre"`
+		ConsultorioNombre *string   `json:"consultorio_nombre"`
+		CancelableHasta   time.Time `json:"cancelable_hasta"`
+	}
+
+	anticipacion := anticipacionCancelacionPaciente()
+	var citas []CitaDetalle
+	for rows.Next() {
+		var cita CitaDetalle
+		err := rows.Scan(&cita.IDConsulta, &cita.Tipo, &cita.IDMedico, &cita.IDHorario, &cita.Hora,
+			&cita.Estado, &cita.Motivo, &cita.MedicoNombre, &cita.ConsultorioNombre)
+		if err != nil {
+			continue
+		}
+		cita.CancelableHasta = cita.Hora.Add(-anticipacion)
+		citas = append(citas, cita)
+	}
+
+	return c.JSON(fiber.Map{
+		"citas":             citas,
+		"total":             len(citas),
+		"max_citas_futuras": maxCitasFuturasPaciente(),
+	})
+}
+
+// CancelarCitaPaciente permite a un paciente cancelar su propia cita respetando la anticipación mínima
+func CancelarCitaPaciente(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	// El motivo de cancelación es opcional
+	var req struct {
+		Motivo string `json:"motivo"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Datos inválidos",
+			})
+		}
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la cita")
+	}
+	defer tx.Rollback(ctx)
+
+	// cambiarEstadoConsulta verifica que la cita sea del paciente y la anticipación mínima
+	consulta, err := cambiarEstadoConsulta(ctx, tx, id, actorDe(c).soloPropios(), models.EstadoCancelada, req.Motivo)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la cita")
+	}
+
+	if err := liberarHorario(ctx, tx, consulta.IDHorario); err != nil {
+		return responderErrorConsulta(c, err, "Error al liberar el horario")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la cita")
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje":     "Cita cancelada exitosamente",
+		"id_consulta": id,
+	})
+}
+
+// ReprogramarCitaPaciente permite a un paciente mover su propia cita a otro horario del mismo
+// médico, con la misma anticipación mínima que la cancelación
+func ReprogramarCitaPaciente(c *fiber.Ctx) error {
+	// reprogramarConsulta verifica que la cita sea del paciente y la anticipación mínima
+	return reprogramarConsulta(c, actorDe(c).soloPropios())
+}
@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/lizet96/hospital-backend/database"
 	"github.com/lizet96/hospital-backend/models"
+	"github.com/lizet96/hospital-backend/notificaciones"
 )
 
 // CrearConsulta crea una nueva consulta médica
@@ -19,135 +25,89 @@ func CrearConsulta(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verificar permisos usando el nuevo sistema de roles
-	userRole := c.Locals("user_role").(string)
-	if userRole != "medico" && userRole != "admin" {
+	// Con alcance propio solo se crean consultas en las que el usuario es el médico
+	switch usuario := actorDe(c); usuario.alcance("consultas_create") {
+	case sinAlcance:
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos pueden crear consultas",
+			"error": "No tienes permisos para crear consultas",
 		})
-	}
-
-	// Si es médico, debe ser el mismo que está en la consulta
-	if userRole == "medico" {
-		userID := c.Locals("user_id").(int)
-		if consulta.IDMedico != userID {
+	case alcancePropios:
+		if consulta.IDMedico != usuario.id {
 			return c.Status(403).JSON(fiber.Map{
 				"error": "No puedes crear consultas para otro médico",
 			})
 		}
 	}
 
-	// Verificar que el horario esté disponible
-	var disponible bool
-	err := database.GetDB().QueryRow(context.Background(),
-		"SELECT consulta_disponible FROM Horario WHERE id_horario = $1", consulta.IDHorario).Scan(&disponible)
-	if err != nil || !disponible {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Horario no disponible",
-		})
+	// Reservar el horario e insertar la consulta en una sola transacción
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al crear la consulta")
 	}
+	defer tx.Rollback(ctx)
 
-	// Insertar consulta (incluyendo el campo hora)
-	var nuevoID int
-	err = database.GetDB().QueryRow(context.Background(),
-		`INSERT INTO Consulta (tipo, diagnostico, costo, id_paciente, id_medico, id_horario, hora)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id_consulta`,
-		consulta.Tipo, consulta.Diagnostico, consulta.Costo, consulta.IDPaciente, consulta.IDMedico,
-		consulta.IDHorario, consulta.Hora).Scan(&nuevoID)
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al crear la consulta",
-		})
+	if err := crearConsultaEnHorario(ctx, tx, &consulta, c.Locals("user_id").(int)); err != nil {
+		return responderErrorConsulta(c, err, "Error al crear la consulta")
 	}
 
-	// Marcar horario como no disponible
-	_, err = database.GetDB().Exec(context.Background(),
-		"UPDATE Horario SET consulta_disponible = false WHERE id_horario = $1", consulta.IDHorario)
-	if err != nil {
-		// Log error but don't fail the request
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al crear la consulta")
 	}
 
 	return c.Status(201).JSON(fiber.Map{
 		"mensaje":     "Consulta creada exitosamente",
-		"id_consulta": nuevoID,
+		"id_consulta": consulta.ID,
+		"estado":      consulta.Estado,
 	})
 }
 
-// ObtenerConsultas obtiene las consultas según el rol de usuario
+// ObtenerConsultas obtiene las consultas según el alcance del usuario
 func ObtenerConsultas(c *fiber.Ctx) error {
-	userID := c.Locals("user_id").(int)
-	userRole := c.Locals("user_role").(string)
-
-	// Debug: Log para verificar el rol del usuario
-	fmt.Printf("DEBUG - UserID: %d, UserRole: '%s'\n", userID, userRole)
+	usuario := actorDe(c)
 
-	// Debug: Verificar si hay consultas en la base de datos
-	var totalConsultas int
-	err := database.GetDB().QueryRow(context.Background(), "SELECT COUNT(*) FROM Consulta").Scan(&totalConsultas)
-	if err != nil {
-		fmt.Printf("DEBUG - Error al contar consultas: %v\n", err)
-	} else {
-		fmt.Printf("DEBUG - Total consultas en BD: %d\n", totalConsultas)
+	// Filtro opcional por estado (?estado=programada)
+	estado := c.Query("estado")
+	if estado != "" && !models.EstadoConsultaValido(estado) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Estado de consulta inválido",
+		})
 	}
 
-	var query string
+	query := `SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico,
+			 c.id_horario, c.hora, c.estado,
+			 p.nombre as paciente_nombre, m.nombre as medico_nombre,
+			 co.nombre_numero as consultorio_nombre, h.turno as horario_turno
+			 FROM Consulta c
+			 JOIN Usuario p ON c.id_paciente = p.id_usuario
+			 JOIN Usuario m ON c.id_medico = m.id_usuario
+			 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+			 LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
+			 WHERE c.deleted_at IS NULL`
 	var args []interface{}
 
-	switch userRole {
-	case "admin":
-		// Admin puede ver todas las consultas
-		query = `SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico, 
-				 c.id_horario, c.hora,
-				 p.nombre as paciente_nombre, m.nombre as medico_nombre,
-				 co.nombre_numero as consultorio_nombre, h.turno as horario_turno
-				 FROM Consulta c
-				 JOIN Usuario p ON c.id_paciente = p.id_usuario
-				 JOIN Usuario m ON c.id_medico = m.id_usuario
-				 LEFT JOIN Horario h ON c.id_horario = h.id_horario
-				 LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
-				 ORDER BY c.id_consulta DESC`
-	case "medico":
-		// Médico solo ve sus consultas
-		query = `SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico,
-				 c.id_horario, c.hora,
-				 p.nombre as paciente_nombre, m.nombre as medico_nombre,
-				 co.nombre_numero as consultorio_nombre, h.turno as horario_turno
-				 FROM Consulta c
-				 JOIN Usuario p ON c.id_paciente = p.id_usuario
-				 JOIN Usuario m ON c.id_medico = m.id_usuario
-				 LEFT JOIN Horario h ON c.id_horario = h.id_horario
-				 LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
-				 WHERE c.id_medico = $1
-				 ORDER BY c.id_consulta DESC`
-		args = append(args, userID)
-	case "paciente":
-		// Paciente solo ve sus consultas
-		query = `SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico,
-				 c.id_horario, c.hora,
-				 p.nombre as paciente_nombre, m.nombre as medico_nombre,
-				 co.nombre_numero as consultorio_nombre, h.turno as horario_turno
-				 FROM Consulta c
-				 JOIN Usuario p ON c.id_paciente = p.id_usuario
-				 JOIN Usuario m ON c.id_medico = m.id_usuario
-				 LEFT JOIN Horario h ON c.id_horario = h.id_horario
-				 LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
-				 WHERE c.id_paciente = $1
-				 ORDER BY c.id_consulta DESC`
-		args = append(args, userID)
+	switch usuario.alcance("consultas_read") {
+	case alcanceTodos:
+		// Todas las consultas
+	case alcancePropios:
+		// Las consultas en las que el usuario es el médico o el paciente
+		args = append(args, usuario.id)
+		query += fmt.Sprintf(" AND (c.id_medico = $%d OR c.id_paciente = $%d)", len(args), len(args))
 	default:
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Tipo de usuario no autorizado",
+			"error": "No tienes permisos para ver consultas",
 		})
 	}
 
-	// Debug: Log de la consulta SQL
-	fmt.Printf("DEBUG - Query: %s\n", query)
-	fmt.Printf("DEBUG - Args: %v\n", args)
+	if estado != "" {
+		args = append(args, estado)
+		query += fmt.Sprintf(" AND c.estado = $%d", len(args))
+	}
+	query += " ORDER BY c.id_consulta DESC"
 
 	rows, err := database.GetDB().Query(context.Background(), query, args...)
 	if err != nil {
-		fmt.Printf("DEBUG - Error en query: %v\n", err)
+		log.Printf("Error al obtener consultas: %v", err)
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al obtener consultas",
 		})
@@ -166,7 +126,7 @@ func ObtenerConsultas(c *fiber.Ctx) error {
 	for rows.Next() {
 		var consulta ConsultaDetalle
 		err := rows.Scan(&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo,
-			&consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario, &consulta.Hora,
+			&consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario, &consulta.Hora, &consulta.Estado,
 			&consulta.PacienteNombre, &consulta.MedicoNombre, &consulta.ConsultorioNombre, &consulta.HorarioTurno)
 		if err != nil {
 			continue
@@ -174,13 +134,6 @@ func ObtenerConsultas(c *fiber.Ctx) error {
 		consultas = append(consultas, consulta)
 	}
 
-	// Debug: Log del resultado
-	fmt.Printf("DEBUG - Total consultas encontradas: %d\n", len(consultas))
-	for i, consulta := range consultas {
-		fmt.Printf("DEBUG - Consulta %d: ID=%d, Tipo=%s, Paciente=%s, Medico=%s\n", 
-			i, consulta.ID, consulta.Tipo, consulta.PacienteNombre, consulta.MedicoNombre)
-	}
-
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    consultas,
@@ -197,26 +150,19 @@ func ActualizarConsulta(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verificar permisos
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
-
-	if userRole != "medico" && userRole != "admin" {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos pueden actualizar consultas",
+	// Verificar permisos: con alcance propio solo el médico de la consulta la actualiza
+	var medicoConsulta int
+	err = database.GetDB().QueryRow(context.Background(),
+		"SELECT id_medico FROM Consulta WHERE id_consulta = $1 AND deleted_at IS NULL", id).Scan(&medicoConsulta)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Consulta no encontrada",
 		})
 	}
-
-	// Si es médico, verificar que sea su consulta
-	if userRole == "medico" {
-		var medicoConsulta int
-		err := database.GetDB().QueryRow(context.Background(),
-			"SELECT id_medico FROM Consulta WHERE id_consulta = $1", id).Scan(&medicoConsulta)
-		if err != nil || medicoConsulta != userID {
-			return c.Status(403).JSON(fiber.Map{
-				"error": "No puedes actualizar esta consulta",
-			})
-		}
+	if err != nil || !actorDe(c).puede("consultas_update", medicoConsulta) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes actualizar esta consulta",
+		})
 	}
 
 	var consulta models.Consulta
@@ -229,7 +175,7 @@ func ActualizarConsulta(c *fiber.Ctx) error {
 	// Actualizar consulta (solo campos existentes)
 	_, err = database.GetDB().Exec(context.Background(),
 		`UPDATE Consulta SET tipo = $1, diagnostico = $2, costo = $3
-		 WHERE id_consulta = $4`,
+		 WHERE id_consulta = $4 AND deleted_at IS NULL`,
 		consulta.Tipo, consulta.Diagnostico, consulta.Costo, id)
 
 	if err != nil {
@@ -252,40 +198,36 @@ func ObtenerConsultaPorID(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
+	usuario := actorDe(c)
 
 	var consulta models.Consulta
 	var nombrePaciente, nombreMedico, nombreConsultorio string
 
 	query := `
-		SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico, c.id_horario,
+		SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico, c.id_horario, c.estado,
 		       u1.nombre as nombre_paciente, u2.nombre as nombre_medico,
-		       co.nombre as nombre_consultorio
+		       co.nombre_numero as nombre_consultorio
 		FROM Consulta c
 		JOIN Usuario u1 ON c.id_paciente = u1.id_usuario
 		JOIN Usuario u2 ON c.id_medico = u2.id_usuario
 		JOIN Horario h ON c.id_horario = h.id_horario
 		JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
-		WHERE c.id_consulta = $1`
-
-	// Agregar filtros según el rol de usuario
-	if userRole == "paciente" {
-		query += " AND c.id_paciente = $2"
-		err = database.GetDB().QueryRow(context.Background(), query, id, userID).Scan(
-			&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario,
-			&nombrePaciente, &nombreMedico, &nombreConsultorio)
-	} else if userRole == "medico" {
-		query += " AND c.id_medico = $2"
-		err = database.GetDB().QueryRow(context.Background(), query, id, userID).Scan(
-			&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario,
-			&nombrePaciente, &nombreMedico, &nombreConsultorio)
-	} else {
-		// Admin y enfermera pueden ver todas
-		err = database.GetDB().QueryRow(context.Background(), query, id).Scan(
-			&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario,
-			&nombrePaciente, &nombreMedico, &nombreConsultorio)
+		WHERE c.id_consulta = $1 AND c.deleted_at IS NULL`
+
+	// Agregar filtros según el alcance del usuario
+	args := []interface{}{id}
+	switch usuario.alcance("consultas_read") {
+	case alcancePropios:
+		query += " AND (c.id_paciente = $2 OR c.id_medico = $2)"
+		args = append(args, usuario.id)
+	case sinAlcance:
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver consultas",
+		})
 	}
+	err = database.GetDB().QueryRow(context.Background(), query, args...).Scan(
+		&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario, &consulta.Estado,
+		&nombrePaciente, &nombreMedico, &nombreConsultorio)
 
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{
@@ -310,27 +252,32 @@ func ObtenerConsultasPorPaciente(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
-
 	// Verificar permisos
-	if userRole == "paciente" && pacienteID != userID {
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "consultas_read", pacienteID); err != nil || !permitido {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "No puedes ver las consultas de otro paciente",
+			"error": "No puedes ver las consultas de este paciente",
+		})
+	}
+
+	// Filtro opcional por estado (?estado=programada)
+	estado := c.Query("estado")
+	if estado != "" && !models.EstadoConsultaValido(estado) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Estado de consulta inválido",
 		})
 	}
 
 	query := `
-		SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico, c.id_horario,
-		       u2.nombre as nombre_medico, co.nombre as nombre_consultorio
+		SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico, c.id_horario, c.estado,
+		       u2.nombre as nombre_medico, co.nombre_numero as nombre_consultorio
 		FROM Consulta c
 		JOIN Usuario u2 ON c.id_medico = u2.id_usuario
 		JOIN Horario h ON c.id_horario = h.id_horario
 		JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
-		WHERE c.id_paciente = $1
+		WHERE c.id_paciente = $1 AND ($2 = '' OR c.estado = $2) AND c.deleted_at IS NULL
 		ORDER BY c.id_consulta DESC`
 
-	rows, err := database.GetDB().Query(context.Background(), query, pacienteID)
+	rows, err := database.GetDB().Query(context.Background(), query, pacienteID, estado)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al obtener consultas",
@@ -344,7 +291,7 @@ func ObtenerConsultasPorPaciente(c *fiber.Ctx) error {
 		var nombreMedico, nombreConsultorio string
 
 		err := rows.Scan(
-			&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario,
+			&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario, &consulta.Estado,
 			&nombreMedico, &nombreConsultorio)
 		if err != nil {
 			continue
@@ -372,27 +319,41 @@ func ObtenerConsultasPorMedico(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
-
-	// Verificar permisos
-	if userRole == "medico" && medicoID != userID {
+	// Verificar permisos: con alcance propio, de la agenda de otro médico solo se ven las
+	// consultas en las que el usuario es el paciente
+	usuario := actorDe(c)
+	soloPaciente := 0
+	switch usuario.alcance("consultas_read") {
+	case sinAlcance:
 		return c.Status(403).JSON(fiber.Map{
-			"error": "No puedes ver las consultas de otro médico",
+			"error": "No tienes permisos para ver consultas",
+		})
+	case alcancePropios:
+		if medicoID != usuario.id {
+			soloPaciente = usuario.id
+		}
+	}
+
+	// Filtro opcional por estado (?estado=programada)
+	estado := c.Query("estado")
+	if estado != "" && !models.EstadoConsultaValido(estado) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Estado de consulta inválido",
 		})
 	}
 
 	query := `
-		SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico, c.id_horario,
-		       u1.nombre as nombre_paciente, co.nombre as nombre_consultorio
+		SELECT c.id_consulta, c.tipo, c.diagnostico, c.costo, c.id_paciente, c.id_medico, c.id_horario, c.estado,
+		       u1.nombre as nombre_paciente, co.nombre_numero as nombre_consultorio
 		FROM Consulta c
 		JOIN Usuario u1 ON c.id_paciente = u1.id_usuario
 		JOIN Horario h ON c.id_horario = h.id_horario
 		JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
-		WHERE c.id_medico = $1
+		WHERE c.id_medico = $1 AND ($2 = '' OR c.estado = $2) AND ($3 = 0 OR c.id_paciente = $3)
+		  AND c.deleted_at IS NULL
 		ORDER BY c.id_consulta DESC`
 
-	rows, err := database.GetDB().Query(context.Background(), query, medicoID)
+	rows, err := database.GetDB().Query(context.Background(), query, medicoID, estado, soloPaciente)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al obtener consultas",
@@ -406,7 +367,7 @@ func ObtenerConsultasPorMedico(c *fiber.Ctx) error {
 		var nombrePaciente, nombreConsultorio string
 
 		err := rows.Scan(
-			&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario,
+			&consulta.ID, &consulta.Tipo, &consulta.Diagnostico, &consulta.Costo, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario, &consulta.Estado,
 			&nombrePaciente, &nombreConsultorio)
 		if err != nil {
 			continue
@@ -425,7 +386,305 @@ func ObtenerConsultasPorMedico(c *fiber.Ctx) error {
 	})
 }
 
-// CompletarConsulta marca una consulta como completada
+// permisoTransicionConsulta es el permiso que exige llevar una consulta a un estado. Con alcance
+// propio, soloMedico indica que el usuario debe ser el médico de la consulta; si no, basta con
+// ser su médico o su paciente.
+type permisoTransicionConsulta struct {
+	permiso    string
+	soloMedico bool
+}
+
+// permisosTransicionConsulta define el permiso que exige llevar una consulta a cada estado
+var permisosTransicionConsulta = map[string]permisoTransicionConsulta{
+	models.EstadoConfirmada: {"consultas_confirmar", false},
+	models.EstadoEnCurso:    {"consultas_atender", true},
+	models.EstadoCompletada: {"consultas_atender", true},
+	models.EstadoCancelada:  {"consultas_cancelar", false},
+	models.EstadoNoAsistio:  {"consultas_no_asistio", true},
+}
+
+// errorConsulta describe una operación sobre consultas rechazada y el código HTTP a devolver
+type errorConsulta struct {
+	status  int
+	mensaje string
+}
+
+func (e *errorConsulta) Error() string {
+	return e.mensaje
+}
+
+// bloquearConsulta obtiene la consulta bloqueada para modificarla dentro de la transacción.
+// Hora toma la fecha del horario y, si no tiene, la hora registrada en la consulta.
+func bloquearConsulta(ctx context.Context, tx pgx.Tx, id int) (models.Consulta, error) {
+	var consulta models.Consulta
+	var hora *time.Time
+	err := tx.QueryRow(ctx,
+		`SELECT c.id_consulta, c.id_paciente, c.id_medico, c.id_horario, c.estado, COALESCE(h.fecha_hora, c.hora)
+		 FROM Consulta c
+		 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		 WHERE c.id_consulta = $1 AND c.deleted_at IS NULL FOR UPDATE OF c`, id).Scan(
+		&consulta.ID, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario, &consulta.Estado, &hora)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return consulta, &errorConsulta{404, "Consulta no encontrada"}
+		}
+		return consulta, err
+	}
+	if hora != nil {
+		consulta.Hora = *hora
+	}
+	return consulta, nil
+}
+
+// validarCambioConsulta verifica que el usuario tenga el permiso del nuevo estado, que con
+// alcance propio solo modifique sus propias consultas, que la transición sea válida y que los
+// pacientes cancelen con la anticipación mínima configurada
+func validarCambioConsulta(consulta models.Consulta, usuario actor, nuevoEstado string) error {
+	// Verificar que el usuario pueda aplicar este estado
+	alcanceEstado := sinAlcance
+	transicion, ok := permisosTransicionConsulta[nuevoEstado]
+	if ok {
+		alcanceEstado = usuario.alcance(transicion.permiso)
+	}
+	if alcanceEstado == sinAlcance {
+		return &errorConsulta{403, "No tienes permisos para cambiar la consulta a este estado"}
+	}
+
+	// Con alcance propio solo puede modificar sus propias consultas: como médico para atenderlas
+	// o registrar la inasistencia, y como médico o paciente para confirmarlas o cancelarlas
+	if alcanceEstado == alcancePropios {
+		propia := consulta.IDMedico == usuario.id
+		if !transicion.soloMedico {
+			propia = propia || consulta.IDPaciente == usuario.id
+		}
+		if !propia {
+			return &errorConsulta{403, "No puedes modificar esta consulta"}
+		}
+	}
+
+	if !models.TransicionConsultaPermitida(consulta.Estado, nuevoEstado) {
+		return &errorConsulta{409,
+			fmt.Sprintf("No se puede cambiar una consulta de '%s' a '%s'", consulta.Estado, nuevoEstado)}
+	}
+
+	// Los pacientes solo pueden cancelar sus citas con la anticipación mínima configurada
+	if alcanceEstado == alcancePropios && consulta.IDPaciente == usuario.id &&
+		nuevoEstado == models.EstadoCancelada && !consulta.Hora.IsZero() {
+		anticipacion := anticipacionCancelacionPaciente()
+		if consulta.Hora.Sub(horaDePared(time.Now())) < anticipacion {
+			return &errorConsulta{409, fmt.Sprintf(
+				"Las citas solo pueden cancelarse con al menos %d horas de anticipación", int(anticipacion.Hours()))}
+		}
+	}
+	return nil
+}
+
+// cambiarEstadoConsulta bloquea la consulta, valida la transición según las reglas del
+// ciclo de vida y los permisos del usuario, actualiza el estado y registra el cambio en el historial.
+// Devuelve la consulta con el estado anterior a la transición.
+func cambiarEstadoConsulta(ctx context.Context, tx pgx.Tx, id int, usuario actor, nuevoEstado, motivo string) (models.Consulta, error) {
+	consulta, err := bloquearConsulta(ctx, tx, id)
+	if err != nil {
+		return consulta, err
+	}
+
+	if err := validarCambioConsulta(consulta, usuario, nuevoEstado); err != nil {
+		return consulta, err
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE Consulta SET estado = $1, estado_actualizado_at = CURRENT_TIMESTAMP WHERE id_consulta = $2",
+		nuevoEstado, id)
+	if err != nil {
+		return consulta, err
+	}
+
+	var motivoParam interface{}
+	if motivo != "" {
+		motivoParam = motivo
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO ConsultaEstadoHistorial (id_consulta, estado_anterior, estado_nuevo, id_usuario, motivo)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		id, consulta.Estado, nuevoEstado, usuario.id, motivoParam)
+	if err != nil {
+		return consulta, err
+	}
+
+	if nuevoEstado == models.EstadoCancelada {
+		err = notificaciones.EncolarConsulta(ctx, tx, notificaciones.ConsultaCancelada, id,
+			map[string]interface{}{"Motivo": motivo})
+		if err != nil {
+			return consulta, err
+		}
+	}
+
+	return consulta, nil
+}
+
+// responderErrorConsulta convierte el error de una operación sobre consultas en la respuesta HTTP,
+// usando mensaje para los errores internos
+func responderErrorConsulta(c *fiber.Ctx, err error, mensaje string) error {
+	var errConsulta *errorConsulta
+	if errors.As(err, &errConsulta) {
+		return c.Status(errConsulta.status).JSON(fiber.Map{
+			"error": errConsulta.mensaje,
+		})
+	}
+	log.Printf("%s: %v", mensaje, err)
+	return c.Status(500).JSON(fiber.Map{
+		"error": mensaje,
+	})
+}
+
+// reservarHorario marca el horario como ocupado dentro de la transacción. La actualización
+// condicional garantiza que, entre transacciones concurrentes, solo una pueda tomar el horario.
+func reservarHorario(ctx context.Context, tx pgx.Tx, idHorario int) (models.Horario, error) {
+	var horario models.Horario
+	var fechaHora *time.Time
+	err := tx.QueryRow(ctx,
+		`UPDATE Horario SET consulta_disponible = false
+		 WHERE id_horario = $1 AND consulta_disponible = true
+		 RETURNING id_horario, id_medico, id_consultorio, fecha_hora`, idHorario).Scan(
+		&horario.IDHorario, &horario.IDMedico, &horario.IDConsultorio, &fechaHora)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var existe bool
+		if err := tx.QueryRow(ctx,
+			"SELECT EXISTS(SELECT 1 FROM Horario WHERE id_horario = $1)", idHorario).Scan(&existe); err != nil {
+			return horario, err
+		}
+		if !existe {
+			return horario, &errorConsulta{404, "Horario no encontrado"}
+		}
+		return horario, &errorConsulta{409, "El horario ya fue reservado"}
+	}
+	if err != nil {
+		return horario, err
+	}
+	if fechaHora != nil {
+		horario.FechaHora = *fechaHora
+	}
+	return horario, nil
+}
+
+// liberarHorario vuelve a marcar el horario como disponible dentro de la transacción y, si hay
+// pacientes en la lista de espera del médico, lo retiene para el primero elegible
+func liberarHorario(ctx context.Context, tx pgx.Tx, idHorario int) error {
+	if err := marcarHorarioDisponible(ctx, tx, idHorario); err != nil {
+		return err
+	}
+	_, err := ofrecerHorarioListaEspera(ctx, tx, idHorario)
+	return err
+}
+
+// marcarHorarioDisponible marca el horario como disponible sin ofrecerlo a la lista de espera
+func marcarHorarioDisponible(ctx context.Context, tx pgx.Tx, idHorario int) error {
+	_, err := tx.Exec(ctx,
+		"UPDATE Horario SET consulta_disponible = true WHERE id_horario = $1", idHorario)
+	return err
+}
+
+// crearConsultaEnHorario reserva el horario de la consulta, la inserta en estado programada
+// y registra el estado inicial, todo dentro de la transacción recibida
+func crearConsultaEnHorario(ctx context.Context, tx pgx.Tx, consulta *models.Consulta, userID int) error {
+	horario, err := reservarHorario(ctx, tx, consulta.IDHorario)
+	if err != nil {
+		return err
+	}
+
+	// La consulta debe ser con el médico dueño del horario
+	if consulta.IDMedico == 0 {
+		consulta.IDMedico = horario.IDMedico
+	} else if consulta.IDMedico != horario.IDMedico {
+		return &errorConsulta{400, "El horario no corresponde al médico de la consulta"}
+	}
+
+	// Si no se indica la hora, usar la del horario
+	if consulta.Hora.IsZero() && !horario.FechaHora.IsZero() {
+		consulta.Hora = horario.FechaHora
+	}
+
+	consulta.Estado = models.EstadoProgramada
+	err = tx.QueryRow(ctx,
+		`INSERT INTO Consulta (tipo, diagnostico, costo, id_paciente, id_medico, id_horario, hora, estado, motivo)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')) RETURNING id_consulta`,
+		consulta.Tipo, consulta.Diagnostico, consulta.Costo, consulta.IDPaciente, consulta.IDMedico,
+		consulta.IDHorario, consulta.Hora, consulta.Estado, consulta.Motivo).Scan(&consulta.ID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return &errorConsulta{409, "El horario ya tiene una consulta activa"}
+		}
+		return err
+	}
+
+	// Registrar el estado inicial en el historial
+	_, err = tx.Exec(ctx,
+		`INSERT INTO ConsultaEstadoHistorial (id_consulta, estado_anterior, estado_nuevo, id_usuario)
+		 VALUES ($1, NULL, $2, $3)`,
+		consulta.ID, consulta.Estado, userID)
+	if err != nil {
+		return err
+	}
+
+	// Avisar al paciente y al médico; el envío queda en la bandeja de salida de esta transacción
+	return notificaciones.EncolarConsulta(ctx, tx, notificaciones.ConsultaCreada, consulta.ID, nil)
+}
+
+// CambiarEstadoConsulta aplica una transición del ciclo de vida a una consulta
+func CambiarEstadoConsulta(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var req models.CambioEstadoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	if !models.EstadoConsultaValido(req.Estado) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Estado de consulta inválido",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cambiar el estado de la consulta")
+	}
+	defer tx.Rollback(ctx)
+
+	consulta, err := cambiarEstadoConsulta(ctx, tx, id, actorDe(c), req.Estado, req.Motivo)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cambiar el estado de la consulta")
+	}
+
+	// Liberar horario si la consulta fue cancelada
+	if req.Estado == models.EstadoCancelada {
+		if err := liberarHorario(ctx, tx, consulta.IDHorario); err != nil {
+			return responderErrorConsulta(c, err, "Error al liberar el horario")
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al cambiar el estado de la consulta")
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje":         "Estado de la consulta actualizado exitosamente",
+		"id_consulta":     id,
+		"estado_anterior": consulta.Estado,
+		"estado":          req.Estado,
+	})
+}
+
+// CompletarConsulta marca como completada una consulta confirmada o en curso
 func CompletarConsulta(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -434,41 +693,316 @@ func CompletarConsulta(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al completar la consulta")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := cambiarEstadoConsulta(ctx, tx, id, actorDe(c), models.EstadoCompletada, ""); err != nil {
+		return responderErrorConsulta(c, err, "Error al completar la consulta")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al completar la consulta")
+	}
 
-	// Solo médicos pueden completar consultas
-	if userRole != "medico" {
+	return c.JSON(fiber.Map{
+		"mensaje": "Consulta completada exitosamente",
+	})
+}
+
+// CancelarConsulta cancela una consulta y libera el horario
+func CancelarConsulta(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	// El motivo de cancelación es opcional (?motivo=...)
+	motivo := c.Query("motivo")
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la consulta")
+	}
+	defer tx.Rollback(ctx)
+
+	consulta, err := cambiarEstadoConsulta(ctx, tx, id, actorDe(c), models.EstadoCancelada, motivo)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la consulta")
+	}
+
+	// Liberar horario en la misma transacción que la cancelación
+	if err := liberarHorario(ctx, tx, consulta.IDHorario); err != nil {
+		return responderErrorConsulta(c, err, "Error al liberar el horario")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la consulta")
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Consulta cancelada exitosamente",
+	})
+}
+
+// EliminarConsulta elimina una consulta registrada por error (solo admin). El borrado es lógico
+// y solo se permite en consultas que ya terminaron o se cancelaron, para no dejar ocupado el
+// horario de una consulta que ya no se ve.
+func EliminarConsulta(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	// Eliminar consultas no depende de ser su médico o paciente: exige consultas_delete_any
+	if !actorDe(c).puede("consultas_delete") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos pueden completar consultas",
+			"error": "No tienes permisos para eliminar consultas",
 		})
 	}
 
-	// Verificar que la consulta pertenece al médico
-	var medicoID int
+	motivo, err := motivoEliminacion(c, true)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la consulta")
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la consulta")
+	}
+	defer tx.Rollback(ctx)
+
+	consulta, err := bloquearConsulta(ctx, tx, id)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la consulta")
+	}
+	if len(models.TransicionesConsulta[consulta.Estado]) > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "La consulta sigue activa; cancélela antes de eliminarla",
+		})
+	}
+
+	err = eliminarRegistro(ctx, tx, models.EliminadoConsulta, id, c.Locals("user_id").(int), motivo)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la consulta")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la consulta")
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Consulta eliminada exitosamente",
+	})
+}
+
+// ReprogramarConsulta mueve una consulta activa a otro horario del mismo médico conservando su id.
+// Reserva el nuevo horario y libera el anterior en la misma transacción; aplica las mismas reglas
+// de rol, propiedad y anticipación que la cancelación.
+func ReprogramarConsulta(c *fiber.Ctx) error {
+	return reprogramarConsulta(c, actorDe(c))
+}
+
+// reprogramarConsulta mueve la consulta con las reglas del usuario indicado
+func reprogramarConsulta(c *fiber.Ctx, usuario actor) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var req models.ReprogramarConsultaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	if req.IDHorario == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El nuevo horario es requerido",
+		})
+	}
+
+	userID := usuario.id
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+	defer tx.Rollback(ctx)
+
+	consulta, err := bloquearConsulta(ctx, tx, id)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	// Dejar el horario actual equivale a cancelarlo: se validan las mismas reglas
+	if err := validarCambioConsulta(consulta, usuario, models.EstadoCancelada); err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	if req.IDHorario == consulta.IDHorario {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "La consulta ya está en ese horario",
+		})
+	}
+
+	// Bloquear al paciente antes que el horario, en el mismo orden que una reserva nueva
+	if err := bloquearReservasPaciente(ctx, tx, consulta.IDPaciente); err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	nuevoHorario, err := reservarHorario(ctx, tx, req.IDHorario)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	if nuevoHorario.IDMedico != consulta.IDMedico {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El nuevo horario debe ser del mismo médico",
+		})
+	}
+	if !nuevoHorario.FechaHora.IsZero() && !nuevoHorario.FechaHora.After(horaDePared(time.Now())) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "No se puede reprogramar a un horario en el pasado",
+		})
+	}
+
+	// El nuevo horario cumple los mismos límites por paciente que una reserva, sin contar esta consulta
+	if !nuevoHorario.FechaHora.IsZero() {
+		if err := validarCitasPaciente(ctx, tx, consulta.IDPaciente, nuevoHorario.IDMedico,
+			nuevoHorario.FechaHora, consulta.ID); err != nil {
+			return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE Consulta SET id_horario = $1, hora = $2 WHERE id_consulta = $3",
+		nuevoHorario.IDHorario, parametroTimestamp(nuevoHorario.FechaHora), id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "El horario ya tiene una consulta activa",
+			})
+		}
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	// Liberar el horario anterior (puede ofrecerse a la lista de espera)
+	if err := liberarHorario(ctx, tx, consulta.IDHorario); err != nil {
+		return responderErrorConsulta(c, err, "Error al liberar el horario")
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO ConsultaReprogramacion (id_consulta, id_horario_anterior, id_horario_nuevo,
+		 hora_anterior, hora_nueva, id_usuario, motivo)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
+		id, consulta.IDHorario, nuevoHorario.IDHorario, parametroTimestamp(consulta.Hora),
+		parametroTimestamp(nuevoHorario.FechaHora), userID, req.Motivo)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	err = notificaciones.EncolarConsulta(ctx, tx, notificaciones.ConsultaReprogramada, id, map[string]interface{}{
+		"Motivo":        req.Motivo,
+		"FechaAnterior": notificaciones.FormatearFecha(&consulta.Hora),
+	})
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al reprogramar la consulta")
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje":             "Consulta reprogramada exitosamente",
+		"id_consulta":         id,
+		"id_horario_anterior": consulta.IDHorario,
+		"id_horario":          nuevoHorario.IDHorario,
+		"hora_anterior":       consulta.Hora,
+		"hora":                nuevoHorario.FechaHora,
+	})
+}
+
+// ObtenerReprogramacionesConsulta obtiene los cambios de horario de una consulta
+func ObtenerReprogramacionesConsulta(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var idPaciente, idMedico int
 	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT id_medico FROM Consulta WHERE id_consulta = $1", id).Scan(&medicoID)
+		"SELECT id_paciente, id_medico FROM Consulta WHERE id_consulta = $1 AND deleted_at IS NULL", id).Scan(&idPaciente, &idMedico)
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Consulta no encontrada",
 		})
 	}
 
-	if medicoID != userID {
+	// Verificar permisos
+	if !actorDe(c).puede("consultas_read", idPaciente, idMedico) {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "No puedes completar esta consulta",
+			"error": "No puedes ver las reprogramaciones de esta consulta",
 		})
 	}
 
-	// Como no existe campo estado en la tabla, solo retornamos éxito
-	// La lógica de completar consulta se manejará a nivel de aplicación
+	rows, err := database.GetDB().Query(context.Background(),
+		`SELECT r.id_reprogramacion, r.id_consulta, r.id_horario_anterior, r.id_horario_nuevo,
+		        r.hora_anterior, r.hora_nueva, r.id_usuario, r.motivo, r.created_at,
+		        u.nombre as usuario_nombre
+		 FROM ConsultaReprogramacion r
+		 LEFT JOIN Usuario u ON r.id_usuario = u.id_usuario
+		 WHERE r.id_consulta = $1
+		 ORDER BY r.created_at, r.id_reprogramacion`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener reprogramaciones de la consulta",
+		})
+	}
+	defer rows.Close()
+
+	type ReprogramacionDetalle struct {
+		models.ConsultaReprogramacion
+		UsuarioNombre *string `json:"usuario_nombre"`
+	}
+
+	var reprogramaciones []ReprogramacionDetalle
+	for rows.Next() {
+		var r ReprogramacionDetalle
+		err := rows.Scan(&r.IDReprogramacion, &r.IDConsulta, &r.IDHorarioAnterior, &r.IDHorarioNuevo,
+			&r.HoraAnterior, &r.HoraNueva, &r.IDUsuario, &r.Motivo, &r.CreatedAt, &r.UsuarioNombre)
+		if err != nil {
+			continue
+		}
+		reprogramaciones = append(reprogramaciones, r)
+	}
+
 	return c.JSON(fiber.Map{
-		"mensaje": "Consulta completada exitosamente",
+		"id_consulta":      id,
+		"reprogramaciones": reprogramaciones,
+		"total":            len(reprogramaciones),
 	})
 }
 
-// CancelarConsulta cancela una consulta y libera el horario
-func CancelarConsulta(c *fiber.Ctx) error {
+// ObtenerHistorialEstadosConsulta obtiene las transiciones de estado de una consulta
+func ObtenerHistorialEstadosConsulta(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
@@ -476,47 +1010,59 @@ func CancelarConsulta(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verificar permisos
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
-
-	// Obtener información de la consulta
-	var consulta models.Consulta
+	var idPaciente, idMedico int
+	var estado string
 	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT id_consulta, id_paciente, id_medico, id_horario FROM Consulta WHERE id_consulta = $1", id).Scan(
-		&consulta.ID, &consulta.IDPaciente, &consulta.IDMedico, &consulta.IDHorario)
-
+		"SELECT id_paciente, id_medico, estado FROM Consulta WHERE id_consulta = $1 AND deleted_at IS NULL", id).Scan(
+		&idPaciente, &idMedico, &estado)
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Consulta no encontrada",
 		})
 	}
 
-	// Verificar permisos específicos
-	if userRole == "paciente" && consulta.IDPaciente != userID {
+	// Verificar permisos
+	if !actorDe(c).puede("consultas_read", idPaciente, idMedico) {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "No puedes cancelar esta consulta",
+			"error": "No puedes ver el historial de esta consulta",
 		})
 	}
-	if userRole == "medico" && consulta.IDMedico != userID {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "No puedes cancelar esta consulta",
+
+	rows, err := database.GetDB().Query(context.Background(),
+		`SELECT h.id_historial, h.id_consulta, h.estado_anterior, h.estado_nuevo, h.id_usuario, h.motivo, h.created_at,
+		        u.nombre as usuario_nombre
+		 FROM ConsultaEstadoHistorial h
+		 LEFT JOIN Usuario u ON h.id_usuario = u.id_usuario
+		 WHERE h.id_consulta = $1
+		 ORDER BY h.created_at, h.id_historial`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener historial de la consulta",
 		})
 	}
+	defer rows.Close()
 
-	// Como no existe campo estado en la tabla, asumimos que todas las consultas se pueden cancelar
-
-	// Como no existe campo estado en la tabla, solo liberamos el horario
-	// La lógica de cancelar consulta se manejará a nivel de aplicación
+	type HistorialDetalle struct {
+		models.ConsultaEstadoHistorial
+		UsuarioNombre *string `json:"usuario_nombre"`
+	}
 
-	// Liberar horario
-	_, err = database.GetDB().Exec(context.Background(),
-		"UPDATE Horario SET consulta_disponible = true WHERE id_horario = $1", consulta.IDHorario)
-	if err != nil {
-		// Log error but don't fail the request
+	var historial []HistorialDetalle
+	for rows.Next() {
+		var h HistorialDetalle
+		err := rows.Scan(&h.IDHistorial, &h.IDConsulta, &h.EstadoAnterior, &h.EstadoNuevo,
+			&h.IDUsuario, &h.Motivo, &h.CreatedAt, &h.UsuarioNombre)
+		if err != nil {
+			continue
+		}
+		historial = append(historial, h)
 	}
 
 	return c.JSON(fiber.Map{
-		"mensaje": "Consulta cancelada exitosamente",
+		"id_consulta":           id,
+		"estado":                estado,
+		"transiciones_posibles": models.TransicionesConsulta[estado],
+		"historial":             historial,
+		"total":                 len(historial),
 	})
 }
@@ -11,11 +11,10 @@ import (
 
 // CrearConsultorio crea un nuevo consultorio
 func CrearConsultorio(c *fiber.Ctx) error {
-	// Solo admin puede crear consultorios
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" {
+	// Los consultorios no tienen responsable: se exige consultorios_create_any
+	if !actorDe(c).puede("consultorios_create") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden crear consultorios",
+			"error": "No tienes permisos para crear consultorios",
 		})
 	}
 
@@ -124,11 +123,10 @@ func ObtenerConsultorioPorID(c *fiber.Ctx) error {
 
 // ActualizarConsultorio actualiza un consultorio existente
 func ActualizarConsultorio(c *fiber.Ctx) error {
-	// Solo admin puede actualizar consultorios
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" {
+	// Los consultorios no tienen responsable: se exige consultorios_update_any
+	if !actorDe(c).puede("consultorios_update") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden actualizar consultorios",
+			"error": "No tienes permisos para actualizar consultorios",
 		})
 	}
 
@@ -199,11 +197,10 @@ func ActualizarConsultorio(c *fiber.Ctx) error {
 
 // EliminarConsultorio elimina un consultorio
 func EliminarConsultorio(c *fiber.Ctx) error {
-	// Solo admin puede eliminar consultorios
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" {
+	// Los consultorios no tienen responsable: se exige consultorios_delete_any
+	if !actorDe(c).puede("consultorios_delete") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden eliminar consultorios",
+			"error": "No tienes permisos para eliminar consultorios",
 		})
 	}
 
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// limiteBusquedaCIE10 es el máximo de resultados de la búsqueda en el catálogo
+const limiteBusquedaCIE10 = 50
+
+// BuscarCIE10 busca en el catálogo CIE-10 por prefijo de código o por fragmento de la descripción
+// (?q=, ?limite=, ?incluir_inactivos=true)
+func BuscarCIE10(c *fiber.Ctx) error {
+	// Los comodines de LIKE no forman parte de la búsqueda
+	q := strings.NewReplacer("%", "", "_", "", "\\", "").Replace(strings.TrimSpace(c.Query("q")))
+	if len([]rune(q)) < 2 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "La búsqueda debe tener al menos 2 caracteres",
+		})
+	}
+
+	limite := c.QueryInt("limite", 20)
+	if limite <= 0 || limite > limiteBusquedaCIE10 {
+		limite = limiteBusquedaCIE10
+	}
+
+	prefijo, _ := models.NormalizarCodigoCIE10(q)
+	query := `SELECT codigo, descripcion, activo FROM CatalogoCIE10
+			  WHERE (codigo LIKE $1 || '%' OR descripcion_busqueda LIKE '%' || $2 || '%')`
+	if !c.QueryBool("incluir_inactivos") {
+		query += " AND activo"
+	}
+	query += " ORDER BY (codigo LIKE $1 || '%') DESC, codigo LIMIT $3"
+
+	rows, err := database.GetDB().Query(context.Background(), query,
+		prefijo, models.NormalizarBusquedaCIE10(q), limite)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al buscar en el catálogo CIE-10",
+		})
+	}
+	defer rows.Close()
+
+	var codigos []models.CodigoCIE10
+	for rows.Next() {
+		var cie models.CodigoCIE10
+		if err := rows.Scan(&cie.Codigo, &cie.Descripcion, &cie.Activo); err != nil {
+			continue
+		}
+		codigos = append(codigos, cie)
+	}
+
+	return c.JSON(fiber.Map{
+		"codigos": codigos,
+		"total":   len(codigos),
+	})
+}
+
+// ObtenerCIE10 obtiene un código del catálogo CIE-10
+func ObtenerCIE10(c *fiber.Ctx) error {
+	codigo, valido := models.NormalizarCodigoCIE10(c.Params("codigo"))
+	if !valido {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Código CIE-10 inválido",
+		})
+	}
+
+	var cie models.CodigoCIE10
+	err := database.GetDB().QueryRow(context.Background(),
+		"SELECT codigo, descripcion, activo FROM CatalogoCIE10 WHERE codigo = $1", codigo).Scan(
+		&cie.Codigo, &cie.Descripcion, &cie.Activo)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Código CIE-10 no encontrado",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"codigo": cie,
+	})
+}
+
+// consultorFilas es el pool o una transacción, para leer dentro o fuera de una transacción
+type consultorFilas interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// obtenerDiagnosticosConsulta obtiene los diagnósticos de la consulta, el principal primero
+func obtenerDiagnosticosConsulta(ctx context.Context, q consultorFilas, idConsulta int) ([]models.ConsultaDiagnostico, error) {
+	rows, err := q.Query(ctx,
+		`SELECT d.id_consulta_diagnostico, d.id_consulta, d.codigo, cie.descripcion, d.principal,
+		        d.notas, d.id_usuario, d.created_at
+		 FROM ConsultaDiagnostico d
+		 JOIN CatalogoCIE10 cie ON d.codigo = cie.codigo
+		 WHERE d.id_consulta = $1
+		 ORDER BY d.principal DESC, d.id_consulta_diagnostico`, idConsulta)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diagnosticos := []models.ConsultaDiagnostico{}
+	for rows.Next() {
+		var d models.ConsultaDiagnostico
+		err := rows.Scan(&d.IDConsultaDiagnostico, &d.IDConsulta, &d.Codigo, &d.Descripcion, &d.Principal,
+			&d.Notas, &d.IDUsuario, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		diagnosticos = append(diagnosticos, d)
+	}
+	return diagnosticos, rows.Err()
+}
+
+// ObtenerDiagnosticosConsulta obtiene los diagnósticos codificados de una consulta
+func ObtenerDiagnosticosConsulta(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var idPaciente, idMedico int
+	err = database.GetDB().QueryRow(context.Background(),
+		"SELECT id_paciente, id_medico FROM Consulta WHERE id_consulta = $1 AND deleted_at IS NULL", id).Scan(&idPaciente, &idMedico)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Consulta no encontrada",
+		})
+	}
+
+	// Verificar permisos
+	if !actorDe(c).puede("consultas_read", idPaciente, idMedico) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes ver los diagnósticos de esta consulta",
+		})
+	}
+
+	diagnosticos, err := obtenerDiagnosticosConsulta(context.Background(), database.GetDB(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener los diagnósticos de la consulta",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"id_consulta":  id,
+		"diagnosticos": diagnosticos,
+		"total":        len(diagnosticos),
+	})
+}
+
+// ActualizarDiagnosticosConsulta reemplaza los diagnósticos codificados de una consulta. La lista
+// debe tener exactamente un diagnóstico principal; los demás son secundarios. Una lista vacía
+// elimina los diagnósticos.
+func ActualizarDiagnosticosConsulta(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var req models.DiagnosticosConsultaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	usuario := actorDe(c)
+	if usuario.alcance("consultas_update") == sinAlcance {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para registrar diagnósticos",
+		})
+	}
+
+	// Validar códigos, duplicados y diagnóstico principal
+	principales := 0
+	vistos := make(map[string]bool)
+	for i := range req.Diagnosticos {
+		d := &req.Diagnosticos[i]
+		codigo, valido := models.NormalizarCodigoCIE10(d.Codigo)
+		if !valido {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Código CIE-10 inválido: " + d.Codigo,
+			})
+		}
+		if vistos[codigo] {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Código CIE-10 repetido: " + codigo,
+			})
+		}
+		vistos[codigo] = true
+		d.Codigo = codigo
+		d.Notas = strings.TrimSpace(d.Notas)
+		if d.Principal {
+			principales++
+		}
+	}
+	if len(req.Diagnosticos) > 0 && principales != 1 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Debe indicar exactamente un diagnóstico principal",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar los diagnósticos")
+	}
+	defer tx.Rollback(ctx)
+
+	consulta, err := bloquearConsulta(ctx, tx, id)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar los diagnósticos")
+	}
+	if !usuario.puede("consultas_update", consulta.IDMedico) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Solo el médico de la consulta puede registrar sus diagnósticos",
+		})
+	}
+	if consulta.Estado == models.EstadoCancelada || consulta.Estado == models.EstadoNoAsistio {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "No se pueden registrar diagnósticos en una consulta " + consulta.Estado,
+		})
+	}
+
+	// Los códigos inactivos solo se conservan si la consulta ya los tenía
+	for _, d := range req.Diagnosticos {
+		var activo, asignado bool
+		err := tx.QueryRow(ctx,
+			`SELECT cie.activo, EXISTS (
+			     SELECT 1 FROM ConsultaDiagnostico WHERE id_consulta = $2 AND codigo = cie.codigo)
+			 FROM CatalogoCIE10 cie WHERE cie.codigo = $1`, d.Codigo, id).Scan(&activo, &asignado)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(400).JSON(fiber.Map{
+					"error": "El código " + d.Codigo + " no existe en el catálogo CIE-10",
+				})
+			}
+			return responderErrorConsulta(c, err, "Error al actualizar los diagnósticos")
+		}
+		if !activo && !asignado {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "El código " + d.Codigo + " está inactivo en el catálogo CIE-10",
+			})
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM ConsultaDiagnostico WHERE id_consulta = $1", id); err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar los diagnósticos")
+	}
+	for _, d := range req.Diagnosticos {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO ConsultaDiagnostico (id_consulta, codigo, principal, notas, id_usuario)
+			 VALUES ($1, $2, $3, NULLIF($4, ''), $5)`,
+			id, d.Codigo, d.Principal, d.Notas, usuario.id)
+		if err != nil {
+			return responderErrorConsulta(c, err, "Error al actualizar los diagnósticos")
+		}
+	}
+
+	diagnosticos, err := obtenerDiagnosticosConsulta(ctx, tx, id)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar los diagnósticos")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar los diagnósticos")
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje":      "Diagnósticos actualizados exitosamente",
+		"id_consulta":  id,
+		"diagnosticos": diagnosticos,
+	})
+}
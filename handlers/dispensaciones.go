@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// maxResurtidosReceta es el número máximo de resurtidos que puede autorizar el médico
+const maxResurtidosReceta = 12
+
+// consultorFila es lo que comparten el pool y las transacciones para leer una fila
+type consultorFila interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// validarSurtidoReceta revisa los resurtidos y la vigencia pedidos al crear o actualizar una receta
+func validarSurtidoReceta(req models.SurtidoRecetaRequest) error {
+	if req.Resurtidos != nil && (*req.Resurtidos < 0 || *req.Resurtidos > maxResurtidosReceta) {
+		return &errorConsulta{400, fmt.Sprintf("Los resurtidos deben estar entre 0 y %d", maxResurtidosReceta)}
+	}
+	if req.VigenciaDias != nil && (*req.VigenciaDias < 1 || *req.VigenciaDias > 365) {
+		return &errorConsulta{400, "La vigencia debe estar entre 1 y 365 días"}
+	}
+	return nil
+}
+
+// obtenerSurtidoReceta calcula el estado de surtido de la receta. Con una transacción y
+// bloquear en true la receta queda bloqueada hasta el fin de la transacción, de modo que dos
+// farmacias no puedan usar el mismo surtido.
+func obtenerSurtidoReceta(ctx context.Context, q consultorFila, idReceta int, bloquear bool) (models.SurtidoReceta, error) {
+	var s models.SurtidoReceta
+	var vencida bool
+	query := `SELECT resurtidos, COALESCE(vigente_hasta, fecha + $2::int),
+			  COALESCE(vigente_hasta, fecha + $2::int) < CURRENT_DATE
+			  FROM Receta WHERE id_receta = $1`
+	if bloquear {
+		query += " FOR UPDATE"
+	}
+	if err := q.QueryRow(ctx, query, idReceta, diasVigenciaReceta()).Scan(&s.Resurtidos, &s.VigenteHasta, &vencida); err != nil {
+		return s, err
+	}
+	err := q.QueryRow(ctx,
+		"SELECT COUNT(*) FROM Dispensacion WHERE id_receta = $1", idReceta).Scan(&s.Surtidos)
+	if err != nil {
+		return s, err
+	}
+
+	s.SurtidosRestantes = max(1+s.Resurtidos-s.Surtidos, 0)
+	switch {
+	case s.SurtidosRestantes == 0:
+		s.Estado = models.EstadoSurtidoSurtida
+	case vencida:
+		s.Estado = models.EstadoSurtidoVencida
+	default:
+		s.Estado = models.EstadoSurtidoDisponible
+	}
+	return s, nil
+}
+
+// obtenerDispensaciones obtiene los surtidos de la receta con lo entregado en cada uno
+func obtenerDispensaciones(ctx context.Context, idReceta int) ([]models.Dispensacion, error) {
+	rows, err := database.GetDB().Query(ctx,
+		`SELECT d.id_dispensacion, d.id_receta, d.id_farmaceutico, u.nombre, d.numero, d.resurtidos_restantes,
+		        d.observaciones, d.fecha
+		 FROM Dispensacion d
+		 JOIN Usuario u ON d.id_farmaceutico = u.id_usuario
+		 WHERE d.id_receta = $1
+		 ORDER BY d.numero`, idReceta)
+	if err != nil {
+		return nil, err
+	}
+	dispensaciones := []models.Dispensacion{}
+	posicion := make(map[int]int)
+	for rows.Next() {
+		var d models.Dispensacion
+		if err := rows.Scan(&d.IDDispensacion, &d.IDReceta, &d.IDFarmaceutico, &d.FarmaceuticoNombre, &d.Numero,
+			&d.ResurtidosRestantes, &d.Observaciones, &d.Fecha); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Items = []models.DispensacionItem{}
+		posicion[d.IDDispensacion] = len(dispensaciones)
+		dispensaciones = append(dispensaciones, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = database.GetDB().Query(ctx,
+		`SELECT di.id_dispensacion, di.id_item, di.medicamento, di.cantidad
+		 FROM DispensacionItem di
+		 JOIN Dispensacion d ON di.id_dispensacion = d.id_dispensacion
+		 JOIN RecetaItem i ON di.id_item = i.id_item
+		 WHERE d.id_receta = $1
+		 ORDER BY di.id_dispensacion, i.orden`, idReceta)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var idDispensacion int
+		var item models.DispensacionItem
+		if err := rows.Scan(&idDispensacion, &item.IDItem, &item.Medicamento, &item.Cantidad); err != nil {
+			return nil, err
+		}
+		if p, ok := posicion[idDispensacion]; ok {
+			dispensaciones[p].Items = append(dispensaciones[p].Items, item)
+		}
+	}
+	return dispensaciones, rows.Err()
+}
+
+// recetaSurtida bloquea la receta dentro de la transacción e indica si ya tiene algún surtido;
+// una receta surtida no se modifica ni se elimina. DispensarReceta bloquea la misma fila, así que
+// un surtido no puede confirmarse entre esta revisión y el cambio. Devuelve pgx.ErrNoRows si la
+// receta no existe o fue eliminada.
+func recetaSurtida(ctx context.Context, tx pgx.Tx, idReceta int) (bool, error) {
+	var surtida bool
+	err := tx.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM Dispensacion d WHERE d.id_receta = r.id_receta)
+		 FROM Receta r WHERE r.id_receta = $1 AND r.deleted_at IS NULL
+		 FOR UPDATE OF r`, idReceta).Scan(&surtida)
+	return surtida, err
+}
+
+// BuscarRecetaFarmacia busca la receta por su código de verificación para surtirla
+func BuscarRecetaFarmacia(c *fiber.Ctx) error {
+	codigo := normalizarCodigoVerificacion(c.Params("codigo"))
+	if len(codigo) != longitudCodigoVerificacion {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Código de verificación inválido",
+		})
+	}
+
+	ctx := context.Background()
+	receta, err := obtenerDocumentoReceta(ctx, "r.codigo_verificacion = $1", codigo)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "No existe una receta con ese código de verificación",
+		})
+	}
+
+	surtido, err := obtenerSurtidoReceta(ctx, database.GetDB(), receta.IDReceta, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener el surtido de la receta",
+		})
+	}
+	dispensaciones, err := obtenerDispensaciones(ctx, receta.IDReceta)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener el surtido de la receta",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"receta": fiber.Map{
+			"id_receta":           receta.IDReceta,
+			"codigo_verificacion": formatoCodigoVerificacion(receta.Codigo),
+			"fecha":               receta.Fecha.Format("2006-01-02"),
+			"medico":              receta.Medico,
+			"paciente":            receta.Paciente,
+			"consultorio":         receta.Consultorio,
+			"instrucciones":       receta.Instrucciones,
+			"items":               receta.Items,
+		},
+		"surtido":        surtido,
+		"dispensaciones": dispensaciones,
+	})
+}
+
+// DispensarReceta registra un surtido de la receta. Falla con 409 si la receta venció o ya se
+// usaron el surtido original y todos sus resurtidos.
+func DispensarReceta(c *fiber.Ctx) error {
+	codigo := normalizarCodigoVerificacion(c.Params("codigo"))
+	if len(codigo) != longitudCodigoVerificacion {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Código de verificación inválido",
+		})
+	}
+
+	var req models.DispensacionRequest
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	farmaceuticoID := c.Locals("user_id").(int)
+	ctx := context.Background()
+
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al surtir la receta",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	// El bloqueo se toma antes de revisar deleted_at para no surtir una receta que se está
+	// modificando o eliminando
+	var idReceta int
+	err = tx.QueryRow(ctx, "SELECT id_receta FROM Receta WHERE codigo_verificacion = $1 AND deleted_at IS NULL FOR UPDATE", codigo).Scan(&idReceta)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "No existe una receta con ese código de verificación",
+		})
+	}
+
+	surtido, err := obtenerSurtidoReceta(ctx, tx, idReceta, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al surtir la receta",
+		})
+	}
+	switch surtido.Estado {
+	case models.EstadoSurtidoSurtida:
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "La receta ya fue surtida y no le quedan resurtidos",
+			"surtido": surtido,
+		})
+	case models.EstadoSurtidoVencida:
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "La receta está vencida",
+			"surtido": surtido,
+		})
+	}
+
+	recetados, err := obtenerItemsReceta(ctx, tx, idReceta)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al surtir la receta",
+		})
+	}
+	items, err := itemsDispensacion(recetados, req.Items)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al surtir la receta")
+	}
+
+	dispensacion := models.Dispensacion{
+		IDReceta:            idReceta,
+		IDFarmaceutico:      farmaceuticoID,
+		Numero:              surtido.Surtidos + 1,
+		ResurtidosRestantes: surtido.SurtidosRestantes - 1,
+		Observaciones:       strings.TrimSpace(req.Observaciones),
+		Items:               items,
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO Dispensacion (id_receta, id_farmaceutico, numero, resurtidos_restantes, observaciones)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id_dispensacion, fecha`,
+		dispensacion.IDReceta, dispensacion.IDFarmaceutico, dispensacion.Numero,
+		dispensacion.ResurtidosRestantes, dispensacion.Observaciones).Scan(&dispensacion.IDDispensacion, &dispensacion.Fecha)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al surtir la receta",
+		})
+	}
+	for _, item := range items {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO DispensacionItem (id_dispensacion, id_item, medicamento, cantidad) VALUES ($1, $2, $3, $4)`,
+			dispensacion.IDDispensacion, item.IDItem, item.Medicamento, item.Cantidad)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al surtir la receta",
+			})
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al surtir la receta",
+		})
+	}
+
+	surtido.Surtidos++
+	surtido.SurtidosRestantes--
+	if surtido.SurtidosRestantes == 0 {
+		surtido.Estado = models.EstadoSurtidoSurtida
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"dispensacion": dispensacion,
+		"surtido":      surtido,
+		"mensaje":      "Receta surtida exitosamente",
+	})
+}
+
+// itemsDispensacion valida lo que se entrega contra los renglones de la receta. Sin renglones
+// pedidos se entregan todos con la cantidad recetada; la cantidad no puede exceder la recetada.
+func itemsDispensacion(recetados []models.RecetaItem, pedidos []models.DispensacionItemRequest) ([]models.DispensacionItem, error) {
+	if len(pedidos) == 0 {
+		items := make([]models.DispensacionItem, len(recetados))
+		for i, r := range recetados {
+			items[i] = models.DispensacionItem{IDItem: r.IDItem, Medicamento: r.Medicamento, Cantidad: r.Cantidad}
+		}
+		return items, nil
+	}
+
+	porID := make(map[int]models.RecetaItem, len(recetados))
+	for _, r := range recetados {
+		porID[r.IDItem] = r
+	}
+	vistos := make(map[int]bool)
+	items := make([]models.DispensacionItem, 0, len(pedidos))
+	for _, p := range pedidos {
+		r, ok := porID[p.IDItem]
+		if !ok {
+			return nil, &errorConsulta{400, fmt.Sprintf("El medicamento %d no pertenece a la receta", p.IDItem)}
+		}
+		if vistos[p.IDItem] {
+			return nil, &errorConsulta{400, fmt.Sprintf("El medicamento %d está repetido", p.IDItem)}
+		}
+		vistos[p.IDItem] = true
+
+		cantidad := p.Cantidad
+		if cantidad == nil {
+			cantidad = r.Cantidad
+		}
+		if cantidad != nil && *cantidad <= 0 {
+			return nil, &errorConsulta{400, "La cantidad entregada debe ser mayor que cero"}
+		}
+		if cantidad != nil && r.Cantidad != nil && *cantidad > *r.Cantidad {
+			return nil, &errorConsulta{400, fmt.Sprintf("La cantidad de %s excede la recetada (%d)", r.Medicamento, *r.Cantidad)}
+		}
+		items = append(items, models.DispensacionItem{IDItem: r.IDItem, Medicamento: r.Medicamento, Cantidad: cantidad})
+	}
+	return items, nil
+}
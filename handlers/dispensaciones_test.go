@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lizet96/hospital-backend/models"
+)
+
+func TestItemsDispensacion(t *testing.T) {
+	cantidad := func(n int) *int { return &n }
+	recetados := []models.RecetaItem{
+		{IDItem: 1, Medicamento: "Amoxicilina 500 mg", Cantidad: cantidad(21)},
+		{IDItem: 2, Medicamento: "Paracetamol 500 mg", Cantidad: cantidad(10)},
+		{IDItem: 3, Medicamento: "Crema hidratante"},
+	}
+
+	casos := []struct {
+		nombre     string
+		pedidos    []models.DispensacionItemRequest
+		entregados map[int]*int
+		status     int // 0 si no hay error
+	}{
+		{
+			nombre:     "sin pedidos se entrega todo",
+			entregados: map[int]*int{1: cantidad(21), 2: cantidad(10), 3: nil},
+		},
+		{
+			nombre:     "entrega parcial",
+			pedidos:    []models.DispensacionItemRequest{{IDItem: 1, Cantidad: cantidad(7)}},
+			entregados: map[int]*int{1: cantidad(7)},
+		},
+		{
+			nombre:     "sin cantidad se entrega la recetada",
+			pedidos:    []models.DispensacionItemRequest{{IDItem: 2}, {IDItem: 3}},
+			entregados: map[int]*int{2: cantidad(10), 3: nil},
+		},
+		{
+			nombre:     "sin cantidad recetada no hay límite",
+			pedidos:    []models.DispensacionItemRequest{{IDItem: 3, Cantidad: cantidad(2)}},
+			entregados: map[int]*int{3: cantidad(2)},
+		},
+		{
+			nombre:  "excede la recetada",
+			pedidos: []models.DispensacionItemRequest{{IDItem: 2, Cantidad: cantidad(11)}},
+			status:  400,
+		},
+		{
+			nombre:  "cantidad cero",
+			pedidos: []models.DispensacionItemRequest{{IDItem: 1, Cantidad: cantidad(0)}},
+			status:  400,
+		},
+		{
+			nombre:  "renglón de otra receta",
+			pedidos: []models.DispensacionItemRequest{{IDItem: 9}},
+			status:  400,
+		},
+		{
+			nombre:  "renglón repetido",
+			pedidos: []models.DispensacionItemRequest{{IDItem: 1, Cantidad: cantidad(1)}, {IDItem: 1, Cantidad: cantidad(1)}},
+			status:  400,
+		},
+	}
+	for _, caso := range casos {
+		items, err := itemsDispensacion(recetados, caso.pedidos)
+		if caso.status != 0 {
+			var errConsulta *errorConsulta
+			if !errors.As(err, &errConsulta) || errConsulta.status != caso.status {
+				t.Errorf("%s: error = %v, se esperaba un error %d", caso.nombre, err, caso.status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error inesperado: %v", caso.nombre, err)
+			continue
+		}
+
+		if len(items) != len(caso.entregados) {
+			t.Errorf("%s: %d renglones entregados, se esperaban %d", caso.nombre, len(items), len(caso.entregados))
+			continue
+		}
+		for _, item := range items {
+			esperada, ok := caso.entregados[item.IDItem]
+			if !ok {
+				t.Errorf("%s: se entregó el renglón %d", caso.nombre, item.IDItem)
+				continue
+			}
+			if (item.Cantidad == nil) != (esperada == nil) || (esperada != nil && *item.Cantidad != *esperada) {
+				t.Errorf("%s: renglón %d con cantidad %v, se esperaba %v", caso.nombre, item.IDItem, item.Cantidad, esperada)
+			}
+		}
+	}
+}
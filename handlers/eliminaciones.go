@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// maxLongitudMotivoEliminacion limita el texto del motivo de eliminación o restauración
+const maxLongitudMotivoEliminacion = 500
+
+// ejecutorSQL es la parte común del pool y de una transacción para ejecutar sentencias
+type ejecutorSQL interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// registroEliminable describe la tabla de cada tipo de registro con borrado lógico y cómo se
+// resume en el listado de eliminados (t es la tabla y p el paciente, si se une)
+type registroEliminable struct {
+	tabla        string
+	columnaID    string
+	union        string
+	descripcion  string
+	noEncontrado string
+}
+
+// tiposEliminables fija el orden en que se consultan los tipos de registro
+var tiposEliminables = []string{
+	models.EliminadoExpediente, models.EliminadoReceta, models.EliminadoConsulta, models.EliminadoUsuario,
+}
+
+var registrosEliminables = map[string]registroEliminable{
+	models.EliminadoExpediente: {
+		tabla:        "Expediente",
+		columnaID:    "id_expediente",
+		union:        "JOIN Usuario p ON t.id_paciente = p.id_usuario",
+		descripcion:  "CONCAT('Expediente de ', p.nombre, ' ', p.apellido)",
+		noEncontrado: "Expediente no encontrado",
+	},
+	models.EliminadoReceta: {
+		tabla:        "Receta",
+		columnaID:    "id_receta",
+		union:        "JOIN Usuario p ON t.id_paciente = p.id_usuario",
+		descripcion:  "CONCAT('Receta del ', TO_CHAR(t.fecha, 'YYYY-MM-DD'), ' de ', p.nombre, ' ', p.apellido)",
+		noEncontrado: "Receta no encontrada",
+	},
+	models.EliminadoConsulta: {
+		tabla:        "Consulta",
+		columnaID:    "id_consulta",
+		union:        "JOIN Usuario p ON t.id_paciente = p.id_usuario",
+		descripcion:  "CONCAT('Consulta ', t.tipo, ' (', t.estado, ') de ', p.nombre, ' ', p.apellido)",
+		noEncontrado: "Consulta no encontrada",
+	},
+	models.EliminadoUsuario: {
+		tabla:        "Usuario",
+		columnaID:    "id_usuario",
+		descripcion:  "CONCAT(t.nombre, ' ', t.apellido, ' <', t.email, '>')",
+		noEncontrado: "Usuario no encontrado",
+	},
+}
+
+// motivoEliminacion lee el motivo del cuerpo de la solicitud o, si no hay cuerpo, de ?motivo=.
+// Al eliminar el motivo es obligatorio; al restaurar es opcional.
+func motivoEliminacion(c *fiber.Ctx, obligatorio bool) (string, error) {
+	var req models.EliminacionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return "", &errorConsulta{400, "Datos inválidos"}
+		}
+	} else {
+		req.Motivo = c.Query("motivo")
+	}
+
+	motivo := strings.TrimSpace(req.Motivo)
+	if obligatorio && motivo == "" {
+		return "", &errorConsulta{400, "El motivo de la eliminación es obligatorio"}
+	}
+	if len([]rune(motivo)) > maxLongitudMotivoEliminacion {
+		return "", &errorConsulta{400, fmt.Sprintf("El motivo no puede exceder %d caracteres", maxLongitudMotivoEliminacion)}
+	}
+	return motivo, nil
+}
+
+// eliminarRegistro marca el registro como eliminado con quién, cuándo y por qué, y lo anota en
+// el historial. Devuelve un errorConsulta 404 si no existe o ya estaba eliminado.
+func eliminarRegistro(ctx context.Context, q ejecutorSQL, tipo string, id, userID int, motivo string) error {
+	registro := registrosEliminables[tipo]
+	ahora := time.Now()
+	result, err := q.Exec(ctx,
+		fmt.Sprintf(`UPDATE %s SET deleted_at = $1, deleted_by = $2, motivo_eliminacion = $3
+		             WHERE %s = $4 AND deleted_at IS NULL`, registro.tabla, registro.columnaID),
+		ahora, userID, motivo, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return &errorConsulta{404, registro.noEncontrado}
+	}
+	return registrarHistorialEliminacion(ctx, q, tipo, id, "eliminar", userID, motivo, ahora)
+}
+
+// registrarHistorialEliminacion anota una eliminación o restauración. Las columnas deleted_*
+// solo reflejan la eliminación vigente; el historial conserva todas.
+func registrarHistorialEliminacion(ctx context.Context, q ejecutorSQL, tipo string, id int, accion string,
+	userID int, motivo string, fecha time.Time) error {
+	_, err := q.Exec(ctx,
+		`INSERT INTO EliminacionHistorial (tipo, id_registro, accion, id_usuario, motivo, fecha)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)`,
+		tipo, id, accion, userID, motivo, fecha)
+	return err
+}
+
+// ObtenerEliminados lista los registros eliminados, del más reciente al más antiguo, con quién
+// los eliminó y el motivo (solo admin). Filtro opcional ?tipo=expediente|receta|consulta|usuario.
+func ObtenerEliminados(c *fiber.Ctx) error {
+	tipos := tiposEliminables
+	if tipo := c.Query("tipo"); tipo != "" {
+		if _, ok := registrosEliminables[tipo]; !ok {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Tipo inválido. Use expediente, receta, consulta o usuario",
+			})
+		}
+		tipos = []string{tipo}
+	}
+
+	var consultas []string
+	for _, tipo := range tipos {
+		registro := registrosEliminables[tipo]
+		consultas = append(consultas, fmt.Sprintf(
+			`SELECT '%s' AS tipo, t.%s AS id, %s AS descripcion, t.deleted_at, t.deleted_by, e.nombre,
+			        COALESCE(t.motivo_eliminacion, '')
+			 FROM %s t %s
+			 LEFT JOIN Usuario e ON t.deleted_by = e.id_usuario
+			 WHERE t.deleted_at IS NOT NULL`,
+			tipo, registro.columnaID, registro.descripcion, registro.tabla, registro.union))
+	}
+
+	rows, err := database.GetDB().Query(context.Background(),
+		strings.Join(consultas, " UNION ALL ")+" ORDER BY deleted_at DESC LIMIT 500")
+	if err != nil {
+		log.Printf("Error al obtener los registros eliminados: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener los registros eliminados",
+		})
+	}
+	defer rows.Close()
+
+	eliminados := []models.RegistroEliminado{}
+	for rows.Next() {
+		var r models.RegistroEliminado
+		if err := rows.Scan(&r.Tipo, &r.ID, &r.Descripcion, &r.DeletedAt, &r.DeletedBy, &r.EliminadoPor,
+			&r.MotivoEliminacion); err != nil {
+			continue
+		}
+		eliminados = append(eliminados, r)
+	}
+
+	return c.JSON(fiber.Map{
+		"eliminados": eliminados,
+		"total":      len(eliminados),
+	})
+}
+
+// RestaurarEliminado restaura un registro eliminado (solo admin). La restauración queda en el
+// historial con quién la hizo y el motivo, si se indica.
+func RestaurarEliminado(c *fiber.Ctx) error {
+	tipo := c.Params("tipo")
+	registro, ok := registrosEliminables[tipo]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Tipo inválido. Use expediente, receta, consulta o usuario",
+		})
+	}
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+	motivo, err := motivoEliminacion(c, false)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al restaurar el registro")
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx,
+		fmt.Sprintf(`UPDATE %s SET deleted_at = NULL, deleted_by = NULL, motivo_eliminacion = NULL
+		             WHERE %s = $1 AND deleted_at IS NOT NULL`, registro.tabla, registro.columnaID), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al restaurar el registro",
+		})
+	}
+	if result.RowsAffected() == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": registro.noEncontrado + " entre los eliminados",
+		})
+	}
+
+	userID := c.Locals("user_id").(int)
+	if err := registrarHistorialEliminacion(ctx, tx, tipo, id, "restaurar", userID, motivo, time.Now()); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al restaurar el registro",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al restaurar el registro",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Registro restaurado exitosamente",
+		"tipo":    tipo,
+		"id":      id,
+	})
+}
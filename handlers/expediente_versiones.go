@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// camposModificadosExpediente devuelve los campos versionados cuyo valor cambió, en el orden
+// de models.CamposExpedienteVersionados
+func camposModificadosExpediente(anterior, nueva models.ExpedienteVersion) []string {
+	var campos []string
+	for _, cambio := range models.CompararCamposExpediente(anterior, nueva) {
+		if cambio.Modificado {
+			campos = append(campos, cambio.Campo)
+		}
+	}
+	return campos
+}
+
+// registrarVersionExpediente guarda el contenido del expediente como su siguiente versión y
+// devuelve el número asignado. El expediente debe estar bloqueado (o recién creado) dentro de
+// la transacción para que dos cambios simultáneos no tomen el mismo número.
+func registrarVersionExpediente(ctx context.Context, tx pgx.Tx, version models.ExpedienteVersion) (int, error) {
+	var numero int
+	err := tx.QueryRow(ctx,
+		`INSERT INTO ExpedienteVersion (id_expediente, numero, antecedentes, historial_clinico, seguro,
+		                                campos_modificados, id_usuario, created_at)
+		 SELECT $1, COALESCE(MAX(numero), 0) + 1, $2, $3, $4, $5, $6, $7
+		 FROM ExpedienteVersion WHERE id_expediente = $1
+		 RETURNING numero`,
+		version.IDExpediente, version.Antecedentes, version.HistorialClinico, version.Seguro,
+		strings.Join(version.CamposModificados, ","), version.IDUsuario, time.Now()).Scan(&numero)
+	return numero, err
+}
+
+// accesoExpediente verifica que el expediente exista y que el usuario tenga el permiso sobre su
+// paciente, con las mismas reglas que ObtenerExpedientePorID (ver puedeVerPaciente)
+func accesoExpediente(ctx context.Context, usuario actor, permiso string, idExpediente int) error {
+	var idPaciente int
+	err := database.GetDB().QueryRow(ctx,
+		"SELECT id_paciente FROM Expediente WHERE id_expediente = $1 AND deleted_at IS NULL", idExpediente).Scan(&idPaciente)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &errorConsulta{404, "Expediente no encontrado"}
+	}
+	if err != nil {
+		return err
+	}
+
+	if permitido, err := puedeVerPaciente(ctx, usuario, permiso, idPaciente); err != nil || permitido {
+		return err
+	}
+	return &errorConsulta{403, "No tienes acceso a este expediente"}
+}
+
+// columnasVersionExpediente son las columnas que lee escanearVersionExpediente
+const columnasVersionExpediente = `v.id_version, v.id_expediente, v.numero, v.antecedentes, v.historial_clinico,
+	v.seguro, v.campos_modificados, v.id_usuario, u.nombre, v.created_at`
+
+// escanearVersionExpediente lee una versión con el nombre de quien hizo el cambio
+func escanearVersionExpediente(fila pgx.Row) (models.ExpedienteVersion, error) {
+	var v models.ExpedienteVersion
+	var campos string
+	err := fila.Scan(&v.IDVersion, &v.IDExpediente, &v.Numero, &v.Antecedentes, &v.HistorialClinico,
+		&v.Seguro, &campos, &v.IDUsuario, &v.UsuarioNombre, &v.CreatedAt)
+	v.CamposModificados = []string{}
+	if campos != "" {
+		v.CamposModificados = strings.Split(campos, ",")
+	}
+	return v, err
+}
+
+// obtenerVersionExpediente obtiene una versión del expediente por su número. Con el número 0
+// devuelve una versión vacía, que sirve como punto de partida al comparar la versión 1.
+func obtenerVersionExpediente(ctx context.Context, idExpediente, numero int) (models.ExpedienteVersion, error) {
+	if numero == 0 {
+		return models.ExpedienteVersion{IDExpediente: idExpediente, CamposModificados: []string{}}, nil
+	}
+	version, err := escanearVersionExpediente(database.GetDB().QueryRow(ctx,
+		`SELECT `+columnasVersionExpediente+`
+		 FROM ExpedienteVersion v
+		 LEFT JOIN Usuario u ON v.id_usuario = u.id_usuario
+		 WHERE v.id_expediente = $1 AND v.numero = $2`, idExpediente, numero))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return version, &errorConsulta{404, "Versión no encontrada"}
+	}
+	return version, err
+}
+
+// ObtenerVersionesExpediente lista las versiones del expediente, de la más reciente a la más
+// antigua, con quién hizo cada cambio y qué campos modificó
+func ObtenerVersionesExpediente(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	ctx := context.Background()
+	if err := accesoExpediente(ctx, actorDe(c), "expedientes_read", id); err != nil {
+		return responderErrorConsulta(c, err, "Error al obtener el expediente")
+	}
+
+	rows, err := database.GetDB().Query(ctx,
+		`SELECT `+columnasVersionExpediente+`
+		 FROM ExpedienteVersion v
+		 LEFT JOIN Usuario u ON v.id_usuario = u.id_usuario
+		 WHERE v.id_expediente = $1
+		 ORDER BY v.numero DESC`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener las versiones del expediente",
+		})
+	}
+	defer rows.Close()
+
+	versiones := []models.ExpedienteVersion{}
+	for rows.Next() {
+		version, err := escanearVersionExpediente(rows)
+		if err != nil {
+			continue
+		}
+		versiones = append(versiones, version)
+	}
+
+	return c.JSON(fiber.Map{
+		"id_expediente": id,
+		"versiones":     versiones,
+		"total":         len(versiones),
+	})
+}
+
+// ObtenerVersionExpediente obtiene el contenido del expediente en una versión y los cambios
+// respecto a la versión anterior
+func ObtenerVersionExpediente(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+	numero, err := strconv.Atoi(c.Params("numero"))
+	if err != nil || numero < 1 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Número de versión inválido",
+		})
+	}
+
+	ctx := context.Background()
+	if err := accesoExpediente(ctx, actorDe(c), "expedientes_read", id); err != nil {
+		return responderErrorConsulta(c, err, "Error al obtener el expediente")
+	}
+
+	version, err := obtenerVersionExpediente(ctx, id, numero)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al obtener la versión del expediente")
+	}
+	anterior, err := obtenerVersionExpediente(ctx, id, numero-1)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al obtener la versión del expediente")
+	}
+
+	cambios := []models.CambioCampoExpediente{}
+	for _, cambio := range models.CompararCamposExpediente(anterior, version) {
+		if cambio.Modificado {
+			cambios = append(cambios, cambio)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"version": version,
+		"cambios": cambios,
+	})
+}
+
+// CompararVersionesExpediente compara campo por campo dos versiones del expediente
+// (?desde=1&hasta=3). Sin hasta se compara contra la versión más reciente.
+func CompararVersionesExpediente(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	ctx := context.Background()
+	if err := accesoExpediente(ctx, actorDe(c), "expedientes_read", id); err != nil {
+		return responderErrorConsulta(c, err, "Error al obtener el expediente")
+	}
+
+	desde := c.QueryInt("desde")
+	hasta := c.QueryInt("hasta")
+	if hasta == 0 {
+		if err := database.GetDB().QueryRow(ctx,
+			"SELECT COALESCE(MAX(numero), 0) FROM ExpedienteVersion WHERE id_expediente = $1", id).Scan(&hasta); err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al obtener las versiones del expediente",
+			})
+		}
+	}
+	if desde < 1 || hasta < 1 || desde == hasta {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Indique dos números de versión distintos en desde y hasta",
+		})
+	}
+
+	anterior, err := obtenerVersionExpediente(ctx, id, desde)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al obtener la versión del expediente")
+	}
+	nueva, err := obtenerVersionExpediente(ctx, id, hasta)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al obtener la versión del expediente")
+	}
+
+	return c.JSON(fiber.Map{
+		"desde":   anterior,
+		"hasta":   nueva,
+		"cambios": models.CompararCamposExpediente(anterior, nueva),
+	})
+}
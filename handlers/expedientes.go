@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 	"github.com/lizet96/hospital-backend/database"
 	"github.com/lizet96/hospital-backend/models"
 )
@@ -19,11 +21,10 @@ func CrearExpediente(c *fiber.Ctx) error {
 		})
 	}
 
-	// Solo médicos y admin pueden crear expedientes
-	userRole := c.Locals("user_role").(string)
-	if userRole != "medico" && userRole != "admin" {
+	// Verificar que el usuario pueda crear el expediente de este paciente
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "expedientes_create", expediente.IDPaciente); err != nil || !permitido {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos pueden crear expedientes",
+			"error": "No puedes crear el expediente de este paciente",
 		})
 	}
 
@@ -39,24 +40,40 @@ func CrearExpediente(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verificar que no exista ya un expediente para este paciente
-	var existeExpediente int
+	// Verificar que no exista ya un expediente para este paciente; si fue eliminado se restaura
+	// en lugar de crear otro
+	var existeExpediente, eliminados int
 	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Expediente WHERE id_paciente = $1", expediente.IDPaciente).Scan(&existeExpediente)
+		"SELECT COUNT(*), COUNT(deleted_at) FROM Expediente WHERE id_paciente = $1",
+		expediente.IDPaciente).Scan(&existeExpediente, &eliminados)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error interno del servidor",
 		})
 	}
-	if existeExpediente > 0 {
+	if existeExpediente > eliminados {
 		return c.Status(409).JSON(fiber.Map{
 			"error": "Ya existe un expediente para este paciente",
 		})
 	}
+	if eliminados > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "El paciente tiene un expediente eliminado; un administrador puede restaurarlo",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
 
 	// Crear expediente
 	var nuevoID int
-	err = database.GetDB().QueryRow(context.Background(),
+	err = tx.QueryRow(ctx,
 		`INSERT INTO Expediente (antecedentes, historial_clinico, seguro, id_paciente, created_at, updated_at)
 		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_expediente`,
 		expediente.Antecedentes, expediente.HistorialClinico, expediente.Seguro, expediente.IDPaciente,
@@ -68,6 +85,28 @@ func CrearExpediente(c *fiber.Ctx) error {
 		})
 	}
 
+	// La versión 1 guarda el contenido con el que se creó el expediente
+	userID := c.Locals("user_id").(int)
+	version := models.ExpedienteVersion{
+		IDExpediente:     nuevoID,
+		Antecedentes:     expediente.Antecedentes,
+		HistorialClinico: expediente.HistorialClinico,
+		Seguro:           expediente.Seguro,
+		IDUsuario:        &userID,
+	}
+	version.CamposModificados = camposModificadosExpediente(models.ExpedienteVersion{}, version)
+	if _, err := registrarVersionExpediente(ctx, tx, version); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear expediente",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear expediente",
+		})
+	}
+
 	return c.Status(201).JSON(fiber.Map{
 		"mensaje":       "Expediente creado exitosamente",
 		"id_expediente": nuevoID,
@@ -76,43 +115,29 @@ func CrearExpediente(c *fiber.Ctx) error {
 
 // ObtenerExpedientes obtiene expedientes según permisos del usuario
 func ObtenerExpedientes(c *fiber.Ctx) error {
-	userID := c.Locals("user_id").(int)
-	userRole := c.Locals("user_role").(string)
+	usuario := actorDe(c)
 
-	var query string
+	query := `SELECT e.id_expediente, e.antecedentes, e.historial_clinico, e.seguro,
+			 e.id_paciente, e.created_at, e.updated_at, u.nombre as paciente_nombre
+			 FROM Expediente e
+			 JOIN Usuario u ON e.id_paciente = u.id_usuario
+			 WHERE e.deleted_at IS NULL`
 	var args []interface{}
 
-	switch userRole {
-	case "admin":
-		// Admin puede ver todos los expedientes
-		query = `SELECT e.id_expediente, e.antecedentes, e.historial_clinico, e.seguro, 
-				 e.id_paciente, e.created_at, e.updated_at, u.nombre as paciente_nombre
-				 FROM Expediente e
-				 JOIN Usuario u ON e.id_paciente = u.id_usuario
-				 ORDER BY e.created_at DESC`
-	case "medico":
-		// Médico puede ver expedientes de sus pacientes (que han tenido consultas con él)
-		query = `SELECT DISTINCT e.id_expediente, e.antecedentes, e.historial_clinico, e.seguro,
-				 e.id_paciente, e.created_at, e.updated_at, u.nombre as paciente_nombre
-				 FROM Expediente e
-				 JOIN Usuario u ON e.id_paciente = u.id_usuario
-				 JOIN Consulta c ON e.id_paciente = c.id_paciente
-				 WHERE c.id_medico = $1
-				 ORDER BY e.created_at DESC`
-		args = append(args, userID)
-	case "paciente":
-		// Paciente solo puede ver su propio expediente
-		query = `SELECT e.id_expediente, e.antecedentes, e.historial_clinico, e.seguro,
-				 e.id_paciente, e.created_at, e.updated_at, u.nombre as paciente_nombre
-				 FROM Expediente e
-				 JOIN Usuario u ON e.id_paciente = u.id_usuario
-				 WHERE e.id_paciente = $1`
-		args = append(args, userID)
+	switch usuario.alcance("expedientes_read") {
+	case alcanceTodos:
+		// Todos los expedientes
+	case alcancePropios:
+		// El propio expediente y los de los pacientes que han tenido consultas con el usuario
+		args = append(args, usuario.id)
+		query += ` AND (e.id_paciente = $1 OR EXISTS (SELECT 1 FROM Consulta c
+				 WHERE c.id_paciente = e.id_paciente AND c.id_medico = $1 AND c.deleted_at IS NULL))`
 	default:
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Tipo de usuario no autorizado",
+			"error": "No tienes acceso a los expedientes",
 		})
 	}
+	query += " ORDER BY e.created_at DESC"
 
 	rows, err := database.GetDB().Query(context.Background(), query, args...)
 	if err != nil {
@@ -154,9 +179,6 @@ func ObtenerExpedientePorID(c *fiber.Ctx) error {
 		})
 	}
 
-	userID := c.Locals("user_id").(int)
-	userRole := c.Locals("user_role").(string)
-
 	// Obtener expediente
 	var expediente models.Expediente
 	var pacienteNombre string
@@ -165,7 +187,7 @@ func ObtenerExpedientePorID(c *fiber.Ctx) error {
 		 e.id_paciente, e.created_at, e.updated_at, u.nombre
 		 FROM Expediente e
 		 JOIN Usuario u ON e.id_paciente = u.id_usuario
-		 WHERE e.id_expediente = $1`, id).Scan(
+		 WHERE e.id_expediente = $1 AND e.deleted_at IS NULL`, id).Scan(
 		&expediente.ID, &expediente.Antecedentes, &expediente.HistorialClinico,
 		&expediente.Seguro, &expediente.IDPaciente, &expediente.CreatedAt,
 		&expediente.UpdatedAt, &pacienteNombre)
@@ -177,40 +199,32 @@ func ObtenerExpedientePorID(c *fiber.Ctx) error {
 	}
 
 	// Verificar permisos
-	switch userRole {
-	case "admin":
-		// Admin puede ver cualquier expediente
-	case "medico":
-		// Médico solo puede ver expedientes de sus pacientes
-		var tieneAcceso int
-		err = database.GetDB().QueryRow(context.Background(),
-			"SELECT COUNT(*) FROM Consulta WHERE id_paciente = $1 AND id_medico = $2",
-			expediente.IDPaciente, userID).Scan(&tieneAcceso)
-		if err != nil || tieneAcceso == 0 {
-			return c.Status(403).JSON(fiber.Map{
-				"error": "No tienes acceso a este expediente",
-			})
-		}
-	case "paciente":
-		// Paciente solo puede ver su propio expediente
-		if expediente.IDPaciente != userID {
-			return c.Status(403).JSON(fiber.Map{
-				"error": "No tienes acceso a este expediente",
-			})
-		}
-	default:
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "expedientes_read", expediente.IDPaciente); err != nil || !permitido {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Tipo de usuario no autorizado",
+			"error": "No tienes acceso a este expediente",
 		})
 	}
 
+	// Línea de tiempo con las notas clínicas vigentes de las consultas del paciente
+	timeline, err := obtenerNotasClinicas(context.Background(), "n.id_paciente = $1", expediente.IDPaciente)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener las notas clínicas del expediente",
+		})
+	}
+	if timeline == nil {
+		timeline = []NotaClinicaVigente{}
+	}
+
 	return c.JSON(fiber.Map{
 		"expediente":      expediente,
 		"paciente_nombre": pacienteNombre,
+		"timeline":        timeline,
 	})
 }
 
-// ActualizarExpediente actualiza un expediente existente
+// ActualizarExpediente actualiza un expediente existente. El contenido anterior no se pierde:
+// cada cambio queda como una nueva versión con quién lo hizo y los campos modificados.
 func ActualizarExpediente(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -219,46 +233,62 @@ func ActualizarExpediente(c *fiber.Ctx) error {
 		})
 	}
 
-	// Solo médicos y admin pueden actualizar expedientes
-	userRole := c.Locals("user_role").(string)
-	if userRole != "medico" && userRole != "admin" {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos pueden actualizar expedientes",
+	// Verificar que el usuario pueda actualizar el expediente
+	usuario := actorDe(c)
+	userID := usuario.id
+	ctx := context.Background()
+	if err := accesoExpediente(ctx, usuario, "expedientes_update", id); err != nil {
+		return responderErrorConsulta(c, err, "Error interno del servidor")
+	}
+
+	var expediente models.Expediente
+	if err := c.BodyParser(&expediente); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
 		})
 	}
 
-	// Si es médico, verificar que tenga acceso al expediente
-	if userRole == "medico" {
-		userID := c.Locals("user_id").(int)
-		var idPaciente int
-		err := database.GetDB().QueryRow(context.Background(),
-			"SELECT id_paciente FROM Expediente WHERE id_expediente = $1", id).Scan(&idPaciente)
-		if err != nil {
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	// Bloquear el expediente para comparar contra su contenido actual y numerar la versión
+	anterior := models.ExpedienteVersion{IDExpediente: id}
+	err = tx.QueryRow(ctx,
+		`SELECT COALESCE(antecedentes, ''), COALESCE(historial_clinico, ''), COALESCE(seguro, '')
+		 FROM Expediente WHERE id_expediente = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(
+		&anterior.Antecedentes, &anterior.HistorialClinico, &anterior.Seguro)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return c.Status(404).JSON(fiber.Map{
 				"error": "Expediente no encontrado",
 			})
 		}
-
-		var tieneAcceso int
-		err = database.GetDB().QueryRow(context.Background(),
-			"SELECT COUNT(*) FROM Consulta WHERE id_paciente = $1 AND id_medico = $2",
-			idPaciente, userID).Scan(&tieneAcceso)
-		if err != nil || tieneAcceso == 0 {
-			return c.Status(403).JSON(fiber.Map{
-				"error": "No tienes acceso a este expediente",
-			})
-		}
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar expediente",
+		})
 	}
 
-	var expediente models.Expediente
-	if err := c.BodyParser(&expediente); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Datos inválidos",
+	version := models.ExpedienteVersion{
+		IDExpediente:     id,
+		Antecedentes:     expediente.Antecedentes,
+		HistorialClinico: expediente.HistorialClinico,
+		Seguro:           expediente.Seguro,
+		IDUsuario:        &userID,
+	}
+	version.CamposModificados = camposModificadosExpediente(anterior, version)
+	if len(version.CamposModificados) == 0 {
+		return c.JSON(fiber.Map{
+			"mensaje": "El expediente no tiene cambios",
 		})
 	}
 
 	// Actualizar expediente
-	_, err = database.GetDB().Exec(context.Background(),
+	_, err = tx.Exec(ctx,
 		`UPDATE Expediente SET antecedentes = $1, historial_clinico = $2, seguro = $3, updated_at = $4
 		 WHERE id_expediente = $5`,
 		expediente.Antecedentes, expediente.HistorialClinico, expediente.Seguro, time.Now(), id)
@@ -269,8 +299,23 @@ func ActualizarExpediente(c *fiber.Ctx) error {
 		})
 	}
 
+	numero, err := registrarVersionExpediente(ctx, tx, version)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar expediente",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar expediente",
+		})
+	}
+
 	return c.JSON(fiber.Map{
-		"mensaje": "Expediente actualizado exitosamente",
+		"mensaje":            "Expediente actualizado exitosamente",
+		"version":            numero,
+		"campos_modificados": version.CamposModificados,
 	})
 }
 
@@ -283,13 +328,10 @@ func ObtenerExpedientePorPaciente(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
-
 	// Verificar permisos
-	if userRole == "paciente" && pacienteID != userID {
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "expedientes_read", pacienteID); err != nil || !permitido {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "No puedes ver los expedientes de otro paciente",
+			"error": "No puedes ver los expedientes de este paciente",
 		})
 	}
 
@@ -300,7 +342,7 @@ func ObtenerExpedientePorPaciente(c *fiber.Ctx) error {
 		       u.nombre as nombre_paciente, u.email
 		FROM Expediente e
 		JOIN Usuario u ON e.id_paciente = u.id_usuario
-		WHERE e.id_paciente = $1
+		WHERE e.id_paciente = $1 AND e.deleted_at IS NULL
 		ORDER BY e.fecha_creacion DESC`
 
 	rows, err := database.GetDB().Query(context.Background(), query, pacienteID)
@@ -338,7 +380,8 @@ func ObtenerExpedientePorPaciente(c *fiber.Ctx) error {
 	})
 }
 
-// EliminarExpediente elimina un expediente médico
+// EliminarExpediente elimina un expediente médico. El borrado es lógico: el expediente y sus
+// versiones se conservan con quién lo eliminó, cuándo y el motivo, y un admin puede restaurarlo.
 func EliminarExpediente(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -347,31 +390,33 @@ func EliminarExpediente(c *fiber.Ctx) error {
 		})
 	}
 
-	// Solo admin puede eliminar expedientes
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" {
+	// Eliminar expedientes no depende de la relación con el paciente: exige expedientes_delete_any
+	if !actorDe(c).puede("expedientes_delete") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden eliminar expedientes",
+			"error": "No tienes permisos para eliminar expedientes",
 		})
 	}
 
-	// Verificar que el expediente existe
-	var existe int
-	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Expediente WHERE id_expediente = $1", id).Scan(&existe)
-	if err != nil || existe == 0 {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Expediente no encontrado",
-		})
+	motivo, err := motivoEliminacion(c, true)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar expediente")
 	}
 
-	// Eliminar expediente
-	_, err = database.GetDB().Exec(context.Background(),
-		"DELETE FROM Expediente WHERE id_expediente = $1", id)
+	// Eliminar expediente y registrarlo en el historial en la misma transacción
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al eliminar expediente",
-		})
+		return responderErrorConsulta(c, err, "Error al eliminar expediente")
+	}
+	defer tx.Rollback(ctx)
+
+	err = eliminarRegistro(ctx, tx, models.EliminadoExpediente, id, c.Locals("user_id").(int), motivo)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar expediente")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar expediente")
 	}
 
 	return c.JSON(fiber.Map{
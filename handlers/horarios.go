@@ -2,21 +2,25 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/lizet96/hospital-backend/database"
 	"github.com/lizet96/hospital-backend/models"
 )
 
 // CrearHorario crea un nuevo horario médico
 func CrearHorario(c *fiber.Ctx) error {
-	// Solo admin puede crear horarios
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" {
+	// Crear horarios para cualquier médico exige horarios_create_any
+	if !actorDe(c).puede("horarios_create") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden crear horarios",
+			"error": "No tienes permisos para crear horarios",
 		})
 	}
 
@@ -34,19 +38,15 @@ func CrearHorario(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verificar que el médico existe y tiene rol de médico
-	var rolNombre string
-	err := database.GetDB().QueryRow(context.Background(),
-		`SELECT r.nombre FROM Usuario u 
-		 JOIN Rol r ON u.id_rol = r.id_rol 
-		 WHERE u.id_usuario = $1`, horario.IDMedico).Scan(&rolNombre)
+	// Verificar que el médico existe y su rol puede atender consultas
+	medico, err := esMedico(context.Background(), horario.IDMedico)
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Médico no encontrado",
 		})
 	}
 
-	if rolNombre != "medico" {
+	if !medico {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "El usuario especificado no es un médico",
 		})
@@ -62,21 +62,30 @@ func CrearHorario(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verificar que no exista un horario duplicado (mismo médico, consultorio y turno)
-	var existeHorario bool
-	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT EXISTS(SELECT 1 FROM Horario WHERE id_medico = $1 AND id_consultorio = $2 AND turno = $3)",
-		horario.IDMedico, horario.IDConsultorio, horario.Turno).Scan(&existeHorario)
+	tieneFecha, err := normalizarIntervaloHorario(&horario)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al verificar horario",
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	if existeHorario {
-		return c.Status(409).JSON(fiber.Map{
-			"error": "Ya existe un horario para este médico en este consultorio y turno",
-		})
+	if !tieneFecha {
+		// Horario sin fecha: verificar que no exista un duplicado (mismo médico, consultorio y turno)
+		var existeHorario bool
+		err = database.GetDB().QueryRow(context.Background(),
+			"SELECT EXISTS(SELECT 1 FROM Horario WHERE id_medico = $1 AND id_consultorio = $2 AND turno = $3)",
+			horario.IDMedico, horario.IDConsultorio, horario.Turno).Scan(&existeHorario)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al verificar horario",
+			})
+		}
+
+		if existeHorario {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "Ya existe un horario para este médico en este consultorio y turno",
+			})
+		}
 	}
 
 	// Establecer disponibilidad por defecto
@@ -84,14 +93,52 @@ func CrearHorario(c *fiber.Ctx) error {
 		horario.ConsultaDisponible = true
 	}
 
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el horario",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	// Un médico no puede estar en dos consultorios a la vez ni un consultorio tener dos médicos
+	if tieneFecha {
+		conflictos, err := buscarConflictosHorario(ctx, tx, horario.IDMedico, horario.IDConsultorio,
+			horario.FechaHora, horario.FechaHoraFin, 0)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al verificar conflictos de horario",
+			})
+		}
+		if len(conflictos) > 0 {
+			return c.Status(409).JSON(fiber.Map{
+				"error":      "El horario se traslapa con otros horarios del médico o del consultorio",
+				"conflictos": conflictos,
+			})
+		}
+	}
+
 	// Insertar horario
-	query := `INSERT INTO Horario (turno, id_medico, id_consultorio, consulta_disponible) 
-			  VALUES ($1, $2, $3, $4) RETURNING id_horario`
+	query := `INSERT INTO Horario (turno, id_medico, id_consultorio, consulta_disponible, fecha_hora, fecha_hora_fin)
+			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_horario`
 
-	err = database.GetDB().QueryRow(context.Background(), query,
-		horario.Turno, horario.IDMedico, horario.IDConsultorio, horario.ConsultaDisponible).Scan(&horario.IDHorario)
+	err = tx.QueryRow(ctx, query,
+		horario.Turno, horario.IDMedico, horario.IDConsultorio, horario.ConsultaDisponible,
+		parametroTimestamp(horario.FechaHora), parametroTimestamp(horario.FechaHoraFin)).Scan(&horario.IDHorario)
 
 	if err != nil {
+		if esTraslapeHorario(err) {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "El horario se traslapa con otro horario registrado al mismo tiempo",
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el horario",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al crear el horario",
 		})
@@ -103,46 +150,41 @@ func CrearHorario(c *fiber.Ctx) error {
 	})
 }
 
-// ObtenerHorarios obtiene todos los horarios (con filtros según el rol)
+// ObtenerHorarios obtiene todos los horarios (con filtros según horarios_read_any/_own)
 func ObtenerHorarios(c *fiber.Ctx) error {
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
+	usuario := actorDe(c)
 
 	var query string
 	var args []interface{}
 
-	switch userRole {
-	case "admin", "enfermera":
-		// Admin y enfermeras pueden ver todos los horarios
+	switch usuario.alcance("horarios_read") {
+	case alcanceTodos:
+		// Puede ver todos los horarios
 		query = `SELECT h.id_horario, h.turno, h.id_medico, h.id_consultorio, h.consulta_disponible,
-					 u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+					 h.fecha_hora, h.fecha_hora_fin, u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
 					 FROM Horario h
 					 JOIN Usuario u ON h.id_medico = u.id_usuario
 					 JOIN Consultorio c ON h.id_consultorio = c.id_consultorio
 					 ORDER BY h.turno, u.nombre`
-	case "medico":
+	case alcancePropios:
 		// Médico solo ve sus propios horarios
 		query = `SELECT h.id_horario, h.turno, h.id_medico, h.id_consultorio, h.consulta_disponible,
-					 u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+					 h.fecha_hora, h.fecha_hora_fin, u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
 					 FROM Horario h
 					 JOIN Usuario u ON h.id_medico = u.id_usuario
 					 JOIN Consultorio c ON h.id_consultorio = c.id_consultorio
 					 WHERE h.id_medico = $1
 					 ORDER BY h.turno`
-		args = append(args, userID)
-	case "paciente":
-		// Pacientes solo ven horarios disponibles
+		args = append(args, usuario.id)
+	default:
+		// Con solo horarios_read (pacientes) se ven los horarios disponibles
 		query = `SELECT h.id_horario, h.turno, h.id_medico, h.id_consultorio, h.consulta_disponible,
-					 u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+					 h.fecha_hora, h.fecha_hora_fin, u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
 					 FROM Horario h
 					 JOIN Usuario u ON h.id_medico = u.id_usuario
 					 JOIN Consultorio c ON h.id_consultorio = c.id_consultorio
 					 WHERE h.consulta_disponible = true
 					 ORDER BY h.turno, u.nombre`
-	default:
-		return c.Status(403).JSON(fiber.Map{
-			"error": "No tienes permisos para ver horarios",
-		})
 	}
 
 	rows, err := database.GetDB().Query(context.Background(), query, args...)
@@ -160,24 +202,28 @@ func ObtenerHorarios(c *fiber.Ctx) error {
 	}
 
 	var horarios []HorarioDetalle
-	log.Println("DEBUG - Iniciando escaneo de filas")
 	for rows.Next() {
 		var horario HorarioDetalle
+		var fechaHora, fechaHoraFin *time.Time
 		err := rows.Scan(
 			&horario.IDHorario, &horario.Turno, &horario.IDMedico,
-			&horario.IDConsultorio, &horario.ConsultaDisponible, &horario.FechaHora,
+			&horario.IDConsultorio, &horario.ConsultaDisponible, &fechaHora, &fechaHoraFin,
 			&horario.MedicoNombre, &horario.ConsultorioNombre,
 		)
 		if err != nil {
-			log.Println("DEBUG - Error en scan:", err)
-			return c.Status(400).JSON(fiber.Map{
-				"error": "Error al procesar horarios disponibles",
-				"details": err.Error(),
+			log.Printf("Error al leer horario: %v", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al leer horarios",
 			})
 		}
+		if fechaHora != nil {
+			horario.FechaHora = *fechaHora
+		}
+		if fechaHoraFin != nil {
+			horario.FechaHoraFin = *fechaHoraFin
+		}
 		horarios = append(horarios, horario)
 	}
-	log.Println("DEBUG - Total horarios encontrados:", len(horarios))
 
 	return c.JSON(fiber.Map{
 		"horarios": horarios,
@@ -196,12 +242,11 @@ func ObtenerHorarioPorID(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
+	usuario := actorDe(c)
 
-	// Construir query según el rol
+	// Construir query según el alcance de horarios_read
 	query := `SELECT h.id_horario, h.turno, h.id_medico, h.id_consultorio, h.consulta_disponible,
-			  u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+			  h.fecha_hora, h.fecha_hora_fin, u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
 			  FROM Horario h
 			  JOIN Usuario u ON h.id_medico = u.id_usuario
 			  JOIN Consultorio c ON h.id_consultorio = c.id_consultorio
@@ -210,19 +255,15 @@ func ObtenerHorarioPorID(c *fiber.Ctx) error {
 	var args []interface{}
 	args = append(args, id)
 
-	// Agregar filtros según el rol
-	switch userRole {
-	case "medico":
+	// Agregar filtros según el alcance
+	switch usuario.alcance("horarios_read") {
+	case alcancePropios:
 		query += " AND h.id_medico = $2"
-		args = append(args, userID)
-	case "paciente":
-		query += " AND h.consulta_disponible = true"
-	case "admin", "enfermera":
-		// Pueden ver cualquier horario
+		args = append(args, usuario.id)
+	case alcanceTodos:
+		// Puede ver cualquier horario
 	default:
-		return c.Status(403).JSON(fiber.Map{
-			"error": "No tienes permisos para ver este horario",
-		})
+		query += " AND h.consulta_disponible = true"
 	}
 
 	type HorarioDetalle struct {
@@ -232,9 +273,10 @@ func ObtenerHorarioPorID(c *fiber.Ctx) error {
 	}
 
 	var horario HorarioDetalle
+	var fechaHora, fechaHoraFin *time.Time
 	err = database.GetDB().QueryRow(context.Background(), query, args...).Scan(
 		&horario.IDHorario, &horario.Turno, &horario.IDMedico,
-		&horario.IDConsultorio, &horario.ConsultaDisponible,
+		&horario.IDConsultorio, &horario.ConsultaDisponible, &fechaHora, &fechaHoraFin,
 		&horario.MedicoNombre, &horario.ConsultorioNombre,
 	)
 
@@ -243,19 +285,44 @@ func ObtenerHorarioPorID(c *fiber.Ctx) error {
 			"error": "Horario no encontrado",
 		})
 	}
+	if fechaHora != nil {
+		horario.FechaHora = *fechaHora
+	}
+	if fechaHoraFin != nil {
+		horario.FechaHoraFin = *fechaHoraFin
+	}
 
 	return c.JSON(fiber.Map{
 		"horario": horario,
 	})
 }
 
-// ActualizarHorario actualiza un horario existente
+// horarioOcupado bloquea el horario e indica si tiene una consulta no cancelada o está retenido
+// para un paciente de la lista de espera
+func horarioOcupado(ctx context.Context, tx pgx.Tx, idHorario int) (bool, error) {
+	var ocupado bool
+	err := tx.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM Consulta WHERE id_horario = h.id_horario AND estado <> 'cancelada')
+		     OR EXISTS(SELECT 1 FROM ListaEspera WHERE id_horario = h.id_horario AND estado = 'ofrecida')
+		 FROM Horario h WHERE h.id_horario = $1 FOR UPDATE OF h`, idHorario).Scan(&ocupado)
+	return ocupado, err
+}
+
+// mismaFecha compara la fecha guardada de un horario (nil si no tiene) con la enviada (cero si no tiene)
+func mismaFecha(guardada *time.Time, enviada time.Time) bool {
+	if guardada == nil {
+		return enviada.IsZero()
+	}
+	return horaDePared(*guardada).Equal(enviada)
+}
+
+// ActualizarHorario actualiza un horario existente. La disponibilidad no se modifica aquí sino
+// con CambiarDisponibilidadHorario.
 func ActualizarHorario(c *fiber.Ctx) error {
-	// Solo admin puede actualizar horarios
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" {
+	// Actualizar horarios exige horarios_update_any (el médico solo cambia su disponibilidad)
+	if !actorDe(c).puede("horarios_update") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden actualizar horarios",
+			"error": "No tienes permisos para actualizar horarios",
 		})
 	}
 
@@ -269,9 +336,11 @@ func ActualizarHorario(c *fiber.Ctx) error {
 
 	// Verificar que el horario existe
 	var horarioExistente models.Horario
+	var fechaHoraExistente, fechaHoraFinExistente *time.Time
 	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT id_horario, id_medico, id_consultorio FROM Horario WHERE id_horario = $1", id).Scan(
-		&horarioExistente.IDHorario, &horarioExistente.IDMedico, &horarioExistente.IDConsultorio)
+		"SELECT id_horario, id_medico, id_consultorio, fecha_hora, fecha_hora_fin FROM Horario WHERE id_horario = $1", id).Scan(
+		&horarioExistente.IDHorario, &horarioExistente.IDMedico, &horarioExistente.IDConsultorio,
+		&fechaHoraExistente, &fechaHoraFinExistente)
 
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{
@@ -295,18 +364,14 @@ func ActualizarHorario(c *fiber.Ctx) error {
 
 	// Si se cambia el médico, verificar que existe y es médico
 	if horarioActualizado.IDMedico != 0 && horarioActualizado.IDMedico != horarioExistente.IDMedico {
-		var rolNombre string
-		err := database.GetDB().QueryRow(context.Background(),
-			`SELECT r.nombre FROM Usuario u 
-			 JOIN Rol r ON u.id_rol = r.id_rol 
-			 WHERE u.id_usuario = $1`, horarioActualizado.IDMedico).Scan(&rolNombre)
+		medico, err := esMedico(context.Background(), horarioActualizado.IDMedico)
 		if err != nil {
 			return c.Status(404).JSON(fiber.Map{
 				"error": "Médico no encontrado",
 			})
 		}
 
-		if rolNombre != "medico" {
+		if !medico {
 			return c.Status(400).JSON(fiber.Map{
 				"error": "El usuario especificado no es un médico",
 			})
@@ -329,32 +394,104 @@ func ActualizarHorario(c *fiber.Ctx) error {
 		horarioActualizado.IDConsultorio = horarioExistente.IDConsultorio
 	}
 
-	// Verificar que no exista un horario duplicado
-	var existeOtroHorario bool
-	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT EXISTS(SELECT 1 FROM Horario WHERE id_medico = $1 AND id_consultorio = $2 AND turno = $3 AND id_horario != $4)",
-		horarioActualizado.IDMedico, horarioActualizado.IDConsultorio, horarioActualizado.Turno, id).Scan(&existeOtroHorario)
+	// Si no se envía un nuevo intervalo se conserva el actual
+	if horarioActualizado.FechaHora.IsZero() && fechaHoraExistente != nil {
+		horarioActualizado.FechaHora = *fechaHoraExistente
+		if horarioActualizado.FechaHoraFin.IsZero() && fechaHoraFinExistente != nil {
+			horarioActualizado.FechaHoraFin = *fechaHoraFinExistente
+		}
+	}
+
+	tieneFecha, err := normalizarIntervaloHorario(&horarioActualizado)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if !tieneFecha {
+		// Horario sin fecha: verificar que no exista un duplicado
+		var existeOtroHorario bool
+		err = database.GetDB().QueryRow(context.Background(),
+			"SELECT EXISTS(SELECT 1 FROM Horario WHERE id_medico = $1 AND id_consultorio = $2 AND turno = $3 AND id_horario != $4)",
+			horarioActualizado.IDMedico, horarioActualizado.IDConsultorio, horarioActualizado.Turno, id).Scan(&existeOtroHorario)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al verificar horario",
+			})
+		}
+
+		if existeOtroHorario {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "Ya existe otro horario para este médico en este consultorio y turno",
+			})
+		}
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al verificar horario",
+			"error": "Error al actualizar el horario",
 		})
 	}
+	defer tx.Rollback(ctx)
 
-	if existeOtroHorario {
+	// Un horario reservado o retenido para la lista de espera no cambia de fecha ni de médico:
+	// la consulta o la oferta quedarían en otro momento sin avisar al paciente
+	ocupado, err := horarioOcupado(ctx, tx, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar el horario",
+		})
+	}
+	cambiaFecha := !mismaFecha(fechaHoraExistente, horarioActualizado.FechaHora) ||
+		(fechaHoraFinExistente != nil && !mismaFecha(fechaHoraFinExistente, horarioActualizado.FechaHoraFin))
+	if ocupado && (cambiaFecha || horarioActualizado.IDMedico != horarioExistente.IDMedico) {
 		return c.Status(409).JSON(fiber.Map{
-			"error": "Ya existe otro horario para este médico en este consultorio y turno",
+			"error": "El horario tiene una consulta o está retenido para la lista de espera; reprograma la consulta en lugar de cambiar su fecha o médico",
 		})
 	}
 
-	// Actualizar horario
-	query := `UPDATE Horario SET turno = $1, id_medico = $2, id_consultorio = $3, consulta_disponible = $4 
-			  WHERE id_horario = $5`
+	// Verificar traslapes con otros horarios del médico o del consultorio
+	if tieneFecha {
+		conflictos, err := buscarConflictosHorario(ctx, tx, horarioActualizado.IDMedico, horarioActualizado.IDConsultorio,
+			horarioActualizado.FechaHora, horarioActualizado.FechaHoraFin, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al verificar conflictos de horario",
+			})
+		}
+		if len(conflictos) > 0 {
+			return c.Status(409).JSON(fiber.Map{
+				"error":      "El horario se traslapa con otros horarios del médico o del consultorio",
+				"conflictos": conflictos,
+			})
+		}
+	}
 
-	_, err = database.GetDB().Exec(context.Background(), query,
+	// Actualizar horario; la disponibilidad solo cambia con CambiarDisponibilidadHorario, que
+	// respeta las consultas y la lista de espera
+	query := `UPDATE Horario SET turno = $1, id_medico = $2, id_consultorio = $3,
+			  fecha_hora = $4, fecha_hora_fin = $5, updated_at = CURRENT_TIMESTAMP
+			  WHERE id_horario = $6`
+
+	_, err = tx.Exec(ctx, query,
 		horarioActualizado.Turno, horarioActualizado.IDMedico, horarioActualizado.IDConsultorio,
-		horarioActualizado.ConsultaDisponible, id)
+		parametroTimestamp(horarioActualizado.FechaHora), parametroTimestamp(horarioActualizado.FechaHoraFin), id)
 
 	if err != nil {
+		if esTraslapeHorario(err) {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "El horario se traslapa con otro horario registrado al mismo tiempo",
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar el horario",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al actualizar el horario",
 		})
@@ -367,11 +504,10 @@ func ActualizarHorario(c *fiber.Ctx) error {
 
 // Línea 363 - En EliminarHorario
 func EliminarHorario(c *fiber.Ctx) error {
-	// Solo admin puede eliminar horarios
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" {
+	// Eliminar horarios exige horarios_delete_any
+	if !actorDe(c).puede("horarios_delete") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden eliminar horarios",
+			"error": "No tienes permisos para eliminar horarios",
 		})
 	}
 
@@ -421,11 +557,12 @@ func EliminarHorario(c *fiber.Ctx) error {
 
 // CambiarDisponibilidadHorario - Línea 433
 func CambiarDisponibilidadHorario(c *fiber.Ctx) error {
-	// Admin y médicos pueden cambiar disponibilidad
-	userRole := c.Locals("user_role").(string)
-	if userRole != "admin" && userRole != "medico" {
+	// Con horarios_disponibilidad_any se cambia cualquier horario y con _own los del médico
+	usuario := actorDe(c)
+	alcanceHorario := usuario.alcance("horarios_disponibilidad")
+	if alcanceHorario == sinAlcance {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores y médicos pueden cambiar la disponibilidad",
+			"error": "No tienes permisos para cambiar la disponibilidad",
 		})
 	}
 
@@ -437,20 +574,18 @@ func CambiarDisponibilidadHorario(c *fiber.Ctx) error {
 		})
 	}
 
-	userID := c.Locals("user_id").(int)
-
 	// Verificar permisos
 	var query string
 	var args []interface{}
 
-	if userRole == "admin" {
-		// Admin puede cambiar cualquier horario
+	if alcanceHorario == alcanceTodos {
+		// Puede cambiar cualquier horario
 		query = "SELECT id_horario, consulta_disponible FROM Horario WHERE id_horario = $1"
 		args = append(args, id)
 	} else {
 		// Médico solo puede cambiar sus propios horarios
 		query = "SELECT id_horario, consulta_disponible FROM Horario WHERE id_horario = $1 AND id_medico = $2"
-		args = append(args, id, userID)
+		args = append(args, id, usuario.id)
 	}
 
 	var horarioID int
@@ -475,8 +610,44 @@ func CambiarDisponibilidadHorario(c *fiber.Ctx) error {
 		})
 	}
 
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la disponibilidad",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	// Un horario retenido para la lista de espera no puede publicarse; si se marca como no
+	// disponible, el paciente conserva su lugar en la lista
+	var idListaEspera int
+	err = tx.QueryRow(ctx,
+		`SELECT id_lista_espera FROM ListaEspera WHERE id_horario = $1 AND estado = 'ofrecida' FOR UPDATE`,
+		id).Scan(&idListaEspera)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la disponibilidad",
+		})
+	}
+	if idListaEspera != 0 {
+		if req.Disponible {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "El horario está retenido para un paciente de la lista de espera",
+			})
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE ListaEspera SET estado = 'en_espera', id_horario = NULL, retencion_expira_at = NULL,
+			 updated_at = CURRENT_TIMESTAMP WHERE id_lista_espera = $1`, idListaEspera)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al actualizar la disponibilidad",
+			})
+		}
+	}
+
 	// Actualizar disponibilidad
-	_, err = database.GetDB().Exec(context.Background(),
+	_, err = tx.Exec(ctx,
 		"UPDATE Horario SET consulta_disponible = $1 WHERE id_horario = $2",
 		req.Disponible, id)
 
@@ -486,8 +657,27 @@ func CambiarDisponibilidadHorario(c *fiber.Ctx) error {
 		})
 	}
 
-	var mensaje string
+	// Un horario que se abre se ofrece primero a la lista de espera del médico
+	var ofrecidoA int
 	if req.Disponible {
+		ofrecidoA, err = ofrecerHorarioListaEspera(ctx, tx, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al ofrecer el horario a la lista de espera",
+			})
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la disponibilidad",
+		})
+	}
+
+	var mensaje string
+	if ofrecidoA != 0 {
+		mensaje = "Horario retenido para un paciente de la lista de espera"
+	} else if req.Disponible {
 		mensaje = "Horario marcado como disponible"
 	} else {
 		mensaje = "Horario marcado como no disponible"
@@ -496,32 +686,27 @@ func CambiarDisponibilidadHorario(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"mensaje":                 mensaje,
 		"disponibilidad_anterior": disponibilidadActual,
-		"disponibilidad_nueva":    req.Disponible,
+		"disponibilidad_nueva":    req.Disponible && ofrecidoA == 0,
 	})
 }
 
 // ObtenerHorariosDisponibles obtiene solo los horarios disponibles
 func ObtenerHorariosDisponibles(c *fiber.Ctx) error {
-	// Debug: Log que se está ejecutando la función
-	log.Println("DEBUG - Ejecutando ObtenerHorariosDisponibles")
-	
 	// Obtener horarios disponibles para citas
+	// Los horarios con fecha solo se ofrecen si aún no han pasado
 	query := `SELECT h.id_horario, h.turno, h.id_medico, h.id_consultorio, h.consulta_disponible, h.fecha_hora,
-			  u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+			  h.fecha_hora_fin, u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
 			  FROM Horario h
 			  JOIN Usuario u ON h.id_medico = u.id_usuario
 			  JOIN Consultorio c ON h.id_consultorio = c.id_consultorio
-			  WHERE h.consulta_disponible = true
-			  ORDER BY h.turno, u.nombre`
+			  WHERE h.consulta_disponible = true AND (h.fecha_hora IS NULL OR h.fecha_hora > $1)
+			  ORDER BY h.fecha_hora, h.turno, u.nombre`
 
-	log.Println("DEBUG - Query:", query)
-	
-	rows, err := database.GetDB().Query(context.Background(), query)
+	rows, err := database.GetDB().Query(context.Background(), query, horaDePared(time.Now()).Format(formatoTimestamp))
 	if err != nil {
-		log.Println("DEBUG - Error en query:", err)
-		return c.Status(400).JSON(fiber.Map{
+		log.Printf("Error al obtener horarios disponibles: %v", err)
+		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al obtener horarios disponibles",
-			"details": err.Error(),
 		})
 	}
 	defer rows.Close()
@@ -535,14 +720,21 @@ func ObtenerHorariosDisponibles(c *fiber.Ctx) error {
 	var horarios []HorarioDetalle
 	for rows.Next() {
 		var horario HorarioDetalle
+		var fechaHora, fechaHoraFin *time.Time
 		err := rows.Scan(
 			&horario.IDHorario, &horario.Turno, &horario.IDMedico,
-			&horario.IDConsultorio, &horario.ConsultaDisponible,
+			&horario.IDConsultorio, &horario.ConsultaDisponible, &fechaHora, &fechaHoraFin,
 			&horario.MedicoNombre, &horario.ConsultorioNombre,
 		)
 		if err != nil {
 			continue
 		}
+		if fechaHora != nil {
+			horario.FechaHora = *fechaHora
+		}
+		if fechaHoraFin != nil {
+			horario.FechaHoraFin = *fechaHoraFin
+		}
 		horarios = append(horarios, horario)
 	}
 
@@ -563,51 +755,41 @@ func ObtenerHorariosPorMedico(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
-
 	// Verificar permisos
-	switch userRole {
-	case "medico":
+	alcanceHorarios := actorDe(c).alcance("horarios_read")
+	switch alcanceHorarios {
+	case alcancePropios:
 		// Médico solo puede ver sus propios horarios
-		if userID != medicoID {
+		if c.Locals("user_id").(int) != medicoID {
 			return c.Status(403).JSON(fiber.Map{
 				"error": "No tienes permisos para ver los horarios de otro médico",
 			})
 		}
-	case "admin", "enfermera":
-		// Pueden ver horarios de cualquier médico
-	case "paciente":
-		// Pacientes solo ven horarios disponibles
+	case alcanceTodos:
+		// Puede ver horarios de cualquier médico
 	default:
-		return c.Status(403).JSON(fiber.Map{
-			"error": "No tienes permisos para ver horarios",
-		})
+		// Con solo horarios_read (pacientes) se ven los horarios disponibles
 	}
 
-	// Verificar que el médico existe y tiene rol de médico
-	var rolNombre string
-	err = database.GetDB().QueryRow(context.Background(),
-		`SELECT r.nombre FROM Usuario u 
-		 JOIN Rol r ON u.id_rol = r.id_rol 
-		 WHERE u.id_usuario = $1`, medicoID).Scan(&rolNombre)
+	// Verificar que el médico existe y su rol puede atender consultas
+	medico, err := esMedico(context.Background(), medicoID)
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Médico no encontrado",
 		})
 	}
 
-	if rolNombre != "medico" {
+	if !medico {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "El usuario especificado no es un médico",
 		})
 	}
 
-	// Construir query según el rol
+	// Construir query según el alcance
 	var query string
-	if userRole == "paciente" {
+	if alcanceHorarios == sinAlcance {
 		query = `SELECT h.id_horario, h.turno, h.id_medico, h.id_consultorio, h.consulta_disponible,
-				 u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+				 h.fecha_hora, h.fecha_hora_fin, u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
 				 FROM Horario h
 				 JOIN Usuario u ON h.id_medico = u.id_usuario
 				 JOIN Consultorio c ON h.id_consultorio = c.id_consultorio
@@ -615,7 +797,7 @@ func ObtenerHorariosPorMedico(c *fiber.Ctx) error {
 				 ORDER BY h.turno`
 	} else {
 		query = `SELECT h.id_horario, h.turno, h.id_medico, h.id_consultorio, h.consulta_disponible,
-				 u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+				 h.fecha_hora, h.fecha_hora_fin, u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
 				 FROM Horario h
 				 JOIN Usuario u ON h.id_medico = u.id_usuario
 				 JOIN Consultorio c ON h.id_consultorio = c.id_consultorio
@@ -640,13 +822,23 @@ func ObtenerHorariosPorMedico(c *fiber.Ctx) error {
 	var horarios []HorarioDetalle
 	for rows.Next() {
 		var horario HorarioDetalle
+		var fechaHora, fechaHoraFin *time.Time
 		err := rows.Scan(
 			&horario.IDHorario, &horario.Turno, &horario.IDMedico,
-			&horario.IDConsultorio, &horario.ConsultaDisponible,
+			&horario.IDConsultorio, &horario.ConsultaDisponible, &fechaHora, &fechaHoraFin,
 			&horario.MedicoNombre, &horario.ConsultorioNombre,
 		)
 		if err != nil {
-			continue
+			log.Printf("Error al leer horario del médico %d: %v", medicoID, err)
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al leer horarios del médico",
+			})
+		}
+		if fechaHora != nil {
+			horario.FechaHora = *fechaHora
+		}
+		if fechaHoraFin != nil {
+			horario.FechaHoraFin = *fechaHoraFin
 		}
 		horarios = append(horarios, horario)
 	}
@@ -657,3 +849,88 @@ func ObtenerHorariosPorMedico(c *fiber.Ctx) error {
 		"medico_id": medicoID,
 	})
 }
+
+// Las columnas TIMESTAMP de Horario y Consulta no tienen zona horaria y guardan la hora de pared
+// local. pgx las lee como UTC, así que las fechas de agenda se manejan como hora de pared en UTC
+// y se envían a la base de datos como texto para que PostgreSQL no aplique conversiones.
+const formatoTimestamp = "2006-01-02 15:04:05"
+
+// horaDePared devuelve la hora de pared de t expresada en UTC
+func horaDePared(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// duracionHorarioPorDefecto se usa cuando un horario con fecha no indica su hora de fin
+const duracionHorarioPorDefecto = 30 * time.Minute
+
+// normalizarIntervaloHorario convierte el intervalo del horario a hora de pared y completa la
+// hora de fin. Devuelve false si el horario no tiene fecha (horarios solo con turno).
+func normalizarIntervaloHorario(h *models.Horario) (bool, error) {
+	if h.FechaHora.IsZero() {
+		if !h.FechaHoraFin.IsZero() {
+			return false, fmt.Errorf("La hora de fin requiere la fecha y hora de inicio")
+		}
+		return false, nil
+	}
+
+	h.FechaHora = horaDePared(h.FechaHora)
+	if h.FechaHoraFin.IsZero() {
+		h.FechaHoraFin = h.FechaHora.Add(duracionHorarioPorDefecto)
+	} else {
+		h.FechaHoraFin = horaDePared(h.FechaHoraFin)
+	}
+
+	if !h.FechaHoraFin.After(h.FechaHora) {
+		return true, fmt.Errorf("La hora de fin debe ser posterior a la hora de inicio")
+	}
+	return true, nil
+}
+
+// parametroTimestamp prepara una fecha para una columna TIMESTAMP; la fecha cero se guarda como NULL
+func parametroTimestamp(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(formatoTimestamp)
+}
+
+// esTraslapeHorario indica si el error proviene de las restricciones de exclusión de Horario
+func esTraslapeHorario(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
+
+// buscarConflictosHorario devuelve los horarios que se traslapan con el intervalo [inicio, fin)
+// para el mismo médico o el mismo consultorio. excluirID permite ignorar el propio horario al actualizar.
+func buscarConflictosHorario(ctx context.Context, tx pgx.Tx, idMedico, idConsultorio int, inicio, fin time.Time, excluirID int) ([]models.ConflictoHorario, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT id_horario, id_medico, id_consultorio, fecha_hora, fecha_hora_fin
+		 FROM Horario
+		 WHERE (id_medico = $1 OR id_consultorio = $2)
+		   AND id_horario <> $3
+		   AND fecha_hora IS NOT NULL AND fecha_hora_fin IS NOT NULL
+		   AND fecha_hora < $5 AND fecha_hora_fin > $4
+		 ORDER BY fecha_hora`,
+		idMedico, idConsultorio, excluirID, inicio.Format(formatoTimestamp), fin.Format(formatoTimestamp))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conflictos []models.ConflictoHorario
+	for rows.Next() {
+		var conflicto models.ConflictoHorario
+		err := rows.Scan(&conflicto.IDHorario, &conflicto.IDMedico, &conflicto.IDConsultorio,
+			&conflicto.FechaHora, &conflicto.FechaHoraFin)
+		if err != nil {
+			return nil, err
+		}
+		if conflicto.IDMedico == idMedico {
+			conflicto.Tipo = "medico"
+		} else {
+			conflicto.Tipo = "consultorio"
+		}
+		conflictos = append(conflictos, conflicto)
+	}
+	return conflictos, rows.Err()
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// Días de vigencia de una receta cuando el médico no los indica
+const (
+	diasVigenciaRecetaPorDefecto = 30
+	variableDiasVigenciaReceta   = "RECETAS_DIAS_VIGENCIA"
+)
+
+// diasVigenciaReceta es el número de días desde su fecha en que una receta sigue vigente
+func diasVigenciaReceta() int {
+	return enteroDeEntorno(variableDiasVigenciaReceta, diasVigenciaRecetaPorDefecto)
+}
+
+// medicamentoActivo es un medicamento de la receta o de otra receta activa del paciente: del
+// catálogo (idMedicamento) o en texto libre
+type medicamentoActivo struct {
+	idReceta      int
+	idMedicamento *int
+	medicamento   string
+}
+
+// medicamentosItems convierte los renglones de la receta en los medicamentos a revisar
+func medicamentosItems(items []models.RecetaItem) []medicamentoActivo {
+	medicamentos := make([]medicamentoActivo, len(items))
+	for i, item := range items {
+		medicamentos[i] = medicamentoActivo{idMedicamento: item.IDMedicamento, medicamento: item.Medicamento}
+	}
+	return medicamentos
+}
+
+// medicamentosActivosPaciente devuelve los medicamentos de las recetas vigentes del paciente,
+// sin contar la receta que se está actualizando
+func medicamentosActivosPaciente(ctx context.Context, idPaciente, idRecetaExcluida int) ([]medicamentoActivo, error) {
+	rows, err := database.GetDB().Query(ctx,
+		`SELECT r.id_receta, i.id_medicamento, i.medicamento
+		 FROM RecetaItem i
+		 JOIN Receta r ON i.id_receta = r.id_receta
+		 WHERE r.id_paciente = $1 AND r.id_receta <> $2 AND r.deleted_at IS NULL
+		   AND COALESCE(r.vigente_hasta, r.fecha + $3::int) >= CURRENT_DATE
+		 ORDER BY r.id_receta, i.orden`, idPaciente, idRecetaExcluida, diasVigenciaReceta())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activos []medicamentoActivo
+	for rows.Next() {
+		var m medicamentoActivo
+		if err := rows.Scan(&m.idReceta, &m.idMedicamento, &m.medicamento); err != nil {
+			return nil, err
+		}
+		activos = append(activos, m)
+	}
+	return activos, rows.Err()
+}
+
+// genericosCatalogo devuelve el nombre genérico (normalizado) de cada medicamento del catálogo;
+// una interacción aplica a todas las presentaciones del mismo genérico
+func genericosCatalogo(ctx context.Context, medicamentos []medicamentoActivo) (map[int]string, error) {
+	var ids []int
+	for _, m := range medicamentos {
+		if m.idMedicamento != nil {
+			ids = append(ids, *m.idMedicamento)
+		}
+	}
+	genericos := make(map[int]string)
+	if len(ids) == 0 {
+		return genericos, nil
+	}
+
+	rows, err := database.GetDB().Query(ctx,
+		"SELECT id_medicamento, nombre_busqueda FROM Medicamento WHERE id_medicamento = ANY($1)", ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var generico string
+		if err := rows.Scan(&id, &generico); err != nil {
+			return nil, err
+		}
+		genericos[id] = generico
+	}
+	return genericos, rows.Err()
+}
+
+// interaccionCatalogo es una interacción candidata con el genérico de los medicamentos del
+// catálogo a los que apunta cada lado (nil si el lado no tiene medicamento del catálogo)
+type interaccionCatalogo struct {
+	models.InteraccionMedicamento
+	genericoA *string
+	genericoB *string
+}
+
+// coincideMedicamento indica si el medicamento corresponde a un lado de la interacción. Un
+// medicamento del catálogo coincide con el medicamento del catálogo de la interacción o con otra
+// presentación del mismo genérico; el texto libre, o una interacción sin medicamento del
+// catálogo, se compara por las palabras del nombre.
+func coincideMedicamento(m medicamentoActivo, genericos map[int]string, generico *string, nombre string) bool {
+	if m.idMedicamento != nil && generico != nil {
+		propio, ok := genericos[*m.idMedicamento]
+		return ok && propio == *generico
+	}
+	return models.ContienePalabras(m.medicamento, nombre)
+}
+
+// buscarInteracciones compara los medicamentos nuevos entre sí y con los medicamentos activos.
+// La consulta descarta las interacciones cuyos dos lados no pueden coincidir con ningún
+// medicamento; el emparejamiento exacto se hace después con coincideMedicamento.
+func buscarInteracciones(ctx context.Context, nuevos, activos []medicamentoActivo) ([]models.AlertaInteraccion, error) {
+	todos := append(append([]medicamentoActivo{}, nuevos...), activos...)
+	genericos, err := genericosCatalogo(ctx, todos)
+	if err != nil {
+		return nil, err
+	}
+	listaGenericos := make([]string, 0, len(genericos))
+	for _, generico := range genericos {
+		listaGenericos = append(listaGenericos, generico)
+	}
+
+	// Cada medicamento queda entre espacios y separado por "|" para que un nombre no coincida
+	// con palabras de dos medicamentos distintos
+	textos := make([]string, 0, len(todos))
+	for _, m := range todos {
+		textos = append(textos, models.TerminoBusqueda(m.medicamento))
+	}
+	texto := "| " + strings.Join(textos, " | ") + " |"
+
+	rows, err := database.GetDB().Query(ctx,
+		`SELECT i.id_interaccion, i.id_medicamento_a, i.id_medicamento_b, i.medicamento_a, i.medicamento_b,
+		        i.severidad, i.descripcion, ma.nombre_busqueda, mb.nombre_busqueda
+		 FROM InteraccionMedicamento i
+		 LEFT JOIN Medicamento ma ON i.id_medicamento_a = ma.id_medicamento
+		 LEFT JOIN Medicamento mb ON i.id_medicamento_b = mb.id_medicamento
+		 WHERE i.activa
+		   AND (ma.nombre_busqueda = ANY($2) OR $1 LIKE '% ' || i.nombre_a || ' %')
+		   AND (mb.nombre_busqueda = ANY($2) OR $1 LIKE '% ' || i.nombre_b || ' %')
+		 ORDER BY i.id_interaccion`, texto, listaGenericos)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var interacciones []interaccionCatalogo
+	for rows.Next() {
+		var i interaccionCatalogo
+		if err := rows.Scan(&i.IDInteraccion, &i.IDMedicamentoA, &i.IDMedicamentoB, &i.MedicamentoA, &i.MedicamentoB,
+			&i.Severidad, &i.Descripcion, &i.genericoA, &i.genericoB); err != nil {
+			return nil, err
+		}
+		interacciones = append(interacciones, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Cada par se reporta una vez aunque la interacción coincida en ambos sentidos
+	var alertas []models.AlertaInteraccion
+	vistas := make(map[string]bool)
+	agregar := func(i interaccionCatalogo, llave, medicamento, con string, idReceta *int) {
+		llave = fmt.Sprintf("%d|%s", i.IDInteraccion, llave)
+		if vistas[llave] {
+			return
+		}
+		vistas[llave] = true
+		alertas = append(alertas, models.AlertaInteraccion{
+			IDInteraccion:       i.IDInteraccion,
+			Severidad:           i.Severidad,
+			Descripcion:         i.Descripcion,
+			Medicamento:         medicamento,
+			ConMedicamento:      con,
+			IDRecetaRelacionada: idReceta,
+		})
+	}
+
+	type lado struct {
+		generico *string
+		nombre   string
+	}
+	for _, i := range interacciones {
+		a, b := lado{i.genericoA, i.MedicamentoA}, lado{i.genericoB, i.MedicamentoB}
+		for _, par := range [][2]lado{{a, b}, {b, a}} {
+			for x, nuevo := range nuevos {
+				if !coincideMedicamento(nuevo, genericos, par[0].generico, par[0].nombre) {
+					continue
+				}
+				for y, otro := range nuevos {
+					if x != y && coincideMedicamento(otro, genericos, par[1].generico, par[1].nombre) {
+						agregar(i, fmt.Sprintf("n%d|n%d", min(x, y), max(x, y)), nuevo.medicamento, otro.medicamento, nil)
+					}
+				}
+				for k, activo := range activos {
+					if coincideMedicamento(activo, genericos, par[1].generico, par[1].nombre) {
+						idReceta := activo.idReceta
+						agregar(i, fmt.Sprintf("n%d|a%d", x, k), nuevo.medicamento, activo.medicamento, &idReceta)
+					}
+				}
+			}
+		}
+	}
+	return alertas, nil
+}
+
+// revisarInteraccionesReceta busca interacciones de los medicamentos de la receta entre sí y con
+// las demás recetas activas del paciente. Las leves y moderadas solo se devuelven como
+// advertencias; si hay alguna grave la receta solo procede cuando el médico la omite
+// explícitamente con un motivo, y en ese caso también se devuelve el motivo a registrar.
+func revisarInteraccionesReceta(ctx context.Context, idPaciente, idReceta int, items []models.RecetaItem, omision models.OmisionInteraccionesRequest) ([]models.AlertaInteraccion, string, error) {
+	activos, err := medicamentosActivosPaciente(ctx, idPaciente, idReceta)
+	if err != nil {
+		return nil, "", err
+	}
+	alertas, err := buscarInteracciones(ctx, medicamentosItems(items), activos)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(interaccionesGraves(alertas)) == 0 {
+		return alertas, "", nil
+	}
+
+	if !omision.OmitirInteracciones {
+		return alertas, "", errInteraccionesReceta
+	}
+	motivo := strings.TrimSpace(omision.MotivoInteracciones)
+	if motivo == "" {
+		return nil, "", &errorConsulta{400, "El motivo para omitir las interacciones graves es requerido"}
+	}
+	return alertas, motivo, nil
+}
+
+// interaccionesGraves filtra las alertas que bloquean la receta
+func interaccionesGraves(alertas []models.AlertaInteraccion) []models.AlertaInteraccion {
+	var graves []models.AlertaInteraccion
+	for _, a := range alertas {
+		if a.Severidad == models.InteraccionGrave {
+			graves = append(graves, a)
+		}
+	}
+	return graves
+}
+
+// errInteraccionesReceta indica que la receta tiene interacciones graves y no se pidió omitirlas
+var errInteraccionesReceta = errors.New("la receta tiene interacciones graves entre medicamentos")
+
+// responderInteraccionesReceta responde a errInteraccionesReceta con las interacciones encontradas
+func responderInteraccionesReceta(c *fiber.Ctx, alertas []models.AlertaInteraccion) error {
+	return c.Status(409).JSON(fiber.Map{
+		"error":            "La receta tiene interacciones graves entre medicamentos",
+		"interacciones":    alertas,
+		"requiere_omision": true,
+	})
+}
+
+// registrarOmisionesInteraccion guarda, dentro de la transacción de la receta, las interacciones
+// graves que el médico decidió omitir
+func registrarOmisionesInteraccion(ctx context.Context, tx pgx.Tx, idReceta, idMedico int, alertas []models.AlertaInteraccion, motivo string) error {
+	for _, a := range interaccionesGraves(alertas) {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO RecetaInteraccionOmision (id_receta, id_interaccion, id_receta_relacionada, medicamento,
+			 con_medicamento, id_medico, motivo)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			idReceta, a.IDInteraccion, a.IDRecetaRelacionada, a.Medicamento, a.ConMedicamento, idMedico, motivo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// obtenerOmisionesInteraccion obtiene las interacciones graves omitidas al emitir la receta
+func obtenerOmisionesInteraccion(ctx context.Context, idReceta int) ([]models.RecetaInteraccionOmision, error) {
+	rows, err := database.GetDB().Query(ctx,
+		`SELECT id_omision, id_receta, id_interaccion, id_receta_relacionada, medicamento, con_medicamento,
+		        id_medico, motivo, created_at
+		 FROM RecetaInteraccionOmision
+		 WHERE id_receta = $1
+		 ORDER BY id_omision`, idReceta)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	omisiones := []models.RecetaInteraccionOmision{}
+	for rows.Next() {
+		var o models.RecetaInteraccionOmision
+		if err := rows.Scan(&o.IDOmision, &o.IDReceta, &o.IDInteraccion, &o.IDRecetaRelacionada, &o.Medicamento,
+			&o.ConMedicamento, &o.IDMedico, &o.Motivo, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		omisiones = append(omisiones, o)
+	}
+	return omisiones, rows.Err()
+}
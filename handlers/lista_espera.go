@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+	"github.com/lizet96/hospital-backend/notificaciones"
+)
+
+// Tiempo por defecto que un horario queda retenido para el paciente de la lista de espera
+const (
+	minutosRetencionPorDefecto      = 30
+	variableMinutosRetencionEspera  = "LISTA_ESPERA_MINUTOS_RETENCION"
+	intervaloVencimientoListaEspera = time.Minute
+)
+
+// retencionListaEspera es el tiempo que tiene el paciente para confirmar el horario ofrecido
+func retencionListaEspera() time.Duration {
+	return time.Duration(enteroDeEntorno(variableMinutosRetencionEspera, minutosRetencionPorDefecto)) * time.Minute
+}
+
+// ofrecerHorarioListaEspera retiene un horario recién liberado para el primer paciente elegible
+// de la lista de espera del médico. Solo se ofrecen horarios futuros, disponibles y sin consulta
+// activa. Devuelve el id de la inscripción a la que se ofreció o 0 si nadie lo recibió.
+func ofrecerHorarioListaEspera(ctx context.Context, tx pgx.Tx, idHorario int) (int, error) {
+	var idMedico int
+	var fechaHora *time.Time
+	var disponible bool
+	err := tx.QueryRow(ctx,
+		"SELECT id_medico, fecha_hora, consulta_disponible FROM Horario WHERE id_horario = $1 FOR UPDATE",
+		idHorario).Scan(&idMedico, &fechaHora, &disponible)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ahora := horaDePared(time.Now())
+	if !disponible || fechaHora == nil || !fechaHora.After(ahora) {
+		return 0, nil
+	}
+
+	var ocupado bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM Consulta WHERE id_horario = $1 AND estado <> 'cancelada')
+		     OR EXISTS(SELECT 1 FROM ListaEspera WHERE id_horario = $1 AND estado = 'ofrecida')`,
+		idHorario).Scan(&ocupado)
+	if err != nil || ocupado {
+		return 0, err
+	}
+
+	// Primer paciente en espera cuyo rango de fechas incluye el horario y que no tenga
+	// ya una cita con el médico ese día
+	var idListaEspera int
+	fecha := fechaHora.Format(formatoTimestamp)
+	err = tx.QueryRow(ctx,
+		`SELECT le.id_lista_espera FROM ListaEspera le
+		 WHERE le.id_medico = $1 AND le.estado = 'en_espera'
+		 AND (le.fecha_desde IS NULL OR le.fecha_desde <= $2::date)
+		 AND (le.fecha_hasta IS NULL OR le.fecha_hasta >= $2::date)
+		 AND NOT EXISTS (
+		     SELECT 1 FROM Consulta c
+		     LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		     WHERE c.id_paciente = le.id_paciente AND c.id_medico = le.id_medico
+		     AND c.estado IN ('programada', 'confirmada')
+		     AND COALESCE(h.fecha_hora, c.hora)::date = $2::date)
+		 ORDER BY le.created_at, le.id_lista_espera
+		 LIMIT 1
+		 FOR UPDATE OF le SKIP LOCKED`, idMedico, fecha).Scan(&idListaEspera)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Retener el horario: deja de estar disponible para los demás pacientes
+	if _, err := tx.Exec(ctx,
+		"UPDATE Horario SET consulta_disponible = false WHERE id_horario = $1", idHorario); err != nil {
+		return 0, err
+	}
+
+	expira := horaDePared(time.Now().Add(retencionListaEspera()))
+	_, err = tx.Exec(ctx,
+		`UPDATE ListaEspera SET estado = 'ofrecida', id_horario = $1, retencion_expira_at = $2,
+		 updated_at = CURRENT_TIMESTAMP WHERE id_lista_espera = $3`,
+		idHorario, expira.Format(formatoTimestamp), idListaEspera)
+	if err != nil {
+		return 0, err
+	}
+
+	// Avisar al paciente en la misma transacción: sin el aviso la retención vencería sin que lo sepa
+	if err := notificaciones.EncolarOfertaListaEspera(ctx, tx, idListaEspera); err != nil {
+		return 0, err
+	}
+
+	return idListaEspera, nil
+}
+
+// ProcesarRetencionesVencidas marca como expiradas las ofertas no confirmadas a tiempo y
+// devuelve sus horarios al flujo normal (se ofrecen al siguiente en espera o quedan disponibles).
+// Devuelve el número de retenciones vencidas.
+func ProcesarRetencionesVencidas(ctx context.Context) (int, error) {
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT id_lista_espera, id_horario FROM ListaEspera
+		 WHERE estado = 'ofrecida' AND retencion_expira_at <= $1
+		 FOR UPDATE SKIP LOCKED`, horaDePared(time.Now()).Format(formatoTimestamp))
+	if err != nil {
+		return 0, err
+	}
+
+	type retencion struct {
+		idListaEspera int
+		idHorario     *int
+	}
+	var vencidas []retencion
+	for rows.Next() {
+		var r retencion
+		if err := rows.Scan(&r.idListaEspera, &r.idHorario); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		vencidas = append(vencidas, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range vencidas {
+		_, err := tx.Exec(ctx,
+			`UPDATE ListaEspera SET estado = 'expirada', updated_at = CURRENT_TIMESTAMP
+			 WHERE id_lista_espera = $1`, r.idListaEspera)
+		if err != nil {
+			return 0, err
+		}
+		if r.idHorario != nil {
+			if err := liberarHorario(ctx, tx, *r.idHorario); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(vencidas), nil
+}
+
+// IniciarVencimientoListaEspera revisa periódicamente las retenciones vencidas de la lista de espera
+func IniciarVencimientoListaEspera() {
+	ticker := time.NewTicker(intervaloVencimientoListaEspera)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		vencidas, err := ProcesarRetencionesVencidas(context.Background())
+		if err != nil {
+			log.Printf("Error al procesar retenciones vencidas de la lista de espera: %v", err)
+			continue
+		}
+		if vencidas > 0 {
+			log.Printf("Lista de espera: %d retenciones vencidas liberadas", vencidas)
+		}
+	}
+}
+
+// consultaListaEspera es la consulta base de la lista de espera con las fechas ya formateadas
+const consultaListaEspera = `SELECT le.id_lista_espera, le.id_paciente, le.id_medico,
+		COALESCE(to_char(le.fecha_desde, 'YYYY-MM-DD'), ''), COALESCE(to_char(le.fecha_hasta, 'YYYY-MM-DD'), ''),
+		COALESCE(le.motivo, ''), le.estado, le.id_horario, le.retencion_expira_at, le.id_consulta,
+		le.created_at, le.updated_at, p.nombre as paciente_nombre, m.nombre as medico_nombre, h.fecha_hora
+		FROM ListaEspera le
+		JOIN Usuario p ON le.id_paciente = p.id_usuario
+		JOIN Usuario m ON le.id_medico = m.id_usuario
+		LEFT JOIN Horario h ON le.id_horario = h.id_horario`
+
+// ListaEsperaDetalle agrega nombres y la fecha del horario retenido a la inscripción
+type ListaEsperaDetalle struct {
+	models.ListaEspera
+	PacienteNombre   string     `json:"paciente_nombre"`
+	MedicoNombre     string     `json:"medico_nombre"`
+	HorarioFechaHora *time.Time `json:"horario_fecha_hora"`
+}
+
+// escanearListaEspera lee una fila de consultaListaEspera
+func escanearListaEspera(row interface{ Scan(...interface{}) error }) (ListaEsperaDetalle, error) {
+	var le ListaEsperaDetalle
+	err := row.Scan(&le.IDListaEspera, &le.IDPaciente, &le.IDMedico, &le.FechaDesde, &le.FechaHasta,
+		&le.Motivo, &le.Estado, &le.IDHorario, &le.RetencionExpiraAt, &le.IDConsulta,
+		&le.CreatedAt, &le.UpdatedAt, &le.PacienteNombre, &le.MedicoNombre, &le.HorarioFechaHora)
+	return le, err
+}
+
+// InscribirListaEspera inscribe al paciente autenticado en la lista de espera de un médico
+func InscribirListaEspera(c *fiber.Ctx) error {
+	// La inscripción siempre es del usuario autenticado
+	usuario := actorDe(c)
+	if !usuario.puede("lista_espera_create", usuario.id) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para inscribirte en la lista de espera",
+		})
+	}
+	userID := usuario.id
+
+	var req models.ListaEsperaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	if req.IDMedico == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El médico es requerido",
+		})
+	}
+
+	// Validar el rango de fechas opcional
+	var desde, hasta time.Time
+	var err error
+	if req.FechaDesde != "" {
+		if desde, err = time.Parse("2006-01-02", req.FechaDesde); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "fecha_desde debe tener el formato YYYY-MM-DD",
+			})
+		}
+	}
+	if req.FechaHasta != "" {
+		if hasta, err = time.Parse("2006-01-02", req.FechaHasta); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "fecha_hasta debe tener el formato YYYY-MM-DD",
+			})
+		}
+		hoy := horaDePared(time.Now()).Truncate(24 * time.Hour)
+		if hasta.Before(hoy) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "fecha_hasta no puede estar en el pasado",
+			})
+		}
+		if !desde.IsZero() && hasta.Before(desde) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "fecha_hasta debe ser igual o posterior a fecha_desde",
+			})
+		}
+	}
+
+	// Verificar que el médico existe y su rol puede atender consultas
+	medico, err := esMedico(context.Background(), req.IDMedico)
+	if err != nil || !medico {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Médico no encontrado",
+		})
+	}
+
+	var id int
+	err = database.GetDB().QueryRow(context.Background(),
+		`INSERT INTO ListaEspera (id_paciente, id_medico, fecha_desde, fecha_hasta, motivo)
+		 VALUES ($1, $2, NULLIF($3, '')::date, NULLIF($4, '')::date, NULLIF($5, ''))
+		 RETURNING id_lista_espera`,
+		userID, req.IDMedico, req.FechaDesde, req.FechaHasta, req.Motivo).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "Ya estás en la lista de espera de este médico",
+			})
+		}
+		log.Printf("Error al inscribir en la lista de espera: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al inscribir en la lista de espera",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje":         "Inscripción en la lista de espera registrada",
+		"id_lista_espera": id,
+		"estado":          models.EstadoListaEsperaEnEspera,
+	})
+}
+
+// ObtenerListaEspera obtiene las inscripciones según el permiso lista_espera_read: con alcance
+// propio el paciente ve las suyas y el médico las de su agenda. Acepta los filtros ?estado= y ?medico_id=
+func ObtenerListaEspera(c *fiber.Ctx) error {
+	usuario := actorDe(c)
+
+	query := consultaListaEspera + " WHERE 1 = 1"
+	var args []interface{}
+
+	switch usuario.alcance("lista_espera_read") {
+	case alcanceTodos:
+		// Puede ver todas las inscripciones
+	case alcancePropios:
+		args = append(args, usuario.id)
+		query += fmt.Sprintf(" AND (le.id_medico = $%d OR le.id_paciente = $%d)", len(args), len(args))
+	default:
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver la lista de espera",
+		})
+	}
+
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += fmt.Sprintf(" AND le.estado = $%d", len(args))
+	}
+	if medicoID := c.QueryInt("medico_id"); medicoID > 0 {
+		args = append(args, medicoID)
+		query += fmt.Sprintf(" AND le.id_medico = $%d", len(args))
+	}
+	query += " ORDER BY le.id_medico, le.created_at, le.id_lista_espera"
+
+	rows, err := database.GetDB().Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener la lista de espera",
+		})
+	}
+	defer rows.Close()
+
+	var inscripciones []ListaEsperaDetalle
+	for rows.Next() {
+		le, err := escanearListaEspera(rows)
+		if err != nil {
+			continue
+		}
+		inscripciones = append(inscripciones, le)
+	}
+
+	return c.JSON(fiber.Map{
+		"lista_espera": inscripciones,
+		"total":        len(inscripciones),
+	})
+}
+
+// bloquearListaEspera obtiene la inscripción bloqueada para modificarla y verifica que el
+// usuario tenga el permiso sobre ella (con alcance propio, solo sobre las suyas como paciente)
+func bloquearListaEspera(ctx context.Context, tx pgx.Tx, id int, usuario actor, permiso string) (models.ListaEspera, error) {
+	var le models.ListaEspera
+	err := tx.QueryRow(ctx,
+		`SELECT id_lista_espera, id_paciente, id_medico, COALESCE(motivo, ''), estado, id_horario, retencion_expira_at
+		 FROM ListaEspera WHERE id_lista_espera = $1 FOR UPDATE`, id).Scan(
+		&le.IDListaEspera, &le.IDPaciente, &le.IDMedico, &le.Motivo, &le.Estado, &le.IDHorario, &le.RetencionExpiraAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return le, &errorConsulta{404, "Inscripción no encontrada"}
+	}
+	if err != nil {
+		return le, err
+	}
+
+	switch usuario.alcance(permiso) {
+	case alcanceTodos:
+	case alcancePropios:
+		if le.IDPaciente != usuario.id {
+			return le, &errorConsulta{403, "No puedes modificar esta inscripción"}
+		}
+	default:
+		return le, &errorConsulta{403, "No tienes permisos para modificar la lista de espera"}
+	}
+	return le, nil
+}
+
+// ConfirmarListaEspera reserva para el paciente el horario que se le ofreció desde la lista de espera
+func ConfirmarListaEspera(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	usuario := actorDe(c)
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al confirmar el horario")
+	}
+	defer tx.Rollback(ctx)
+
+	le, err := bloquearListaEspera(ctx, tx, id, usuario, "lista_espera_confirmar")
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al confirmar el horario")
+	}
+
+	if le.Estado != models.EstadoListaEsperaOfrecida || le.IDHorario == nil {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "La inscripción no tiene un horario ofrecido",
+		})
+	}
+	if le.RetencionExpiraAt != nil && !le.RetencionExpiraAt.After(horaDePared(time.Now())) {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "La retención del horario ya expiró",
+		})
+	}
+
+	// El horario retenido vuelve a estar libre solo dentro de esta transacción para reservarlo
+	if err := marcarHorarioDisponible(ctx, tx, *le.IDHorario); err != nil {
+		return responderErrorConsulta(c, err, "Error al confirmar el horario")
+	}
+
+	if err := validarLimitesReservaPaciente(ctx, tx, le.IDPaciente, *le.IDHorario); err != nil {
+		return responderErrorConsulta(c, err, "Error al confirmar el horario")
+	}
+
+	consulta := models.Consulta{
+		Tipo:       "general",
+		IDPaciente: le.IDPaciente,
+		IDMedico:   le.IDMedico,
+		IDHorario:  *le.IDHorario,
+		Motivo:     le.Motivo,
+	}
+	if err := crearConsultaEnHorario(ctx, tx, &consulta, usuario.id); err != nil {
+		return responderErrorConsulta(c, err, "Error al confirmar el horario")
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE ListaEspera SET estado = 'confirmada', id_consulta = $1, updated_at = CURRENT_TIMESTAMP
+		 WHERE id_lista_espera = $2`, consulta.ID, id)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al confirmar el horario")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al confirmar el horario")
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje":     "Horario confirmado, la cita quedó reservada",
+		"id_consulta": consulta.ID,
+		"id_horario":  consulta.IDHorario,
+		"hora":        consulta.Hora,
+		"estado":      consulta.Estado,
+	})
+}
+
+// CancelarListaEspera retira una inscripción de la lista de espera; si tenía un horario
+// retenido, este pasa al siguiente paciente en espera o vuelve a quedar disponible
+func CancelarListaEspera(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la inscripción")
+	}
+	defer tx.Rollback(ctx)
+
+	le, err := bloquearListaEspera(ctx, tx, id, actorDe(c), "lista_espera_update")
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la inscripción")
+	}
+
+	if le.Estado != models.EstadoListaEsperaEnEspera && le.Estado != models.EstadoListaEsperaOfrecida {
+		return c.Status(409).JSON(fiber.Map{
+			"error": fmt.Sprintf("La inscripción ya está en estado '%s'", le.Estado),
+		})
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE ListaEspera SET estado = 'cancelada', updated_at = CURRENT_TIMESTAMP
+		 WHERE id_lista_espera = $1`, id)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la inscripción")
+	}
+
+	if le.Estado == models.EstadoListaEsperaOfrecida && le.IDHorario != nil {
+		if err := liberarHorario(ctx, tx, *le.IDHorario); err != nil {
+			return responderErrorConsulta(c, err, "Error al liberar el horario")
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al cancelar la inscripción")
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Inscripción cancelada exitosamente",
+	})
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// Longitudes máximas de las columnas de texto de Receta y RecetaItem
+const (
+	maxLongitudMedicamento = 255
+	maxLongitudDosis       = 100
+	maxLongitudVia         = 50
+)
+
+// columnasMedicamento son las columnas que lee escanearMedicamento
+const columnasMedicamento = `id_medicamento, COALESCE(clave, ''), nombre_generico, presentacion, concentracion, via, activo`
+
+// escanearMedicamento lee una fila con columnasMedicamento
+func escanearMedicamento(row pgx.Row, m *models.Medicamento) error {
+	return row.Scan(&m.IDMedicamento, &m.Clave, &m.NombreGenerico, &m.Presentacion, &m.Concentracion, &m.Via, &m.Activo)
+}
+
+// BuscarMedicamentos busca en el catálogo por nombre genérico o clave (?q=, ?limite=, ?incluir_inactivos=true)
+func BuscarMedicamentos(c *fiber.Ctx) error {
+	// Los comodines de LIKE no forman parte de la búsqueda
+	q := strings.NewReplacer("%", "", "_", "", "\\", "").Replace(strings.TrimSpace(c.Query("q")))
+	if len([]rune(q)) < 2 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "La búsqueda debe tener al menos 2 caracteres",
+		})
+	}
+
+	limite := c.QueryInt("limite", 20)
+	if limite <= 0 || limite > 50 {
+		limite = 50
+	}
+
+	query := "SELECT " + columnasMedicamento + ` FROM Medicamento
+			  WHERE (nombre_busqueda LIKE '%' || $1 || '%' OR clave = $2)`
+	if !c.QueryBool("incluir_inactivos") {
+		query += " AND activo"
+	}
+	query += " ORDER BY (nombre_busqueda LIKE $1 || '%') DESC, nombre_generico, concentracion LIMIT $3"
+
+	rows, err := database.GetDB().Query(context.Background(), query, models.NormalizarBusqueda(q), q, limite)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al buscar en el catálogo de medicamentos",
+		})
+	}
+	defer rows.Close()
+
+	var medicamentos []models.Medicamento
+	for rows.Next() {
+		var m models.Medicamento
+		if err := escanearMedicamento(rows, &m); err != nil {
+			continue
+		}
+		medicamentos = append(medicamentos, m)
+	}
+
+	return c.JSON(fiber.Map{
+		"medicamentos": medicamentos,
+		"total":        len(medicamentos),
+	})
+}
+
+// ObtenerMedicamento obtiene un medicamento del catálogo
+func ObtenerMedicamento(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var m models.Medicamento
+	err = escanearMedicamento(database.GetDB().QueryRow(context.Background(),
+		"SELECT "+columnasMedicamento+" FROM Medicamento WHERE id_medicamento = $1", id), &m)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Medicamento no encontrado",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"medicamento": m,
+	})
+}
+
+// prepararItemsReceta valida los renglones de la receta y completa los que vienen del catálogo.
+// Sin renglones se usa el formato de un solo medicamento (medicamento y dosis de la receta).
+// También deja en la receta el resumen que leen los endpoints de un solo medicamento.
+func prepararItemsReceta(ctx context.Context, receta *models.Receta, solicitud []models.RecetaItemRequest) ([]models.RecetaItem, error) {
+	if len(solicitud) == 0 {
+		solicitud = []models.RecetaItemRequest{{Medicamento: receta.Medicamento, Dosis: receta.Dosis}}
+	}
+
+	items := make([]models.RecetaItem, 0, len(solicitud))
+	for i, req := range solicitud {
+		item := models.RecetaItem{
+			IDMedicamento: req.IDMedicamento,
+			Medicamento:   strings.TrimSpace(req.Medicamento),
+			Dosis:         strings.TrimSpace(req.Dosis),
+			Frecuencia:    strings.TrimSpace(req.Frecuencia),
+			Duracion:      strings.TrimSpace(req.Duracion),
+			Cantidad:      req.Cantidad,
+			Via:           strings.TrimSpace(req.Via),
+			Indicaciones:  strings.TrimSpace(req.Indicaciones),
+			Orden:         i + 1,
+		}
+
+		if item.IDMedicamento != nil {
+			var m models.Medicamento
+			err := escanearMedicamento(database.GetDB().QueryRow(ctx,
+				"SELECT "+columnasMedicamento+" FROM Medicamento WHERE id_medicamento = $1", *item.IDMedicamento), &m)
+			if err != nil || !m.Activo {
+				return nil, &errorConsulta{400, fmt.Sprintf("Medicamento %d no encontrado en el catálogo", *item.IDMedicamento)}
+			}
+			item.Medicamento = m.Descripcion()
+			if item.Via == "" {
+				item.Via = m.Via
+			}
+		}
+
+		if item.Medicamento == "" || item.Dosis == "" {
+			return nil, &errorConsulta{400, fmt.Sprintf("El medicamento %d requiere nombre (o id_medicamento) y dosis", i+1)}
+		}
+		if len([]rune(item.Medicamento)) > maxLongitudMedicamento || len([]rune(item.Dosis)) > maxLongitudDosis ||
+			len([]rune(item.Frecuencia)) > maxLongitudDosis || len([]rune(item.Duracion)) > maxLongitudDosis ||
+			len([]rune(item.Via)) > maxLongitudVia {
+			return nil, &errorConsulta{400, fmt.Sprintf("El medicamento %d excede la longitud permitida", i+1)}
+		}
+		if item.Cantidad != nil && *item.Cantidad <= 0 {
+			return nil, &errorConsulta{400, fmt.Sprintf("La cantidad del medicamento %d debe ser mayor que cero", i+1)}
+		}
+		items = append(items, item)
+	}
+
+	// Resumen para Receta.medicamento y Receta.dosis
+	if len(items) == 1 {
+		receta.Medicamento = items[0].Medicamento
+		receta.Dosis = items[0].Dosis
+	} else {
+		nombres := make([]string, len(items))
+		for i, item := range items {
+			nombres[i] = item.Medicamento
+		}
+		receta.Medicamento = recortarTexto(strings.Join(nombres, "; "), maxLongitudMedicamento)
+		receta.Dosis = fmt.Sprintf("%d medicamentos, ver indicaciones", len(items))
+	}
+	return items, nil
+}
+
+// recortarTexto limita el texto a max caracteres, terminando en "…" si se recorta
+func recortarTexto(texto string, max int) string {
+	runas := []rune(texto)
+	if len(runas) <= max {
+		return texto
+	}
+	return string(runas[:max-1]) + "…"
+}
+
+// nombresItemsReceta devuelve el texto de cada medicamento de la receta
+func nombresItemsReceta(items []models.RecetaItem) []string {
+	nombres := make([]string, len(items))
+	for i, item := range items {
+		nombres[i] = item.Medicamento
+	}
+	return nombres
+}
+
+// guardarItemsReceta reemplaza los renglones de la receta dentro de la transacción
+func guardarItemsReceta(ctx context.Context, tx pgx.Tx, idReceta int, items []models.RecetaItem) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM RecetaItem WHERE id_receta = $1", idReceta); err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].IDReceta = idReceta
+		err := tx.QueryRow(ctx,
+			`INSERT INTO RecetaItem (id_receta, id_medicamento, medicamento, dosis, frecuencia, duracion,
+			 cantidad, via, indicaciones, orden)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			 RETURNING id_item, created_at`,
+			idReceta, items[i].IDMedicamento, items[i].Medicamento, items[i].Dosis, items[i].Frecuencia,
+			items[i].Duracion, items[i].Cantidad, items[i].Via, items[i].Indicaciones, items[i].Orden).Scan(
+			&items[i].IDItem, &items[i].CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// obtenerItemsReceta obtiene los renglones de la receta en orden
+func obtenerItemsReceta(ctx context.Context, q consultorFilas, idReceta int) ([]models.RecetaItem, error) {
+	rows, err := q.Query(ctx,
+		`SELECT id_item, id_receta, id_medicamento, medicamento, dosis, frecuencia, duracion, cantidad, via,
+		        indicaciones, orden, created_at
+		 FROM RecetaItem WHERE id_receta = $1 ORDER BY orden, id_item`, idReceta)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.RecetaItem{}
+	for rows.Next() {
+		var item models.RecetaItem
+		err := rows.Scan(&item.IDItem, &item.IDReceta, &item.IDMedicamento, &item.Medicamento, &item.Dosis,
+			&item.Frecuencia, &item.Duracion, &item.Cantidad, &item.Via, &item.Indicaciones, &item.Orden, &item.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
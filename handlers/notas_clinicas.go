@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// NotaClinicaDetalle es una nota o enmienda con el nombre del médico y la verificación de su firma
+type NotaClinicaDetalle struct {
+	models.NotaClinica
+	MedicoNombre string `json:"medico_nombre"`
+	FirmaValida  bool   `json:"firma_valida"`
+}
+
+// NotaClinicaVigente es la nota SOAP de una consulta con las enmiendas ya aplicadas. Cada
+// sección toma el valor de la enmienda más reciente que la modificó.
+type NotaClinicaVigente struct {
+	IDConsulta    int                  `json:"id_consulta"`
+	FechaConsulta *time.Time           `json:"fecha_consulta"`
+	IDMedico      int                  `json:"id_medico"`
+	MedicoNombre  string               `json:"medico_nombre"`
+	Subjetivo     string               `json:"subjetivo"`
+	Objetivo      string               `json:"objetivo"`
+	Evaluacion    string               `json:"evaluacion"`
+	Plan          string               `json:"plan"`
+	Enmendada     bool                 `json:"enmendada"`
+	CreatedAt     time.Time            `json:"created_at"`
+	ActualizadaAt time.Time            `json:"actualizada_at"`
+	Original      NotaClinicaDetalle   `json:"original"`
+	Enmiendas     []NotaClinicaDetalle `json:"enmiendas"`
+}
+
+// claveNotasClinicas firma las notas clínicas; la carga CargarClaveNotasClinicas
+var claveNotasClinicas []byte
+
+// claveDeEntorno lee una clave de firma de la variable de entorno nombre. Las claves son
+// obligatorias y cada una es distinta de las demás y de las de los tokens, para que filtrar o
+// rotar una no afecte a las otras; main llama a las funciones CargarClave* al iniciar para
+// detener el servidor si falta alguna.
+func claveDeEntorno(nombre string) ([]byte, error) {
+	clave := os.Getenv(nombre)
+	if clave == "" {
+		return nil, fmt.Errorf("%s no está definido", nombre)
+	}
+	return []byte(clave), nil
+}
+
+// CargarClaveNotasClinicas lee NOTAS_CLINICAS_SECRETO, la clave con la que se firman las notas
+// clínicas
+func CargarClaveNotasClinicas() error {
+	clave, err := claveDeEntorno("NOTAS_CLINICAS_SECRETO")
+	if err != nil {
+		return err
+	}
+	claveNotasClinicas = clave
+	return nil
+}
+
+// firmaNotaClinica calcula la firma de una nota: el HMAC-SHA256, con NOTAS_CLINICAS_SECRETO, de
+// su contenido, su autor, la nota que enmienda y su fecha. Como las notas no se modifican, una
+// firma que no coincide indica que la fila se alteró fuera de la aplicación; sin la clave no se
+// puede recalcular.
+func firmaNotaClinica(n models.NotaClinica) (string, error) {
+	if len(claveNotasClinicas) == 0 {
+		return "", errors.New("clave de las notas clínicas no configurada")
+	}
+	idOriginal := 0
+	if n.IDNotaOriginal != nil {
+		idOriginal = *n.IDNotaOriginal
+	}
+	motivo := ""
+	if n.MotivoEnmienda != nil {
+		motivo = *n.MotivoEnmienda
+	}
+	contenido, err := json.Marshal([]interface{}{
+		n.IDConsulta, n.IDPaciente, n.IDMedico, idOriginal, n.Tipo,
+		n.Subjetivo, n.Objetivo, n.Evaluacion, n.Plan, motivo,
+		n.CreatedAt.Format(formatoTimestamp),
+	})
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, claveNotasClinicas)
+	mac.Write(contenido)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// firmaNotaClinicaValida compara la firma guardada con la recalculada en tiempo constante
+func firmaNotaClinicaValida(n models.NotaClinica) bool {
+	esperada, err := firmaNotaClinica(n)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(esperada), []byte(n.Firma))
+}
+
+// normalizarNotaClinica recorta los espacios de las secciones y el motivo
+func normalizarNotaClinica(req *models.NotaClinicaRequest) {
+	req.Subjetivo = strings.TrimSpace(req.Subjetivo)
+	req.Objetivo = strings.TrimSpace(req.Objetivo)
+	req.Evaluacion = strings.TrimSpace(req.Evaluacion)
+	req.Plan = strings.TrimSpace(req.Plan)
+	req.Motivo = strings.TrimSpace(req.Motivo)
+}
+
+// insertarNotaClinica firma la nota con la fecha actual y la guarda
+func insertarNotaClinica(ctx context.Context, tx pgx.Tx, nota *models.NotaClinica) error {
+	nota.CreatedAt = horaDePared(time.Now())
+	firma, err := firmaNotaClinica(*nota)
+	if err != nil {
+		return err
+	}
+	nota.Firma = firma
+	return tx.QueryRow(ctx,
+		`INSERT INTO NotaClinica (id_consulta, id_paciente, id_medico, id_nota_original, tipo,
+		 subjetivo, objetivo, evaluacion, plan, motivo_enmienda, firma, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		 RETURNING id_nota`,
+		nota.IDConsulta, nota.IDPaciente, nota.IDMedico, nota.IDNotaOriginal, nota.Tipo,
+		nota.Subjetivo, nota.Objetivo, nota.Evaluacion, nota.Plan, nota.MotivoEnmienda,
+		nota.Firma, nota.CreatedAt.Format(formatoTimestamp)).Scan(&nota.IDNota)
+}
+
+// obtenerNotasClinicas obtiene las notas vigentes que cumplen el filtro ("n.id_consulta = $1"
+// o "n.id_paciente = $1"), de la consulta más reciente a la más antigua
+func obtenerNotasClinicas(ctx context.Context, filtro string, valor int) ([]NotaClinicaVigente, error) {
+	rows, err := database.GetDB().Query(ctx,
+		`SELECT n.id_nota, n.id_consulta, n.id_paciente, n.id_medico, n.id_nota_original, n.tipo,
+		        n.subjetivo, n.objetivo, n.evaluacion, n.plan, n.motivo_enmienda, n.firma, n.created_at,
+		        u.nombre, COALESCE(h.fecha_hora, c.hora)
+		 FROM NotaClinica n
+		 JOIN Usuario u ON n.id_medico = u.id_usuario
+		 JOIN Consulta c ON n.id_consulta = c.id_consulta
+		 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		 WHERE c.deleted_at IS NULL AND `+filtro+`
+		 ORDER BY COALESCE(n.id_nota_original, n.id_nota), n.created_at, n.id_nota`, valor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notas []NotaClinicaVigente
+	for rows.Next() {
+		var d NotaClinicaDetalle
+		var fechaConsulta *time.Time
+		err := rows.Scan(&d.IDNota, &d.IDConsulta, &d.IDPaciente, &d.IDMedico, &d.IDNotaOriginal, &d.Tipo,
+			&d.Subjetivo, &d.Objetivo, &d.Evaluacion, &d.Plan, &d.MotivoEnmienda, &d.Firma, &d.CreatedAt,
+			&d.MedicoNombre, &fechaConsulta)
+		if err != nil {
+			return nil, err
+		}
+		d.FirmaValida = firmaNotaClinicaValida(d.NotaClinica)
+
+		// Las enmiendas vienen justo después de su nota original
+		if d.Tipo == models.TipoNotaOriginal {
+			notas = append(notas, NotaClinicaVigente{
+				IDConsulta:    d.IDConsulta,
+				FechaConsulta: fechaConsulta,
+				IDMedico:      d.IDMedico,
+				MedicoNombre:  d.MedicoNombre,
+				Subjetivo:     d.Subjetivo,
+				Objetivo:      d.Objetivo,
+				Evaluacion:    d.Evaluacion,
+				Plan:          d.Plan,
+				CreatedAt:     d.CreatedAt,
+				ActualizadaAt: d.CreatedAt,
+				Original:      d,
+				Enmiendas:     []NotaClinicaDetalle{},
+			})
+			continue
+		}
+		if len(notas) == 0 {
+			continue
+		}
+		vigente := &notas[len(notas)-1]
+		if d.Subjetivo != "" {
+			vigente.Subjetivo = d.Subjetivo
+		}
+		if d.Objetivo != "" {
+			vigente.Objetivo = d.Objetivo
+		}
+		if d.Evaluacion != "" {
+			vigente.Evaluacion = d.Evaluacion
+		}
+		if d.Plan != "" {
+			vigente.Plan = d.Plan
+		}
+		vigente.Enmendada = true
+		vigente.ActualizadaAt = d.CreatedAt
+		vigente.Enmiendas = append(vigente.Enmiendas, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Ordenar de la consulta más reciente a la más antigua
+	for i, j := 0, len(notas)-1; i < j; i, j = i+1, j-1 {
+		notas[i], notas[j] = notas[j], notas[i]
+	}
+	return notas, nil
+}
+
+// CrearNotaClinica registra la nota SOAP de una consulta. Solo el médico de la consulta puede
+// escribirla y cada consulta tiene una sola nota; los cambios posteriores son enmiendas.
+func CrearNotaClinica(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var req models.NotaClinicaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+	normalizarNotaClinica(&req)
+	if req.Subjetivo == "" && req.Objetivo == "" && req.Evaluacion == "" && req.Plan == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "La nota debe incluir al menos una sección (subjetivo, objetivo, evaluacion o plan)",
+		})
+	}
+
+	usuario := actorDe(c)
+	userID := usuario.id
+	if usuario.alcance("consultas_nota") == sinAlcance {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para registrar notas clínicas",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al registrar la nota clínica")
+	}
+	defer tx.Rollback(ctx)
+
+	consulta, err := bloquearConsulta(ctx, tx, id)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al registrar la nota clínica")
+	}
+	if !usuario.puede("consultas_nota", consulta.IDMedico) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Solo el médico de la consulta puede registrar su nota clínica",
+		})
+	}
+	if consulta.Estado == models.EstadoCancelada || consulta.Estado == models.EstadoNoAsistio {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "No se pueden registrar notas en una consulta " + consulta.Estado,
+		})
+	}
+
+	nota := models.NotaClinica{
+		IDConsulta: consulta.ID,
+		IDPaciente: consulta.IDPaciente,
+		IDMedico:   userID,
+		Tipo:       models.TipoNotaOriginal,
+		Subjetivo:  req.Subjetivo,
+		Objetivo:   req.Objetivo,
+		Evaluacion: req.Evaluacion,
+		Plan:       req.Plan,
+	}
+	if err := insertarNotaClinica(ctx, tx, &nota); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "La consulta ya tiene una nota clínica; registre una enmienda",
+			})
+		}
+		return responderErrorConsulta(c, err, "Error al registrar la nota clínica")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al registrar la nota clínica")
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje": "Nota clínica registrada exitosamente",
+		"nota":    nota,
+	})
+}
+
+// EnmendarNotaClinica agrega una enmienda a la nota de la consulta. Solo el médico que firmó la
+// nota original puede enmendarla; se envían las secciones que cambian y el motivo.
+func EnmendarNotaClinica(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var req models.NotaClinicaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+	normalizarNotaClinica(&req)
+	if req.Motivo == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El motivo de la enmienda es requerido",
+		})
+	}
+	if req.Subjetivo == "" && req.Objetivo == "" && req.Evaluacion == "" && req.Plan == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "La enmienda debe modificar al menos una sección",
+		})
+	}
+
+	userID := c.Locals("user_id").(int)
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al registrar la enmienda")
+	}
+	defer tx.Rollback(ctx)
+
+	// Bloquear la nota original para que las enmiendas concurrentes queden en orden
+	var original models.NotaClinica
+	err = tx.QueryRow(ctx,
+		`SELECT id_nota, id_consulta, id_paciente, id_medico FROM NotaClinica
+		 WHERE id_consulta = $1 AND tipo = 'original' FOR UPDATE`, id).Scan(
+		&original.IDNota, &original.IDConsulta, &original.IDPaciente, &original.IDMedico)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "La consulta no tiene nota clínica",
+			})
+		}
+		return responderErrorConsulta(c, err, "Error al registrar la enmienda")
+	}
+	if original.IDMedico != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Solo el médico que firmó la nota puede enmendarla",
+		})
+	}
+
+	enmienda := models.NotaClinica{
+		IDConsulta:     original.IDConsulta,
+		IDPaciente:     original.IDPaciente,
+		IDMedico:       userID,
+		IDNotaOriginal: &original.IDNota,
+		Tipo:           models.TipoNotaEnmienda,
+		Subjetivo:      req.Subjetivo,
+		Objetivo:       req.Objetivo,
+		Evaluacion:     req.Evaluacion,
+		Plan:           req.Plan,
+		MotivoEnmienda: &req.Motivo,
+	}
+	if err := insertarNotaClinica(ctx, tx, &enmienda); err != nil {
+		return responderErrorConsulta(c, err, "Error al registrar la enmienda")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al registrar la enmienda")
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje":  "Enmienda registrada exitosamente",
+		"enmienda": enmienda,
+	})
+}
+
+// ObtenerNotaClinica obtiene la nota vigente de una consulta con la nota original y sus enmiendas
+func ObtenerNotaClinica(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var idPaciente, idMedico int
+	err = database.GetDB().QueryRow(context.Background(),
+		"SELECT id_paciente, id_medico FROM Consulta WHERE id_consulta = $1 AND deleted_at IS NULL", id).Scan(&idPaciente, &idMedico)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Consulta no encontrada",
+		})
+	}
+
+	// Verificar permisos
+	if !actorDe(c).puede("consultas_read", idPaciente, idMedico) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes ver la nota clínica de esta consulta",
+		})
+	}
+
+	notas, err := obtenerNotasClinicas(context.Background(), "n.id_consulta = $1", id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener la nota clínica",
+		})
+	}
+	if len(notas) == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "La consulta no tiene nota clínica",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"id_consulta": id,
+		"nota":        notas[0],
+	})
+}
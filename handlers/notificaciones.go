@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// ObtenerMisNotificaciones obtiene la bandeja de entrada del usuario autenticado (?no_leidas=true)
+func ObtenerMisNotificaciones(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	query := `SELECT id_notificacion, id_usuario, tipo, asunto, cuerpo, leida, created_at, leida_at
+			  FROM Notificacion WHERE id_usuario = $1`
+	if c.QueryBool("no_leidas") {
+		query += " AND leida = false"
+	}
+	query += " ORDER BY created_at DESC, id_notificacion DESC LIMIT 100"
+
+	rows, err := database.GetDB().Query(context.Background(), query, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener notificaciones",
+		})
+	}
+	defer rows.Close()
+
+	var notificaciones []models.Notificacion
+	for rows.Next() {
+		var n models.Notificacion
+		err := rows.Scan(&n.IDNotificacion, &n.IDUsuario, &n.Tipo, &n.Asunto, &n.Cuerpo,
+			&n.Leida, &n.CreatedAt, &n.LeidaAt)
+		if err != nil {
+			continue
+		}
+		notificaciones = append(notificaciones, n)
+	}
+
+	var noLeidas int
+	database.GetDB().QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM Notificacion WHERE id_usuario = $1 AND leida = false", userID).Scan(&noLeidas)
+
+	return c.JSON(fiber.Map{
+		"notificaciones": notificaciones,
+		"total":          len(notificaciones),
+		"no_leidas":      noLeidas,
+	})
+}
+
+// MarcarNotificacionLeida marca como leída una notificación del usuario autenticado
+func MarcarNotificacionLeida(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	userID := c.Locals("user_id").(int)
+
+	result, err := database.GetDB().Exec(context.Background(),
+		`UPDATE Notificacion SET leida = true, leida_at = COALESCE(leida_at, CURRENT_TIMESTAMP)
+		 WHERE id_notificacion = $1 AND id_usuario = $2`, id, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la notificación",
+		})
+	}
+
+	if result.RowsAffected() == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Notificación no encontrada",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Notificación marcada como leída",
+	})
+}
+
+// ObtenerNotificacionesSalida obtiene los envíos de la bandeja de salida de todos los usuarios
+// (notificaciones_salida_read_any, ?estado=)
+func ObtenerNotificacionesSalida(c *fiber.Ctx) error {
+	if !actorDe(c).puede("notificaciones_salida_read") {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver la bandeja de salida",
+		})
+	}
+
+	query := `SELECT id_notificacion_salida, id_usuario, canal, destino, tipo, asunto, cuerpo, estado,
+			  intentos, max_intentos, proximo_intento_at, ultimo_error, created_at, enviada_at
+			  FROM NotificacionSalida`
+	var args []interface{}
+	if estado := c.Query("estado"); estado != "" {
+		args = append(args, estado)
+		query += fmt.Sprintf(" WHERE estado = $%d", len(args))
+	}
+	query += " ORDER BY id_notificacion_salida DESC LIMIT 200"
+
+	rows, err := database.GetDB().Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener la bandeja de salida",
+		})
+	}
+	defer rows.Close()
+
+	var envios []models.NotificacionSalida
+	for rows.Next() {
+		var n models.NotificacionSalida
+		err := rows.Scan(&n.IDNotificacionSalida, &n.IDUsuario, &n.Canal, &n.Destino, &n.Tipo, &n.Asunto,
+			&n.Cuerpo, &n.Estado, &n.Intentos, &n.MaxIntentos, &n.ProximoIntentoAt, &n.UltimoError,
+			&n.CreatedAt, &n.EnviadaAt)
+		if err != nil {
+			continue
+		}
+		envios = append(envios, n)
+	}
+
+	return c.JSON(fiber.Map{
+		"envios": envios,
+		"total":  len(envios),
+	})
+}
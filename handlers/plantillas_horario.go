@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// maxDiasGeneracion limita el periodo que se puede publicar en una sola llamada
+const maxDiasGeneracion = 93
+
+// diasSemanaATexto convierte los días de la semana al formato almacenado ("1,3,5")
+func diasSemanaATexto(dias []int) string {
+	partes := make([]string, len(dias))
+	for i, dia := range dias {
+		partes[i] = strconv.Itoa(dia)
+	}
+	return strings.Join(partes, ",")
+}
+
+// textoADiasSemana convierte el formato almacenado ("1,3,5") a una lista de días
+func textoADiasSemana(texto string) []int {
+	var dias []int
+	for _, parte := range strings.Split(texto, ",") {
+		dia, err := strconv.Atoi(strings.TrimSpace(parte))
+		if err != nil {
+			continue
+		}
+		dias = append(dias, dia)
+	}
+	return dias
+}
+
+// validarPlantillaHorario revisa los campos de la plantilla y normaliza los días de la semana
+func validarPlantillaHorario(p *models.PlantillaHorario) error {
+	if p.IDMedico == 0 || p.IDConsultorio == 0 {
+		return fmt.Errorf("Médico y consultorio son requeridos")
+	}
+
+	if len(p.DiasSemana) == 0 {
+		return fmt.Errorf("Debe indicar al menos un día de la semana")
+	}
+	vistos := make(map[int]bool)
+	var dias []int
+	for _, dia := range p.DiasSemana {
+		if dia < 0 || dia > 6 {
+			return fmt.Errorf("Los días de la semana deben estar entre 0 (domingo) y 6 (sábado)")
+		}
+		if !vistos[dia] {
+			vistos[dia] = true
+			dias = append(dias, dia)
+		}
+	}
+	sort.Ints(dias)
+	p.DiasSemana = dias
+
+	inicio, err := time.Parse("15:04", p.HoraInicio)
+	if err != nil {
+		return fmt.Errorf("Formato de hora de inicio inválido. Use HH:MM")
+	}
+	fin, err := time.Parse("15:04", p.HoraFin)
+	if err != nil {
+		return fmt.Errorf("Formato de hora de fin inválido. Use HH:MM")
+	}
+	if !fin.After(inicio) {
+		return fmt.Errorf("La hora de fin debe ser posterior a la hora de inicio")
+	}
+
+	if p.DuracionMinutos <= 0 {
+		return fmt.Errorf("La duración de cada horario debe ser mayor a cero")
+	}
+	if time.Duration(p.DuracionMinutos)*time.Minute > fin.Sub(inicio) {
+		return fmt.Errorf("La duración de cada horario excede el rango de horas de la plantilla")
+	}
+
+	desde, err := time.Parse("2006-01-02", p.VigenteDesde)
+	if err != nil {
+		return fmt.Errorf("Formato de fecha de inicio de vigencia inválido. Use YYYY-MM-DD")
+	}
+	if p.VigenteHasta != "" {
+		hasta, err := time.Parse("2006-01-02", p.VigenteHasta)
+		if err != nil {
+			return fmt.Errorf("Formato de fecha de fin de vigencia inválido. Use YYYY-MM-DD")
+		}
+		if hasta.Before(desde) {
+			return fmt.Errorf("La fecha de fin de vigencia debe ser posterior a la de inicio")
+		}
+	}
+
+	return nil
+}
+
+// intervalosPlantilla calcula los intervalos [inicio, fin) que produce la plantilla entre
+// desde y hasta (ambos días inclusive), respetando su vigencia. Las horas son de pared (UTC).
+func intervalosPlantilla(p models.PlantillaHorario, desde, hasta time.Time) [][2]time.Time {
+	vigenteDesde, _ := time.ParseInLocation("2006-01-02", p.VigenteDesde, time.UTC)
+	if desde.Before(vigenteDesde) {
+		desde = vigenteDesde
+	}
+	if p.VigenteHasta != "" {
+		vigenteHasta, _ := time.ParseInLocation("2006-01-02", p.VigenteHasta, time.UTC)
+		if hasta.After(vigenteHasta) {
+			hasta = vigenteHasta
+		}
+	}
+
+	horaInicio, _ := time.Parse("15:04", p.HoraInicio)
+	horaFin, _ := time.Parse("15:04", p.HoraFin)
+	duracion := time.Duration(p.DuracionMinutos) * time.Minute
+
+	dias := make(map[time.Weekday]bool)
+	for _, dia := range p.DiasSemana {
+		dias[time.Weekday(dia)] = true
+	}
+
+	var intervalos [][2]time.Time
+	for dia := desde; !dia.After(hasta); dia = dia.AddDate(0, 0, 1) {
+		if !dias[dia.Weekday()] {
+			continue
+		}
+		inicioDia := time.Date(dia.Year(), dia.Month(), dia.Day(), horaInicio.Hour(), horaInicio.Minute(), 0, 0, time.UTC)
+		finDia := time.Date(dia.Year(), dia.Month(), dia.Day(), horaFin.Hour(), horaFin.Minute(), 0, 0, time.UTC)
+		for inicio := inicioDia; !inicio.Add(duracion).After(finDia); inicio = inicio.Add(duracion) {
+			intervalos = append(intervalos, [2]time.Time{inicio, inicio.Add(duracion)})
+		}
+	}
+	return intervalos
+}
+
+// CrearPlantillaHorario crea una plantilla de horario recurrente
+func CrearPlantillaHorario(c *fiber.Ctx) error {
+	// Crear plantillas para cualquier médico exige horarios_create_any
+	if !actorDe(c).puede("horarios_create") {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para crear plantillas de horario",
+		})
+	}
+
+	var plantilla models.PlantillaHorario
+	if err := c.BodyParser(&plantilla); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	if err := validarPlantillaHorario(&plantilla); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Verificar que el médico existe y su rol puede atender consultas
+	medico, err := esMedico(context.Background(), plantilla.IDMedico)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Médico no encontrado",
+		})
+	}
+
+	if !medico {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El usuario especificado no es un médico",
+		})
+	}
+
+	// Verificar que el consultorio existe
+	var consultorioExiste bool
+	err = database.GetDB().QueryRow(context.Background(),
+		"SELECT EXISTS(SELECT 1 FROM Consultorio WHERE id_consultorio = $1)", plantilla.IDConsultorio).Scan(&consultorioExiste)
+	if err != nil || !consultorioExiste {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Consultorio no encontrado",
+		})
+	}
+
+	var vigenteHasta interface{}
+	if plantilla.VigenteHasta != "" {
+		vigenteHasta = plantilla.VigenteHasta
+	}
+
+	plantilla.Activo = true
+	err = database.GetDB().QueryRow(context.Background(),
+		`INSERT INTO PlantillaHorario (id_medico, id_consultorio, dias_semana, hora_inicio, hora_fin,
+		                               duracion_minutos, turno, vigente_desde, vigente_hasta, activo)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 RETURNING id_plantilla, created_at, updated_at`,
+		plantilla.IDMedico, plantilla.IDConsultorio, diasSemanaATexto(plantilla.DiasSemana),
+		plantilla.HoraInicio, plantilla.HoraFin, plantilla.DuracionMinutos, plantilla.Turno,
+		plantilla.VigenteDesde, vigenteHasta, plantilla.Activo).Scan(
+		&plantilla.IDPlantilla, &plantilla.CreatedAt, &plantilla.UpdatedAt)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear la plantilla de horario",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"plantilla": plantilla,
+		"mensaje":   "Plantilla de horario creada exitosamente",
+	})
+}
+
+// consultaPlantillas es la consulta base de plantillas con fechas y horas ya formateadas
+const consultaPlantillas = `SELECT p.id_plantilla, p.id_medico, p.id_consultorio, p.dias_semana,
+		to_char(p.hora_inicio, 'HH24:MI'), to_char(p.hora_fin, 'HH24:MI'), p.duracion_minutos,
+		COALESCE(p.turno, ''), to_char(p.vigente_desde, 'YYYY-MM-DD'),
+		COALESCE(to_char(p.vigente_hasta, 'YYYY-MM-DD'), ''), p.activo, p.created_at, p.updated_at,
+		u.nombre as medico_nombre, c.nombre_numero as consultorio_nombre
+		FROM PlantillaHorario p
+		JOIN Usuario u ON p.id_medico = u.id_usuario
+		JOIN Consultorio c ON p.id_consultorio = c.id_consultorio`
+
+// PlantillaHorarioDetalle agrega los nombres de médico y consultorio a la plantilla
+type PlantillaHorarioDetalle struct {
+	models.PlantillaHorario
+	MedicoNombre      string `json:"medico_nombre"`
+	ConsultorioNombre string `json:"consultorio_nombre"`
+}
+
+// escanearPlantilla lee una fila de consultaPlantillas
+func escanearPlantilla(row interface{ Scan(...interface{}) error }) (PlantillaHorarioDetalle, error) {
+	var p PlantillaHorarioDetalle
+	var dias string
+	err := row.Scan(&p.IDPlantilla, &p.IDMedico, &p.IDConsultorio, &dias,
+		&p.HoraInicio, &p.HoraFin, &p.DuracionMinutos, &p.Turno, &p.VigenteDesde,
+		&p.VigenteHasta, &p.Activo, &p.CreatedAt, &p.UpdatedAt,
+		&p.MedicoNombre, &p.ConsultorioNombre)
+	p.DiasSemana = textoADiasSemana(dias)
+	return p, err
+}
+
+// ObtenerPlantillasHorario obtiene las plantillas de horario (con horarios_read_own el médico
+// solo ve las suyas)
+func ObtenerPlantillasHorario(c *fiber.Ctx) error {
+	usuario := actorDe(c)
+
+	query := consultaPlantillas
+	var args []interface{}
+
+	switch usuario.alcance("horarios_read") {
+	case alcanceTodos:
+		// Puede ver todas las plantillas
+	case alcancePropios:
+		query += " WHERE p.id_medico = $1"
+		args = append(args, usuario.id)
+	default:
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver plantillas de horario",
+		})
+	}
+	query += " ORDER BY u.nombre, p.vigente_desde"
+
+	rows, err := database.GetDB().Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener plantillas de horario",
+		})
+	}
+	defer rows.Close()
+
+	var plantillas []PlantillaHorarioDetalle
+	for rows.Next() {
+		plantilla, err := escanearPlantilla(rows)
+		if err != nil {
+			continue
+		}
+		plantillas = append(plantillas, plantilla)
+	}
+
+	return c.JSON(fiber.Map{
+		"plantillas": plantillas,
+		"total":      len(plantillas),
+	})
+}
+
+// ObtenerPlantillaHorarioPorID obtiene una plantilla de horario específica
+func ObtenerPlantillaHorarioPorID(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	plantilla, err := escanearPlantilla(database.GetDB().QueryRow(context.Background(),
+		consultaPlantillas+" WHERE p.id_plantilla = $1", id))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Plantilla de horario no encontrada",
+		})
+	}
+
+	if !actorDe(c).puede("horarios_read", plantilla.IDMedico) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver esta plantilla",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"plantilla": plantilla,
+	})
+}
+
+// DesactivarPlantillaHorario desactiva una plantilla; los horarios ya generados se conservan
+func DesactivarPlantillaHorario(c *fiber.Ctx) error {
+	// Desactivar plantillas exige horarios_delete_any
+	if !actorDe(c).puede("horarios_delete") {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para desactivar plantillas de horario",
+		})
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	result, err := database.GetDB().Exec(context.Background(),
+		"UPDATE PlantillaHorario SET activo = false, updated_at = CURRENT_TIMESTAMP WHERE id_plantilla = $1", id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al desactivar la plantilla de horario",
+		})
+	}
+
+	if result.RowsAffected() == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Plantilla de horario no encontrada",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Plantilla de horario desactivada exitosamente",
+	})
+}
+
+// GenerarHorariosDesdePlantilla crea los horarios con fecha concreta que produce una plantilla
+// en el periodo indicado. Omite los horarios que ya existen y reporta los que se traslapan
+// con otros horarios del médico o del consultorio.
+func GenerarHorariosDesdePlantilla(c *fiber.Ctx) error {
+	// Publicar horarios para cualquier médico exige horarios_create_any
+	if !actorDe(c).puede("horarios_create") {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para generar horarios",
+		})
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var req models.GenerarHorariosRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	desde, err := time.ParseInLocation("2006-01-02", req.Desde, time.UTC)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Formato de fecha 'desde' inválido. Use YYYY-MM-DD",
+		})
+	}
+	hasta, err := time.ParseInLocation("2006-01-02", req.Hasta, time.UTC)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Formato de fecha 'hasta' inválido. Use YYYY-MM-DD",
+		})
+	}
+	if hasta.Before(desde) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "La fecha 'hasta' debe ser posterior a 'desde'",
+		})
+	}
+	if hasta.Sub(desde) > maxDiasGeneracion*24*time.Hour {
+		return c.Status(400).JSON(fiber.Map{
+			"error": fmt.Sprintf("El periodo no puede exceder %d días", maxDiasGeneracion),
+		})
+	}
+
+	plantilla, err := escanearPlantilla(database.GetDB().QueryRow(context.Background(),
+		consultaPlantillas+" WHERE p.id_plantilla = $1", id))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Plantilla de horario no encontrada",
+		})
+	}
+	if !plantilla.Activo {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "La plantilla de horario está desactivada",
+		})
+	}
+
+	turno := plantilla.Turno
+	if turno == "" {
+		turno = plantilla.HoraInicio + "-" + plantilla.HoraFin
+	}
+
+	type HorarioOmitido struct {
+		FechaHora    time.Time `json:"fecha_hora"`
+		FechaHoraFin time.Time `json:"fecha_hora_fin"`
+		Motivo       string    `json:"motivo"`
+	}
+	type HorarioEnConflicto struct {
+		FechaHora    time.Time                 `json:"fecha_hora"`
+		FechaHoraFin time.Time                 `json:"fecha_hora_fin"`
+		Conflictos   []models.ConflictoHorario `json:"conflictos"`
+	}
+
+	creados := []models.Horario{}
+	omitidos := []HorarioOmitido{}
+	enConflicto := []HorarioEnConflicto{}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al generar horarios",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	ahora := horaDePared(time.Now())
+	for _, intervalo := range intervalosPlantilla(plantilla.PlantillaHorario, desde, hasta) {
+		inicio, fin := intervalo[0], intervalo[1]
+
+		if inicio.Before(ahora) {
+			omitidos = append(omitidos, HorarioOmitido{inicio, fin, "Fecha en el pasado"})
+			continue
+		}
+
+		conflictos, err := buscarConflictosHorario(ctx, tx, plantilla.IDMedico, plantilla.IDConsultorio, inicio, fin, 0)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al verificar conflictos de horario",
+			})
+		}
+
+		// Un horario idéntico del mismo médico en el mismo consultorio ya fue publicado
+		existente := false
+		for _, conflicto := range conflictos {
+			if conflicto.IDMedico == plantilla.IDMedico && conflicto.IDConsultorio == plantilla.IDConsultorio &&
+				conflicto.FechaHora.Equal(inicio) && conflicto.FechaHoraFin.Equal(fin) {
+				existente = true
+				break
+			}
+		}
+		if existente {
+			omitidos = append(omitidos, HorarioOmitido{inicio, fin, "Ya existe"})
+			continue
+		}
+		if len(conflictos) > 0 {
+			enConflicto = append(enConflicto, HorarioEnConflicto{inicio, fin, conflictos})
+			continue
+		}
+
+		horario := models.Horario{
+			Turno:              turno,
+			IDMedico:           plantilla.IDMedico,
+			IDConsultorio:      plantilla.IDConsultorio,
+			ConsultaDisponible: true,
+			FechaHora:          inicio,
+			FechaHoraFin:       fin,
+			IDPlantilla:        &plantilla.IDPlantilla,
+		}
+		// Cada horario se inserta en un savepoint: si otro horario se publicó al mismo tiempo, la
+		// restricción de exclusión rechaza solo este y se reporta como conflicto
+		err = insertarHorarioPlantilla(ctx, tx, &horario)
+		if esTraslapeHorario(err) {
+			conflictos, err := buscarConflictosHorario(ctx, tx, plantilla.IDMedico, plantilla.IDConsultorio, inicio, fin, 0)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{
+					"error": "Error al verificar conflictos de horario",
+				})
+			}
+			enConflicto = append(enConflicto, HorarioEnConflicto{inicio, fin, conflictos})
+			continue
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al crear los horarios",
+			})
+		}
+		creados = append(creados, horario)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al generar horarios",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje":          "Horarios generados exitosamente",
+		"id_plantilla":     plantilla.IDPlantilla,
+		"creados":          creados,
+		"omitidos":         omitidos,
+		"conflictos":       enConflicto,
+		"total_creados":    len(creados),
+		"total_omitidos":   len(omitidos),
+		"total_conflictos": len(enConflicto),
+	})
+}
+
+// insertarHorarioPlantilla inserta un horario generado dentro de un savepoint de la transacción,
+// de modo que un error deshace solo este horario y no el lote completo
+func insertarHorarioPlantilla(ctx context.Context, tx pgx.Tx, horario *models.Horario) error {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer savepoint.Rollback(ctx)
+
+	err = savepoint.QueryRow(ctx,
+		`INSERT INTO Horario (turno, id_medico, id_consultorio, consulta_disponible, fecha_hora, fecha_hora_fin, id_plantilla)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id_horario`,
+		horario.Turno, horario.IDMedico, horario.IDConsultorio, horario.ConsultaDisponible,
+		horario.FechaHora.Format(formatoTimestamp), horario.FechaHoraFin.Format(formatoTimestamp),
+		horario.IDPlantilla).Scan(&horario.IDHorario)
+	if err != nil {
+		return err
+	}
+	return savepoint.Commit(ctx)
+}
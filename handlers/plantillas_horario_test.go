@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lizet96/hospital-backend/models"
+)
+
+func TestIntervalosPlantilla(t *testing.T) {
+	fecha := func(texto string) time.Time {
+		f, err := time.ParseInLocation("2006-01-02 15:04", texto, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	dia := func(texto string) time.Time { return fecha(texto + " 00:00") }
+
+	// Lunes y miércoles de 09:00 a 10:00 en bloques de 30 minutos; el 2024-01-01 fue lunes
+	plantilla := models.PlantillaHorario{
+		DiasSemana:      []int{1, 3},
+		HoraInicio:      "09:00",
+		HoraFin:         "10:00",
+		DuracionMinutos: 30,
+		VigenteDesde:    "2024-01-01",
+	}
+	conVigencia := plantilla
+	conVigencia.VigenteDesde = "2024-01-03"
+	conVigencia.VigenteHasta = "2024-01-08"
+	sobrante := plantilla
+	sobrante.HoraFin = "10:15"
+	sobrante.DuracionMinutos = 45
+
+	casos := []struct {
+		nombre      string
+		plantilla   models.PlantillaHorario
+		desde       string
+		hasta       string
+		intervalos  int
+		primero     string
+		ultimoFinal string
+	}{
+		{"una semana", plantilla, "2024-01-01", "2024-01-07", 4, "2024-01-01 09:00", "2024-01-03 10:00"},
+		{"un solo día", plantilla, "2024-01-03", "2024-01-03", 2, "2024-01-03 09:00", "2024-01-03 10:00"},
+		{"sin días de la plantilla", plantilla, "2024-01-04", "2024-01-07", 0, "", ""},
+		{"antes de la vigencia", plantilla, "2023-12-25", "2024-01-01", 2, "2024-01-01 09:00", "2024-01-01 10:00"},
+		{"recortado a la vigencia", conVigencia, "2024-01-01", "2024-01-31", 4, "2024-01-03 09:00", "2024-01-08 10:00"},
+		{"bloque que no cabe completo", sobrante, "2024-01-01", "2024-01-01", 1, "2024-01-01 09:00", "2024-01-01 09:45"},
+		{"periodo invertido", plantilla, "2024-01-07", "2024-01-01", 0, "", ""},
+	}
+	for _, caso := range casos {
+		intervalos := intervalosPlantilla(caso.plantilla, dia(caso.desde), dia(caso.hasta))
+		if len(intervalos) != caso.intervalos {
+			t.Errorf("%s: %d intervalos, se esperaban %d", caso.nombre, len(intervalos), caso.intervalos)
+			continue
+		}
+		if caso.intervalos == 0 {
+			continue
+		}
+		if !intervalos[0][0].Equal(fecha(caso.primero)) {
+			t.Errorf("%s: el primer intervalo empieza %v, se esperaba %s", caso.nombre, intervalos[0][0], caso.primero)
+		}
+		if fin := intervalos[len(intervalos)-1][1]; !fin.Equal(fecha(caso.ultimoFinal)) {
+			t.Errorf("%s: el último intervalo termina %v, se esperaba %s", caso.nombre, fin, caso.ultimoFinal)
+		}
+		for _, intervalo := range intervalos {
+			if intervalo[1].Sub(intervalo[0]) != time.Duration(caso.plantilla.DuracionMinutos)*time.Minute {
+				t.Errorf("%s: el intervalo %v no dura %d minutos", caso.nombre, intervalo, caso.plantilla.DuracionMinutos)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/middleware"
+)
+
+// Las reglas de acceso de los handlers se expresan con permisos, no con nombres de rol. Además
+// del permiso de la ruta (consultas_update), cada acción tiene dos variantes que fijan su alcance:
+// <permiso>_own la permite sobre los registros propios del usuario y <permiso>_any sobre los de
+// cualquiera. Qué es un registro propio lo decide cada handler: el médico de la consulta, el
+// paciente de la receta, etc. Así un rol creado desde la API funciona otorgándole permisos.
+const (
+	sufijoPropios = "_own"
+	sufijoTodos   = "_any"
+)
+
+// alcance indica sobre qué registros puede el usuario realizar una acción
+type alcance int
+
+const (
+	sinAlcance alcance = iota
+	alcancePropios
+	alcanceTodos
+)
+
+// actor es el usuario que realiza una operación y cómo se consultan sus permisos
+type actor struct {
+	id           int
+	tienePermiso func(permiso string) bool
+}
+
+// actorDe devuelve el usuario autenticado de la solicitud
+func actorDe(c *fiber.Ctx) actor {
+	return actor{
+		id:           c.Locals("user_id").(int),
+		tienePermiso: func(permiso string) bool { return hasPermission(c, permiso) },
+	}
+}
+
+// actorUsuario devuelve un usuario que actúa sin sesión (por ejemplo, con el enlace de un
+// recordatorio) con los permisos de su rol
+func actorUsuario(ctx context.Context, userID int) actor {
+	return actor{
+		id:           userID,
+		tienePermiso: func(permiso string) bool { return tienePermisoUsuario(ctx, userID, permiso) },
+	}
+}
+
+// soloPropios limita al usuario a sus registros propios aunque su rol tenga permisos <permiso>_any.
+// Se usa en los flujos en los que actúa como paciente: el portal de citas y los recordatorios.
+func (a actor) soloPropios() actor {
+	tienePermiso := a.tienePermiso
+	return actor{
+		id: a.id,
+		tienePermiso: func(permiso string) bool {
+			return !strings.HasSuffix(permiso, sufijoTodos) && tienePermiso(permiso)
+		},
+	}
+}
+
+// tienePermisoUsuario indica si el rol activo del usuario tiene el permiso
+func tienePermisoUsuario(ctx context.Context, userID int, permiso string) bool {
+	acceso, err := middleware.ObtenerAccesoUsuario(ctx, userID)
+	return err == nil && acceso.Tiene(permiso)
+}
+
+// alcance devuelve el alcance del usuario para el permiso según tenga <permiso>_any o <permiso>_own
+func (a actor) alcance(permiso string) alcance {
+	if a.tienePermiso(permiso + sufijoTodos) {
+		return alcanceTodos
+	}
+	if a.tienePermiso(permiso + sufijoPropios) {
+		return alcancePropios
+	}
+	return sinAlcance
+}
+
+// puede indica si el usuario puede aplicar el permiso a un registro: a cualquiera con
+// <permiso>_any, o con <permiso>_own si es uno de los responsables indicados. Sin responsables
+// solo cuenta <permiso>_any.
+func (a actor) puede(permiso string, responsables ...int) bool {
+	switch a.alcance(permiso) {
+	case alcanceTodos:
+		return true
+	case alcancePropios:
+		for _, id := range responsables {
+			if id == a.id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// puedeVerPaciente indica si el usuario puede consultar la información clínica del paciente
+// protegida por el permiso: con <permiso>_any la de cualquiera y con <permiso>_own la propia o
+// la de los pacientes con los que tiene consultas como médico
+func puedeVerPaciente(ctx context.Context, a actor, permiso string, idPaciente int) (bool, error) {
+	switch a.alcance(permiso) {
+	case alcanceTodos:
+		return true, nil
+	case alcancePropios:
+		if idPaciente == a.id {
+			return true, nil
+		}
+		return atiendeAPaciente(ctx, a.id, idPaciente)
+	}
+	return false, nil
+}
+
+// atiendeAPaciente indica si el usuario tiene consultas como médico con el paciente
+func atiendeAPaciente(ctx context.Context, idMedico, idPaciente int) (bool, error) {
+	var atiende bool
+	err := database.GetDB().QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM Consulta WHERE id_paciente = $1 AND id_medico = $2 AND deleted_at IS NULL)",
+		idPaciente, idMedico).Scan(&atiende)
+	return atiende, err
+}
+
+// condicionRolMedico devuelve la condición SQL que reconoce como médico al usuario con el alias
+// indicado: su rol activo puede atender consultas (consultas_atender_own o _any). Así un rol
+// creado desde la API, como medico_residente, también puede tener horarios y pacientes.
+func condicionRolMedico(alias string) string {
+	return `EXISTS (
+		SELECT 1 FROM Rol rm
+		JOIN RolPermiso rpm ON rm.id_rol = rpm.id_rol
+		JOIN Permiso pm ON rpm.id_permiso = pm.id_permiso
+		WHERE rm.id_rol = ` + alias + `.id_rol AND rm.activo = true
+		AND pm.nombre IN ('consultas_atender` + sufijoPropios + `', 'consultas_atender` + sufijoTodos + `'))`
+}
+
+// esMedico indica si el usuario puede ser el médico de horarios y consultas. Devuelve
+// pgx.ErrNoRows si el usuario no existe.
+func esMedico(ctx context.Context, idUsuario int) (bool, error) {
+	var medico bool
+	err := database.GetDB().QueryRow(ctx,
+		"SELECT "+condicionRolMedico("u")+" FROM Usuario u WHERE u.id_usuario = $1 AND u.deleted_at IS NULL",
+		idUsuario).Scan(&medico)
+	return medico, err
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import "testing"
+
+// actorConPermisos crea un usuario con los permisos indicados, sin base de datos
+func actorConPermisos(id int, permisos ...string) actor {
+	tiene := make(map[string]bool)
+	for _, permiso := range permisos {
+		tiene[permiso] = true
+	}
+	return actor{id: id, tienePermiso: func(permiso string) bool { return tiene[permiso] }}
+}
+
+func TestActorAlcance(t *testing.T) {
+	casos := []struct {
+		nombre   string
+		usuario  actor
+		esperado alcance
+	}{
+		{"sin permisos", actorConPermisos(1), sinAlcance},
+		{"permiso de la ruta", actorConPermisos(1, "consultas_read"), sinAlcance},
+		{"propios", actorConPermisos(1, "consultas_read_own"), alcancePropios},
+		{"todos", actorConPermisos(1, "consultas_read_any"), alcanceTodos},
+		{"propios y todos", actorConPermisos(1, "consultas_read_own", "consultas_read_any"), alcanceTodos},
+		{"otro permiso", actorConPermisos(1, "recetas_read_any"), sinAlcance},
+		{"solo propios con todos", actorConPermisos(1, "consultas_read_any").soloPropios(), sinAlcance},
+		{"solo propios con ambos", actorConPermisos(1, "consultas_read_own", "consultas_read_any").soloPropios(), alcancePropios},
+	}
+	for _, caso := range casos {
+		if got := caso.usuario.alcance("consultas_read"); got != caso.esperado {
+			t.Errorf("%s: alcance = %v, se esperaba %v", caso.nombre, got, caso.esperado)
+		}
+	}
+}
+
+func TestActorPuede(t *testing.T) {
+	casos := []struct {
+		nombre       string
+		usuario      actor
+		responsables []int
+		puede        bool
+	}{
+		{"todos sin responsables", actorConPermisos(1, "consultas_update_any"), nil, true},
+		{"todos de otro", actorConPermisos(1, "consultas_update_any"), []int{2, 3}, true},
+		{"propios sin responsables", actorConPermisos(1, "consultas_update_own"), nil, false},
+		{"propios de otro", actorConPermisos(1, "consultas_update_own"), []int{2, 3}, false},
+		{"propios como médico", actorConPermisos(1, "consultas_update_own"), []int{1, 3}, true},
+		{"propios como paciente", actorConPermisos(3, "consultas_update_own"), []int{1, 3}, true},
+		{"sin permisos", actorConPermisos(1), []int{1}, false},
+		{"solo propios de otro", actorConPermisos(1, "consultas_update_own", "consultas_update_any").soloPropios(), []int{2}, false},
+		{"solo propios del usuario", actorConPermisos(1, "consultas_update_own", "consultas_update_any").soloPropios(), []int{1}, true},
+	}
+	for _, caso := range casos {
+		if got := caso.usuario.puede("consultas_update", caso.responsables...); got != caso.puede {
+			t.Errorf("%s: puede = %v, se esperaba %v", caso.nombre, got, caso.puede)
+		}
+	}
+}
+
+func TestActorSoloPropios(t *testing.T) {
+	usuario := actorConPermisos(4, "citas_update", "consultas_update_own", "consultas_update_any").soloPropios()
+	casos := []struct {
+		permiso string
+		tiene   bool
+	}{
+		{"citas_update", true},
+		{"consultas_update_own", true},
+		{"consultas_update_any", false},
+		{"recetas_read_own", false},
+	}
+	if usuario.id != 4 {
+		t.Errorf("soloPropios cambió el id del usuario: %d", usuario.id)
+	}
+	for _, caso := range casos {
+		if got := usuario.tienePermiso(caso.permiso); got != caso.tiene {
+			t.Errorf("tienePermiso(%q) = %v, se esperaba %v", caso.permiso, got, caso.tiene)
+		}
+	}
+}
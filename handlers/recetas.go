@@ -2,37 +2,68 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 	"github.com/lizet96/hospital-backend/database"
 	"github.com/lizet96/hospital-backend/models"
+	"github.com/lizet96/hospital-backend/notificaciones"
 )
 
+// recetaSolicitud reúne los campos del cuerpo de CrearReceta y ActualizarReceta que no forman
+// parte de models.Receta
+type recetaSolicitud struct {
+	models.RecetaItemsRequest
+	models.OmisionAlergiasRequest
+	models.OmisionInteraccionesRequest
+	models.SurtidoRecetaRequest
+}
+
 // CrearReceta crea una nueva receta médica
 func CrearReceta(c *fiber.Ctx) error {
-	// Solo médicos pueden crear recetas
-	userRole := c.Locals("user_role").(string)
-	if userRole != "medico" {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos pueden crear recetas",
-		})
-	}
-
-	medicoID := c.Locals("user_id").(int)
+	// Quien crea la receta queda como su médico
+	usuario := actorDe(c)
+	medicoID := usuario.id
 
 	var receta models.Receta
+	var solicitud recetaSolicitud
 	if err := c.BodyParser(&receta); err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Datos inválidos",
 		})
 	}
+	if err := c.BodyParser(&solicitud); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
 
 	// Validaciones
-	if receta.Medicamento == "" || receta.Dosis == "" || receta.IDPaciente == 0 || receta.IDConsultorio == 0 {
+	if receta.IDPaciente == 0 || receta.IDConsultorio == 0 {
 		return c.Status(400).JSON(fiber.Map{
-			"error": "Medicamento, dosis, paciente y consultorio son requeridos",
+			"error": "Paciente y consultorio son requeridos",
+		})
+	}
+	if err := validarSurtidoReceta(solicitud.SurtidoRecetaRequest); err != nil {
+		return responderErrorConsulta(c, err, "Error al crear la receta")
+	}
+	resurtidos, vigenciaDias := 0, diasVigenciaReceta()
+	if solicitud.Resurtidos != nil {
+		resurtidos = *solicitud.Resurtidos
+	}
+	if solicitud.VigenciaDias != nil {
+		vigenciaDias = *solicitud.VigenciaDias
+	}
+
+	// Verificar que el usuario pueda recetar a este paciente
+	if permitido, err := puedeVerPaciente(context.Background(), usuario, "recetas_create", receta.IDPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes crear recetas para este paciente",
 		})
 	}
 
@@ -64,17 +95,61 @@ func CrearReceta(c *fiber.Ctx) error {
 		})
 	}
 
+	// Medicamentos de la receta: varios renglones o el formato de un solo medicamento
+	ctx := context.Background()
+	items, err := prepararItemsReceta(ctx, &receta, solicitud.Items)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al crear la receta")
+	}
+
+	// Comparar los medicamentos con las alergias registradas del paciente
+	alergias, motivoOmision, err := revisarAlergiasReceta(ctx, receta.IDPaciente, nombresItemsReceta(items), solicitud.OmisionAlergiasRequest)
+	if errors.Is(err, errAlergiasReceta) {
+		return responderAlergiasReceta(c, alergias)
+	}
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al crear la receta")
+	}
+
+	// Buscar interacciones entre los medicamentos y con las demás recetas activas del paciente
+	interacciones, motivoInteracciones, err := revisarInteraccionesReceta(ctx, receta.IDPaciente, 0,
+		items, solicitud.OmisionInteraccionesRequest)
+	if errors.Is(err, errInteraccionesReceta) {
+		return responderInteraccionesReceta(c, interacciones)
+	}
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al crear la receta")
+	}
+
 	// Establecer fecha actual si no se proporciona
 	if receta.Fecha.IsZero() {
 		receta.Fecha = time.Now()
 	}
 
-	// Insertar receta
-	query := `INSERT INTO Receta (fecha, medicamento, dosis, id_medico, id_paciente, id_consultorio) 
-			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_receta`
+	// Insertar la receta, sus medicamentos y las alergias omitidas en una sola transacción
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear la receta",
+		})
+	}
+	defer tx.Rollback(ctx)
 
-	err = database.GetDB().QueryRow(context.Background(), query,
-		receta.Fecha, receta.Medicamento, receta.Dosis, medicoID, receta.IDPaciente, receta.IDConsultorio).Scan(&receta.IDReceta)
+	// Código con el que la receta impresa se verifica en la farmacia
+	codigoVerificacion, err := generarCodigoVerificacion()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear la receta",
+		})
+	}
+
+	query := `INSERT INTO Receta (fecha, medicamento, dosis, instrucciones, id_medico, id_paciente, id_consultorio,
+			  codigo_verificacion, resurtidos, vigente_hasta) 
+			  VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $1::date + $10::int) RETURNING id_receta`
+
+	err = tx.QueryRow(ctx, query,
+		receta.Fecha, receta.Medicamento, receta.Dosis, strings.TrimSpace(receta.Instrucciones), medicoID,
+		receta.IDPaciente, receta.IDConsultorio, codigoVerificacion, resurtidos, vigenciaDias).Scan(&receta.IDReceta)
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -82,68 +157,94 @@ func CrearReceta(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := guardarItemsReceta(ctx, tx, receta.IDReceta, items); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear la receta",
+		})
+	}
+
+	if err := registrarOmisionesAlergia(ctx, tx, receta.IDReceta, medicoID, alergias, motivoOmision); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear la receta",
+		})
+	}
+
+	if err := registrarOmisionesInteraccion(ctx, tx, receta.IDReceta, medicoID, interacciones, motivoInteracciones); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear la receta",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear la receta",
+		})
+	}
+
 	receta.IDMedico = medicoID
 
-	return c.Status(201).JSON(fiber.Map{
-		"receta":  receta,
-		"mensaje": "Receta creada exitosamente",
+	// Avisar al paciente; un fallo al encolar no invalida la receta ya creada
+	var medicoNombre string
+	database.GetDB().QueryRow(context.Background(),
+		"SELECT nombre FROM Usuario WHERE id_usuario = $1", medicoID).Scan(&medicoNombre)
+	err = notificaciones.Encolar(context.Background(), database.GetDB(), notificaciones.Evento{
+		Tipo:          notificaciones.RecetaEmitida,
+		Destinatarios: []int{receta.IDPaciente},
+		Datos: map[string]interface{}{
+			"IDReceta":    receta.IDReceta,
+			"Medico":      medicoNombre,
+			"Medicamento": receta.Medicamento,
+			"Dosis":       receta.Dosis,
+			"Fecha":       notificaciones.FormatearFecha(&receta.Fecha),
+		},
 	})
+	if err != nil {
+		log.Printf("Error al encolar la notificación de la receta %d: %v", receta.IDReceta, err)
+	}
+
+	respuesta := fiber.Map{
+		"receta":              receta,
+		"items":               items,
+		"codigo_verificacion": formatoCodigoVerificacion(codigoVerificacion),
+		"resurtidos":          resurtidos,
+		"vigente_hasta":       receta.Fecha.AddDate(0, 0, vigenciaDias).Format("2006-01-02"),
+		"mensaje":             "Receta creada exitosamente",
+	}
+	if len(alergias) > 0 {
+		respuesta["alergias_omitidas"] = alergias
+	}
+	if len(interacciones) > 0 {
+		respuesta["interacciones"] = interacciones
+	}
+	return c.Status(201).JSON(respuesta)
 }
 
 // ObtenerRecetas obtiene todas las recetas (con filtros según el rol)
 func ObtenerRecetas(c *fiber.Ctx) error {
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
+	usuario := actorDe(c)
 
-	var query string
+	query := `SELECT r.id_receta, r.fecha, r.medicamento, r.dosis, r.id_medico, r.id_paciente, r.id_consultorio,
+				 u_medico.nombre as medico_nombre, u_paciente.nombre as paciente_nombre, c.nombre_numero as consultorio_nombre
+				 FROM Receta r
+				 JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
+				 JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
+				 JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
+				 WHERE r.deleted_at IS NULL`
 	var args []interface{}
 
-	switch userRole {
-	case "admin":
-		// Admin puede ver todas las recetas
-		query = `SELECT r.id_receta, r.fecha, r.medicamento, r.dosis, r.id_medico, r.id_paciente, r.id_consultorio,
-					 u_medico.nombre as medico_nombre, u_paciente.nombre as paciente_nombre, c.nombre_numero as consultorio_nombre
-					 FROM Receta r
-					 JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
-					 JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
-					 JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
-					 ORDER BY r.fecha DESC`
-	case "medico":
-		// Médico solo ve sus propias recetas
-		query = `SELECT r.id_receta, r.fecha, r.medicamento, r.dosis, r.id_medico, r.id_paciente, r.id_consultorio,
-					 u_medico.nombre as medico_nombre, u_paciente.nombre as paciente_nombre, c.nombre_numero as consultorio_nombre
-					 FROM Receta r
-					 JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
-					 JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
-					 JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
-					 WHERE r.id_medico = $1
-					 ORDER BY r.fecha DESC`
-		args = append(args, userID)
-	case "paciente":
-		// Paciente solo ve sus propias recetas
-		query = `SELECT r.id_receta, r.fecha, r.medicamento, r.dosis, r.id_medico, r.id_paciente, r.id_consultorio,
-					 u_medico.nombre as medico_nombre, u_paciente.nombre as paciente_nombre, c.nombre_numero as consultorio_nombre
-					 FROM Receta r
-					 JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
-					 JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
-					 JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
-					 WHERE r.id_paciente = $1
-					 ORDER BY r.fecha DESC`
-		args = append(args, userID)
-	case "enfermera":
-		// Enfermera puede ver todas las recetas para administración
-		query = `SELECT r.id_receta, r.fecha, r.medicamento, r.dosis, r.id_medico, r.id_paciente, r.id_consultorio,
-					 u_medico.nombre as medico_nombre, u_paciente.nombre as paciente_nombre, c.nombre_numero as consultorio_nombre
-					 FROM Receta r
-					 JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
-					 JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
-					 JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
-					 ORDER BY r.fecha DESC`
+	switch usuario.alcance("recetas_read") {
+	case alcanceTodos:
+		// Todas las recetas
+	case alcancePropios:
+		// Las recetas que el usuario emitió o que le emitieron
+		query += " AND (r.id_medico = $1 OR r.id_paciente = $1)"
+		args = append(args, usuario.id)
 	default:
 		return c.Status(403).JSON(fiber.Map{
 			"error": "No tienes permisos para ver recetas",
 		})
 	}
+	query += " ORDER BY r.fecha DESC"
 
 	rows, err := database.GetDB().Query(context.Background(), query, args...)
 	if err != nil {
@@ -190,31 +291,27 @@ func ObtenerRecetaPorID(c *fiber.Ctx) error {
 		})
 	}
 
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
+	usuario := actorDe(c)
 
-	// Construir query según el rol
-	query := `SELECT r.id_receta, r.fecha, r.medicamento, r.dosis, r.id_medico, r.id_paciente, r.id_consultorio,
+	// Construir query según el alcance del usuario
+	query := `SELECT r.id_receta, r.fecha, r.medicamento, r.dosis, COALESCE(r.instrucciones, ''), r.id_medico, r.id_paciente, r.id_consultorio,
 			  u_medico.nombre as medico_nombre, u_paciente.nombre as paciente_nombre, c.nombre_numero as consultorio_nombre
 			  FROM Receta r
 			  JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
 			  JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
 			  JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
-			  WHERE r.id_receta = $1`
+			  WHERE r.id_receta = $1 AND r.deleted_at IS NULL`
 
 	var args []interface{}
 	args = append(args, id)
 
-	// Agregar filtros según el rol
-	switch userRole {
-	case "medico":
-		query += " AND r.id_medico = $2"
-		args = append(args, userID)
-	case "paciente":
-		query += " AND r.id_paciente = $2"
-		args = append(args, userID)
-	case "admin", "enfermera":
-		// Pueden ver cualquier receta
+	// Agregar filtros según el alcance
+	switch usuario.alcance("recetas_read") {
+	case alcancePropios:
+		query += " AND (r.id_medico = $2 OR r.id_paciente = $2)"
+		args = append(args, usuario.id)
+	case alcanceTodos:
+		// Puede ver cualquier receta
 	default:
 		return c.Status(403).JSON(fiber.Map{
 			"error": "No tienes permisos para ver esta receta",
@@ -230,7 +327,7 @@ func ObtenerRecetaPorID(c *fiber.Ctx) error {
 
 	var receta RecetaDetalle
 	err = database.GetDB().QueryRow(context.Background(), query, args...).Scan(
-		&receta.IDReceta, &receta.Fecha, &receta.Medicamento, &receta.Dosis,
+		&receta.IDReceta, &receta.Fecha, &receta.Medicamento, &receta.Dosis, &receta.Instrucciones,
 		&receta.IDMedico, &receta.IDPaciente, &receta.IDConsultorio,
 		&receta.MedicoNombre, &receta.PacienteNombre, &receta.ConsultorioNombre,
 	)
@@ -241,21 +338,73 @@ func ObtenerRecetaPorID(c *fiber.Ctx) error {
 		})
 	}
 
+	// Medicamentos de la receta; las recetas anteriores al catálogo tienen un solo renglón
+	items, err := obtenerItemsReceta(context.Background(), database.GetDB(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener los medicamentos de la receta",
+		})
+	}
+
+	// Alergias que el médico decidió omitir al emitir la receta
+	type OmisionDetalle struct {
+		models.RecetaAlergiaOmision
+		Sustancia string `json:"sustancia"`
+		Severidad string `json:"severidad"`
+	}
+	omisiones := []OmisionDetalle{}
+	rows, err := database.GetDB().Query(context.Background(),
+		`SELECT o.id_omision, o.id_receta, o.id_alergia, o.id_medico, o.motivo, o.created_at, a.sustancia, a.severidad
+		 FROM RecetaAlergiaOmision o
+		 JOIN Alergia a ON o.id_alergia = a.id_alergia
+		 WHERE o.id_receta = $1
+		 ORDER BY o.id_omision`, id)
+	if err == nil {
+		for rows.Next() {
+			var o OmisionDetalle
+			if err := rows.Scan(&o.IDOmision, &o.IDReceta, &o.IDAlergia, &o.IDMedico, &o.Motivo, &o.CreatedAt,
+				&o.Sustancia, &o.Severidad); err != nil {
+				continue
+			}
+			omisiones = append(omisiones, o)
+		}
+		rows.Close()
+	}
+
+	// Interacciones graves que el médico decidió omitir
+	interaccionesOmitidas, err := obtenerOmisionesInteraccion(context.Background(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener las interacciones de la receta",
+		})
+	}
+
+	// Surtidos en farmacia
+	surtido, err := obtenerSurtidoReceta(context.Background(), database.GetDB(), id, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener el surtido de la receta",
+		})
+	}
+	dispensaciones, err := obtenerDispensaciones(context.Background(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener el surtido de la receta",
+		})
+	}
+
 	return c.JSON(fiber.Map{
-		"receta": receta,
+		"receta":                 receta,
+		"items":                  items,
+		"alergias_omitidas":      omisiones,
+		"interacciones_omitidas": interaccionesOmitidas,
+		"surtido":                surtido,
+		"dispensaciones":         dispensaciones,
 	})
 }
 
 // ActualizarReceta actualiza una receta existente
 func ActualizarReceta(c *fiber.Ctx) error {
-	// Solo médicos pueden actualizar recetas
-	userRole := c.Locals("user_role").(string)
-	if userRole != "medico" {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos pueden actualizar recetas",
-		})
-	}
-
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
@@ -264,61 +413,146 @@ func ActualizarReceta(c *fiber.Ctx) error {
 		})
 	}
 
-	medicoID := c.Locals("user_id").(int)
+	usuario := actorDe(c)
+	medicoID := usuario.id
 
-	// Verificar que la receta existe y pertenece al médico
+	// Verificar que la receta existe y que el usuario puede modificarla (la propia, como su médico)
 	var recetaExistente models.Receta
 	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT id_receta, id_medico FROM Receta WHERE id_receta = $1 AND id_medico = $2",
-		id, medicoID).Scan(&recetaExistente.IDReceta, &recetaExistente.IDMedico)
+		"SELECT id_receta, id_medico, id_paciente FROM Receta WHERE id_receta = $1 AND deleted_at IS NULL",
+		id).Scan(&recetaExistente.IDReceta, &recetaExistente.IDMedico, &recetaExistente.IDPaciente)
 
-	if err != nil {
+	if err != nil || !usuario.puede("recetas_update", recetaExistente.IDMedico) {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Receta no encontrada o no tienes permisos para modificarla",
 		})
 	}
 
 	var recetaActualizada models.Receta
+	var solicitud recetaSolicitud
 	if err := c.BodyParser(&recetaActualizada); err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Datos inválidos",
 		})
 	}
-
-	// Validaciones
-	if recetaActualizada.Medicamento == "" || recetaActualizada.Dosis == "" {
+	if err := c.BodyParser(&solicitud); err != nil {
 		return c.Status(400).JSON(fiber.Map{
-			"error": "Medicamento y dosis son requeridos",
+			"error": "Datos inválidos",
 		})
 	}
 
-	// Actualizar receta
-	query := `UPDATE Receta SET medicamento = $1, dosis = $2 WHERE id_receta = $3 AND id_medico = $4`
+	ctx := context.Background()
+	if err := validarSurtidoReceta(solicitud.SurtidoRecetaRequest); err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar la receta")
+	}
 
-	_, err = database.GetDB().Exec(context.Background(), query,
-		recetaActualizada.Medicamento, recetaActualizada.Dosis, id, medicoID)
+	// Los renglones enviados reemplazan a los anteriores; sin renglones, medicamento y dosis
+	// reemplazan la receta completa por un solo medicamento
+	items, err := prepararItemsReceta(ctx, &recetaActualizada, solicitud.Items)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar la receta")
+	}
 
+	// Comparar los medicamentos con las alergias registradas del paciente
+	alergias, motivoOmision, err := revisarAlergiasReceta(ctx, recetaExistente.IDPaciente, nombresItemsReceta(items), solicitud.OmisionAlergiasRequest)
+	if errors.Is(err, errAlergiasReceta) {
+		return responderAlergiasReceta(c, alergias)
+	}
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar la receta")
+	}
+
+	// Buscar interacciones entre los medicamentos y con las demás recetas activas del paciente
+	interacciones, motivoInteracciones, err := revisarInteraccionesReceta(ctx, recetaExistente.IDPaciente, id,
+		items, solicitud.OmisionInteraccionesRequest)
+	if errors.Is(err, errInteraccionesReceta) {
+		return responderInteraccionesReceta(c, interacciones)
+	}
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar la receta")
+	}
+
+	tx, err := database.GetDB().Begin(ctx)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al actualizar la receta",
 		})
 	}
+	defer tx.Rollback(ctx)
 
-	return c.JSON(fiber.Map{
-		"mensaje": "Receta actualizada exitosamente",
-	})
-}
+	// Una receta surtida en farmacia ya no se modifica
+	surtida, err := recetaSurtida(ctx, tx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Receta no encontrada o no tienes permisos para modificarla",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la receta",
+		})
+	}
+	if surtida {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "La receta ya fue surtida y no puede modificarse",
+		})
+	}
 
-// EliminarReceta elimina una receta
-func EliminarReceta(c *fiber.Ctx) error {
-	// Solo médicos y admin pueden eliminar recetas
-	userRole := c.Locals("user_role").(string)
-	if userRole != "medico" && userRole != "admin" {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo médicos y administradores pueden eliminar recetas",
+	// Actualizar receta; instrucciones, resurtidos y vigencia se conservan si no se envían
+	query := `UPDATE Receta SET medicamento = $1, dosis = $2, instrucciones = COALESCE(NULLIF($3, ''), instrucciones),
+			  resurtidos = COALESCE($6, resurtidos), vigente_hasta = COALESCE(fecha + $7::int, vigente_hasta)
+			  WHERE id_receta = $4 AND id_medico = $5`
+
+	_, err = tx.Exec(ctx, query,
+		recetaActualizada.Medicamento, recetaActualizada.Dosis, strings.TrimSpace(recetaActualizada.Instrucciones), id, recetaExistente.IDMedico,
+		solicitud.Resurtidos, solicitud.VigenciaDias)
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la receta",
 		})
 	}
 
+	if err := guardarItemsReceta(ctx, tx, id, items); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la receta",
+		})
+	}
+
+	if err := registrarOmisionesAlergia(ctx, tx, id, medicoID, alergias, motivoOmision); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la receta",
+		})
+	}
+
+	if err := registrarOmisionesInteraccion(ctx, tx, id, medicoID, interacciones, motivoInteracciones); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la receta",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar la receta",
+		})
+	}
+
+	respuesta := fiber.Map{
+		"items":   items,
+		"mensaje": "Receta actualizada exitosamente",
+	}
+	if len(alergias) > 0 {
+		respuesta["alergias_omitidas"] = alergias
+	}
+	if len(interacciones) > 0 {
+		respuesta["interacciones"] = interacciones
+	}
+	return c.JSON(respuesta)
+}
+
+// EliminarReceta elimina una receta. El borrado es lógico: la receta se conserva con quién la
+// eliminó, cuándo y el motivo, y un admin puede restaurarla.
+func EliminarReceta(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
@@ -327,34 +561,56 @@ func EliminarReceta(c *fiber.Ctx) error {
 		})
 	}
 
-	userID := c.Locals("user_id").(int)
+	usuario := actorDe(c)
+	userID := usuario.id
+	motivo, err := motivoEliminacion(c, true)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la receta")
+	}
 
-	// Verificar permisos
-	var query string
-	var args []interface{}
+	ctx := context.Background()
 
-	if userRole == "admin" {
-		// Admin puede eliminar cualquier receta
-		query = "DELETE FROM Receta WHERE id_receta = $1"
-		args = append(args, id)
-	} else {
-		// Médico solo puede eliminar sus propias recetas
-		query = "DELETE FROM Receta WHERE id_receta = $1 AND id_medico = $2"
-		args = append(args, id, userID)
+	// Con alcance propio solo se eliminan las recetas emitidas por el usuario
+	var idMedico int
+	err = database.GetDB().QueryRow(ctx,
+		"SELECT id_medico FROM Receta WHERE id_receta = $1 AND deleted_at IS NULL", id).Scan(&idMedico)
+	if err != nil || !usuario.puede("recetas_delete", idMedico) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Receta no encontrada o no tienes permisos para eliminarla",
+		})
 	}
 
-	result, err := database.GetDB().Exec(context.Background(), query, args...)
+	// Eliminar la receta y registrarla en el historial en la misma transacción
+	tx, err := database.GetDB().Begin(ctx)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al eliminar la receta",
-		})
+		return responderErrorConsulta(c, err, "Error al eliminar la receta")
 	}
+	defer tx.Rollback(ctx)
 
-	if result.RowsAffected() == 0 {
+	surtida, err := recetaSurtida(ctx, tx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Receta no encontrada o no tienes permisos para eliminarla",
 		})
 	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al eliminar la receta",
+		})
+	}
+	if surtida {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "La receta ya fue surtida y no puede eliminarse",
+		})
+	}
+
+	if err := eliminarRegistro(ctx, tx, models.EliminadoReceta, id, userID, motivo); err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la receta")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar la receta")
+	}
 
 	return c.JSON(fiber.Map{
 		"mensaje": "Receta eliminada exitosamente",
@@ -363,9 +619,6 @@ func EliminarReceta(c *fiber.Ctx) error {
 
 // ObtenerRecetasPorPaciente obtiene todas las recetas de un paciente específico
 func ObtenerRecetasPorPaciente(c *fiber.Ctx) error {
-	userRole := c.Locals("user_role").(string)
-	userID := c.Locals("user_id").(int)
-
 	pacienteIDParam := c.Params("paciente_id")
 	pacienteID, err := strconv.Atoi(pacienteIDParam)
 	if err != nil {
@@ -375,19 +628,9 @@ func ObtenerRecetasPorPaciente(c *fiber.Ctx) error {
 	}
 
 	// Verificar permisos
-	switch userRole {
-	case "paciente":
-		// Paciente solo puede ver sus propias recetas
-		if userID != pacienteID {
-			return c.Status(403).JSON(fiber.Map{
-				"error": "No tienes permisos para ver las recetas de otro paciente",
-			})
-		}
-	case "admin", "medico", "enfermera":
-		// Pueden ver recetas de cualquier paciente
-	default:
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "recetas_read", pacienteID); err != nil || !permitido {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "No tienes permisos para ver recetas",
+			"error": "No tienes permisos para ver las recetas de este paciente",
 		})
 	}
 
@@ -397,7 +640,7 @@ func ObtenerRecetasPorPaciente(c *fiber.Ctx) error {
 			  JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
 			  JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
 			  JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
-			  WHERE r.id_paciente = $1
+			  WHERE r.id_paciente = $1 AND r.deleted_at IS NULL
 			  ORDER BY r.fecha DESC`
 
 	rows, err := database.GetDB().Query(context.Background(), query, pacienteID)
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+	"github.com/lizet96/hospital-backend/pdf"
+)
+
+// Configuración por defecto del documento y la verificación de recetas
+const (
+	nombreClinicaPorDefecto         = "Sistema de Gestión Hospitalaria"
+	urlVerificacionRecetaPorDefecto = "http://localhost:3000/api/v1/recetas/verificar"
+	alfabetoCodigoVerificacion      = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	longitudCodigoVerificacion      = 12
+	longitudHuellaRecetaEnBytes     = 12
+)
+
+// claveRecetas calcula la huella de las recetas impresas; la carga CargarClaveRecetas
+var claveRecetas []byte
+
+// documentoReceta son los datos de la receta que se imprimen y se firman
+type documentoReceta struct {
+	IDReceta      int
+	Codigo        string
+	Fecha         time.Time
+	IDMedico      int
+	IDPaciente    int
+	IDConsultorio int
+	Medico        string
+	Paciente      string
+	Consultorio   string
+	Instrucciones string
+	Items         []models.RecetaItem
+}
+
+// CargarClaveRecetas lee RECETAS_SECRETO, la clave con la que se calcula la huella de las
+// recetas impresas; si cambiara, las recetas ya impresas dejarían de verificarse
+func CargarClaveRecetas() error {
+	clave, err := claveDeEntorno("RECETAS_SECRETO")
+	if err != nil {
+		return err
+	}
+	claveRecetas = clave
+	return nil
+}
+
+// generarCodigoVerificacion genera el código aleatorio con el que se verifica una receta
+func generarCodigoVerificacion() (string, error) {
+	aleatorio := make([]byte, longitudCodigoVerificacion)
+	if _, err := rand.Read(aleatorio); err != nil {
+		return "", err
+	}
+	codigo := make([]byte, longitudCodigoVerificacion)
+	for i, b := range aleatorio {
+		codigo[i] = alfabetoCodigoVerificacion[int(b)%len(alfabetoCodigoVerificacion)]
+	}
+	return string(codigo), nil
+}
+
+// normalizarCodigoVerificacion acepta el código con guiones, espacios o en minúsculas
+func normalizarCodigoVerificacion(codigo string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(codigo) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// formatoCodigoVerificacion separa el código en grupos de 4 para imprimirlo
+func formatoCodigoVerificacion(codigo string) string {
+	var grupos []string
+	for len(codigo) > 4 {
+		grupos = append(grupos, codigo[:4])
+		codigo = codigo[4:]
+	}
+	return strings.Join(append(grupos, codigo), "-")
+}
+
+// huellaReceta firma con HMAC-SHA256 el contenido de la receta. Los nombres de médico,
+// paciente y consultorio no forman parte de la huella para que un cambio de nombre no invalide
+// las recetas impresas; sí sus identificadores.
+func huellaReceta(r documentoReceta) (string, error) {
+	if len(claveRecetas) == 0 {
+		return "", errors.New("clave de las recetas no configurada")
+	}
+	items := make([]interface{}, len(r.Items))
+	for i, item := range r.Items {
+		cantidad := 0
+		if item.Cantidad != nil {
+			cantidad = *item.Cantidad
+		}
+		items[i] = []interface{}{item.Medicamento, item.Dosis, item.Frecuencia, item.Duracion, cantidad,
+			item.Via, item.Indicaciones}
+	}
+	contenido, err := json.Marshal([]interface{}{
+		r.IDReceta, r.Codigo, r.Fecha.Format("2006-01-02"), r.IDMedico, r.IDPaciente, r.IDConsultorio,
+		r.Instrucciones, items,
+	})
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, claveRecetas)
+	mac.Write(contenido)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:longitudHuellaRecetaEnBytes]), nil
+}
+
+// enlaceVerificacionReceta arma la URL pública que va en el código QR
+func enlaceVerificacionReceta(r documentoReceta) (string, error) {
+	huella, err := huellaReceta(r)
+	if err != nil {
+		return "", err
+	}
+	return urlVerificacionRecetas() + "/" + r.Codigo + "?h=" + huella, nil
+}
+
+// urlVerificacionRecetas es la base de la URL de verificación (RECETAS_URL_VERIFICACION)
+func urlVerificacionRecetas() string {
+	base := os.Getenv("RECETAS_URL_VERIFICACION")
+	if base == "" {
+		base = urlVerificacionRecetaPorDefecto
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// obtenerDocumentoReceta carga la receta que cumple el filtro ("r.id_receta = $1" o
+// "r.codigo_verificacion = $1", más las condiciones de visibilidad) con sus medicamentos.
+// Las recetas eliminadas no se encuentran: ni se imprimen ni se verifican ni se surten.
+func obtenerDocumentoReceta(ctx context.Context, filtro string, args ...interface{}) (documentoReceta, error) {
+	var r documentoReceta
+	err := database.GetDB().QueryRow(ctx,
+		`SELECT r.id_receta, COALESCE(r.codigo_verificacion, ''), r.fecha, r.id_medico, r.id_paciente,
+		        r.id_consultorio, u_medico.nombre, u_paciente.nombre, c.nombre_numero,
+		        COALESCE(r.instrucciones, '')
+		 FROM Receta r
+		 JOIN Usuario u_medico ON r.id_medico = u_medico.id_usuario
+		 JOIN Usuario u_paciente ON r.id_paciente = u_paciente.id_usuario
+		 JOIN Consultorio c ON r.id_consultorio = c.id_consultorio
+		 WHERE r.deleted_at IS NULL AND `+filtro, args...).Scan(
+		&r.IDReceta, &r.Codigo, &r.Fecha, &r.IDMedico, &r.IDPaciente, &r.IDConsultorio,
+		&r.Medico, &r.Paciente, &r.Consultorio, &r.Instrucciones)
+	if err != nil {
+		return r, err
+	}
+
+	r.Items, err = obtenerItemsReceta(ctx, database.GetDB(), r.IDReceta)
+	return r, err
+}
+
+// asignarCodigoVerificacion genera el código de las recetas que aún no lo tienen
+func asignarCodigoVerificacion(ctx context.Context, r *documentoReceta) error {
+	codigo, err := generarCodigoVerificacion()
+	if err != nil {
+		return err
+	}
+	err = database.GetDB().QueryRow(ctx,
+		`UPDATE Receta SET codigo_verificacion = COALESCE(codigo_verificacion, $1)
+		 WHERE id_receta = $2 RETURNING codigo_verificacion`, codigo, r.IDReceta).Scan(&r.Codigo)
+	return err
+}
+
+// ObtenerRecetaPDF genera el documento imprimible de la receta con su código de verificación
+func ObtenerRecetaPDF(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	usuario := actorDe(c)
+
+	// Mismas reglas de visibilidad que ObtenerRecetaPorID
+	filtro := "r.id_receta = $1"
+	args := []interface{}{id}
+	switch usuario.alcance("recetas_read") {
+	case alcancePropios:
+		filtro += " AND (r.id_medico = $2 OR r.id_paciente = $2)"
+		args = append(args, usuario.id)
+	case alcanceTodos:
+		// Puede ver cualquier receta
+	default:
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver esta receta",
+		})
+	}
+
+	ctx := context.Background()
+	receta, err := obtenerDocumentoReceta(ctx, filtro, args...)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Receta no encontrada",
+		})
+	}
+	if receta.Codigo == "" {
+		if err := asignarCodigoVerificacion(ctx, &receta); err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al generar el documento de la receta",
+			})
+		}
+	}
+
+	documento, err := generarPDFReceta(receta)
+	if err != nil {
+		log.Printf("Error al generar el PDF de la receta %d: %v", receta.IDReceta, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al generar el documento de la receta",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="receta-%d.pdf"`, receta.IDReceta))
+	return c.Send(documento)
+}
+
+// VerificarReceta es la verificación pública de una receta impresa. Con el código confirma que
+// la receta existe; con la huella del QR (?h=) confirma además que el documento corresponde a
+// la versión vigente. Del paciente solo se muestran las iniciales.
+func VerificarReceta(c *fiber.Ctx) error {
+	codigo := normalizarCodigoVerificacion(c.Params("codigo"))
+	if len(codigo) != longitudCodigoVerificacion {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Código de verificación inválido",
+		})
+	}
+
+	receta, err := obtenerDocumentoReceta(context.Background(), "r.codigo_verificacion = $1", codigo)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"valida": false,
+			"error":  "No existe una receta con ese código de verificación",
+		})
+	}
+
+	type ItemVerificado struct {
+		Medicamento string `json:"medicamento"`
+		Dosis       string `json:"dosis"`
+		Frecuencia  string `json:"frecuencia"`
+		Duracion    string `json:"duracion"`
+		Cantidad    *int   `json:"cantidad"`
+	}
+	items := make([]ItemVerificado, len(receta.Items))
+	for i, item := range receta.Items {
+		items[i] = ItemVerificado{item.Medicamento, item.Dosis, item.Frecuencia, item.Duracion, item.Cantidad}
+	}
+
+	respuesta := fiber.Map{
+		"valida":  true,
+		"mensaje": "La receta es auténtica",
+		"receta": fiber.Map{
+			"codigo_verificacion": formatoCodigoVerificacion(receta.Codigo),
+			"fecha":               receta.Fecha.Format("2006-01-02"),
+			"medico":              receta.Medico,
+			"consultorio":         receta.Consultorio,
+			"paciente_iniciales":  iniciales(receta.Paciente),
+			"items":               items,
+		},
+	}
+
+	if huella := c.Query("h"); huella != "" {
+		esperada, err := huellaReceta(receta)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al verificar la receta",
+			})
+		}
+		if !hmac.Equal([]byte(huella), []byte(esperada)) {
+			respuesta["valida"] = false
+			respuesta["mensaje"] = "El documento no corresponde a la versión vigente de la receta: " +
+				"fue alterado o la receta se modificó después de imprimirse"
+		}
+	} else {
+		respuesta["mensaje"] = "La receta existe; compare los datos con el documento impreso"
+	}
+
+	return c.JSON(respuesta)
+}
+
+// iniciales abrevia un nombre ("Ana María López" queda "A. M. L.")
+func iniciales(nombre string) string {
+	var partes []string
+	for _, palabra := range strings.Fields(nombre) {
+		partes = append(partes, strings.ToUpper(string([]rune(palabra)[:1]))+".")
+	}
+	return strings.Join(partes, " ")
+}
+
+// generarPDFReceta dibuja la receta en tamaño carta: encabezado de la clínica con el código QR,
+// datos de la receta, medicamentos, instrucciones y firma. Los medicamentos que no caben pasan
+// a páginas siguientes.
+func generarPDFReceta(r documentoReceta) ([]byte, error) {
+	const (
+		margen     = 50.0 // puntos
+		tamano     = 10.0 // texto normal
+		titulos    = 11.0 // títulos de sección
+		interlinea = 13.0
+		separacion = 8.0 // entre medicamentos
+		ladoQR     = 96.0
+		pie        = 60.0 // espacio reservado al pie de cada página
+	)
+	anchoUtil := pdf.AnchoCarta - 2*margen
+	limite := pdf.AltoCarta - margen - pie
+	codigo := formatoCodigoVerificacion(r.Codigo)
+
+	doc := pdf.Nuevo(pdf.AnchoCarta, pdf.AltoCarta)
+	doc.Metadatos(fmt.Sprintf("Receta %d", r.IDReceta), r.Medico)
+
+	dibujarPie := func() {
+		y := pdf.AltoCarta - margen
+		doc.Linea(margen, y-22, pdf.AnchoCarta-margen, y-22, 0.5)
+		doc.Texto(margen, y-10, 8, false, fmt.Sprintf("Código de verificación: %s", codigo))
+		doc.Texto(margen, y, 8, false, "Verifique la autenticidad en "+urlVerificacionRecetas()+"/"+r.Codigo)
+	}
+
+	// Encabezado de la clínica y código QR
+	doc.NuevaPagina()
+	dibujarPie()
+	nombreClinica := os.Getenv("CLINICA_NOMBRE")
+	if nombreClinica == "" {
+		nombreClinica = nombreClinicaPorDefecto
+	}
+	y := margen + 10
+	doc.Texto(margen, y, 16, true, nombreClinica)
+	for _, linea := range []string{os.Getenv("CLINICA_DIRECCION"), os.Getenv("CLINICA_TELEFONO")} {
+		if linea != "" {
+			y += interlinea
+			doc.Texto(margen, y, 9, false, linea)
+		}
+	}
+	y += 28
+	doc.Texto(margen, y, 14, true, "RECETA MÉDICA")
+	y += 16
+	doc.Texto(margen, y, 9, false, fmt.Sprintf("Folio %d · Código %s", r.IDReceta, codigo))
+
+	enlace, err := enlaceVerificacionReceta(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.CodigoQR(pdf.AnchoCarta-margen-ladoQR, margen-14, ladoQR, enlace); err != nil {
+		return nil, err
+	}
+	y = max(y, margen-14+ladoQR) + 10
+	doc.Linea(margen, y, pdf.AnchoCarta-margen, y, 0.8)
+
+	// Datos de la receta
+	campos := [][2]string{
+		{"Fecha", r.Fecha.Format("02/01/2006")},
+		{"Médico", r.Medico},
+		{"Consultorio", r.Consultorio},
+		{"Paciente", r.Paciente},
+	}
+	y += 8
+	for _, campo := range campos {
+		y += interlinea + 2
+		doc.Texto(margen, y, tamano, true, campo[0]+":")
+		doc.Texto(margen+80, y, tamano, false, campo[1])
+	}
+	y += 14
+	doc.Linea(margen, y, pdf.AnchoCarta-margen, y, 0.5)
+
+	// asegurar continúa en una página nueva cuando el bloque siguiente no cabe
+	asegurar := func(alto float64) {
+		if y+alto <= limite {
+			return
+		}
+		doc.NuevaPagina()
+		dibujarPie()
+		y = margen + 10
+		doc.Texto(margen, y, 9, true, fmt.Sprintf("Receta folio %d (continuación)", r.IDReceta))
+		y += 10
+		doc.Linea(margen, y, pdf.AnchoCarta-margen, y, 0.5)
+	}
+
+	// Medicamentos
+	y += 20
+	doc.Texto(margen, y, titulos, true, "Medicamentos")
+	sangria := 16.0
+	for i, item := range r.Items {
+		titulo := pdf.Dividir(fmt.Sprintf("%d. %s", i+1, item.Medicamento), anchoUtil, tamano, true)
+		detalle := pdf.Dividir(detalleItemReceta(item), anchoUtil-sangria, tamano, false)
+		var indicaciones []string
+		if item.Indicaciones != "" {
+			indicaciones = pdf.Dividir("Indicaciones: "+item.Indicaciones, anchoUtil-sangria, tamano, false)
+		}
+
+		asegurar(float64(len(titulo)+len(detalle)+len(indicaciones))*interlinea + separacion)
+		y += separacion
+		for _, linea := range titulo {
+			y += interlinea
+			doc.Texto(margen, y, tamano, true, linea)
+		}
+		for _, linea := range append(detalle, indicaciones...) {
+			y += interlinea
+			doc.Texto(margen+sangria, y, tamano, false, linea)
+		}
+	}
+
+	// Instrucciones generales
+	if r.Instrucciones != "" {
+		lineas := pdf.Dividir(r.Instrucciones, anchoUtil, tamano, false)
+		asegurar(float64(len(lineas)+2) * interlinea)
+		y += 24
+		doc.Texto(margen, y, titulos, true, "Instrucciones")
+		for _, linea := range lineas {
+			y += interlinea
+			doc.Texto(margen, y, tamano, false, linea)
+		}
+	}
+
+	// Firma del médico
+	asegurar(70)
+	y += 60
+	doc.Linea(pdf.AnchoCarta-margen-200, y, pdf.AnchoCarta-margen, y, 0.5)
+	doc.TextoDerecha(pdf.AnchoCarta-margen, y+12, 9, false, r.Medico)
+
+	return doc.Bytes(), nil
+}
+
+// detalleItemReceta resume la posología de un medicamento en una línea
+func detalleItemReceta(item models.RecetaItem) string {
+	partes := []string{"Dosis: " + item.Dosis}
+	if item.Frecuencia != "" {
+		partes = append(partes, "Frecuencia: "+item.Frecuencia)
+	}
+	if item.Duracion != "" {
+		partes = append(partes, "Duración: "+item.Duracion)
+	}
+	if item.Cantidad != nil {
+		partes = append(partes, fmt.Sprintf("Cantidad: %d", *item.Cantidad))
+	}
+	if item.Via != "" {
+		partes = append(partes, "Vía: "+item.Via)
+	}
+	return strings.Join(partes, " · ")
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lizet96/hospital-backend/models"
+)
+
+func TestHuellaReceta(t *testing.T) {
+	anterior := claveRecetas
+	claveRecetas = []byte("clave de prueba")
+	t.Cleanup(func() { claveRecetas = anterior })
+
+	cantidad := 21
+	base := documentoReceta{
+		IDReceta:      8,
+		Codigo:        "ABCD2345EFGH",
+		Fecha:         time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC),
+		IDMedico:      2,
+		IDPaciente:    5,
+		IDConsultorio: 1,
+		Medico:        "Dra. López",
+		Paciente:      "Juan Pérez",
+		Consultorio:   "101",
+		Instrucciones: "Tomar con alimentos",
+		Items: []models.RecetaItem{
+			{Medicamento: "Amoxicilina 500 mg", Dosis: "1 cápsula", Frecuencia: "cada 8 horas",
+				Duracion: "7 días", Cantidad: &cantidad, Via: "oral"},
+		},
+	}
+	huellaBase, err := huellaReceta(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otraCantidad := 30
+	casos := []struct {
+		nombre  string
+		cambiar func(r *documentoReceta)
+		cambia  bool
+	}{
+		{"misma receta", func(r *documentoReceta) {}, false},
+		{"otro nombre de médico", func(r *documentoReceta) { r.Medico = "Dra. López García" }, false},
+		{"otro nombre de paciente", func(r *documentoReceta) { r.Paciente = "Juan Pérez Gómez" }, false},
+		{"otra hora del mismo día", func(r *documentoReceta) { r.Fecha = r.Fecha.Add(time.Hour) }, false},
+		{"otro día", func(r *documentoReceta) { r.Fecha = r.Fecha.AddDate(0, 0, 1) }, true},
+		{"otro código", func(r *documentoReceta) { r.Codigo = "ABCD2345EFGJ" }, true},
+		{"otro paciente", func(r *documentoReceta) { r.IDPaciente = 6 }, true},
+		{"otras instrucciones", func(r *documentoReceta) { r.Instrucciones = "Tomar en ayunas" }, true},
+		{"otra dosis", func(r *documentoReceta) {
+			r.Items = []models.RecetaItem{{Medicamento: "Amoxicilina 500 mg", Dosis: "2 cápsulas"}}
+		}, true},
+		{"otra cantidad", func(r *documentoReceta) {
+			r.Items = append([]models.RecetaItem{}, r.Items...)
+			r.Items[0].Cantidad = &otraCantidad
+		}, true},
+		{"renglón agregado", func(r *documentoReceta) {
+			r.Items = append(append([]models.RecetaItem{}, r.Items...), models.RecetaItem{Medicamento: "Paracetamol"})
+		}, true},
+	}
+	for _, caso := range casos {
+		receta := base
+		caso.cambiar(&receta)
+		huella, err := huellaReceta(receta)
+		if err != nil {
+			t.Errorf("%s: error inesperado: %v", caso.nombre, err)
+			continue
+		}
+		if (huella != huellaBase) != caso.cambia {
+			t.Errorf("%s: la huella cambió: %v, se esperaba %v", caso.nombre, huella != huellaBase, caso.cambia)
+		}
+	}
+
+	// La huella depende de la clave y sin clave no se calcula
+	claveRecetas = []byte("otra clave")
+	if huella, _ := huellaReceta(base); huella == huellaBase {
+		t.Error("la huella no depende de la clave")
+	}
+	claveRecetas = nil
+	if _, err := huellaReceta(base); err == nil {
+		t.Error("se calculó una huella sin clave")
+	}
+}
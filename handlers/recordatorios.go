@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+	"github.com/lizet96/hospital-backend/notificaciones"
+)
+
+// Configuración por defecto de los recordatorios de citas
+const (
+	offsetsRecordatorioPorDefecto = "24h,2h"
+	urlRecordatoriosPorDefecto    = "http://localhost:3000/api/v1/recordatorios"
+	intervaloRecordatorios        = time.Minute
+	loteRecordatorios             = 100
+)
+
+// Acciones disponibles desde el enlace de un recordatorio
+const (
+	accionRecordatorioConfirmar = "confirmar"
+	accionRecordatorioCancelar  = "cancelar"
+)
+
+// claveRecordatorios firma los enlaces de los recordatorios; la carga CargarClaveRecordatorios
+var claveRecordatorios []byte
+
+// offsetsRecordatorio lee RECORDATORIOS_OFFSETS (por ejemplo "24h,2h") ordenados de menor a mayor
+func offsetsRecordatorio() []time.Duration {
+	lista := os.Getenv("RECORDATORIOS_OFFSETS")
+	if lista == "" {
+		lista = offsetsRecordatorioPorDefecto
+	}
+
+	var offsets []time.Duration
+	for _, texto := range strings.Split(lista, ",") {
+		offset, err := time.ParseDuration(strings.TrimSpace(texto))
+		if err != nil || offset <= 0 {
+			log.Printf("Recordatorios: offset inválido '%s' ignorado", texto)
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+// CargarClaveRecordatorios lee RECORDATORIOS_SECRETO, la clave con la que se firman los enlaces
+// de los recordatorios
+func CargarClaveRecordatorios() error {
+	clave, err := claveDeEntorno("RECORDATORIOS_SECRETO")
+	if err != nil {
+		return err
+	}
+	claveRecordatorios = clave
+	return nil
+}
+
+// claveFirmaRecordatorios devuelve la clave de los enlaces; sin ella no se firma ni verifica nada
+func claveFirmaRecordatorios() ([]byte, error) {
+	if len(claveRecordatorios) == 0 {
+		return nil, errors.New("clave de los recordatorios no configurada")
+	}
+	return claveRecordatorios, nil
+}
+
+// firmarEnlaceRecordatorio genera el token de un enlace: id del recordatorio, acción y
+// vencimiento firmados con HMAC-SHA256
+func firmarEnlaceRecordatorio(idRecordatorio int, accion string, expira time.Time) (string, error) {
+	clave, err := claveFirmaRecordatorios()
+	if err != nil {
+		return "", err
+	}
+	carga := fmt.Sprintf("%d.%s.%d", idRecordatorio, accion, expira.Unix())
+	mac := hmac.New(sha256.New, clave)
+	mac.Write([]byte(carga))
+	return base64.RawURLEncoding.EncodeToString([]byte(carga)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verificarEnlaceRecordatorio valida la firma y el vencimiento del token y devuelve sus datos
+func verificarEnlaceRecordatorio(token string) (int, string, error) {
+	partes := strings.Split(token, ".")
+	if len(partes) != 2 {
+		return 0, "", errors.New("token con formato inválido")
+	}
+	carga, err := base64.RawURLEncoding.DecodeString(partes[0])
+	if err != nil {
+		return 0, "", errors.New("token con formato inválido")
+	}
+	firma, err := base64.RawURLEncoding.DecodeString(partes[1])
+	if err != nil {
+		return 0, "", errors.New("token con formato inválido")
+	}
+
+	clave, err := claveFirmaRecordatorios()
+	if err != nil {
+		return 0, "", err
+	}
+	mac := hmac.New(sha256.New, clave)
+	mac.Write(carga)
+	if !hmac.Equal(firma, mac.Sum(nil)) {
+		return 0, "", errors.New("firma inválida")
+	}
+
+	campos := strings.Split(string(carga), ".")
+	if len(campos) != 3 {
+		return 0, "", errors.New("token con formato inválido")
+	}
+	idRecordatorio, err1 := strconv.Atoi(campos[0])
+	expira, err2 := strconv.ParseInt(campos[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, "", errors.New("token con formato inválido")
+	}
+	if !horaDePared(time.Now()).Before(time.Unix(expira, 0)) {
+		return 0, "", errors.New("el enlace ya venció")
+	}
+	return idRecordatorio, campos[1], nil
+}
+
+// enlaceRecordatorio arma la URL pública de una acción del recordatorio
+func enlaceRecordatorio(idRecordatorio int, accion string, expira time.Time) (string, error) {
+	token, err := firmarEnlaceRecordatorio(idRecordatorio, accion, expira)
+	if err != nil {
+		return "", err
+	}
+	base := os.Getenv("RECORDATORIOS_URL_BASE")
+	if base == "" {
+		base = urlRecordatoriosPorDefecto
+	}
+	return strings.TrimRight(base, "/") + "/" + token, nil
+}
+
+// ProcesarRecordatorios encola los recordatorios que ya corresponde enviar. Un recordatorio se
+// registra una sola vez por consulta y offset, así que reiniciar el servidor no los duplica; si
+// ya se envió uno más cercano a la cita, los de offsets mayores se omiten.
+func ProcesarRecordatorios(ctx context.Context) (int, error) {
+	enviados := 0
+	for _, offset := range offsetsRecordatorio() {
+		n, err := procesarRecordatoriosOffset(ctx, offset)
+		enviados += n
+		if err != nil {
+			return enviados, err
+		}
+	}
+	return enviados, nil
+}
+
+// procesarRecordatoriosOffset encola los recordatorios de un offset en una transacción
+func procesarRecordatoriosOffset(ctx context.Context, offset time.Duration) (int, error) {
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	ahora := horaDePared(time.Now())
+	minutos := int(offset.Minutes())
+	rows, err := tx.Query(ctx,
+		`SELECT c.id_consulta, c.estado, COALESCE(h.fecha_hora, c.hora)
+		 FROM Consulta c
+		 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		 WHERE c.estado IN ('programada', 'confirmada')
+		 AND COALESCE(h.fecha_hora, c.hora) > $1
+		 AND COALESCE(h.fecha_hora, c.hora) <= $2
+		 AND NOT EXISTS (
+		     SELECT 1 FROM RecordatorioConsulta r
+		     WHERE r.id_consulta = c.id_consulta AND r.minutos_antes <= $3)
+		 ORDER BY COALESCE(h.fecha_hora, c.hora)
+		 LIMIT $4`,
+		ahora.Format(formatoTimestamp), ahora.Add(offset).Format(formatoTimestamp), minutos, loteRecordatorios)
+	if err != nil {
+		return 0, err
+	}
+
+	type pendiente struct {
+		idConsulta int
+		estado     string
+		hora       time.Time
+	}
+	var pendientes []pendiente
+	for rows.Next() {
+		var p pendiente
+		if err := rows.Scan(&p.idConsulta, &p.estado, &p.hora); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pendientes = append(pendientes, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	enviados := 0
+	for _, p := range pendientes {
+		var idRecordatorio int
+		err := tx.QueryRow(ctx,
+			`INSERT INTO RecordatorioConsulta (id_consulta, minutos_antes) VALUES ($1, $2)
+			 ON CONFLICT (id_consulta, minutos_antes) DO NOTHING
+			 RETURNING id_recordatorio`, p.idConsulta, minutos).Scan(&idRecordatorio)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// El enlace para confirmar vale hasta la hora de la cita. El de cancelar solo se ofrece si
+		// aún hay la anticipación mínima que se exige al paciente, y vence cuando deja de haberla.
+		datos := map[string]interface{}{
+			"EnlaceConfirmar": "",
+			"EnlaceCancelar":  "",
+		}
+		if p.estado == models.EstadoProgramada {
+			if datos["EnlaceConfirmar"], err = enlaceRecordatorio(idRecordatorio, accionRecordatorioConfirmar, p.hora); err != nil {
+				return 0, err
+			}
+		}
+		if limite := p.hora.Add(-anticipacionCancelacionPaciente()); ahora.Before(limite) {
+			if datos["EnlaceCancelar"], err = enlaceRecordatorio(idRecordatorio, accionRecordatorioCancelar, limite); err != nil {
+				return 0, err
+			}
+		}
+		err = notificaciones.EncolarConsultaPaciente(ctx, tx, notificaciones.ConsultaRecordatorio, p.idConsulta, datos)
+		if err != nil {
+			return 0, err
+		}
+		enviados++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return enviados, nil
+}
+
+// IniciarRecordatorios revisa periódicamente las citas próximas y encola sus recordatorios
+func IniciarRecordatorios() {
+	log.Printf("Recordatorios: offsets %v", offsetsRecordatorio())
+
+	ticker := time.NewTicker(intervaloRecordatorios)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		enviados, err := ProcesarRecordatorios(context.Background())
+		if err != nil {
+			log.Printf("Error al procesar recordatorios: %v", err)
+			continue
+		}
+		if enviados > 0 {
+			log.Printf("Recordatorios: %d encolados", enviados)
+		}
+	}
+}
+
+// VerRecordatorio muestra la cita y la acción de un enlace de recordatorio sin aplicarla (ruta pública)
+func VerRecordatorio(c *fiber.Ctx) error {
+	idRecordatorio, accion, err := verificarEnlaceRecordatorio(c.Params("token"))
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Enlace inválido: " + err.Error(),
+		})
+	}
+
+	var idConsulta int
+	var estado, medico string
+	var hora *time.Time
+	var usadoAt *time.Time
+	err = database.GetDB().QueryRow(context.Background(),
+		`SELECT c.id_consulta, c.estado, m.nombre, COALESCE(h.fecha_hora, c.hora), r.usado_at
+		 FROM RecordatorioConsulta r
+		 JOIN Consulta c ON r.id_consulta = c.id_consulta
+		 JOIN Usuario m ON c.id_medico = m.id_usuario
+		 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		 WHERE r.id_recordatorio = $1`, idRecordatorio).Scan(&idConsulta, &estado, &medico, &hora, &usadoAt)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Recordatorio no encontrado",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"accion":        accion,
+		"usado":         usadoAt != nil,
+		"id_consulta":   idConsulta,
+		"estado":        estado,
+		"medico_nombre": medico,
+		"hora":          hora,
+	})
+}
+
+// UsarRecordatorio aplica la acción (confirmar o cancelar) de un enlace de recordatorio.
+// Es una ruta pública: el token firmado identifica al paciente y solo puede usarse una vez.
+func UsarRecordatorio(c *fiber.Ctx) error {
+	idRecordatorio, accion, err := verificarEnlaceRecordatorio(c.Params("token"))
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Enlace inválido: " + err.Error(),
+		})
+	}
+
+	var nuevoEstado string
+	switch accion {
+	case accionRecordatorioConfirmar:
+		nuevoEstado = models.EstadoConfirmada
+	case accionRecordatorioCancelar:
+		nuevoEstado = models.EstadoCancelada
+	default:
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Acción inválida",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al procesar el recordatorio")
+	}
+	defer tx.Rollback(ctx)
+
+	var idConsulta, idPaciente int
+	var usadoAt *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT r.id_consulta, c.id_paciente, r.usado_at
+		 FROM RecordatorioConsulta r
+		 JOIN Consulta c ON r.id_consulta = c.id_consulta
+		 WHERE r.id_recordatorio = $1 FOR UPDATE OF r`, idRecordatorio).Scan(&idConsulta, &idPaciente, &usadoAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Recordatorio no encontrado",
+		})
+	}
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al procesar el recordatorio")
+	}
+
+	if usadoAt != nil {
+		return c.Status(410).JSON(fiber.Map{
+			"error": "El enlace ya fue utilizado",
+		})
+	}
+
+	// Se aplican las reglas del paciente (incluida la anticipación mínima para cancelar): el
+	// enlace solo permite actuar sobre sus propias citas
+	consulta, err := cambiarEstadoConsulta(ctx, tx, idConsulta, actorUsuario(ctx, idPaciente).soloPropios(), nuevoEstado,
+		"Desde el recordatorio de la cita")
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al procesar el recordatorio")
+	}
+
+	if nuevoEstado == models.EstadoCancelada {
+		if err := liberarHorario(ctx, tx, consulta.IDHorario); err != nil {
+			return responderErrorConsulta(c, err, "Error al liberar el horario")
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE RecordatorioConsulta SET usado_at = CURRENT_TIMESTAMP, accion_usada = $1
+		 WHERE id_recordatorio = $2`, accion, idRecordatorio)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al procesar el recordatorio")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return responderErrorConsulta(c, err, "Error al procesar el recordatorio")
+	}
+
+	mensaje := "Asistencia confirmada"
+	if nuevoEstado == models.EstadoCancelada {
+		mensaje = "Cita cancelada"
+	}
+	return c.JSON(fiber.Map{
+		"mensaje":     mensaje,
+		"id_consulta": idConsulta,
+		"estado":      nuevoEstado,
+	})
+}
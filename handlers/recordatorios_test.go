@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnlaceRecordatorio(t *testing.T) {
+	anterior := claveRecordatorios
+	claveRecordatorios = []byte("clave de prueba")
+	t.Cleanup(func() { claveRecordatorios = anterior })
+
+	ahora := horaDePared(time.Now())
+	firmar := func(id int, accion string, expira time.Time) string {
+		token, err := firmarEnlaceRecordatorio(id, accion, expira)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	vigente := firmar(15, "confirmar", ahora.Add(time.Hour))
+	partes := strings.Split(vigente, ".")
+	otraCarga := strings.Split(firmar(16, "cancelar", ahora.Add(time.Hour)), ".")[0]
+
+	casos := []struct {
+		nombre string
+		token  string
+		id     int
+		accion string
+		error  bool
+	}{
+		{"vigente", vigente, 15, "confirmar", false},
+		{"otra acción", firmar(15, "cancelar", ahora.Add(time.Hour)), 15, "cancelar", false},
+		{"vencido", firmar(15, "confirmar", ahora.Add(-time.Minute)), 0, "", true},
+		{"carga de otro token", otraCarga + "." + partes[1], 0, "", true},
+		{"firma alterada", partes[0] + "." + strings.Repeat("A", len(partes[1])), 0, "", true},
+		{"sin firma", partes[0], 0, "", true},
+		{"no es base64", "%%%." + partes[1], 0, "", true},
+		{"vacío", "", 0, "", true},
+	}
+	for _, caso := range casos {
+		id, accion, err := verificarEnlaceRecordatorio(caso.token)
+		if (err != nil) != caso.error {
+			t.Errorf("%s: error = %v, se esperaba error: %v", caso.nombre, err, caso.error)
+			continue
+		}
+		if id != caso.id || accion != caso.accion {
+			t.Errorf("%s: = %d, %q; se esperaba %d, %q", caso.nombre, id, accion, caso.id, caso.accion)
+		}
+	}
+
+	// Un token firmado con otra clave no es válido
+	claveRecordatorios = []byte("otra clave")
+	if _, _, err := verificarEnlaceRecordatorio(vigente); err == nil {
+		t.Error("se aceptó un token firmado con otra clave")
+	}
+
+	// Sin clave no se firma ni se verifica
+	claveRecordatorios = nil
+	if _, err := firmarEnlaceRecordatorio(15, "confirmar", ahora.Add(time.Hour)); err == nil {
+		t.Error("se firmó un enlace sin clave")
+	}
+	if _, _, err := verificarEnlaceRecordatorio(vigente); err == nil {
+		t.Error("se verificó un enlace sin clave")
+	}
+}
@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,29 +14,28 @@ import (
 
 // GenerarReporteConsultas genera un reporte de consultas
 func GenerarReporteConsultas(c *fiber.Ctx) error {
-	userID := c.Locals("user_id").(int)
-
-	// Verificar si el usuario es médico para filtrar sus consultas
-	var rolNombre string
-	err := database.GetDB().QueryRow(context.Background(), `
-	    SELECT r.nombre 
-	    FROM Usuario u 
-	    JOIN Rol r ON u.id_rol = r.id_rol 
-	    WHERE u.id_usuario = $1
-	`, userID).Scan(&rolNombre)
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al obtener información del usuario",
+	usuario := actorDe(c)
+	userID := usuario.id
+
+	// Con reportes_read_own solo se cuentan las consultas del médico
+	soloPropias := false
+	switch usuario.alcance("reportes_read") {
+	case alcanceTodos:
+	case alcancePropios:
+		soloPropias = true
+	default:
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver este reporte",
 		})
 	}
 
-	var whereClause string
+	// Las consultas eliminadas no cuentan en los reportes
+	whereClause := "WHERE deleted_at IS NULL"
 	var args []interface{}
 
-	// Si es médico, solo sus consultas
-	if rolNombre == "medico" {
-		whereClause = "WHERE id_medico = $1"
+	// Solo sus consultas
+	if soloPropias {
+		whereClause += " AND id_medico = $1"
 		args = append(args, userID)
 	}
 
@@ -43,16 +45,16 @@ func GenerarReporteConsultas(c *fiber.Ctx) error {
 
 	// Total de consultas
 	query := "SELECT COUNT(*) FROM Consulta " + whereClause
-	err = database.GetDB().QueryRow(context.Background(), query, args...).Scan(&reporte.TotalConsultas)
+	err := database.GetDB().QueryRow(context.Background(), query, args...).Scan(&reporte.TotalConsultas)
 	if err != nil {
 		reporte.TotalConsultas = 0
 	}
 
 	// Consultas de hoy
 	hoy := time.Now().Format("2006-01-02")
-	queryHoy := "SELECT COUNT(*) FROM Consulta WHERE DATE(fecha) = $1"
+	queryHoy := "SELECT COUNT(*) FROM Consulta WHERE DATE(fecha) = $1 AND deleted_at IS NULL"
 	argsHoy := []interface{}{hoy}
-	if rolNombre == "medico" {
+	if soloPropias {
 		queryHoy += " AND id_medico = $2"
 		argsHoy = append(argsHoy, userID)
 	}
@@ -63,9 +65,9 @@ func GenerarReporteConsultas(c *fiber.Ctx) error {
 
 	// Consultas de esta semana
 	inicioSemana := time.Now().AddDate(0, 0, -int(time.Now().Weekday())).Format("2006-01-02")
-	querySemana := "SELECT COUNT(*) FROM Consulta WHERE DATE(fecha) >= $1"
+	querySemana := "SELECT COUNT(*) FROM Consulta WHERE DATE(fecha) >= $1 AND deleted_at IS NULL"
 	argsSemana := []interface{}{inicioSemana}
-	if rolNombre == "medico" {
+	if soloPropias {
 		querySemana += " AND id_medico = $2"
 		argsSemana = append(argsSemana, userID)
 	}
@@ -74,13 +76,32 @@ func GenerarReporteConsultas(c *fiber.Ctx) error {
 		reporte.ConsultasSemana = 0
 	}
 
-	// Ingresos totales
-	queryIngresos := "SELECT COALESCE(SUM(costo), 0) FROM Consulta WHERE estado = 'completada' " + whereClause
+	// Ingresos totales (solo consultas completadas)
+	queryIngresos := "SELECT COALESCE(SUM(costo), 0) FROM Consulta WHERE estado = 'completada' AND deleted_at IS NULL"
+	if soloPropias {
+		queryIngresos += " AND id_medico = $1"
+	}
 	err = database.GetDB().QueryRow(context.Background(), queryIngresos, args...).Scan(&reporte.IngresosTotales)
 	if err != nil {
 		reporte.IngresosTotales = 0
 	}
 
+	// Consultas por estado
+	reporte.ConsultasPorEstado = make(map[string]int)
+	queryEstados := "SELECT estado, COUNT(*) FROM Consulta " + whereClause + " GROUP BY estado"
+	rows, err := database.GetDB().Query(context.Background(), queryEstados, args...)
+	if err == nil {
+		for rows.Next() {
+			var estado string
+			var total int
+			if err := rows.Scan(&estado, &total); err != nil {
+				continue
+			}
+			reporte.ConsultasPorEstado[estado] = total
+		}
+		rows.Close()
+	}
+
 	// Promedio de consultas por día (últimos 30 días)
 	if reporte.TotalConsultas > 0 {
 		reporte.PromedioConsultas = float64(reporte.TotalConsultas) / 30.0
@@ -94,19 +115,9 @@ func GenerarReporteConsultas(c *fiber.Ctx) error {
 
 // ObtenerEstadisticasGenerales obtiene estadísticas generales del sistema
 func ObtenerEstadisticasGenerales(c *fiber.Ctx) error {
-	// Verificar si el usuario es admin usando el nuevo sistema de roles
-	userID := c.Locals("user_id").(int)
-	var rolNombre string
-	err := database.GetDB().QueryRow(context.Background(), `
-	    SELECT r.nombre 
-	    FROM Usuario u 
-	    JOIN Rol r ON u.id_rol = r.id_rol 
-	    WHERE u.id_usuario = $1
-	`, userID).Scan(&rolNombre)
-
-	if err != nil || rolNombre != "admin" {
+	if !actorDe(c).puede("reportes_estadisticas") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden ver estadísticas generales",
+			"error": "No tienes permisos para ver estadísticas generales",
 		})
 	}
 
@@ -126,37 +137,37 @@ func ObtenerEstadisticasGenerales(c *fiber.Ctx) error {
 	stats.FechaGeneracion = time.Now()
 
 	// Total de usuarios
-	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Usuario").Scan(&stats.TotalUsuarios)
+	err := database.GetDB().QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM Usuario WHERE deleted_at IS NULL").Scan(&stats.TotalUsuarios)
 	if err != nil {
 		stats.TotalUsuarios = 0
 	}
 
 	// Total por tipo de usuario usando el nuevo sistema de roles
 	database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol WHERE r.nombre = 'paciente'").Scan(&stats.TotalPacientes)
+		"SELECT COUNT(*) FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol WHERE r.nombre = 'paciente' AND u.deleted_at IS NULL").Scan(&stats.TotalPacientes)
 	database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol WHERE r.nombre = 'medico'").Scan(&stats.TotalMedicos)
+		"SELECT COUNT(*) FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol WHERE r.nombre = 'medico' AND u.deleted_at IS NULL").Scan(&stats.TotalMedicos)
 	database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol WHERE r.nombre = 'enfermera'").Scan(&stats.TotalEnfermeras)
+		"SELECT COUNT(*) FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol WHERE r.nombre = 'enfermera' AND u.deleted_at IS NULL").Scan(&stats.TotalEnfermeras)
 
 	// Total de consultas
 	database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Consulta").Scan(&stats.TotalConsultas)
+		"SELECT COUNT(*) FROM Consulta WHERE deleted_at IS NULL").Scan(&stats.TotalConsultas)
 
 	// Consultas de hoy
 	hoy := time.Now().Format("2006-01-02")
 	database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Consulta WHERE DATE(fecha) = $1", hoy).Scan(&stats.ConsultasHoy)
+		"SELECT COUNT(*) FROM Consulta WHERE DATE(fecha) = $1 AND deleted_at IS NULL", hoy).Scan(&stats.ConsultasHoy)
 
 	// Total de expedientes
 	database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Expediente").Scan(&stats.TotalExpedientes)
+		"SELECT COUNT(*) FROM Expediente WHERE deleted_at IS NULL").Scan(&stats.TotalExpedientes)
 
 	// Ingresos del mes actual
 	inicioMes := time.Now().Format("2006-01-01")
 	database.GetDB().QueryRow(context.Background(),
-		"SELECT COALESCE(SUM(costo), 0) FROM Consulta WHERE estado = 'completada' AND DATE(fecha) >= $1",
+		"SELECT COALESCE(SUM(costo), 0) FROM Consulta WHERE estado = 'completada' AND DATE(fecha) >= $1 AND deleted_at IS NULL",
 		inicioMes).Scan(&stats.IngresosMes)
 
 	return c.JSON(fiber.Map{
@@ -167,45 +178,34 @@ func ObtenerEstadisticasGenerales(c *fiber.Ctx) error {
 
 // ObtenerReportePacientes obtiene reporte de pacientes por médico
 func ObtenerReportePacientes(c *fiber.Ctx) error {
-	// Verificar permisos usando el nuevo sistema de roles
-	userID := c.Locals("user_id").(int)
-	var rolNombre string
-	err := database.GetDB().QueryRow(context.Background(), `
-	    SELECT r.nombre 
-	    FROM Usuario u 
-	    JOIN Rol r ON u.id_rol = r.id_rol 
-	    WHERE u.id_usuario = $1
-	`, userID).Scan(&rolNombre)
-
-	if err != nil || (rolNombre != "admin" && rolNombre != "medico") {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "No tienes permisos para ver este reporte",
-		})
-	}
+	usuario := actorDe(c)
 
 	var query string
 	var args []interface{}
 
-	if rolNombre == "admin" {
-		// Admin puede ver todos los médicos y sus pacientes
+	switch usuario.alcance("reportes_pacientes") {
+	case alcanceTodos:
+		// Puede ver todos los médicos y sus pacientes
 		query = `SELECT m.nombre as medico_nombre, COUNT(DISTINCT c.id_paciente) as total_pacientes,
 				 COUNT(c.id_consulta) as total_consultas
 				 FROM Usuario m
-				 JOIN Rol r ON m.id_rol = r.id_rol
-				 LEFT JOIN Consulta c ON m.id_usuario = c.id_medico
-				 WHERE r.nombre = 'medico'
+				 LEFT JOIN Consulta c ON m.id_usuario = c.id_medico AND c.deleted_at IS NULL
+				 WHERE ` + condicionRolMedico("m") + ` AND m.deleted_at IS NULL
 				 GROUP BY m.id_usuario, m.nombre
 				 ORDER BY total_pacientes DESC`
-	} else {
+	case alcancePropios:
 		// Médico solo ve sus propios pacientes
 		query = `SELECT m.nombre as medico_nombre, COUNT(DISTINCT c.id_paciente) as total_pacientes,
 				 COUNT(c.id_consulta) as total_consultas
 				 FROM Usuario m
-				 JOIN Rol r ON m.id_rol = r.id_rol
-				 LEFT JOIN Consulta c ON m.id_usuario = c.id_medico
-				 WHERE r.nombre = 'medico' AND m.id_usuario = $1
+				 LEFT JOIN Consulta c ON m.id_usuario = c.id_medico AND c.deleted_at IS NULL
+				 WHERE ` + condicionRolMedico("m") + ` AND m.deleted_at IS NULL AND m.id_usuario = $1
 				 GROUP BY m.id_usuario, m.nombre`
-		args = append(args, userID)
+		args = append(args, usuario.id)
+	default:
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver este reporte",
+		})
 	}
 
 	rows, err := database.GetDB().Query(context.Background(), query, args...)
@@ -241,19 +241,9 @@ func ObtenerReportePacientes(c *fiber.Ctx) error {
 
 // ObtenerReporteIngresos obtiene reporte de ingresos por período
 func ObtenerReporteIngresos(c *fiber.Ctx) error {
-	// Verificar si el usuario es admin usando el nuevo sistema de roles
-	userID := c.Locals("user_id").(int)
-	var rolNombre string
-	err := database.GetDB().QueryRow(context.Background(), `
-	    SELECT r.nombre 
-	    FROM Usuario u 
-	    JOIN Rol r ON u.id_rol = r.id_rol 
-	    WHERE u.id_usuario = $1
-	`, userID).Scan(&rolNombre)
-
-	if err != nil || rolNombre != "admin" {
+	if !actorDe(c).puede("reportes_ingresos") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden ver reportes de ingresos",
+			"error": "No tienes permisos para ver reportes de ingresos",
 		})
 	}
 
@@ -278,7 +268,7 @@ func ObtenerReporteIngresos(c *fiber.Ctx) error {
 	query := `SELECT DATE(fecha) as fecha, COUNT(*) as total_consultas, 
 			  COALESCE(SUM(costo), 0) as ingresos_dia
 			  FROM Consulta 
-			  WHERE estado = 'completada' AND DATE(fecha) BETWEEN $1 AND $2
+			  WHERE estado = 'completada' AND DATE(fecha) BETWEEN $1 AND $2 AND deleted_at IS NULL
 			  GROUP BY DATE(fecha)
 			  ORDER BY fecha DESC`
 
@@ -320,19 +310,9 @@ func ObtenerReporteIngresos(c *fiber.Ctx) error {
 
 // GenerarReporteUsuarios genera un reporte de usuarios del sistema
 func GenerarReporteUsuarios(c *fiber.Ctx) error {
-	// Verificar si el usuario es admin usando el nuevo sistema de roles
-	userID := c.Locals("user_id").(int)
-	var rolNombre string
-	err := database.GetDB().QueryRow(context.Background(), `
-	    SELECT r.nombre 
-	    FROM Usuario u 
-	    JOIN Rol r ON u.id_rol = r.id_rol 
-	    WHERE u.id_usuario = $1
-	`, userID).Scan(&rolNombre)
-
-	if err != nil || rolNombre != "admin" {
+	if !actorDe(c).puede("reportes_usuarios") {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Solo administradores pueden ver reportes de usuarios",
+			"error": "No tienes permisos para ver reportes de usuarios",
 		})
 	}
 
@@ -388,17 +368,10 @@ func GenerarReporteUsuarios(c *fiber.Ctx) error {
 
 // GenerarReporteExpedientes genera un reporte de expedientes médicos
 func GenerarReporteExpedientes(c *fiber.Ctx) error {
-	// Verificar permisos usando el nuevo sistema de roles
-	userID := c.Locals("user_id").(int)
-	var rolNombre string
-	err := database.GetDB().QueryRow(context.Background(), `
-	    SELECT r.nombre 
-	    FROM Usuario u 
-	    JOIN Rol r ON u.id_rol = r.id_rol 
-	    WHERE u.id_usuario = $1
-	`, userID).Scan(&rolNombre)
-
-	if err != nil || (rolNombre != "admin" && rolNombre != "medico" && rolNombre != "enfermera") {
+	// Con reportes_expedientes_own el médico solo ve los expedientes que creó
+	usuario := actorDe(c)
+	alcanceReporte := usuario.alcance("reportes_expedientes")
+	if alcanceReporte == sinAlcance {
 		return c.Status(403).JSON(fiber.Map{
 			"error": "No tienes permisos para ver este reporte",
 		})
@@ -417,8 +390,8 @@ func GenerarReporteExpedientes(c *fiber.Ctx) error {
 	var query string
 	var args []interface{}
 
-	if rolNombre == "admin" || rolNombre == "enfermera" {
-		// Admin y enfermeras pueden ver todos los expedientes
+	if alcanceReporte == alcanceTodos {
+		// Puede ver todos los expedientes
 		query = `
 			SELECT e.id_expediente, 
 			       p.nombre || ' ' || p.apellido as paciente_nombre,
@@ -430,7 +403,8 @@ func GenerarReporteExpedientes(c *fiber.Ctx) error {
 			FROM Expediente e
 			JOIN Usuario p ON e.id_paciente = p.id_usuario
 			JOIN Usuario m ON e.id_medico = m.id_usuario
-			LEFT JOIN Consulta c ON e.id_expediente = c.id_expediente
+			LEFT JOIN Consulta c ON e.id_expediente = c.id_expediente AND c.deleted_at IS NULL
+			WHERE e.deleted_at IS NULL
 			GROUP BY e.id_expediente, p.nombre, p.apellido, p.email, m.nombre, m.apellido, e.fecha_creacion
 			ORDER BY e.fecha_creacion DESC
 		`
@@ -447,12 +421,12 @@ func GenerarReporteExpedientes(c *fiber.Ctx) error {
 			FROM Expediente e
 			JOIN Usuario p ON e.id_paciente = p.id_usuario
 			JOIN Usuario m ON e.id_medico = m.id_usuario
-			LEFT JOIN Consulta c ON e.id_expediente = c.id_expediente
-			WHERE e.id_medico = $1
+			LEFT JOIN Consulta c ON e.id_expediente = c.id_expediente AND c.deleted_at IS NULL
+			WHERE e.id_medico = $1 AND e.deleted_at IS NULL
 			GROUP BY e.id_expediente, p.nombre, p.apellido, p.email, m.nombre, m.apellido, e.fecha_creacion
 			ORDER BY e.fecha_creacion DESC
 		`
-		args = append(args, userID)
+		args = append(args, usuario.id)
 	}
 
 	rows, err := database.GetDB().Query(context.Background(), query, args...)
@@ -495,3 +469,137 @@ func GenerarReporteExpedientes(c *fiber.Ctx) error {
 		},
 	})
 }
+
+// periodosReporteDiagnosticos traduce el periodo solicitado a la unidad de date_trunc y al formato de la etiqueta
+var periodosReporteDiagnosticos = map[string][2]string{
+	"dia":    {"day", "YYYY-MM-DD"},
+	"semana": {"week", `IYYY-"S"IW`},
+	"mes":    {"month", "YYYY-MM"},
+	"anio":   {"year", "YYYY"},
+}
+
+// GenerarReporteDiagnosticos agrupa las consultas por código CIE-10 y periodo
+// (?fecha_inicio=, ?fecha_fin=, ?periodo=dia|semana|mes|anio, ?codigo= prefijo,
+// ?solo_principal=true, ?agrupar=categoria para sumar las subcategorías en su categoría de 3 caracteres).
+// Con reportes_read_own solo se cuentan las consultas del médico; las canceladas y las
+// inasistencias no se cuentan.
+func GenerarReporteDiagnosticos(c *fiber.Ctx) error {
+	usuario := actorDe(c)
+	alcanceReporte := usuario.alcance("reportes_read")
+	if alcanceReporte == sinAlcance {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes permisos para ver este reporte",
+		})
+	}
+
+	periodo := c.Query("periodo", "mes")
+	formato, ok := periodosReporteDiagnosticos[periodo]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Periodo inválido (dia, semana, mes o anio)",
+		})
+	}
+
+	fechaInicio := c.Query("fecha_inicio")
+	fechaFin := c.Query("fecha_fin")
+	if fechaInicio == "" {
+		// Por defecto, último año
+		fechaInicio = time.Now().AddDate(-1, 0, 0).Format("2006-01-02")
+	}
+	if fechaFin == "" {
+		fechaFin = time.Now().Format("2006-01-02")
+	}
+	for _, fecha := range []string{fechaInicio, fechaFin} {
+		if _, err := time.Parse("2006-01-02", fecha); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Formato de fecha inválido. Use YYYY-MM-DD",
+			})
+		}
+	}
+
+	codigoExpr := "d.codigo"
+	if c.Query("agrupar") == "categoria" {
+		codigoExpr = "split_part(d.codigo, '.', 1)"
+	}
+
+	args := []interface{}{fechaInicio, fechaFin}
+	filtros := ""
+	if c.QueryBool("solo_principal") {
+		filtros += " AND d.principal"
+	}
+	if alcanceReporte == alcancePropios {
+		args = append(args, usuario.id)
+		filtros += fmt.Sprintf(" AND c.id_medico = $%d", len(args))
+	}
+	if codigo := c.Query("codigo"); codigo != "" {
+		prefijo, _ := models.NormalizarCodigoCIE10(codigo)
+		args = append(args, strings.NewReplacer("%", "", "_", "").Replace(prefijo))
+		filtros += fmt.Sprintf(" AND d.codigo LIKE $%d || '%%'", len(args))
+	}
+
+	query := fmt.Sprintf(`
+		WITH dx AS (
+		    SELECT %s AS codigo, c.id_consulta, c.id_paciente,
+		           to_char(date_trunc('%s', COALESCE(h.fecha_hora, c.hora, c.fecha)), '%s') AS periodo
+		    FROM ConsultaDiagnostico d
+		    JOIN Consulta c ON d.id_consulta = c.id_consulta
+		    LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		    WHERE c.estado NOT IN ('cancelada', 'no_asistio') AND c.deleted_at IS NULL
+		    AND DATE(COALESCE(h.fecha_hora, c.hora, c.fecha)) BETWEEN $1 AND $2%s
+		)
+		SELECT dx.periodo, dx.codigo, COALESCE(cie.descripcion, ''),
+		       COUNT(DISTINCT dx.id_consulta), COUNT(DISTINCT dx.id_paciente)
+		FROM dx
+		LEFT JOIN CatalogoCIE10 cie ON cie.codigo = dx.codigo
+		GROUP BY dx.periodo, dx.codigo, cie.descripcion
+		ORDER BY dx.periodo, COUNT(DISTINCT dx.id_consulta) DESC, dx.codigo`,
+		codigoExpr, formato[0], formato[1], filtros)
+
+	rows, err := database.GetDB().Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al generar reporte de diagnósticos",
+		})
+	}
+	defer rows.Close()
+
+	var filas []models.ReporteDiagnostico
+	totales := make(map[string]*models.ReporteDiagnostico)
+	var orden []string
+	for rows.Next() {
+		var fila models.ReporteDiagnostico
+		err := rows.Scan(&fila.Periodo, &fila.Codigo, &fila.Descripcion, &fila.TotalConsultas, &fila.TotalPacientes)
+		if err != nil {
+			continue
+		}
+		filas = append(filas, fila)
+
+		// Cada consulta cae en un solo periodo, así que los totales por código se pueden sumar
+		total, ok := totales[fila.Codigo]
+		if !ok {
+			total = &models.ReporteDiagnostico{Codigo: fila.Codigo, Descripcion: fila.Descripcion}
+			totales[fila.Codigo] = total
+			orden = append(orden, fila.Codigo)
+		}
+		total.TotalConsultas += fila.TotalConsultas
+	}
+
+	resumen := make([]models.ReporteDiagnostico, 0, len(orden))
+	for _, codigo := range orden {
+		resumen = append(resumen, *totales[codigo])
+	}
+	sort.SliceStable(resumen, func(i, j int) bool {
+		return resumen[i].TotalConsultas > resumen[j].TotalConsultas
+	})
+
+	return c.JSON(fiber.Map{
+		"reporte_diagnosticos": filas,
+		"totales_por_codigo":   resumen,
+		"resumen": fiber.Map{
+			"periodo":          periodo,
+			"fecha_inicio":     fechaInicio,
+			"fecha_fin":        fechaFin,
+			"fecha_generacion": time.Now(),
+		},
+	})
+}
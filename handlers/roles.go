@@ -0,0 +1,735 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/middleware"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// bloqueoGestionRoles es la llave del advisory lock que serializa los cambios de roles y
+// permisos, para que dos administradores no retiren a la vez los últimos permisos protegidos
+const bloqueoGestionRoles = 72021
+
+// nombreRolPermisoValido restringe los nombres de roles y permisos al formato de los existentes
+// (minúsculas, dígitos y guion bajo), ya que el código y las rutas los buscan por nombre
+var nombreRolPermisoValido = regexp.MustCompile(`^[a-z][a-z0-9_]{1,49}$`)
+
+// bloquearGestionRoles toma el lock de gestión de roles hasta el fin de la transacción. Debe
+// llamarse antes de modificar roles, permisos o el rol de un usuario.
+func bloquearGestionRoles(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", bloqueoGestionRoles)
+	return err
+}
+
+// verificarPermisosProtegidos comprueba, ya aplicado el cambio dentro de la transacción, que
+// cada permiso de models.PermisosProtegidos lo conserve al menos un usuario activo con un rol
+// activo; si no, el cambio se rechaza con 409
+func verificarPermisosProtegidos(ctx context.Context, tx pgx.Tx) error {
+	for _, permiso := range models.PermisosProtegidos {
+		var conservado bool
+		err := tx.QueryRow(ctx,
+			`SELECT EXISTS (
+				SELECT 1 FROM Usuario u
+				JOIN Rol r ON u.id_rol = r.id_rol
+				JOIN RolPermiso rp ON r.id_rol = rp.id_rol
+				JOIN Permiso p ON rp.id_permiso = p.id_permiso
+				WHERE p.nombre = $1 AND r.activo = true AND u.deleted_at IS NULL
+			)`, permiso).Scan(&conservado)
+		if err != nil {
+			return err
+		}
+		if !conservado {
+			return &errorConsulta{409, fmt.Sprintf("El cambio dejaría sin ningún usuario activo con el permiso %s", permiso)}
+		}
+	}
+	return nil
+}
+
+// registrarAuditoriaRol guarda un cambio de roles o permisos en AuditoriaRol
+func registrarAuditoriaRol(ctx context.Context, q ejecutorSQL, a models.AuditoriaRol) error {
+	_, err := q.Exec(ctx,
+		`INSERT INTO AuditoriaRol (id_usuario, accion, id_rol, id_permiso, id_usuario_afectado, id_rol_anterior, fecha)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		a.IDUsuario, a.Accion, a.IDRol, a.IDPermiso, a.IDUsuarioAfectado, a.IDRolAnterior, time.Now())
+	return err
+}
+
+// asignarRolUsuario cambia el rol de un usuario dentro de la transacción, con auditoría y
+// verificando los permisos protegidos. Devuelve el rol anterior; si el usuario ya tenía ese rol
+// no hace nada.
+func asignarRolUsuario(ctx context.Context, tx pgx.Tx, userID, idUsuario, idRol int) (int, error) {
+	if err := bloquearGestionRoles(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	var activo bool
+	err := tx.QueryRow(ctx, "SELECT activo FROM Rol WHERE id_rol = $1", idRol).Scan(&activo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, &errorConsulta{404, "Rol no encontrado"}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !activo {
+		return 0, &errorConsulta{409, "No se puede asignar un rol desactivado"}
+	}
+
+	var anterior int
+	err = tx.QueryRow(ctx,
+		"SELECT id_rol FROM Usuario WHERE id_usuario = $1 AND deleted_at IS NULL FOR UPDATE", idUsuario).Scan(&anterior)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, &errorConsulta{404, "Usuario no encontrado"}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if anterior == idRol {
+		return anterior, nil
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE Usuario SET id_rol = $1, updated_at = $2 WHERE id_usuario = $3", idRol, time.Now(), idUsuario); err != nil {
+		return 0, err
+	}
+	if err := verificarPermisosProtegidos(ctx, tx); err != nil {
+		return 0, err
+	}
+	err = registrarAuditoriaRol(ctx, tx, models.AuditoriaRol{
+		IDUsuario:         userID,
+		Accion:            models.AuditoriaAsignarRol,
+		IDRol:             &idRol,
+		IDUsuarioAfectado: &idUsuario,
+		IDRolAnterior:     &anterior,
+	})
+	return anterior, err
+}
+
+// ObtenerRoles lista todos los roles, activos y desactivados, con cuántos usuarios tiene cada uno
+func ObtenerRoles(c *fiber.Ctx) error {
+	rows, err := database.GetDB().Query(context.Background(),
+		`SELECT r.id_rol, r.nombre, COALESCE(r.descripcion, ''), r.activo,
+		        (SELECT COUNT(*) FROM Usuario u WHERE u.id_rol = r.id_rol AND u.deleted_at IS NULL)
+		 FROM Rol r
+		 ORDER BY r.id_rol`)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener roles",
+		})
+	}
+	defer rows.Close()
+
+	roles := []models.Rol{}
+	for rows.Next() {
+		var rol models.Rol
+		if err := rows.Scan(&rol.IDRol, &rol.Nombre, &rol.Descripcion, &rol.Activo, &rol.TotalUsuarios); err != nil {
+			continue
+		}
+		roles = append(roles, rol)
+	}
+
+	return c.JSON(fiber.Map{
+		"roles": roles,
+		"total": len(roles),
+	})
+}
+
+// CrearRol crea un rol activo y sin permisos
+func CrearRol(c *fiber.Ctx) error {
+	var req models.RolRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+	req.Nombre = strings.TrimSpace(req.Nombre)
+	if !nombreRolPermisoValido.MatchString(req.Nombre) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El nombre debe tener de 2 a 50 caracteres en minúsculas, dígitos o guion bajo",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	if err := bloquearGestionRoles(ctx, tx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el rol",
+		})
+	}
+
+	var existe bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM Rol WHERE nombre = $1)", req.Nombre).Scan(&existe); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el rol",
+		})
+	}
+	if existe {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "Ya existe un rol con ese nombre",
+		})
+	}
+
+	rol := models.Rol{Nombre: req.Nombre, Descripcion: strings.TrimSpace(req.Descripcion), Activo: true}
+	if err := tx.QueryRow(ctx,
+		"INSERT INTO Rol (nombre, descripcion, activo) VALUES ($1, $2, true) RETURNING id_rol",
+		rol.Nombre, rol.Descripcion).Scan(&rol.IDRol); err != nil {
+		log.Printf("Error al crear el rol: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el rol",
+		})
+	}
+
+	if err := registrarAuditoriaRol(ctx, tx, models.AuditoriaRol{
+		IDUsuario: c.Locals("user_id").(int),
+		Accion:    models.AuditoriaCrearRol,
+		IDRol:     &rol.IDRol,
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el rol",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el rol",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje": "Rol creado exitosamente",
+		"rol":     rol,
+	})
+}
+
+// cambiarEstadoRol activa o desactiva un rol. Un rol con usuarios no se desactiva: sus usuarios
+// dejarían de poder usar la API, así que primero deben reasignarse.
+func cambiarEstadoRol(c *fiber.Ctx, activo bool) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	if err := bloquearGestionRoles(ctx, tx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar el rol",
+		})
+	}
+
+	var actual bool
+	var usuarios int
+	err = tx.QueryRow(ctx,
+		`SELECT r.activo, (SELECT COUNT(*) FROM Usuario u WHERE u.id_rol = r.id_rol AND u.deleted_at IS NULL)
+		 FROM Rol r WHERE r.id_rol = $1 FOR UPDATE`, id).Scan(&actual, &usuarios)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Rol no encontrado",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar el rol",
+		})
+	}
+	if actual == activo {
+		estado := "desactivado"
+		if activo {
+			estado = "activo"
+		}
+		return c.Status(409).JSON(fiber.Map{
+			"error": "El rol ya está " + estado,
+		})
+	}
+	if !activo && usuarios > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error":          "El rol tiene usuarios asignados; reasígnelos antes de desactivarlo",
+			"total_usuarios": usuarios,
+		})
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE Rol SET activo = $1 WHERE id_rol = $2", activo, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar el rol",
+		})
+	}
+	if err := verificarPermisosProtegidos(ctx, tx); err != nil {
+		return responderErrorConsulta(c, err, "Error al actualizar el rol")
+	}
+
+	accion := models.AuditoriaDesactivarRol
+	if activo {
+		accion = models.AuditoriaActivarRol
+	}
+	if err := registrarAuditoriaRol(ctx, tx, models.AuditoriaRol{
+		IDUsuario: c.Locals("user_id").(int),
+		Accion:    accion,
+		IDRol:     &id,
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar el rol",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar el rol",
+		})
+	}
+	middleware.InvalidarPermisos()
+
+	mensaje := "Rol desactivado exitosamente"
+	if activo {
+		mensaje = "Rol activado exitosamente"
+	}
+	return c.JSON(fiber.Map{
+		"mensaje": mensaje,
+	})
+}
+
+// DesactivarRol desactiva un rol sin usuarios asignados
+func DesactivarRol(c *fiber.Ctx) error {
+	return cambiarEstadoRol(c, false)
+}
+
+// ActivarRol vuelve a activar un rol desactivado
+func ActivarRol(c *fiber.Ctx) error {
+	return cambiarEstadoRol(c, true)
+}
+
+// ObtenerPermisos lista el catálogo de permisos (?recurso= filtra por recurso)
+func ObtenerPermisos(c *fiber.Ctx) error {
+	query := "SELECT id_permiso, nombre, COALESCE(descripcion, ''), recurso, accion FROM Permiso"
+	var args []interface{}
+	if recurso := c.Query("recurso"); recurso != "" {
+		args = append(args, recurso)
+		query += " WHERE recurso = $1"
+	}
+	query += " ORDER BY recurso, accion"
+
+	rows, err := database.GetDB().Query(context.Background(), query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener permisos",
+		})
+	}
+	defer rows.Close()
+
+	permisos := []models.Permiso{}
+	for rows.Next() {
+		var p models.Permiso
+		if err := rows.Scan(&p.IDPermiso, &p.Nombre, &p.Descripcion, &p.Recurso, &p.Accion); err != nil {
+			continue
+		}
+		permisos = append(permisos, p)
+	}
+
+	return c.JSON(fiber.Map{
+		"permisos": permisos,
+		"total":    len(permisos),
+	})
+}
+
+// CrearPermiso agrega un permiso al catálogo. El permiso no se otorga a ningún rol; solo tiene
+// efecto en las rutas que lo exigen con RequirePermission o hasPermission.
+func CrearPermiso(c *fiber.Ctx) error {
+	var req models.PermisoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+	req.Recurso = strings.TrimSpace(req.Recurso)
+	req.Accion = strings.TrimSpace(req.Accion)
+	if req.Recurso == "" || req.Accion == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El recurso y la acción son obligatorios",
+		})
+	}
+	req.Nombre = strings.TrimSpace(req.Nombre)
+	if req.Nombre == "" {
+		req.Nombre = req.Recurso + "_" + req.Accion
+	}
+	if !nombreRolPermisoValido.MatchString(req.Nombre) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El nombre debe tener de 2 a 50 caracteres en minúsculas, dígitos o guion bajo",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	if err := bloquearGestionRoles(ctx, tx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el permiso",
+		})
+	}
+
+	var existe bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM Permiso WHERE nombre = $1)", req.Nombre).Scan(&existe); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el permiso",
+		})
+	}
+	if existe {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "Ya existe un permiso con ese nombre",
+		})
+	}
+
+	permiso := models.Permiso{
+		Nombre:      req.Nombre,
+		Descripcion: strings.TrimSpace(req.Descripcion),
+		Recurso:     req.Recurso,
+		Accion:      req.Accion,
+	}
+	if err := tx.QueryRow(ctx,
+		"INSERT INTO Permiso (nombre, descripcion, recurso, accion) VALUES ($1, $2, $3, $4) RETURNING id_permiso",
+		permiso.Nombre, permiso.Descripcion, permiso.Recurso, permiso.Accion).Scan(&permiso.IDPermiso); err != nil {
+		log.Printf("Error al crear el permiso: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el permiso",
+		})
+	}
+
+	if err := registrarAuditoriaRol(ctx, tx, models.AuditoriaRol{
+		IDUsuario: c.Locals("user_id").(int),
+		Accion:    models.AuditoriaCrearPermiso,
+		IDPermiso: &permiso.IDPermiso,
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el permiso",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al crear el permiso",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje": "Permiso creado exitosamente",
+		"permiso": permiso,
+	})
+}
+
+// OtorgarPermisoRol agrega un permiso a un rol. El permiso se indica por id_permiso o por nombre.
+func OtorgarPermisoRol(c *fiber.Ctx) error {
+	idRol, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID de rol inválido",
+		})
+	}
+
+	var req models.OtorgarPermisoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+	req.Nombre = strings.TrimSpace(req.Nombre)
+	if req.IDPermiso <= 0 && req.Nombre == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Indique el id_permiso o el nombre del permiso",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	if err := bloquearGestionRoles(ctx, tx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al otorgar el permiso",
+		})
+	}
+
+	var existeRol bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM Rol WHERE id_rol = $1)", idRol).Scan(&existeRol); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al otorgar el permiso",
+		})
+	}
+	if !existeRol {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Rol no encontrado",
+		})
+	}
+
+	query, arg := "SELECT id_permiso, nombre FROM Permiso WHERE nombre = $1", interface{}(req.Nombre)
+	if req.IDPermiso > 0 {
+		query, arg = "SELECT id_permiso, nombre FROM Permiso WHERE id_permiso = $1", req.IDPermiso
+	}
+	var permiso models.Permiso
+	err = tx.QueryRow(ctx, query, arg).Scan(&permiso.IDPermiso, &permiso.Nombre)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Permiso no encontrado",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al otorgar el permiso",
+		})
+	}
+
+	result, err := tx.Exec(ctx,
+		`INSERT INTO RolPermiso (id_rol, id_permiso)
+		 SELECT $1, $2
+		 WHERE NOT EXISTS (SELECT 1 FROM RolPermiso WHERE id_rol = $1 AND id_permiso = $2)`,
+		idRol, permiso.IDPermiso)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al otorgar el permiso",
+		})
+	}
+	if result.RowsAffected() == 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "El rol ya tiene ese permiso",
+		})
+	}
+
+	if err := registrarAuditoriaRol(ctx, tx, models.AuditoriaRol{
+		IDUsuario: c.Locals("user_id").(int),
+		Accion:    models.AuditoriaOtorgarPermiso,
+		IDRol:     &idRol,
+		IDPermiso: &permiso.IDPermiso,
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al otorgar el permiso",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al otorgar el permiso",
+		})
+	}
+	middleware.InvalidarPermisos()
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje":    "Permiso otorgado exitosamente",
+		"id_rol":     idRol,
+		"id_permiso": permiso.IDPermiso,
+		"permiso":    permiso.Nombre,
+	})
+}
+
+// RevocarPermisoRol quita un permiso a un rol. No se permite si con ello ningún usuario activo
+// conservaría alguno de los permisos protegidos.
+func RevocarPermisoRol(c *fiber.Ctx) error {
+	idRol, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID de rol inválido",
+		})
+	}
+	idPermiso, err := strconv.Atoi(c.Params("id_permiso"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID de permiso inválido",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	if err := bloquearGestionRoles(ctx, tx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar el permiso",
+		})
+	}
+
+	result, err := tx.Exec(ctx, "DELETE FROM RolPermiso WHERE id_rol = $1 AND id_permiso = $2", idRol, idPermiso)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar el permiso",
+		})
+	}
+	if result.RowsAffected() == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "El rol no tiene ese permiso",
+		})
+	}
+	if err := verificarPermisosProtegidos(ctx, tx); err != nil {
+		return responderErrorConsulta(c, err, "Error al revocar el permiso")
+	}
+
+	if err := registrarAuditoriaRol(ctx, tx, models.AuditoriaRol{
+		IDUsuario: c.Locals("user_id").(int),
+		Accion:    models.AuditoriaRevocarPermiso,
+		IDRol:     &idRol,
+		IDPermiso: &idPermiso,
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar el permiso",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar el permiso",
+		})
+	}
+	middleware.InvalidarPermisos()
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Permiso revocado exitosamente",
+	})
+}
+
+// AsignarRolUsuario cambia el rol de un usuario. No se permite si con ello ningún usuario activo
+// conservaría alguno de los permisos protegidos.
+func AsignarRolUsuario(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	var req models.AsignarRolRequest
+	if err := c.BodyParser(&req); err != nil || req.IDRol <= 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "El id_rol es obligatorio",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	anterior, err := asignarRolUsuario(ctx, tx, c.Locals("user_id").(int), id, req.IDRol)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al asignar el rol")
+	}
+	if anterior == req.IDRol {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "El usuario ya tiene ese rol",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al asignar el rol",
+		})
+	}
+	middleware.InvalidarPermisosUsuario(id)
+
+	return c.JSON(fiber.Map{
+		"mensaje":         "Rol asignado exitosamente",
+		"id_usuario":      id,
+		"id_rol":          req.IDRol,
+		"id_rol_anterior": anterior,
+	})
+}
+
+// ObtenerAuditoriaRoles lista los cambios de roles y permisos, del más reciente al más antiguo
+// (?accion=, ?id_rol=, ?id_usuario= para quien hizo o recibió el cambio, ?limite=)
+func ObtenerAuditoriaRoles(c *fiber.Ctx) error {
+	var condiciones []string
+	var args []interface{}
+	if accion := c.Query("accion"); accion != "" {
+		args = append(args, accion)
+		condiciones = append(condiciones, fmt.Sprintf("a.accion = $%d", len(args)))
+	}
+	if idRol := c.QueryInt("id_rol"); idRol > 0 {
+		args = append(args, idRol)
+		condiciones = append(condiciones, fmt.Sprintf("(a.id_rol = $%d OR a.id_rol_anterior = $%d)", len(args), len(args)))
+	}
+	if idUsuario := c.QueryInt("id_usuario"); idUsuario > 0 {
+		args = append(args, idUsuario)
+		condiciones = append(condiciones, fmt.Sprintf("(a.id_usuario = $%d OR a.id_usuario_afectado = $%d)", len(args), len(args)))
+	}
+
+	limite := c.QueryInt("limite", 100)
+	if limite < 1 || limite > 500 {
+		limite = 100
+	}
+
+	query := `SELECT a.id_auditoria, a.id_usuario, CONCAT(u.nombre, ' ', u.apellido), a.accion,
+	                 a.id_rol, r.nombre, a.id_permiso, p.nombre, a.id_usuario_afectado, a.id_rol_anterior, a.fecha
+	          FROM AuditoriaRol a
+	          JOIN Usuario u ON a.id_usuario = u.id_usuario
+	          LEFT JOIN Rol r ON a.id_rol = r.id_rol
+	          LEFT JOIN Permiso p ON a.id_permiso = p.id_permiso`
+	if len(condiciones) > 0 {
+		query += " WHERE " + strings.Join(condiciones, " AND ")
+	}
+	args = append(args, limite)
+	query += fmt.Sprintf(" ORDER BY a.fecha DESC, a.id_auditoria DESC LIMIT $%d", len(args))
+
+	rows, err := database.GetDB().Query(context.Background(), query, args...)
+	if err != nil {
+		log.Printf("Error al obtener la auditoría de roles: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener la auditoría de roles",
+		})
+	}
+	defer rows.Close()
+
+	auditoria := []models.AuditoriaRol{}
+	for rows.Next() {
+		var a models.AuditoriaRol
+		if err := rows.Scan(&a.IDAuditoria, &a.IDUsuario, &a.UsuarioNombre, &a.Accion, &a.IDRol, &a.RolNombre,
+			&a.IDPermiso, &a.PermisoNombre, &a.IDUsuarioAfectado, &a.IDRolAnterior, &a.Fecha); err != nil {
+			continue
+		}
+		auditoria = append(auditoria, a)
+	}
+
+	return c.JSON(fiber.Map{
+		"auditoria": auditoria,
+		"total":     len(auditoria),
+	})
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/middleware"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// maxLongitudDispositivo es el largo máximo del nombre del dispositivo de una sesión
+const maxLongitudDispositivo = 100
+
+// hashRefreshToken es lo que se guarda de cada refresh token; el token en claro solo lo tiene el cliente
+func hashRefreshToken(token string) string {
+	suma := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(suma[:])
+}
+
+// nombreDispositivo usa el nombre enviado al iniciar sesión o, si no hay, el User-Agent
+func nombreDispositivo(c *fiber.Ctx, dispositivo string) string {
+	nombre := strings.TrimSpace(dispositivo)
+	if nombre == "" {
+		nombre = strings.TrimSpace(c.Get("User-Agent"))
+	}
+	if nombre == "" {
+		return "Dispositivo desconocido"
+	}
+	if runas := []rune(nombre); len(runas) > maxLongitudDispositivo {
+		nombre = string(runas[:maxLongitudDispositivo])
+	}
+	return nombre
+}
+
+// crearSesion abre una sesión para el dispositivo y devuelve su id y su primer refresh token
+func crearSesion(ctx context.Context, c *fiber.Ctx, userID int, dispositivo string) (int, string, error) {
+	refreshToken, err := middleware.GenerateRefreshTokenString()
+	if err != nil {
+		return 0, "", err
+	}
+
+	var idSesion int
+	err = database.GetDB().QueryRow(ctx, `
+		WITH sesion AS (
+			INSERT INTO Sesion (id_usuario, dispositivo, user_agent, ip, expires_at)
+			VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+			RETURNING id_sesion, id_usuario, expires_at
+		)
+		INSERT INTO refresh_tokens (user_id, id_sesion, token_hash, expires_at)
+		SELECT id_usuario, id_sesion, $6, expires_at FROM sesion
+		RETURNING id_sesion`,
+		userID, nombreDispositivo(c, dispositivo), c.Get("User-Agent"), c.IP(),
+		time.Now().Add(middleware.RefreshTokenDuration), hashRefreshToken(refreshToken)).Scan(&idSesion)
+	if err != nil {
+		return 0, "", err
+	}
+	return idSesion, refreshToken, nil
+}
+
+// sesionRenovada es el resultado de rotar el refresh token de una sesión
+type sesionRenovada struct {
+	userID       int
+	idSesion     int
+	acceso       *middleware.AccesoUsuario
+	refreshToken string
+}
+
+// rotarRefreshToken cambia el refresh token de la sesión por uno nuevo. Un token que ya fue
+// rotado solo puede presentarse si fue robado (o si el cliente lo reenvió), por lo que su uso
+// revoca la sesión completa y con ella el token que recibió quien lo usó primero.
+func rotarRefreshToken(ctx context.Context, c *fiber.Ctx, token string) (sesionRenovada, error) {
+	var renovada sesionRenovada
+
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return renovada, err
+	}
+	defer tx.Rollback(ctx)
+
+	// El bloqueo hace que dos renovaciones simultáneas con el mismo token se traten como reutilización
+	var idToken int
+	var rotado, revocado, vigente, sesionActiva bool
+	err = tx.QueryRow(ctx, `
+		SELECT rt.id, rt.id_sesion, s.id_usuario, rt.rotated_at IS NOT NULL, rt.is_revoked,
+		       rt.expires_at > NOW(), s.revoked_at IS NULL AND s.expires_at > NOW()
+		FROM refresh_tokens rt
+		JOIN Sesion s ON rt.id_sesion = s.id_sesion
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s`, hashRefreshToken(token)).
+		Scan(&idToken, &renovada.idSesion, &renovada.userID, &rotado, &revocado, &vigente, &sesionActiva)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return renovada, &errorConsulta{401, "Refresh token inválido o revocado"}
+	}
+	if err != nil {
+		return renovada, err
+	}
+
+	if rotado {
+		if err := revocarSesion(ctx, tx, renovada.idSesion, models.SesionReutilizada); err != nil {
+			return renovada, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return renovada, err
+		}
+		log.Printf("Refresh token reutilizado en la sesión %d del usuario %d desde %s; sesión revocada",
+			renovada.idSesion, renovada.userID, c.IP())
+		return renovada, &errorConsulta{401, "Refresh token reutilizado; la sesión fue cerrada"}
+	}
+	if revocado || !vigente || !sesionActiva {
+		return renovada, &errorConsulta{401, "Refresh token inválido o revocado"}
+	}
+
+	// El access token nuevo lleva el rol actual del usuario, no el del inicio de sesión
+	renovada.acceso, err = middleware.ObtenerAccesoUsuario(ctx, renovada.userID)
+	if errors.Is(err, middleware.ErrAccesoNoValido) {
+		return renovada, &errorConsulta{401, "Usuario o rol no válido"}
+	}
+	if err != nil {
+		return renovada, err
+	}
+
+	if renovada.refreshToken, err = middleware.GenerateRefreshTokenString(); err != nil {
+		return renovada, err
+	}
+	expira := time.Now().Add(middleware.RefreshTokenDuration)
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE refresh_tokens SET is_revoked = true, rotated_at = NOW() WHERE id = $1", idToken); err != nil {
+		return renovada, err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO refresh_tokens (user_id, id_sesion, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		renovada.userID, renovada.idSesion, hashRefreshToken(renovada.refreshToken), expira); err != nil {
+		return renovada, err
+	}
+	if _, err := tx.Exec(ctx,
+		"UPDATE Sesion SET last_used_at = NOW(), ip = $1, expires_at = $2 WHERE id_sesion = $3",
+		c.IP(), expira, renovada.idSesion); err != nil {
+		return renovada, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return renovada, err
+	}
+	return renovada, nil
+}
+
+// revocarSesion cierra la sesión y revoca todos sus refresh tokens. Sus access tokens dejan de
+// valer de inmediato en JWTMiddleware.
+func revocarSesion(ctx context.Context, tx pgx.Tx, idSesion int, motivo string) error {
+	if _, err := tx.Exec(ctx,
+		"UPDATE Sesion SET revoked_at = NOW(), motivo_revocacion = $1 WHERE id_sesion = $2 AND revoked_at IS NULL",
+		motivo, idSesion); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		"UPDATE refresh_tokens SET is_revoked = true WHERE id_sesion = $1 AND is_revoked = false", idSesion); err != nil {
+		return err
+	}
+	middleware.InvalidarSesiones(idSesion)
+	return nil
+}
+
+// revocarSesionesUsuario cierra todas las sesiones del usuario
+func revocarSesionesUsuario(ctx context.Context, tx pgx.Tx, userID int, motivo string) error {
+	rows, err := tx.Query(ctx,
+		`UPDATE Sesion SET revoked_at = NOW(), motivo_revocacion = $1 WHERE id_usuario = $2 AND revoked_at IS NULL
+		 RETURNING id_sesion`, motivo, userID)
+	if err != nil {
+		return err
+	}
+	var sesiones []int
+	for rows.Next() {
+		var idSesion int
+		if err := rows.Scan(&idSesion); err != nil {
+			rows.Close()
+			return err
+		}
+		sesiones = append(sesiones, idSesion)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		"UPDATE refresh_tokens SET is_revoked = true WHERE user_id = $1 AND is_revoked = false", userID); err != nil {
+		return err
+	}
+	middleware.InvalidarSesiones(sesiones...)
+	return nil
+}
+
+// ObtenerMisSesiones lista las sesiones activas del usuario autenticado, la más reciente primero
+func ObtenerMisSesiones(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	idSesionActual, _ := c.Locals("id_sesion").(int)
+
+	rows, err := database.GetDB().Query(context.Background(), `
+		SELECT id_sesion, dispositivo, user_agent, ip, created_at, last_used_at, expires_at
+		FROM Sesion
+		WHERE id_usuario = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener las sesiones",
+		})
+	}
+	defer rows.Close()
+
+	sesiones := []models.Sesion{}
+	for rows.Next() {
+		var sesion models.Sesion
+		if err := rows.Scan(&sesion.IDSesion, &sesion.Dispositivo, &sesion.UserAgent, &sesion.IP,
+			&sesion.CreatedAt, &sesion.LastUsedAt, &sesion.ExpiresAt); err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al leer las sesiones",
+			})
+		}
+		sesion.Actual = sesion.IDSesion == idSesionActual
+		sesiones = append(sesiones, sesion)
+	}
+
+	return c.JSON(fiber.Map{
+		"sesiones": sesiones,
+		"total":    len(sesiones),
+	})
+}
+
+// RevocarMiSesion cierra una sesión del usuario autenticado; su refresh token y sus access
+// tokens dejan de servir
+func RevocarMiSesion(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	idSesion, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID de sesión inválido",
+		})
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar la sesión",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	// Solo las sesiones propias y activas; las de otros usuarios responden igual que las inexistentes
+	var existe bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM Sesion WHERE id_sesion = $1 AND id_usuario = $2 AND revoked_at IS NULL)`,
+		idSesion, userID).Scan(&existe)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar la sesión",
+		})
+	}
+	if !existe {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Sesión no encontrada",
+		})
+	}
+
+	if err := revocarSesion(ctx, tx, idSesion, models.SesionRevocada); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar la sesión",
+		})
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al revocar la sesión",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"mensaje": "Sesión revocada exitosamente",
+	})
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// toleranciaFechaMedicion permite registrar tomas con el reloj del dispositivo ligeramente adelantado
+const toleranciaFechaMedicion = 5 * time.Minute
+
+// evaluarSignosVitales calcula el IMC, rechaza los valores fuera del rango válido y registra
+// una alerta por cada medición fuera del rango normal
+func evaluarSignosVitales(s *models.SignosVitales) error {
+	s.IMC = nil
+	if s.Peso != nil && s.Talla != nil {
+		metros := *s.Talla / 100
+		imc := math.Round(*s.Peso/(metros*metros)*10) / 10
+		s.IMC = &imc
+	}
+
+	mediciones := s.Mediciones()
+	s.Alertas = []models.AlertaSignos{}
+	for _, rango := range models.RangosSignosVitales {
+		valor := mediciones[rango.Campo]
+		if valor == nil {
+			continue
+		}
+		if *valor < rango.MinValido || *valor > rango.MaxValido {
+			if rango.Campo == "imc" {
+				return fmt.Errorf("El peso y la talla dan un IMC de %g, revise las mediciones", *valor)
+			}
+			return fmt.Errorf("%s fuera del rango válido (%g a %g %s)",
+				rango.Campo, rango.MinValido, rango.MaxValido, rango.Unidad)
+		}
+		if rango.MinNormal == 0 && rango.MaxNormal == 0 {
+			continue
+		}
+
+		alerta := models.AlertaSignos{Campo: rango.Campo, Valor: *valor, Minimo: rango.MinNormal, Maximo: rango.MaxNormal}
+		switch {
+		case *valor < rango.MinNormal:
+			alerta.Nivel = "bajo"
+		case *valor > rango.MaxNormal:
+			alerta.Nivel = "alto"
+		default:
+			continue
+		}
+		s.Alertas = append(s.Alertas, alerta)
+	}
+
+	if s.PresionSistolica != nil && s.PresionDiastolica != nil && *s.PresionSistolica <= *s.PresionDiastolica {
+		return fmt.Errorf("La presión sistólica debe ser mayor que la diastólica")
+	}
+	s.Anormal = len(s.Alertas) > 0
+	return nil
+}
+
+// RegistrarSignosVitales registra una toma de signos vitales de un paciente. Si se envía el peso
+// sin la talla, el IMC se calcula con la última talla registrada del paciente.
+func RegistrarSignosVitales(c *fiber.Ctx) error {
+	var req models.SignosVitalesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Datos inválidos",
+		})
+	}
+
+	userID := c.Locals("user_id").(int)
+
+	if req.PresionSistolica == nil && req.PresionDiastolica == nil && req.FrecuenciaCardiaca == nil &&
+		req.Temperatura == nil && req.SaturacionOxigeno == nil && req.Peso == nil && req.Talla == nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Debe registrar al menos una medición",
+		})
+	}
+
+	ctx := context.Background()
+	if permitido, err := puedeVerPaciente(ctx, actorDe(c), "signos_vitales_create", req.IDPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No puedes registrar signos vitales de este paciente",
+		})
+	}
+
+	// Verificar que el paciente existe y tiene rol de paciente
+	var existePaciente bool
+	err := database.GetDB().QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol
+		 WHERE u.id_usuario = $1 AND r.nombre = 'paciente')`, req.IDPaciente).Scan(&existePaciente)
+	if err != nil || !existePaciente {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Paciente no encontrado",
+		})
+	}
+
+	// La consulta, si se indica, debe ser del mismo paciente y seguir vigente
+	if req.IDConsulta != nil {
+		var idPaciente int
+		var estado string
+		err := database.GetDB().QueryRow(ctx,
+			"SELECT id_paciente, estado FROM Consulta WHERE id_consulta = $1 AND deleted_at IS NULL", *req.IDConsulta).Scan(&idPaciente, &estado)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Consulta no encontrada",
+			})
+		}
+		if idPaciente != req.IDPaciente {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "La consulta no corresponde al paciente",
+			})
+		}
+		if estado == models.EstadoCancelada || estado == models.EstadoNoAsistio {
+			return c.Status(409).JSON(fiber.Map{
+				"error": "No se pueden registrar signos vitales en una consulta " + estado,
+			})
+		}
+	}
+
+	ahora := horaDePared(time.Now())
+	fechaMedicion := ahora
+	if !req.FechaMedicion.IsZero() {
+		fechaMedicion = horaDePared(req.FechaMedicion)
+		if fechaMedicion.After(ahora.Add(toleranciaFechaMedicion)) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "La fecha de medición no puede estar en el futuro",
+			})
+		}
+	}
+
+	signos := models.SignosVitales{
+		IDPaciente:         req.IDPaciente,
+		IDConsulta:         req.IDConsulta,
+		IDUsuario:          userID,
+		FechaMedicion:      fechaMedicion,
+		PresionSistolica:   req.PresionSistolica,
+		PresionDiastolica:  req.PresionDiastolica,
+		FrecuenciaCardiaca: req.FrecuenciaCardiaca,
+		Temperatura:        req.Temperatura,
+		SaturacionOxigeno:  req.SaturacionOxigeno,
+		Peso:               req.Peso,
+		Talla:              req.Talla,
+	}
+	if notas := strings.TrimSpace(req.Notas); notas != "" {
+		signos.Notas = &notas
+	}
+
+	// Sin talla en la toma se usa la última registrada, solo para calcular el IMC
+	talla := signos.Talla
+	if signos.Peso != nil && signos.Talla == nil {
+		var ultimaTalla *float64
+		database.GetDB().QueryRow(ctx,
+			`SELECT talla FROM SignosVitales WHERE id_paciente = $1 AND talla IS NOT NULL
+			 ORDER BY fecha_medicion DESC LIMIT 1`, req.IDPaciente).Scan(&ultimaTalla)
+		signos.Talla = ultimaTalla
+	}
+	if err := evaluarSignosVitales(&signos); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	signos.Talla = talla
+
+	alertas, err := json.Marshal(signos.Alertas)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al registrar los signos vitales",
+		})
+	}
+
+	err = database.GetDB().QueryRow(ctx,
+		`INSERT INTO SignosVitales (id_paciente, id_consulta, id_usuario, fecha_medicion,
+		 presion_sistolica, presion_diastolica, frecuencia_cardiaca, temperatura, saturacion_oxigeno,
+		 peso, talla, imc, anormal, alertas, notas)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14::jsonb, $15)
+		 RETURNING id_signos, created_at`,
+		signos.IDPaciente, signos.IDConsulta, signos.IDUsuario, signos.FechaMedicion.Format(formatoTimestamp),
+		signos.PresionSistolica, signos.PresionDiastolica, signos.FrecuenciaCardiaca, signos.Temperatura,
+		signos.SaturacionOxigeno, signos.Peso, signos.Talla, signos.IMC, signos.Anormal, string(alertas),
+		signos.Notas).Scan(&signos.IDSignos, &signos.CreatedAt)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al registrar los signos vitales",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"mensaje": "Signos vitales registrados exitosamente",
+		"signos":  signos,
+	})
+}
+
+// filtrosSignosVitales arma las condiciones comunes de los listados (?desde=, ?hasta= en
+// YYYY-MM-DD) a partir del paciente, que siempre es $1
+func filtrosSignosVitales(c *fiber.Ctx, idPaciente int) (string, []interface{}, error) {
+	condiciones := "WHERE id_paciente = $1"
+	args := []interface{}{idPaciente}
+	if desde := c.Query("desde"); desde != "" {
+		if _, err := time.Parse("2006-01-02", desde); err != nil {
+			return "", nil, err
+		}
+		args = append(args, desde)
+		condiciones += fmt.Sprintf(" AND fecha_medicion >= $%d::date", len(args))
+	}
+	if hasta := c.Query("hasta"); hasta != "" {
+		if _, err := time.Parse("2006-01-02", hasta); err != nil {
+			return "", nil, err
+		}
+		args = append(args, hasta)
+		condiciones += fmt.Sprintf(" AND fecha_medicion < $%d::date + 1", len(args))
+	}
+	return condiciones, args, nil
+}
+
+// ObtenerSignosVitalesPaciente obtiene las tomas de signos vitales de un paciente, de la más
+// reciente a la más antigua (?desde=, ?hasta=, ?id_consulta=, ?solo_anormales=true)
+func ObtenerSignosVitalesPaciente(c *fiber.Ctx) error {
+	idPaciente, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "signos_vitales_read", idPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes acceso a los signos vitales de este paciente",
+		})
+	}
+
+	condiciones, args, err := filtrosSignosVitales(c, idPaciente)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Formato de fecha inválido. Use YYYY-MM-DD",
+		})
+	}
+	if idConsulta := c.QueryInt("id_consulta"); idConsulta > 0 {
+		args = append(args, idConsulta)
+		condiciones += fmt.Sprintf(" AND id_consulta = $%d", len(args))
+	}
+	if c.QueryBool("solo_anormales") {
+		condiciones += " AND anormal"
+	}
+
+	rows, err := database.GetDB().Query(context.Background(),
+		`SELECT id_signos, id_paciente, id_consulta, id_usuario, fecha_medicion, presion_sistolica,
+		        presion_diastolica, frecuencia_cardiaca, temperatura, saturacion_oxigeno, peso, talla, imc,
+		        anormal, alertas, notas, created_at
+		 FROM SignosVitales `+condiciones+`
+		 ORDER BY fecha_medicion DESC, id_signos DESC LIMIT 200`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener los signos vitales",
+		})
+	}
+	defer rows.Close()
+
+	var tomas []models.SignosVitales
+	for rows.Next() {
+		var s models.SignosVitales
+		err := rows.Scan(&s.IDSignos, &s.IDPaciente, &s.IDConsulta, &s.IDUsuario, &s.FechaMedicion,
+			&s.PresionSistolica, &s.PresionDiastolica, &s.FrecuenciaCardiaca, &s.Temperatura,
+			&s.SaturacionOxigeno, &s.Peso, &s.Talla, &s.IMC, &s.Anormal, &s.Alertas, &s.Notas, &s.CreatedAt)
+		if err != nil {
+			continue
+		}
+		tomas = append(tomas, s)
+	}
+
+	return c.JSON(fiber.Map{
+		"id_paciente": idPaciente,
+		"signos":      tomas,
+		"total":       len(tomas),
+	})
+}
+
+// PuntoSerieSignos es un valor de una medición en el tiempo
+type PuntoSerieSignos struct {
+	Fecha    time.Time `json:"fecha"`
+	Valor    float64   `json:"valor"`
+	Anormal  bool      `json:"anormal"`
+	IDSignos int       `json:"id_signos"`
+}
+
+// ObtenerSerieSignosVitales obtiene las mediciones de un paciente agrupadas por campo y en orden
+// cronológico para graficarlas, junto con los rangos de referencia
+// (?desde=, ?hasta=, ?campos=presion_sistolica,temperatura)
+func ObtenerSerieSignosVitales(c *fiber.Ctx) error {
+	idPaciente, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	if permitido, err := puedeVerPaciente(context.Background(), actorDe(c), "signos_vitales_read", idPaciente); err != nil || !permitido {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes acceso a los signos vitales de este paciente",
+		})
+	}
+
+	// Campos solicitados; por defecto todos
+	rangos := models.RangosSignosVitales
+	if campos := c.Query("campos"); campos != "" {
+		rangos = nil
+		for _, campo := range strings.Split(campos, ",") {
+			campo = strings.TrimSpace(campo)
+			encontrado := false
+			for _, rango := range models.RangosSignosVitales {
+				if rango.Campo == campo {
+					rangos = append(rangos, rango)
+					encontrado = true
+					break
+				}
+			}
+			if !encontrado {
+				return c.Status(400).JSON(fiber.Map{
+					"error": "Campo de signos vitales desconocido: " + campo,
+				})
+			}
+		}
+	}
+
+	condiciones, args, err := filtrosSignosVitales(c, idPaciente)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Formato de fecha inválido. Use YYYY-MM-DD",
+		})
+	}
+
+	rows, err := database.GetDB().Query(context.Background(),
+		`SELECT id_signos, fecha_medicion, presion_sistolica, presion_diastolica, frecuencia_cardiaca,
+		        temperatura, saturacion_oxigeno, peso, talla, imc, alertas
+		 FROM SignosVitales `+condiciones+`
+		 ORDER BY fecha_medicion, id_signos`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener los signos vitales",
+		})
+	}
+	defer rows.Close()
+
+	series := make(map[string][]PuntoSerieSignos, len(rangos))
+	for _, rango := range rangos {
+		series[rango.Campo] = []PuntoSerieSignos{}
+	}
+	for rows.Next() {
+		var s models.SignosVitales
+		err := rows.Scan(&s.IDSignos, &s.FechaMedicion, &s.PresionSistolica, &s.PresionDiastolica,
+			&s.FrecuenciaCardiaca, &s.Temperatura, &s.SaturacionOxigeno, &s.Peso, &s.Talla, &s.IMC, &s.Alertas)
+		if err != nil {
+			continue
+		}
+
+		anormales := make(map[string]bool, len(s.Alertas))
+		for _, alerta := range s.Alertas {
+			anormales[alerta.Campo] = true
+		}
+		mediciones := s.Mediciones()
+		for _, rango := range rangos {
+			if valor := mediciones[rango.Campo]; valor != nil {
+				series[rango.Campo] = append(series[rango.Campo], PuntoSerieSignos{
+					Fecha:    s.FechaMedicion,
+					Valor:    *valor,
+					Anormal:  anormales[rango.Campo],
+					IDSignos: s.IDSignos,
+				})
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"id_paciente": idPaciente,
+		"series":      series,
+		"rangos":      rangos,
+	})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/lizet96/hospital-backend/models"
+)
+
+func TestEvaluarSignosVitales(t *testing.T) {
+	valor := func(v float64) *float64 { return &v }
+
+	casos := []struct {
+		nombre  string
+		signos  models.SignosVitales
+		error   bool
+		imc     *float64
+		alertas []string // campo:nivel
+	}{
+		{
+			nombre: "todo normal",
+			signos: models.SignosVitales{PresionSistolica: valor(120), PresionDiastolica: valor(80),
+				FrecuenciaCardiaca: valor(72), Temperatura: valor(36.6), SaturacionOxigeno: valor(98)},
+		},
+		{
+			nombre:  "hipertensión y fiebre",
+			signos:  models.SignosVitales{PresionSistolica: valor(150), PresionDiastolica: valor(95), Temperatura: valor(38.2)},
+			alertas: []string{"presion_sistolica:alto", "presion_diastolica:alto", "temperatura:alto"},
+		},
+		{
+			nombre:  "saturación baja",
+			signos:  models.SignosVitales{SaturacionOxigeno: valor(90)},
+			alertas: []string{"saturacion_oxigeno:bajo"},
+		},
+		{
+			nombre: "IMC normal",
+			signos: models.SignosVitales{Peso: valor(70), Talla: valor(175)},
+			imc:    valor(22.9),
+		},
+		{
+			nombre:  "IMC alto",
+			signos:  models.SignosVitales{Peso: valor(95), Talla: valor(170)},
+			imc:     valor(32.9),
+			alertas: []string{"imc:alto"},
+		},
+		{
+			nombre: "solo peso no calcula IMC",
+			signos: models.SignosVitales{Peso: valor(70)},
+		},
+		{
+			nombre: "fuera del rango válido",
+			signos: models.SignosVitales{Temperatura: valor(60)},
+			error:  true,
+		},
+		{
+			nombre: "IMC imposible",
+			signos: models.SignosVitales{Peso: valor(400), Talla: valor(100)},
+			error:  true,
+		},
+		{
+			nombre: "sistólica menor que diastólica",
+			signos: models.SignosVitales{PresionSistolica: valor(80), PresionDiastolica: valor(90)},
+			error:  true,
+		},
+	}
+	for _, caso := range casos {
+		signos := caso.signos
+		err := evaluarSignosVitales(&signos)
+		if (err != nil) != caso.error {
+			t.Errorf("%s: error = %v, se esperaba error: %v", caso.nombre, err, caso.error)
+			continue
+		}
+		if caso.error {
+			continue
+		}
+
+		if (signos.IMC == nil) != (caso.imc == nil) || (signos.IMC != nil && *signos.IMC != *caso.imc) {
+			t.Errorf("%s: IMC = %v, se esperaba %v", caso.nombre, signos.IMC, caso.imc)
+		}
+		var alertas []string
+		for _, alerta := range signos.Alertas {
+			alertas = append(alertas, alerta.Campo+":"+alerta.Nivel)
+		}
+		if len(alertas) != len(caso.alertas) {
+			t.Errorf("%s: alertas = %v, se esperaban %v", caso.nombre, alertas, caso.alertas)
+			continue
+		}
+		for i := range alertas {
+			if alertas[i] != caso.alertas[i] {
+				t.Errorf("%s: alertas = %v, se esperaban %v", caso.nombre, alertas, caso.alertas)
+				break
+			}
+		}
+		if signos.Anormal != (len(caso.alertas) > 0) {
+			t.Errorf("%s: anormal = %v con alertas %v", caso.nombre, signos.Anormal, alertas)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/models"
+)
+
+// maxEventosPorPagina limita el tamaño de página de la línea de tiempo del paciente
+const maxEventosPorPagina = 100
+
+// permisosTimeline es el permiso de lectura que exige cada tipo de evento
+var permisosTimeline = map[string]string{
+	models.TimelineConsulta:      "consultas_read",
+	models.TimelineReceta:        "recetas_read",
+	models.TimelineExpediente:    "expedientes_read",
+	models.TimelineSignosVitales: "signos_vitales_read",
+}
+
+// consultasTimeline obtiene los eventos de cada tipo del paciente $1. Todas devuelven las mismas
+// columnas: tipo, id, fecha, resumen, id_usuario, usuario_nombre y detalle.
+var consultasTimeline = map[string]string{
+	models.TimelineConsulta: `
+		SELECT 'consulta' AS tipo, c.id_consulta AS id, COALESCE(h.fecha_hora, c.hora) AS fecha,
+		       CONCAT('Consulta ', c.tipo, ' (', c.estado, ')') AS resumen, c.id_medico AS id_usuario,
+		       m.nombre AS usuario_nombre,
+		       json_build_object('tipo', c.tipo, 'estado', c.estado, 'diagnostico', c.diagnostico,
+		                         'id_horario', c.id_horario, 'consultorio', co.nombre_numero) AS detalle
+		FROM Consulta c
+		JOIN Usuario m ON c.id_medico = m.id_usuario
+		LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
+		WHERE c.id_paciente = $1 AND c.deleted_at IS NULL`,
+	models.TimelineReceta: `
+		SELECT 'receta', r.id_receta, r.fecha::timestamp,
+		       CONCAT('Receta: ', COALESCE((SELECT string_agg(i.medicamento, ', ' ORDER BY i.orden)
+		                                    FROM RecetaItem i WHERE i.id_receta = r.id_receta), r.medicamento)),
+		       r.id_medico, m.nombre,
+		       json_build_object('instrucciones', r.instrucciones, 'consultorio', co.nombre_numero,
+		                         'resurtidos', r.resurtidos, 'vigente_hasta', r.vigente_hasta)
+		FROM Receta r
+		JOIN Usuario m ON r.id_medico = m.id_usuario
+		JOIN Consultorio co ON r.id_consultorio = co.id_consultorio
+		WHERE r.id_paciente = $1 AND r.deleted_at IS NULL`,
+	models.TimelineExpediente: `
+		SELECT 'expediente', v.id_version, v.created_at,
+		       CASE WHEN v.numero = 1 THEN 'Expediente creado'
+		            ELSE CONCAT('Expediente actualizado: ', REPLACE(v.campos_modificados, ',', ', ')) END,
+		       v.id_usuario, u.nombre,
+		       json_build_object('id_expediente', v.id_expediente, 'numero', v.numero,
+		                         'campos_modificados', v.campos_modificados)
+		FROM ExpedienteVersion v
+		JOIN Expediente e ON v.id_expediente = e.id_expediente
+		LEFT JOIN Usuario u ON v.id_usuario = u.id_usuario
+		WHERE e.id_paciente = $1 AND e.deleted_at IS NULL`,
+	models.TimelineSignosVitales: `
+		SELECT 'signos_vitales', s.id_signos, s.fecha_medicion,
+		       CASE WHEN s.anormal THEN 'Signos vitales con valores fuera de rango' ELSE 'Signos vitales' END,
+		       s.id_usuario, u.nombre,
+		       json_build_object('id_consulta', s.id_consulta, 'presion_sistolica', s.presion_sistolica,
+		                         'presion_diastolica', s.presion_diastolica,
+		                         'frecuencia_cardiaca', s.frecuencia_cardiaca, 'temperatura', s.temperatura,
+		                         'saturacion_oxigeno', s.saturacion_oxigeno, 'peso', s.peso, 'talla', s.talla,
+		                         'imc', s.imc, 'alertas', s.alertas)
+		FROM SignosVitales s
+		JOIN Usuario u ON s.id_usuario = u.id_usuario
+		WHERE s.id_paciente = $1`,
+}
+
+// tipoTimelineVisible indica si el usuario puede ver los eventos del tipo: con el permiso de
+// lectura del tipo y con el mismo alcance que en el endpoint que los muestra por separado
+func tipoTimelineVisible(ctx context.Context, usuario actor, tipo string, idPaciente int) (bool, error) {
+	if !usuario.tienePermiso(permisosTimeline[tipo]) {
+		return false, nil
+	}
+	return puedeVerPaciente(ctx, usuario, permisosTimeline[tipo], idPaciente)
+}
+
+// ObtenerTimelinePaciente reúne consultas, recetas, cambios del expediente y signos vitales del
+// paciente en una sola lista, de lo más reciente a lo más antiguo y paginada
+// (?tipos=consulta,receta, ?desde=, ?hasta=, ?pagina=, ?por_pagina=). Los tipos que el usuario no
+// puede ver por separado se omiten.
+func ObtenerTimelinePaciente(c *fiber.Ctx) error {
+	idPaciente, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "ID inválido",
+		})
+	}
+
+	tipos := models.TiposTimeline
+	if filtro := c.Query("tipos"); filtro != "" {
+		tipos = nil
+		for _, tipo := range strings.Split(filtro, ",") {
+			tipo = strings.TrimSpace(tipo)
+			if _, ok := consultasTimeline[tipo]; !ok {
+				return c.Status(400).JSON(fiber.Map{
+					"error": "Tipo inválido. Use consulta, receta, expediente o signos_vitales",
+				})
+			}
+			if !slices.Contains(tipos, tipo) {
+				tipos = append(tipos, tipo)
+			}
+		}
+	}
+
+	pagina := c.QueryInt("pagina", 1)
+	porPagina := c.QueryInt("por_pagina", 20)
+	if pagina < 1 {
+		pagina = 1
+	}
+	if porPagina < 1 {
+		porPagina = 20
+	}
+	porPagina = min(porPagina, maxEventosPorPagina)
+
+	args := []interface{}{idPaciente}
+	var condiciones []string
+	for _, filtro := range []struct{ parametro, condicion string }{
+		{"desde", "fecha >= $%d::date"},
+		{"hasta", "fecha < $%d::date + 1"},
+	} {
+		valor := c.Query(filtro.parametro)
+		if valor == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", valor); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Formato de fecha inválido. Use YYYY-MM-DD",
+			})
+		}
+		args = append(args, valor)
+		condiciones = append(condiciones, fmt.Sprintf(filtro.condicion, len(args)))
+	}
+
+	ctx := context.Background()
+	usuario := actorDe(c)
+	var visibles, consultas []string
+	for _, tipo := range tipos {
+		visible, err := tipoTimelineVisible(ctx, usuario, tipo, idPaciente)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error al obtener la línea de tiempo",
+			})
+		}
+		if visible {
+			visibles = append(visibles, tipo)
+			consultas = append(consultas, consultasTimeline[tipo])
+		}
+	}
+	if len(visibles) == 0 {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "No tienes acceso a la información clínica de este paciente",
+		})
+	}
+
+	var existe bool
+	err = database.GetDB().QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM Usuario u JOIN Rol r ON u.id_rol = r.id_rol
+		                WHERE u.id_usuario = $1 AND r.nombre = 'paciente' AND u.deleted_at IS NULL)`,
+		idPaciente).Scan(&existe)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener la línea de tiempo",
+		})
+	}
+	if !existe {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Paciente no encontrado",
+		})
+	}
+
+	eventos := "SELECT * FROM (" + strings.Join(consultas, " UNION ALL ") + ") eventos"
+	if len(condiciones) > 0 {
+		eventos += " WHERE " + strings.Join(condiciones, " AND ")
+	}
+
+	var total int
+	if err := database.GetDB().QueryRow(ctx, "SELECT COUNT(*) FROM ("+eventos+") t", args...).Scan(&total); err != nil {
+		log.Printf("Error al contar la línea de tiempo: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener la línea de tiempo",
+		})
+	}
+
+	args = append(args, porPagina, (pagina-1)*porPagina)
+	rows, err := database.GetDB().Query(ctx,
+		eventos+fmt.Sprintf(" ORDER BY fecha DESC NULLS LAST, tipo, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		log.Printf("Error al obtener la línea de tiempo: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al obtener la línea de tiempo",
+		})
+	}
+	defer rows.Close()
+
+	timeline := []models.EventoTimeline{}
+	for rows.Next() {
+		var e models.EventoTimeline
+		if err := rows.Scan(&e.Tipo, &e.ID, &e.Fecha, &e.Resumen, &e.IDUsuario, &e.UsuarioNombre, &e.Detalle); err != nil {
+			continue
+		}
+		timeline = append(timeline, e)
+	}
+
+	return c.JSON(fiber.Map{
+		"id_paciente": idPaciente,
+		"tipos":       visibles,
+		"timeline":    timeline,
+		"pagina":      pagina,
+		"por_pagina":  porPagina,
+		"total":       total,
+	})
+}
@@ -276,7 +276,7 @@ func Login(c *fiber.Ctx) error {
 		        u.mfa_enabled, u.mfa_secret, u.backup_codes, u.created_at, r.nombre
 		 FROM Usuario u 
 		 JOIN Rol r ON u.id_rol = r.id_rol 
-		 WHERE u.email = $1`,
+		 WHERE u.email = $1 AND u.deleted_at IS NULL`,
 		loginReq.Email).Scan(&usuario.IDUsuario, &usuario.Nombre, &usuario.Apellido, &usuario.FechaNacimiento,
 		&usuario.IDRol, &usuario.Email, &usuario.Password, &usuario.MFAEnabled, &mfaSecret, &backupCodes,
 		&usuario.CreatedAt, &rolNombre)
@@ -442,29 +442,26 @@ func Login(c *fiber.Ctx) error {
 		}
 	}
 
-	// GENERAR TOKENS JWT (usando id_rol)
-	accessToken, refreshToken, err := middleware.GenerateTokenPair(usuario.IDUsuario, usuario.IDRol)
+	// Abrir la sesión del dispositivo con su refresh token
+	idSesion, refreshToken, err := crearSesion(context.Background(), c, usuario.IDUsuario, loginReq.Dispositivo)
 	if err != nil {
 		return c.Status(500).JSON(StandardResponse{
 			StatusCode: 500,
 			Body: BodyResponse{
 				IntCode: "F02",
-				Data:    []interface{}{fiber.Map{"error": "Error al generar tokens"}},
+				Data:    []interface{}{fiber.Map{"error": "Error al crear la sesión"}},
 			},
 		})
 	}
 
-	// Guardar refresh token
-	_, err = database.GetDB().Exec(context.Background(),
-		`INSERT INTO refresh_tokens (user_id, token, expires_at) VALUES ($1, $2, $3)`,
-		usuario.IDUsuario, refreshToken, time.Now().Add(middleware.RefreshTokenDuration))
-
+	// GENERAR ACCESS TOKEN JWT (usando id_rol)
+	accessToken, err := middleware.GenerateAccessToken(usuario.IDUsuario, usuario.IDRol, idSesion)
 	if err != nil {
 		return c.Status(500).JSON(StandardResponse{
 			StatusCode: 500,
 			Body: BodyResponse{
 				IntCode: "F02",
-				Data:    []interface{}{fiber.Map{"error": "Error al guardar refresh token"}},
+				Data:    []interface{}{fiber.Map{"error": "Error al generar tokens"}},
 			},
 		})
 	}
@@ -479,6 +476,7 @@ func Login(c *fiber.Ctx) error {
 				AccessToken:  accessToken,
 				RefreshToken: refreshToken,
 				ExpiresIn:    int(middleware.AccessTokenDuration.Seconds()),
+				IDSesion:     idSesion,
 				Usuario: models.UsuarioResponse{
 					ID:              usuario.IDUsuario,
 					Nombre:          usuario.Nombre,
@@ -499,6 +497,7 @@ func ObtenerUsuarios(c *fiber.Ctx) error {
 		`SELECT u.id_usuario, u.nombre, u.apellido, u.fecha_nacimiento, u.id_rol, u.email, u.created_at, r.nombre as rol_nombre
 		 FROM Usuario u 
 		 JOIN Rol r ON u.id_rol = r.id_rol 
+		 WHERE u.deleted_at IS NULL
 		 ORDER BY u.created_at DESC`)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -545,7 +544,7 @@ func ObtenerUsuarioPorID(c *fiber.Ctx) error {
 	var usuario models.UsuarioResponse
 	err = database.GetDB().QueryRow(context.Background(),
 		`SELECT u.id_usuario, u.nombre, u.apellido, u.fecha_nacimiento, u.id_rol, u.email, u.created_at
-		 FROM Usuario u WHERE u.id_usuario = $1`, id).Scan(
+		 FROM Usuario u WHERE u.id_usuario = $1 AND u.deleted_at IS NULL`, id).Scan(
 		&usuario.ID, &usuario.Nombre, &usuario.Apellido, &usuario.FechaNacimiento, &usuario.IDRol, &usuario.Email, &usuario.CreatedAt)
 
 	if err != nil {
@@ -600,33 +599,75 @@ func ActualizarUsuario(c *fiber.Ctx) error {
 		usuario.Password = string(hashedPassword)
 	}
 
-	// Actualizar usuario
-	query := "UPDATE Usuario SET nombre = $1, apellido = $2, email = $3, fecha_nacimiento = $4, id_rol = $5, updated_at = $6"
-	args := []interface{}{usuario.Nombre, usuario.Apellido, usuario.Email, usuario.FechaNacimiento, usuario.IDRol, time.Now()}
+	// Actualizar usuario; el rol se cambia aparte, con auditoría, si se envía id_rol
+	query := "UPDATE Usuario SET nombre = $1, apellido = $2, email = $3, fecha_nacimiento = $4, updated_at = $5"
+	args := []interface{}{usuario.Nombre, usuario.Apellido, usuario.Email, usuario.FechaNacimiento, time.Now()}
+
+	// Si hay teléfono, incluirlo en la actualización (se usa para las notificaciones por SMS)
+	if usuario.Telefono != "" {
+		args = append(args, usuario.Telefono)
+		query += fmt.Sprintf(", telefono = $%d", len(args))
+	}
 
 	// Si hay contraseña, incluirla en la actualización
 	if usuario.Password != "" {
-		query += ", password = $7 WHERE id_usuario = $8"
-		args = append(args, usuario.Password, id)
-	} else {
-		query += " WHERE id_usuario = $7"
-		args = append(args, id)
+		args = append(args, usuario.Password)
+		query += fmt.Sprintf(", password = $%d", len(args))
 	}
 
-	_, err = database.GetDB().Exec(context.Background(), query, args...)
+	args = append(args, id)
+	query += fmt.Sprintf(" WHERE id_usuario = $%d AND deleted_at IS NULL", len(args))
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	// Cambiar el rol antes que el resto de los datos, en el mismo orden de bloqueos que
+	// AsignarRolUsuario; cambiarlo exige además el permiso roles_update
+	if usuario.IDRol > 0 {
+		anterior, err := asignarRolUsuario(ctx, tx, userID, id, usuario.IDRol)
+		if err != nil {
+			return responderErrorConsulta(c, err, "Error al actualizar usuario")
+		}
+		if anterior != usuario.IDRol && !hasPermission(c, "roles_update") {
+			return c.Status(403).JSON(fiber.Map{
+				"error": "No tienes permisos para cambiar el rol del usuario",
+			})
+		}
+	}
+
+	result, err := tx.Exec(ctx, query, args...)
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al actualizar usuario",
 		})
 	}
+	if result.RowsAffected() == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Usuario no encontrado",
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al actualizar usuario",
+		})
+	}
+	middleware.InvalidarPermisosUsuario(id)
 
 	return c.JSON(fiber.Map{
 		"mensaje": "Usuario actualizado exitosamente",
 	})
 }
 
-// EliminarUsuario elimina un usuario (solo admin)
+// EliminarUsuario elimina un usuario (solo admin). El borrado es lógico: el usuario se conserva
+// con quién lo eliminó, cuándo y el motivo, ya no puede iniciar sesión y sus sesiones se revocan.
 func EliminarUsuario(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -635,25 +676,54 @@ func EliminarUsuario(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verificar que el usuario existe
-	var existe int
-	err = database.GetDB().QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM Usuario WHERE id_usuario = $1", id).Scan(&existe)
-	if err != nil || existe == 0 {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Usuario no encontrado",
+	userID := c.Locals("user_id").(int)
+	if id == userID {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "No puedes eliminar tu propio usuario",
 		})
 	}
 
-	// Eliminar usuario
-	_, err = database.GetDB().Exec(context.Background(),
-		"DELETE FROM Usuario WHERE id_usuario = $1", id)
+	motivo, err := motivoEliminacion(c, true)
+	if err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar usuario")
+	}
+
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
 	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error interno del servidor",
+		})
+	}
+	defer tx.Rollback(ctx)
+
+	// Eliminar usuario, sin dejar a nadie con los permisos protegidos
+	if err := bloquearGestionRoles(ctx, tx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al eliminar usuario",
+		})
+	}
+	if err := eliminarRegistro(ctx, tx, models.EliminadoUsuario, id, userID, motivo); err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar usuario")
+	}
+	if err := verificarPermisosProtegidos(ctx, tx); err != nil {
+		return responderErrorConsulta(c, err, "Error al eliminar usuario")
+	}
+
+	// Revocar sus sesiones; los access tokens vigentes dejan de valer en JWTMiddleware
+	if err := revocarSesionesUsuario(ctx, tx, id, models.SesionUsuarioEliminado); err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al eliminar usuario",
 		})
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al eliminar usuario",
+		})
+	}
+	middleware.InvalidarPermisosUsuario(id)
+
 	return c.JSON(fiber.Map{
 		"mensaje": "Usuario eliminado exitosamente",
 	})
@@ -681,94 +751,80 @@ func ObtenerPerfil(c *fiber.Ctx) error {
 // RefreshToken renueva un access token usando un refresh token
 func RefreshToken(c *fiber.Ctx) error {
 	var refreshReq models.RefreshRequest
-	if err := c.BodyParser(&refreshReq); err != nil {
+	if err := c.BodyParser(&refreshReq); err != nil || refreshReq.RefreshToken == "" {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Datos inválidos",
 		})
 	}
 
-	// Validar refresh token
-	claims, err := middleware.ValidateToken(refreshReq.RefreshToken, "refresh")
+	// Rotar el refresh token de la sesión; un token ya rotado revoca la sesión completa
+	ctx := context.Background()
+	sesion, err := rotarRefreshToken(ctx, c, refreshReq.RefreshToken)
 	if err != nil {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Refresh token inválido o expirado",
-		})
-	}
-
-	// Verificar que el refresh token existe en la base de datos y no está revocado
-	var exists bool
-	err = database.GetDB().QueryRow(context.Background(),
-		`SELECT EXISTS(SELECT 1 FROM refresh_tokens 
-         WHERE token = $1 AND user_id = $2 AND expires_at > NOW() AND is_revoked = false)`,
-		refreshReq.RefreshToken, claims.UserID).Scan(&exists)
-
-	if err != nil || !exists {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Refresh token inválido o revocado",
-		})
+		return responderErrorConsulta(c, err, "Error al renovar la sesión")
 	}
 
-	// Generar nuevo par de tokens
-	newAccessToken, newRefreshToken, err := middleware.GenerateTokenPair(claims.UserID, claims.IDRol)
+	// Generar nuevo access token
+	newAccessToken, err := middleware.GenerateAccessToken(sesion.userID, sesion.acceso.IDRol, sesion.idSesion)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al generar nuevos tokens",
 		})
 	}
 
-	// Revocar el refresh token anterior
-	_, err = database.GetDB().Exec(context.Background(),
-		"UPDATE refresh_tokens SET is_revoked = true WHERE token = $1",
-		refreshReq.RefreshToken)
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al revocar token anterior",
-		})
-	}
-
-	// Guardar nuevo refresh token
-	_, err = database.GetDB().Exec(context.Background(),
-		`INSERT INTO refresh_tokens (user_id, token, expires_at) 
-         VALUES ($1, $2, $3)`,
-		claims.UserID, newRefreshToken, time.Now().Add(middleware.RefreshTokenDuration))
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al guardar nuevo refresh token",
-		})
-	}
-
 	// Crear respuesta
 	respuesta := models.RefreshResponse{
 		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
+		RefreshToken: sesion.refreshToken,
 		ExpiresIn:    int(middleware.AccessTokenDuration.Seconds()),
 	}
 
 	return c.JSON(respuesta)
 }
 
-// Logout revoca todos los refresh tokens del usuario
+// Logout cierra la sesión del dispositivo que hace la solicitud
 func Logout(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(int)
+	idSesion, _ := c.Locals("id_sesion").(int)
 
-	// Revocar todos los refresh tokens del usuario
-	_, err := database.GetDB().Exec(context.Background(),
-		"UPDATE refresh_tokens SET is_revoked = true WHERE user_id = $1",
-		userID)
+	ctx := context.Background()
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al cerrar sesión",
+		})
+	}
+	defer tx.Rollback(ctx)
 
+	// Cerrar solo la sesión de este dispositivo; los tokens emitidos antes de las sesiones no
+	// indican la suya, así que en ese caso se cierran todas
+	if idSesion != 0 {
+		err = revocarSesion(ctx, tx, idSesion, models.SesionCerrada)
+	} else {
+		err = revocarSesionesUsuario(ctx, tx, userID, models.SesionCerrada)
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Error al cerrar sesión",
 		})
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Error al cerrar sesión",
+		})
+	}
 
 	return c.JSON(fiber.Map{
 		"mensaje": "Sesión cerrada exitosamente",
 	})
 }
 
+// ObtenerJWKS publica las claves públicas con las que otros servicios verifican los access tokens
+func ObtenerJWKS(c *fiber.Ctx) error {
+	c.Set("Cache-Control", "public, max-age=300")
+	return c.JSON(fiber.Map{"keys": middleware.ClavesPublicasJWKS()})
+}
+
 // SetupMFA configura MFA para el usuario
 func SetupMFA(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(int)
@@ -918,7 +974,7 @@ func LoginWithMFA(c *fiber.Ctx) error {
 		        u.mfa_enabled, u.mfa_secret, u.backup_codes, u.created_at, r.nombre
 		 FROM Usuario u 
 		 JOIN Rol r ON u.id_rol = r.id_rol 
-		 WHERE u.email = $1`,
+		 WHERE u.email = $1 AND u.deleted_at IS NULL`,
 		loginReq.Email).Scan(&usuario.IDUsuario, &usuario.Nombre, &usuario.Apellido, &usuario.FechaNacimiento,
 		&usuario.IDRol, &usuario.Email, &usuario.Password, &usuario.MFAEnabled, &mfaSecret, &backupCodes,
 		&usuario.CreatedAt, &rolNombre)
@@ -979,22 +1035,19 @@ func LoginWithMFA(c *fiber.Ctx) error {
 		}
 	}
 
-	// Generar tokens JWT (usando id_rol)
-	accessToken, refreshToken, err := middleware.GenerateTokenPair(usuario.IDUsuario, usuario.IDRol)
+	// Abrir la sesión del dispositivo con su refresh token
+	idSesion, refreshToken, err := crearSesion(context.Background(), c, usuario.IDUsuario, loginReq.Dispositivo)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al generar tokens",
+			"error": "Error al crear la sesión",
 		})
 	}
 
-	// Guardar refresh token
-	_, err = database.GetDB().Exec(context.Background(),
-		`INSERT INTO refresh_tokens (user_id, token, expires_at) VALUES ($1, $2, $3)`,
-		usuario.IDUsuario, refreshToken, time.Now().Add(middleware.RefreshTokenDuration))
-
+	// Generar access token JWT (usando id_rol)
+	accessToken, err := middleware.GenerateAccessToken(usuario.IDUsuario, usuario.IDRol, idSesion)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error": "Error al guardar refresh token",
+			"error": "Error al generar tokens",
 		})
 	}
 
@@ -1003,6 +1056,7 @@ func LoginWithMFA(c *fiber.Ctx) error {
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		ExpiresIn:    int(middleware.AccessTokenDuration.Seconds()),
+		IDSesion:     idSesion,
 		Usuario: models.UsuarioResponse{
 			ID:              usuario.IDUsuario,
 			Nombre:          usuario.Nombre,
@@ -1064,23 +1118,9 @@ func CambiarPassword(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"message": "Contraseña actualizada exitosamente"})
 }
 
-// Función auxiliar para verificar permisos
+// Función auxiliar para verificar permisos con el acceso que JWTMiddleware resolvió para la solicitud
 func hasPermission(c *fiber.Ctx, permiso string) bool {
-	userID := c.Locals("user_id").(int)
-
-	var tienePermiso bool
-	query := `
-		SELECT EXISTS(
-			SELECT 1 FROM Usuario u
-			JOIN Rol r ON u.id_rol = r.id_rol
-			JOIN RolPermiso rp ON r.id_rol = rp.id_rol
-			JOIN Permiso p ON rp.id_permiso = p.id_permiso
-			WHERE u.id_usuario = $1 AND p.nombre = $2
-		)
-	`
-
-	err := database.GetDB().QueryRow(context.Background(), query, userID, permiso).Scan(&tienePermiso)
-	return err == nil && tienePermiso
+	return middleware.TienePermiso(c, permiso)
 }
 
 // ObtenerPermisosPorRol obtiene todos los permisos de un rol específico
@@ -1298,7 +1338,7 @@ func ObtenerUsuariosPorRol(c *fiber.Ctx) error {
 		`SELECT u.id_usuario, u.nombre, u.apellido, u.fecha_nacimiento, u.id_rol, u.email, u.created_at, r.nombre as rol_nombre
 		 FROM Usuario u 
 		 JOIN Rol r ON u.id_rol = r.id_rol 
-		 WHERE u.id_rol = $1
+		 WHERE u.id_rol = $1 AND u.deleted_at IS NULL
 		 ORDER BY u.created_at DESC`, rolID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -1332,7 +1372,7 @@ func ObtenerPacientes(c *fiber.Ctx) error {
 		`SELECT u.id_usuario, u.nombre, u.apellido, u.fecha_nacimiento, u.id_rol, u.email, u.created_at, r.nombre as rol_nombre
 		 FROM Usuario u 
 		 JOIN Rol r ON u.id_rol = r.id_rol 
-		 WHERE r.nombre = 'paciente'
+		 WHERE r.nombre = 'paciente' AND u.deleted_at IS NULL
 		 ORDER BY u.created_at DESC`)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -7,6 +7,9 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
 	"github.com/lizet96/hospital-backend/database"
+	"github.com/lizet96/hospital-backend/handlers"
+	"github.com/lizet96/hospital-backend/middleware"
+	"github.com/lizet96/hospital-backend/notificaciones"
 	"github.com/lizet96/hospital-backend/routes"
 )
 
@@ -15,10 +18,33 @@ func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Advertencia: No se pudo cargar el archivo .env")
 	}
+	// Cargar las claves de firma: tokens, enlaces de recordatorios, recetas impresas y notas clínicas
+	if err := middleware.CargarClavesJWT(); err != nil {
+		log.Fatalf("Error en la configuración de las claves JWT: %v", err)
+	}
+	if err := handlers.CargarClaveRecordatorios(); err != nil {
+		log.Fatalf("Error en la configuración de los recordatorios: %v", err)
+	}
+	if err := handlers.CargarClaveRecetas(); err != nil {
+		log.Fatalf("Error en la configuración de las recetas: %v", err)
+	}
+	if err := handlers.CargarClaveNotasClinicas(); err != nil {
+		log.Fatalf("Error en la configuración de las notas clínicas: %v", err)
+	}
 	// Conectar a la base de datos
 	database.ConnectDB()
 	defer database.CloseDB()
 	log.Println("Conexión a la base de datos establecida")
+
+	// Liberar periódicamente los horarios retenidos para la lista de espera que no se confirmaron
+	go handlers.IniciarVencimientoListaEspera()
+
+	// Enviar las notificaciones pendientes de la bandeja de salida
+	go notificaciones.IniciarDespachador()
+
+	// Encolar los recordatorios de las citas próximas
+	go handlers.IniciarRecordatorios()
+
 	// Crear instancia de Fiber con configuración
 	app := fiber.New(fiber.Config{
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
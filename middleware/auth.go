@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -11,64 +12,38 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/lizet96/hospital-backend/database"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 )
 
-// Clave secreta para firmar los tokens JWT
-var jwtSecret = []byte("clave_secreta_muy_segura_aqui")
-
 // Duraciones de tokens
 const (
 	AccessTokenDuration  = 10 * time.Minute   // 10 minutos
 	RefreshTokenDuration = 7 * 24 * time.Hour // 7 días
 )
 
-// Claims personalizados para el JWT
+// Claims personalizados para el JWT. IDSesion es la sesión del dispositivo que emitió el token.
 type Claims struct {
-	UserID int `json:"user_id"`
-	IDRol  int `json:"id_rol"`
+	UserID   int `json:"user_id"`
+	IDRol    int `json:"id_rol"`
+	IDSesion int `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Función actualizada
-func GenerateTokenPair(userID int, idRol int) (string, string, error) {
-	// Access Token
-	accessClaims := Claims{
-		UserID: userID,
-		IDRol:  idRol,
+// GenerateAccessToken firma el access token de una sesión. Los refresh tokens son opacos y se
+// guardan en la base de datos (ver GenerateRefreshTokenString).
+func GenerateAccessToken(userID int, idRol int, idSesion int) (string, error) {
+	claims := Claims{
+		UserID:   userID,
+		IDRol:    idRol,
+		IDSesion: idSesion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   "access",
 		},
 	}
-
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString(jwtSecret)
-	if err != nil {
-		return "", "", err
-	}
-
-	// Refresh Token
-	refreshClaims := Claims{
-		UserID: userID,
-		IDRol:  idRol,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(RefreshTokenDuration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Subject:   "refresh",
-		},
-	}
-
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString(jwtSecret)
-	if err != nil {
-		return "", "", err
-	}
-
-	return accessTokenString, refreshTokenString, nil
+	return firmarToken(claims)
 }
 
 // GenerateRefreshTokenString genera un string aleatorio para refresh token
@@ -82,9 +57,8 @@ func GenerateRefreshTokenString() (string, error) {
 
 // ValidateToken valida un token JWT
 func ValidateToken(tokenString string, expectedType string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, claveVerificacion,
+		jwt.WithValidMethods([]string{AlgoritmoHS256, AlgoritmoRS256, AlgoritmoEdDSA}))
 
 	if err != nil || !token.Valid {
 		return nil, err
@@ -130,26 +104,37 @@ func JWTMiddleware() fiber.Handler {
 			})
 		}
 
-		// Obtener información del rol desde la base de datos
-		var rolNombre string
-		var idRol int
-		err = database.GetDB().QueryRow(context.Background(), `
-            SELECT u.id_rol, r.nombre 
-            FROM Usuario u 
-            JOIN Rol r ON u.id_rol = r.id_rol 
-            WHERE u.id_usuario = $1 AND r.activo = true
-        `, claims.UserID).Scan(&idRol, &rolNombre)
+		// Rechazar los tokens de una sesión cerrada o revocada (de la caché si no ha vencido)
+		if err := VerificarSesion(context.Background(), claims.UserID, claims.IDSesion); err != nil {
+			if errors.Is(err, ErrSesionRevocada) {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "La sesión fue cerrada",
+				})
+			}
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error interno del servidor",
+			})
+		}
 
-		if err != nil {
+		// Obtener el rol y los permisos una sola vez por solicitud (de la caché si no han vencido)
+		acceso, err := ObtenerAccesoUsuario(context.Background(), claims.UserID)
+		if errors.Is(err, ErrAccesoNoValido) {
 			return c.Status(401).JSON(fiber.Map{
 				"error": "Usuario o rol no válido",
 			})
 		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Error interno del servidor",
+			})
+		}
 
 		// Guardar información del usuario en el contexto
 		c.Locals("user_id", claims.UserID)
-		c.Locals("user_role", rolNombre)
-		c.Locals("id_rol", idRol)
+		c.Locals("user_role", acceso.Rol)
+		c.Locals("id_rol", acceso.IDRol)
+		c.Locals("id_sesion", claims.IDSesion)
+		c.Locals("acceso", acceso)
 
 		return c.Next()
 	}
@@ -178,51 +163,23 @@ func RequireRole(allowedRoles ...string) fiber.Handler {
 	}
 }
 
-// Eliminar RequirePermissionHybrid completamente y usar solo RequirePermission
+// RequirePermission exige que el rol del usuario tenga el permiso; se verifica en memoria con
+// el acceso que JWTMiddleware resolvió para la solicitud
 func RequirePermission(permiso string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		log.Printf("DEBUG - RequirePermission: Verificando permiso '%s'", permiso)
-		
-		userID, ok := c.Locals("user_id").(int)
-		if !ok {
-			log.Println("DEBUG - RequirePermission: Usuario no autenticado")
+		if _, ok := c.Locals("user_id").(int); !ok {
 			return c.Status(401).JSON(fiber.Map{
 				"error": "Usuario no autenticado",
 			})
 		}
-		
-		log.Printf("DEBUG - RequirePermission: UserID=%d, Permiso=%s", userID, permiso)
-
-		// Verificar permiso en la base de datos
-		var tienePermiso bool
-		query := `
-            SELECT EXISTS(
-                SELECT 1 FROM Usuario u
-                JOIN Rol r ON u.id_rol = r.id_rol
-                JOIN RolPermiso rp ON r.id_rol = rp.id_rol
-                JOIN Permiso p ON rp.id_permiso = p.id_permiso
-                WHERE u.id_usuario = $1 AND p.nombre = $2 AND r.activo = true
-            )
-        `
-
-		err := database.GetDB().QueryRow(context.Background(), query, userID, permiso).Scan(&tienePermiso)
-		if err != nil {
-			log.Printf("DEBUG - RequirePermission: Error en query: %v", err)
-			return c.Status(500).JSON(fiber.Map{
-				"error": "Error interno del servidor",
-			})
-		}
-		
-		log.Printf("DEBUG - RequirePermission: TienePermiso=%t", tienePermiso)
-		
-		if !tienePermiso {
-			log.Printf("DEBUG - RequirePermission: Acceso denegado para permiso '%s'", permiso)
+
+		if !TienePermiso(c, permiso) {
+			log.Printf("Acceso denegado: el usuario %d no tiene el permiso '%s'", c.Locals("user_id").(int), permiso)
 			return c.Status(403).JSON(fiber.Map{
 				"error": "Acceso denegado: permisos insuficientes",
 			})
 		}
-		
-		log.Printf("DEBUG - RequirePermission: Permiso '%s' concedido", permiso)
+
 		return c.Next()
 	}
 }
@@ -0,0 +1,305 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algoritmos de firma admitidos para los tokens (JWT_ALGORITMO)
+const (
+	AlgoritmoHS256 = "HS256"
+	AlgoritmoRS256 = "RS256"
+	AlgoritmoEdDSA = "EdDSA"
+)
+
+// claveJWT es una clave con la que se firman o verifican tokens. El kid es la huella RFC 7638
+// de la clave, así que no cambia entre reinicios ni entre instancias del servidor.
+type claveJWT struct {
+	kid     string
+	metodo  jwt.SigningMethod
+	firma   interface{} // clave privada o secreto; nil si solo verifica
+	verif   interface{} // clave pública o secreto
+	publica crypto.PublicKey
+}
+
+// llaveroJWT tiene la clave con la que se firman los tokens nuevos y todas las que se aceptan al
+// verificarlos: la actual y las anteriores que siguen vigentes durante una rotación
+type llaveroJWT struct {
+	actual *claveJWT
+	claves map[string]*claveJWT
+	orden  []string
+}
+
+var (
+	llavero      *llaveroJWT
+	errLlavero   error
+	llaveroOnce  sync.Once
+	errClaveJWT  = errors.New("clave de firma desconocida")
+	errMetodoJWT = errors.New("algoritmo de firma no coincide con la clave")
+)
+
+// CargarClavesJWT lee la configuración de las claves de los tokens. main la llama al iniciar
+// para detener el servidor si la configuración es inválida.
+//
+//	JWT_ALGORITMO            HS256 (por defecto), RS256 o EdDSA
+//	JWT_SECRET               secreto de HS256
+//	JWT_CLAVE_PRIVADA        archivo PEM con la clave privada RSA o Ed25519
+//	JWT_CLAVES_ANTERIORES    archivos PEM (separados por comas) de claves que solo verifican
+//	JWT_SECRETOS_ANTERIORES  secretos HS256 (separados por comas) que solo verifican
+func CargarClavesJWT() error {
+	_, err := obtenerLlavero()
+	return err
+}
+
+// obtenerLlavero carga las claves una sola vez
+func obtenerLlavero() (*llaveroJWT, error) {
+	llaveroOnce.Do(func() {
+		llavero, errLlavero = cargarLlavero()
+	})
+	return llavero, errLlavero
+}
+
+func cargarLlavero() (*llaveroJWT, error) {
+	l := &llaveroJWT{claves: make(map[string]*claveJWT)}
+
+	algoritmo := strings.TrimSpace(os.Getenv("JWT_ALGORITMO"))
+	if algoritmo == "" {
+		algoritmo = AlgoritmoHS256
+	}
+
+	var actual *claveJWT
+	var err error
+	switch algoritmo {
+	case AlgoritmoHS256:
+		// Un secreto aleatorio por proceso invalidaría las sesiones al reiniciar y entre instancias
+		secreto := os.Getenv("JWT_SECRET")
+		if secreto == "" {
+			return nil, errors.New("JWT_SECRET es obligatoria con JWT_ALGORITMO=HS256")
+		}
+		actual = claveSecreta(secreto)
+	case AlgoritmoRS256, AlgoritmoEdDSA:
+		ruta := os.Getenv("JWT_CLAVE_PRIVADA")
+		if ruta == "" {
+			return nil, fmt.Errorf("JWT_CLAVE_PRIVADA es obligatoria con JWT_ALGORITMO=%s", algoritmo)
+		}
+		if actual, err = leerClavePEM(ruta); err != nil {
+			return nil, err
+		}
+		if actual.firma == nil {
+			return nil, fmt.Errorf("%s no contiene una clave privada", ruta)
+		}
+		if actual.metodo.Alg() != algoritmo {
+			return nil, fmt.Errorf("%s es una clave %s y JWT_ALGORITMO es %s", ruta, actual.metodo.Alg(), algoritmo)
+		}
+	default:
+		return nil, fmt.Errorf("JWT_ALGORITMO inválido: %s (use HS256, RS256 o EdDSA)", algoritmo)
+	}
+	l.actual = actual
+	l.agregar(actual)
+
+	// Claves anteriores: siguen verificando los tokens emitidos antes de la rotación
+	for _, ruta := range listaDeEntorno("JWT_CLAVES_ANTERIORES") {
+		clave, err := leerClavePEM(ruta)
+		if err != nil {
+			return nil, err
+		}
+		clave.firma = nil
+		l.agregar(clave)
+	}
+	for _, secreto := range listaDeEntorno("JWT_SECRETOS_ANTERIORES") {
+		clave := claveSecreta(secreto)
+		clave.firma = nil
+		l.agregar(clave)
+	}
+
+	return l, nil
+}
+
+// agregar registra una clave de verificación; si ya existe (por ejemplo, la actual repetida
+// entre las anteriores) se conserva la primera
+func (l *llaveroJWT) agregar(clave *claveJWT) {
+	if _, existe := l.claves[clave.kid]; existe {
+		return
+	}
+	l.claves[clave.kid] = clave
+	l.orden = append(l.orden, clave.kid)
+}
+
+// listaDeEntorno lee una lista separada por comas de una variable de entorno
+func listaDeEntorno(nombre string) []string {
+	var valores []string
+	for _, valor := range strings.Split(os.Getenv(nombre), ",") {
+		if valor = strings.TrimSpace(valor); valor != "" {
+			valores = append(valores, valor)
+		}
+	}
+	return valores
+}
+
+// claveSecreta arma una clave HS256; su kid es la huella del secreto y no lo revela
+func claveSecreta(secreto string) *claveJWT {
+	k := base64.RawURLEncoding.EncodeToString([]byte(secreto))
+	return &claveJWT{
+		kid:    huellaJWK(fmt.Sprintf(`{"k":%q,"kty":"oct"}`, k)),
+		metodo: jwt.SigningMethodHS256,
+		firma:  []byte(secreto),
+		verif:  []byte(secreto),
+	}
+}
+
+// leerClavePEM lee una clave RSA o Ed25519, privada o pública, de un archivo PEM
+func leerClavePEM(ruta string) (*claveJWT, error) {
+	contenido, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer la clave %s: %w", ruta, err)
+	}
+	bloque, _ := pem.Decode(contenido)
+	if bloque == nil {
+		return nil, fmt.Errorf("%s no es un archivo PEM", ruta)
+	}
+
+	var privada crypto.PrivateKey
+	var publica crypto.PublicKey
+	if p, err := x509.ParsePKCS8PrivateKey(bloque.Bytes); err == nil {
+		privada = p
+	} else if p, err := x509.ParsePKCS1PrivateKey(bloque.Bytes); err == nil {
+		privada = p
+	} else if p, err := x509.ParsePKIXPublicKey(bloque.Bytes); err == nil {
+		publica = p
+	} else if p, err := x509.ParsePKCS1PublicKey(bloque.Bytes); err == nil {
+		publica = p
+	} else {
+		return nil, fmt.Errorf("%s no contiene una clave RSA o Ed25519 válida", ruta)
+	}
+
+	switch p := privada.(type) {
+	case *rsa.PrivateKey:
+		publica = &p.PublicKey
+	case ed25519.PrivateKey:
+		publica = p.Public()
+	}
+
+	clave := &claveJWT{firma: privada, publica: publica}
+	switch p := publica.(type) {
+	case *rsa.PublicKey:
+		clave.metodo = jwt.SigningMethodRS256
+		clave.verif = p
+	case ed25519.PublicKey:
+		clave.metodo = jwt.SigningMethodEdDSA
+		clave.verif = p
+	default:
+		return nil, fmt.Errorf("%s no contiene una clave RSA o Ed25519", ruta)
+	}
+	if privada == nil {
+		clave.firma = nil
+	}
+	clave.kid = huellaJWK(jwkCanonico(clave.jwk()))
+	return clave, nil
+}
+
+// JWK es la representación pública de una clave en el JWKS
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// jwk devuelve la parte pública de una clave asimétrica
+func (c *claveJWT) jwk() JWK {
+	j := JWK{Kid: c.kid, Alg: c.metodo.Alg(), Use: "sig"}
+	switch p := c.publica.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = base64.RawURLEncoding.EncodeToString(p.N.Bytes())
+		j.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.E)).Bytes())
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = base64.RawURLEncoding.EncodeToString(p)
+	}
+	return j
+}
+
+// jwkCanonico arma los miembros obligatorios de la clave en orden lexicográfico (RFC 7638)
+func jwkCanonico(j JWK) string {
+	if j.Kty == "RSA" {
+		e, _ := json.Marshal(j.E)
+		n, _ := json.Marshal(j.N)
+		return fmt.Sprintf(`{"e":%s,"kty":"RSA","n":%s}`, e, n)
+	}
+	x, _ := json.Marshal(j.X)
+	return fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":%s}`, x)
+}
+
+// huellaJWK calcula la huella SHA-256 de una clave en su forma canónica
+func huellaJWK(canonico string) string {
+	suma := sha256.Sum256([]byte(canonico))
+	return base64.RawURLEncoding.EncodeToString(suma[:])
+}
+
+// ClavesPublicasJWKS devuelve las claves públicas con las que otros servicios pueden verificar
+// los tokens: la actual y las anteriores. Los secretos HS256 nunca se publican.
+func ClavesPublicasJWKS() []JWK {
+	l, err := obtenerLlavero()
+	claves := []JWK{}
+	if err != nil {
+		return claves
+	}
+	for _, kid := range l.orden {
+		if clave := l.claves[kid]; clave.publica != nil {
+			claves = append(claves, clave.jwk())
+		}
+	}
+	return claves
+}
+
+// firmarToken firma los claims con la clave actual e indica su kid en el encabezado
+func firmarToken(claims jwt.Claims) (string, error) {
+	l, err := obtenerLlavero()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(l.actual.metodo, claims)
+	token.Header["kid"] = l.actual.kid
+	return token.SignedString(l.actual.firma)
+}
+
+// claveVerificacion elige la clave con la que se verifica un token según su kid. Los tokens sin
+// kid (emitidos antes de configurar las claves) solo se verifican con la clave actual. El
+// algoritmo del token debe ser el de la clave, para que no se acepte, por ejemplo, un HS256
+// firmado con una clave pública RSA.
+func claveVerificacion(token *jwt.Token) (interface{}, error) {
+	l, err := obtenerLlavero()
+	if err != nil {
+		return nil, err
+	}
+	clave := l.actual
+	if kid, ok := token.Header["kid"].(string); ok {
+		if clave, ok = l.claves[kid]; !ok {
+			return nil, errClaveJWT
+		}
+	}
+	if token.Method.Alg() != clave.metodo.Alg() {
+		return nil, errMetodoJWT
+	}
+	return clave.verif, nil
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import "testing"
+
+// Los ejemplos de RFC 7638 (sección 3.1) y RFC 8037 (apéndice A.3)
+func TestHuellaJWK(t *testing.T) {
+	casos := []struct {
+		nombre   string
+		jwk      JWK
+		canonico string
+		huella   string
+	}{
+		{
+			nombre: "RSA",
+			jwk: JWK{
+				Kty: "RSA",
+				Kid: "2011-04-29",
+				Alg: "RS256",
+				Use: "sig",
+				N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+					"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn" +
+					"1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+				E: "AQAB",
+			},
+			canonico: `{"e":"AQAB","kty":"RSA","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxu` +
+				`hDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMic` +
+				`AtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0` +
+				`Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}`,
+			huella: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			nombre:   "Ed25519",
+			jwk:      JWK{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+			canonico: `{"crv":"Ed25519","kty":"OKP","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+			huella:   "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+	for _, caso := range casos {
+		canonico := jwkCanonico(caso.jwk)
+		if canonico != caso.canonico {
+			t.Errorf("%s: jwkCanonico = %s, se esperaba %s", caso.nombre, canonico, caso.canonico)
+		}
+		if huella := huellaJWK(canonico); huella != caso.huella {
+			t.Errorf("%s: huellaJWK = %s, se esperaba %s", caso.nombre, huella, caso.huella)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lizet96/hospital-backend/database"
+)
+
+// Valores por defecto de la caché de permisos
+const (
+	cachePermisosSegundosPorDefecto = 30
+	variableCachePermisosSegundos   = "PERMISOS_CACHE_SEGUNDOS"
+)
+
+// ErrAccesoNoValido indica que el usuario no existe, fue eliminado o su rol está desactivado
+var ErrAccesoNoValido = errors.New("usuario o rol no válido")
+
+// AccesoUsuario es el rol activo de un usuario con sus permisos. JWTMiddleware lo resuelve una
+// vez por solicitud y lo guarda en c.Locals("acceso"); las verificaciones de permisos posteriores
+// se hacen en memoria.
+type AccesoUsuario struct {
+	IDRol    int
+	Rol      string
+	Permisos map[string]bool
+}
+
+// Tiene indica si el rol del usuario tiene el permiso
+func (a *AccesoUsuario) Tiene(permiso string) bool {
+	return a != nil && a.Permisos[permiso]
+}
+
+// entradaCachePermisos es el acceso de un usuario guardado en la caché y cuándo vence
+type entradaCachePermisos struct {
+	acceso *AccesoUsuario
+	expira time.Time
+}
+
+// cachePermisos guarda el acceso de cada usuario durante PERMISOS_CACHE_SEGUNDOS. La generación
+// cambia con cada invalidación para descartar las cargas que empezaron antes de ella.
+var cachePermisos = struct {
+	sync.RWMutex
+	usuarios   map[int]entradaCachePermisos
+	generacion uint64
+}{usuarios: make(map[int]entradaCachePermisos)}
+
+// duracionCachePermisos es el tiempo que se reutiliza el acceso de un usuario; 0 desactiva la caché
+func duracionCachePermisos() time.Duration {
+	segundos, err := strconv.Atoi(os.Getenv(variableCachePermisosSegundos))
+	if err != nil || segundos < 0 {
+		segundos = cachePermisosSegundosPorDefecto
+	}
+	return time.Duration(segundos) * time.Second
+}
+
+// ObtenerAccesoUsuario devuelve el rol activo y los permisos del usuario, de la caché si no han
+// vencido o de la base de datos. Devuelve ErrAccesoNoValido si el usuario no puede operar.
+func ObtenerAccesoUsuario(ctx context.Context, userID int) (*AccesoUsuario, error) {
+	ahora := time.Now()
+
+	cachePermisos.RLock()
+	entrada, ok := cachePermisos.usuarios[userID]
+	generacion := cachePermisos.generacion
+	cachePermisos.RUnlock()
+	if ok && ahora.Before(entrada.expira) {
+		return entrada.acceso, nil
+	}
+
+	acceso, err := cargarAccesoUsuario(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if duracion := duracionCachePermisos(); duracion > 0 {
+		cachePermisos.Lock()
+		// Si hubo una invalidación durante la carga, lo leído puede estar desactualizado
+		if cachePermisos.generacion == generacion {
+			cachePermisos.usuarios[userID] = entradaCachePermisos{acceso: acceso, expira: ahora.Add(duracion)}
+		}
+		cachePermisos.Unlock()
+	}
+	return acceso, nil
+}
+
+// cargarAccesoUsuario lee de la base de datos el rol activo del usuario y sus permisos
+func cargarAccesoUsuario(ctx context.Context, userID int) (*AccesoUsuario, error) {
+	rows, err := database.GetDB().Query(ctx, `
+		SELECT r.id_rol, r.nombre, p.nombre
+		FROM Usuario u
+		JOIN Rol r ON u.id_rol = r.id_rol
+		LEFT JOIN RolPermiso rp ON r.id_rol = rp.id_rol
+		LEFT JOIN Permiso p ON rp.id_permiso = p.id_permiso
+		WHERE u.id_usuario = $1 AND r.activo = true AND u.deleted_at IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var acceso *AccesoUsuario
+	for rows.Next() {
+		var idRol int
+		var rol string
+		var permiso *string
+		if err := rows.Scan(&idRol, &rol, &permiso); err != nil {
+			return nil, err
+		}
+		if acceso == nil {
+			acceso = &AccesoUsuario{IDRol: idRol, Rol: rol, Permisos: make(map[string]bool)}
+		}
+		if permiso != nil {
+			acceso.Permisos[*permiso] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if acceso == nil {
+		return nil, ErrAccesoNoValido
+	}
+	return acceso, nil
+}
+
+// InvalidarPermisosUsuario descarta el acceso guardado de un usuario; se usa al cambiar su rol,
+// eliminarlo o restaurarlo
+func InvalidarPermisosUsuario(userID int) {
+	cachePermisos.Lock()
+	delete(cachePermisos.usuarios, userID)
+	cachePermisos.generacion++
+	cachePermisos.Unlock()
+}
+
+// InvalidarPermisos descarta el acceso guardado de todos los usuarios; se usa al cambiar los
+// permisos de un rol o al activarlo o desactivarlo
+func InvalidarPermisos() {
+	cachePermisos.Lock()
+	cachePermisos.usuarios = make(map[int]entradaCachePermisos)
+	cachePermisos.generacion++
+	cachePermisos.Unlock()
+}
+
+// TienePermiso indica si el usuario autenticado de la solicitud tiene el permiso
+func TienePermiso(c *fiber.Ctx, permiso string) bool {
+	acceso, _ := c.Locals("acceso").(*AccesoUsuario)
+	return acceso.Tiene(permiso)
+}
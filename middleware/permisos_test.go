@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDuracionCachePermisos(t *testing.T) {
+	casos := []struct {
+		valor    string
+		duracion time.Duration
+	}{
+		{"", cachePermisosSegundosPorDefecto * time.Second},
+		{"10", 10 * time.Second},
+		{"0", 0},
+		{"-5", cachePermisosSegundosPorDefecto * time.Second},
+		{"treinta", cachePermisosSegundosPorDefecto * time.Second},
+	}
+	for _, caso := range casos {
+		t.Setenv(variableCachePermisosSegundos, caso.valor)
+		if got := duracionCachePermisos(); got != caso.duracion {
+			t.Errorf("duracionCachePermisos() con %q = %v, se esperaba %v", caso.valor, got, caso.duracion)
+		}
+	}
+}
+
+// reiniciarCaches deja las cachés vacías al empezar y al terminar la prueba
+func reiniciarCaches(t *testing.T) {
+	limpiar := func() {
+		InvalidarPermisos()
+		cacheSesiones.Lock()
+		cacheSesiones.sesiones = make(map[int]entradaCacheSesion)
+		cacheSesiones.Unlock()
+	}
+	limpiar()
+	t.Cleanup(limpiar)
+}
+
+// Las entradas vigentes se responden sin consultar la base de datos, que en las pruebas no existe
+func TestObtenerAccesoUsuarioDesdeCache(t *testing.T) {
+	reiniciarCaches(t)
+	acceso := &AccesoUsuario{IDRol: 2, Rol: "medico", Permisos: map[string]bool{"consultas_read_own": true}}
+	cachePermisos.Lock()
+	cachePermisos.usuarios[7] = entradaCachePermisos{acceso: acceso, expira: time.Now().Add(time.Minute)}
+	cachePermisos.Unlock()
+
+	got, err := ObtenerAccesoUsuario(context.Background(), 7)
+	if err != nil || got != acceso {
+		t.Fatalf("ObtenerAccesoUsuario = %v, %v; se esperaba el acceso de la caché", got, err)
+	}
+	if !got.Tiene("consultas_read_own") || got.Tiene("consultas_read_any") {
+		t.Errorf("Tiene no coincide con los permisos del rol: %v", got.Permisos)
+	}
+}
+
+func TestInvalidarPermisos(t *testing.T) {
+	casos := []struct {
+		nombre     string
+		invalidar  func()
+		quedan     []int
+		descartado []int
+	}{
+		{"un usuario", func() { InvalidarPermisosUsuario(1) }, []int{2}, []int{1}},
+		{"todos", InvalidarPermisos, nil, []int{1, 2}},
+	}
+	for _, caso := range casos {
+		reiniciarCaches(t)
+		cachePermisos.Lock()
+		for _, id := range []int{1, 2} {
+			cachePermisos.usuarios[id] = entradaCachePermisos{acceso: &AccesoUsuario{}, expira: time.Now().Add(time.Minute)}
+		}
+		generacion := cachePermisos.generacion
+		cachePermisos.Unlock()
+
+		caso.invalidar()
+
+		cachePermisos.RLock()
+		if cachePermisos.generacion == generacion {
+			t.Errorf("%s: la generación no cambió; una carga en curso podría guardar permisos viejos", caso.nombre)
+		}
+		for _, id := range caso.quedan {
+			if _, ok := cachePermisos.usuarios[id]; !ok {
+				t.Errorf("%s: se descartó el acceso del usuario %d", caso.nombre, id)
+			}
+		}
+		for _, id := range caso.descartado {
+			if _, ok := cachePermisos.usuarios[id]; ok {
+				t.Errorf("%s: el acceso del usuario %d sigue en la caché", caso.nombre, id)
+			}
+		}
+		cachePermisos.RUnlock()
+	}
+}
+
+func TestVerificarSesionDesdeCache(t *testing.T) {
+	casos := []struct {
+		nombre   string
+		idSesion int
+		entrada  *entradaCacheSesion
+		revocar  bool
+		esperado error
+	}{
+		{"token sin sesión", 0, nil, false, nil},
+		{"sesión activa", 5, &entradaCacheSesion{activa: true, expira: time.Now().Add(time.Minute)}, false, nil},
+		{"sesión revocada", 5, &entradaCacheSesion{activa: false, expira: time.Now().Add(time.Minute)}, false, ErrSesionRevocada},
+		{"revocada después de activa", 5, &entradaCacheSesion{activa: true, expira: time.Now().Add(time.Minute)}, true, ErrSesionRevocada},
+	}
+	for _, caso := range casos {
+		reiniciarCaches(t)
+		t.Setenv(variableCachePermisosSegundos, "30")
+		if caso.entrada != nil {
+			cacheSesiones.Lock()
+			cacheSesiones.sesiones[caso.idSesion] = *caso.entrada
+			cacheSesiones.Unlock()
+		}
+		if caso.revocar {
+			InvalidarSesiones(caso.idSesion)
+		}
+
+		if err := VerificarSesion(context.Background(), 1, caso.idSesion); !errors.Is(err, caso.esperado) {
+			t.Errorf("%s: VerificarSesion = %v, se esperaba %v", caso.nombre, err, caso.esperado)
+		}
+	}
+}
+
+func TestInvalidarSesiones(t *testing.T) {
+	casos := []struct {
+		nombre   string
+		segundos string
+		marcada  bool
+	}{
+		{"con caché", "30", true},
+		{"sin caché", "0", false},
+	}
+	for _, caso := range casos {
+		reiniciarCaches(t)
+		t.Setenv(variableCachePermisosSegundos, caso.segundos)
+		cacheSesiones.Lock()
+		cacheSesiones.sesiones[3] = entradaCacheSesion{activa: true, expira: time.Now().Add(time.Minute)}
+		generacion := cacheSesiones.generacion
+		cacheSesiones.Unlock()
+
+		InvalidarSesiones(3)
+
+		cacheSesiones.RLock()
+		entrada, ok := cacheSesiones.sesiones[3]
+		if cacheSesiones.generacion == generacion {
+			t.Errorf("%s: la generación no cambió", caso.nombre)
+		}
+		cacheSesiones.RUnlock()
+		if ok != caso.marcada || (ok && entrada.activa) {
+			t.Errorf("%s: entrada = %+v (existe %v), se esperaba marcada como revocada: %v", caso.nombre, entrada, ok, caso.marcada)
+		}
+	}
+}
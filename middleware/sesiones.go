@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lizet96/hospital-backend/database"
+)
+
+// ErrSesionRevocada indica que la sesión del access token fue cerrada o revocada
+var ErrSesionRevocada = errors.New("sesión revocada")
+
+// entradaCacheSesion indica si una sesión sigue activa y hasta cuándo vale la respuesta
+type entradaCacheSesion struct {
+	activa bool
+	expira time.Time
+}
+
+// cacheSesiones guarda el estado de cada sesión durante PERMISOS_CACHE_SEGUNDOS, con la misma
+// generación que cachePermisos. Una sesión revocada queda marcada como tal hasta que vence la
+// entrada, para que una carga concurrente con la revocación no la vuelva a marcar como activa
+// antes de que la transacción que la revocó se confirme.
+var cacheSesiones = struct {
+	sync.RWMutex
+	sesiones   map[int]entradaCacheSesion
+	generacion uint64
+}{sesiones: make(map[int]entradaCacheSesion)}
+
+// VerificarSesion devuelve ErrSesionRevocada si la sesión del access token ya no está activa.
+// Los tokens emitidos antes de las sesiones no indican la suya (idSesion 0) y vencen solos.
+func VerificarSesion(ctx context.Context, userID, idSesion int) error {
+	if idSesion == 0 {
+		return nil
+	}
+	ahora := time.Now()
+
+	cacheSesiones.RLock()
+	entrada, ok := cacheSesiones.sesiones[idSesion]
+	generacion := cacheSesiones.generacion
+	cacheSesiones.RUnlock()
+	if ok && ahora.Before(entrada.expira) {
+		if !entrada.activa {
+			return ErrSesionRevocada
+		}
+		return nil
+	}
+
+	var activa bool
+	err := database.GetDB().QueryRow(ctx,
+		"SELECT revoked_at IS NULL FROM Sesion WHERE id_sesion = $1 AND id_usuario = $2",
+		idSesion, userID).Scan(&activa)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	if duracion := duracionCachePermisos(); duracion > 0 {
+		cacheSesiones.Lock()
+		// Si hubo una revocación durante la carga, lo leído puede estar desactualizado
+		actual, existe := cacheSesiones.sesiones[idSesion]
+		revocada := existe && !actual.activa && ahora.Before(actual.expira)
+		if cacheSesiones.generacion == generacion && !revocada {
+			cacheSesiones.sesiones[idSesion] = entradaCacheSesion{activa: activa, expira: ahora.Add(duracion)}
+		}
+		cacheSesiones.Unlock()
+	}
+
+	if !activa {
+		return ErrSesionRevocada
+	}
+	return nil
+}
+
+// InvalidarSesiones marca como revocadas las sesiones en la caché; se usa al cerrarlas o
+// revocarlas, para que sus access tokens dejen de valer de inmediato
+func InvalidarSesiones(idsSesion ...int) {
+	duracion := duracionCachePermisos()
+	cacheSesiones.Lock()
+	for _, idSesion := range idsSesion {
+		if duracion > 0 {
+			cacheSesiones.sesiones[idSesion] = entradaCacheSesion{activa: false, expira: time.Now().Add(duracion)}
+		} else {
+			delete(cacheSesiones.sesiones, idSesion)
+		}
+	}
+	cacheSesiones.generacion++
+	cacheSesiones.Unlock()
+}
@@ -0,0 +1,64 @@
+-- Script para agregar el registro estructurado de alergias y su revisión al recetar
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Crear la tabla de alergias del paciente
+CREATE TABLE IF NOT EXISTS Alergia (
+    id_alergia SERIAL PRIMARY KEY,
+    id_paciente INT NOT NULL,
+    sustancia VARCHAR(150) NOT NULL,
+    sustancia_busqueda VARCHAR(150) NOT NULL,   -- sustancia en minúsculas y sin acentos
+    tipo VARCHAR(20) NOT NULL DEFAULT 'medicamento'
+        CHECK (tipo IN ('medicamento', 'alimento', 'ambiental', 'otro')),
+    reaccion TEXT,
+    severidad VARCHAR(20) NOT NULL DEFAULT 'desconocida'
+        CHECK (severidad IN ('leve', 'moderada', 'grave', 'desconocida')),
+    activa BOOLEAN NOT NULL DEFAULT TRUE,
+    verificada_por INT,                         -- médico que confirmó la alergia
+    verificada_at TIMESTAMP,
+    id_usuario INT NOT NULL,                    -- quién la registró
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (id_paciente) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (verificada_por) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario)
+);
+
+-- 2. Una sola alergia activa por sustancia y paciente
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alergia_paciente_sustancia
+    ON Alergia(id_paciente, sustancia_busqueda) WHERE activa;
+
+-- 3. Crear la tabla de omisiones: recetas emitidas a pesar de coincidir con una alergia
+CREATE TABLE IF NOT EXISTS RecetaAlergiaOmision (
+    id_omision SERIAL PRIMARY KEY,
+    id_receta INT NOT NULL,
+    id_alergia INT NOT NULL,
+    id_medico INT NOT NULL,
+    motivo TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (id_receta) REFERENCES Receta(id_receta) ON DELETE CASCADE,
+    FOREIGN KEY (id_alergia) REFERENCES Alergia(id_alergia),
+    FOREIGN KEY (id_medico) REFERENCES Usuario(id_usuario)
+);
+
+CREATE INDEX IF NOT EXISTS idx_receta_alergia_omision_receta ON RecetaAlergiaOmision(id_receta);
+
+-- 4. Pasar al registro las alergias escritas como texto en Expediente.alergias (una por coma,
+-- punto y coma o renglón), que ya no se consulta al recetar. Quedan sin verificar, con
+-- severidad desconocida y registradas a nombre del paciente, porque el expediente no guarda
+-- quién las escribió. Las sustancias que ya están en el registro, activas o no, se omiten.
+INSERT INTO Alergia (id_paciente, sustancia, sustancia_busqueda, tipo, id_usuario)
+SELECT DISTINCT ON (l.id_paciente, l.busqueda) l.id_paciente, l.sustancia, l.busqueda, 'otro', l.id_paciente
+FROM (
+    SELECT e.id_paciente, LEFT(TRIM(t.texto), 150) AS sustancia,
+           LEFT(translate(lower(TRIM(t.texto)), 'áéíóúüñ', 'aeiouun'), 150) AS busqueda
+    FROM Expediente e
+    CROSS JOIN LATERAL regexp_split_to_table(e.alergias, '[,;\r\n]+') AS t(texto)
+    WHERE e.alergias IS NOT NULL
+) l
+WHERE l.busqueda <> ''
+AND l.busqueda NOT IN ('ninguna', 'ninguno', 'niega', 'negadas', 'negativas', 'no', 'n/a', 'na',
+                       'sin alergias', 'no refiere', 'no conocidas', 'desconocidas')
+AND NOT EXISTS (
+    SELECT 1 FROM Alergia a WHERE a.id_paciente = l.id_paciente AND a.sustancia_busqueda = l.busqueda
+)
+ORDER BY l.id_paciente, l.busqueda;
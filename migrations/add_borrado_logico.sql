@@ -0,0 +1,51 @@
+-- Script para agregar el borrado lógico de expedientes, recetas, consultas y usuarios
+-- Ejecutar este script en PostgreSQL (requiere add_versiones_expediente.sql)
+
+-- 1. Quién eliminó cada registro, cuándo y por qué; los registros eliminados se conservan
+ALTER TABLE Expediente ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE Expediente ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES Usuario(id_usuario);
+ALTER TABLE Expediente ADD COLUMN IF NOT EXISTS motivo_eliminacion TEXT;
+
+ALTER TABLE Receta ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE Receta ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES Usuario(id_usuario);
+ALTER TABLE Receta ADD COLUMN IF NOT EXISTS motivo_eliminacion TEXT;
+
+ALTER TABLE Consulta ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE Consulta ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES Usuario(id_usuario);
+ALTER TABLE Consulta ADD COLUMN IF NOT EXISTS motivo_eliminacion TEXT;
+
+ALTER TABLE Usuario ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE Usuario ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES Usuario(id_usuario);
+ALTER TABLE Usuario ADD COLUMN IF NOT EXISTS motivo_eliminacion TEXT;
+
+-- 2. Índices para listar los registros eliminados
+CREATE INDEX IF NOT EXISTS idx_expediente_eliminado ON Expediente(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_receta_eliminada ON Receta(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_consulta_eliminada ON Consulta(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_usuario_eliminado ON Usuario(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- 3. Historial de eliminaciones y restauraciones
+CREATE TABLE IF NOT EXISTS EliminacionHistorial (
+    id_historial SERIAL PRIMARY KEY,
+    tipo VARCHAR(20) NOT NULL CHECK (tipo IN ('expediente', 'receta', 'consulta', 'usuario')),
+    id_registro INT NOT NULL,
+    accion VARCHAR(20) NOT NULL CHECK (accion IN ('eliminar', 'restaurar')),
+    id_usuario INT NOT NULL,
+    motivo TEXT,
+    fecha TIMESTAMP NOT NULL,
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario)
+);
+
+CREATE INDEX IF NOT EXISTS idx_eliminacion_historial_registro ON EliminacionHistorial(tipo, id_registro);
+
+-- 4. Las versiones del expediente ya no se eliminan: el expediente se conserva
+CREATE OR REPLACE FUNCTION impedir_modificar_version_expediente() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'Las versiones del expediente no se pueden modificar ni eliminar';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_expediente_version_inmutable ON ExpedienteVersion;
+CREATE TRIGGER trg_expediente_version_inmutable
+    BEFORE UPDATE OR DELETE ON ExpedienteVersion
+    FOR EACH ROW EXECUTE FUNCTION impedir_modificar_version_expediente();
@@ -0,0 +1,41 @@
+-- Script para agregar el catálogo CIE-10 y los diagnósticos codificados de las consultas
+-- Ejecutar este script en PostgreSQL y luego cargar el catálogo con:
+--   go run ./cmd/importar_cie10 -archivo cie10.csv
+
+-- 1. Extensión para buscar por fragmentos de la descripción
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- 2. Crear el catálogo de diagnósticos
+CREATE TABLE IF NOT EXISTS CatalogoCIE10 (
+    codigo VARCHAR(10) PRIMARY KEY,
+    descripcion TEXT NOT NULL,
+    descripcion_busqueda TEXT NOT NULL,     -- descripción en minúsculas y sin acentos
+    activo BOOLEAN NOT NULL DEFAULT TRUE,   -- los códigos retirados no se asignan a nuevas consultas
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_cie10_busqueda
+    ON CatalogoCIE10 USING gin (descripcion_busqueda gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_cie10_codigo_prefijo
+    ON CatalogoCIE10 (codigo varchar_pattern_ops);
+
+-- 3. Crear la tabla de diagnósticos de cada consulta
+CREATE TABLE IF NOT EXISTS ConsultaDiagnostico (
+    id_consulta_diagnostico SERIAL PRIMARY KEY,
+    id_consulta INT NOT NULL,
+    codigo VARCHAR(10) NOT NULL,
+    principal BOOLEAN NOT NULL DEFAULT FALSE,  -- diagnóstico principal o secundario
+    notas TEXT,
+    id_usuario INT NOT NULL,                   -- quién registró el diagnóstico
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (id_consulta, codigo),
+    FOREIGN KEY (id_consulta) REFERENCES Consulta(id_consulta),
+    FOREIGN KEY (codigo) REFERENCES CatalogoCIE10(codigo),
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario)
+);
+
+-- 4. Un solo diagnóstico principal por consulta
+CREATE UNIQUE INDEX IF NOT EXISTS idx_consulta_diagnostico_principal
+    ON ConsultaDiagnostico(id_consulta) WHERE principal;
+
+CREATE INDEX IF NOT EXISTS idx_consulta_diagnostico_codigo ON ConsultaDiagnostico(codigo);
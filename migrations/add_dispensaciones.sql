@@ -0,0 +1,56 @@
+-- Script para agregar el surtido de recetas en farmacia, resurtidos y vigencia
+-- Ejecutar este script en PostgreSQL después de add_medicamentos.sql y add_verificacion_recetas.sql
+
+-- 1. Resurtidos permitidos y vigencia de cada receta
+ALTER TABLE Receta ADD COLUMN IF NOT EXISTS resurtidos INT NOT NULL DEFAULT 0 CHECK (resurtidos >= 0);
+ALTER TABLE Receta ADD COLUMN IF NOT EXISTS vigente_hasta DATE;
+
+UPDATE Receta SET vigente_hasta = fecha + 30 WHERE vigente_hasta IS NULL;
+
+-- 2. Crear la tabla de surtidos; numero 1 es el surtido original y los siguientes, resurtidos
+CREATE TABLE IF NOT EXISTS Dispensacion (
+    id_dispensacion SERIAL PRIMARY KEY,
+    id_receta INT NOT NULL,
+    id_farmaceutico INT NOT NULL,
+    numero INT NOT NULL CHECK (numero >= 1),
+    resurtidos_restantes INT NOT NULL CHECK (resurtidos_restantes >= 0),
+    observaciones TEXT NOT NULL DEFAULT '',
+    fecha TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (id_receta) REFERENCES Receta(id_receta),
+    FOREIGN KEY (id_farmaceutico) REFERENCES Usuario(id_usuario),
+    UNIQUE (id_receta, numero)                  -- impide surtir dos veces el mismo surtido
+);
+
+-- 3. Cantidades entregadas por medicamento
+CREATE TABLE IF NOT EXISTS DispensacionItem (
+    id_dispensacion INT NOT NULL,
+    id_item INT NOT NULL,
+    medicamento VARCHAR(255) NOT NULL,
+    cantidad INT CHECK (cantidad > 0),
+    PRIMARY KEY (id_dispensacion, id_item),
+    FOREIGN KEY (id_dispensacion) REFERENCES Dispensacion(id_dispensacion) ON DELETE CASCADE,
+    FOREIGN KEY (id_item) REFERENCES RecetaItem(id_item)
+);
+
+-- 4. Rol de farmacia
+INSERT INTO Rol (nombre, activo)
+SELECT 'farmacia', true
+WHERE NOT EXISTS (SELECT 1 FROM Rol WHERE nombre = 'farmacia');
+
+-- 5. Permisos de surtido
+INSERT INTO Permiso (nombre, descripcion, recurso, accion)
+SELECT v.nombre, v.descripcion, 'dispensaciones', v.accion
+FROM (VALUES
+    ('dispensaciones_read', 'Buscar recetas por código de verificación', 'read'),
+    ('dispensaciones_create', 'Surtir recetas', 'create')
+) AS v(nombre, descripcion, accion)
+WHERE NOT EXISTS (SELECT 1 FROM Permiso p WHERE p.nombre = v.nombre);
+
+-- 6. Farmacia y admin buscan y surten recetas
+INSERT INTO RolPermiso (id_rol, id_permiso)
+SELECT r.id_rol, p.id_permiso
+FROM Rol r, Permiso p
+WHERE r.nombre IN ('farmacia', 'admin') AND p.nombre IN ('dispensaciones_read', 'dispensaciones_create')
+AND NOT EXISTS (
+    SELECT 1 FROM RolPermiso rp WHERE rp.id_rol = r.id_rol AND rp.id_permiso = p.id_permiso
+);
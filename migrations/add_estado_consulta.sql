@@ -0,0 +1,35 @@
+-- Script para agregar el ciclo de vida (estado) a la tabla Consulta
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Agregar la columna estado y la fecha del último cambio de estado
+ALTER TABLE Consulta ADD COLUMN IF NOT EXISTS estado VARCHAR(20) NOT NULL DEFAULT 'programada';
+ALTER TABLE Consulta ADD COLUMN IF NOT EXISTS estado_actualizado_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE Consulta ADD COLUMN IF NOT EXISTS fecha TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+-- 2. Restringir los valores permitidos del estado
+ALTER TABLE Consulta DROP CONSTRAINT IF EXISTS consulta_estado_check;
+ALTER TABLE Consulta ADD CONSTRAINT consulta_estado_check
+    CHECK (estado IN ('programada', 'confirmada', 'en_curso', 'completada', 'cancelada', 'no_asistio'));
+
+CREATE INDEX IF NOT EXISTS idx_consulta_estado ON Consulta(estado);
+
+-- 3. Crear la tabla de historial de transiciones (quién y cuándo cambió cada estado)
+CREATE TABLE IF NOT EXISTS ConsultaEstadoHistorial (
+    id_historial SERIAL PRIMARY KEY,
+    id_consulta INT NOT NULL,
+    estado_anterior VARCHAR(20),
+    estado_nuevo VARCHAR(20) NOT NULL,
+    id_usuario INT,
+    motivo TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (id_consulta) REFERENCES Consulta(id_consulta) ON DELETE CASCADE,
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario)
+);
+
+CREATE INDEX IF NOT EXISTS idx_consulta_estado_historial_consulta ON ConsultaEstadoHistorial(id_consulta);
+
+-- 4. Registrar el estado inicial de las consultas existentes
+INSERT INTO ConsultaEstadoHistorial (id_consulta, estado_anterior, estado_nuevo, motivo)
+SELECT c.id_consulta, NULL, c.estado, 'Estado inicial (migración)'
+FROM Consulta c
+WHERE NOT EXISTS (SELECT 1 FROM ConsultaEstadoHistorial h WHERE h.id_consulta = c.id_consulta);
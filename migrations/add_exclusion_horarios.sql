@@ -0,0 +1,30 @@
+-- Script para impedir horarios traslapados del mismo médico o del mismo consultorio
+-- Ejecutar este script en PostgreSQL (requiere la migración add_plantillas_horario.sql)
+
+-- 1. Habilitar btree_gist para combinar igualdad (=) y traslape de rangos (&&) en una exclusión
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+-- 2. Revisar traslapes existentes antes de agregar las restricciones (deben corregirse a mano)
+SELECT a.id_horario, b.id_horario AS id_horario_traslapado, a.id_medico, a.id_consultorio,
+       a.fecha_hora, a.fecha_hora_fin, b.fecha_hora, b.fecha_hora_fin
+FROM Horario a
+JOIN Horario b ON a.id_horario < b.id_horario
+     AND (a.id_medico = b.id_medico OR a.id_consultorio = b.id_consultorio)
+     AND a.fecha_hora < b.fecha_hora_fin AND a.fecha_hora_fin > b.fecha_hora;
+
+-- 3. El fin de un horario debe ser posterior a su inicio
+ALTER TABLE Horario DROP CONSTRAINT IF EXISTS horario_intervalo_check;
+ALTER TABLE Horario ADD CONSTRAINT horario_intervalo_check
+    CHECK (fecha_hora_fin IS NULL OR fecha_hora_fin > fecha_hora);
+
+-- 4. Un médico no puede tener dos horarios que se traslapen
+ALTER TABLE Horario DROP CONSTRAINT IF EXISTS horario_medico_sin_traslape;
+ALTER TABLE Horario ADD CONSTRAINT horario_medico_sin_traslape
+    EXCLUDE USING gist (id_medico WITH =, tsrange(fecha_hora, fecha_hora_fin) WITH &&)
+    WHERE (fecha_hora IS NOT NULL AND fecha_hora_fin IS NOT NULL);
+
+-- 5. Un consultorio no puede tener dos horarios que se traslapen
+ALTER TABLE Horario DROP CONSTRAINT IF EXISTS horario_consultorio_sin_traslape;
+ALTER TABLE Horario ADD CONSTRAINT horario_consultorio_sin_traslape
+    EXCLUDE USING gist (id_consultorio WITH =, tsrange(fecha_hora, fecha_hora_fin) WITH &&)
+    WHERE (fecha_hora IS NOT NULL AND fecha_hora_fin IS NOT NULL);
@@ -0,0 +1,44 @@
+-- Script para administrar roles y permisos desde la API
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Nombres únicos de roles y permisos (la API los busca por nombre)
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rol_nombre ON Rol(nombre);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_permiso_nombre ON Permiso(nombre);
+
+-- 2. Auditoría de los cambios en roles, permisos y en el rol de cada usuario
+CREATE TABLE IF NOT EXISTS AuditoriaRol (
+    id_auditoria SERIAL PRIMARY KEY,
+    id_usuario INT NOT NULL,                  -- quién hizo el cambio
+    accion VARCHAR(30) NOT NULL CHECK (accion IN ('crear_rol', 'desactivar_rol', 'activar_rol',
+        'crear_permiso', 'otorgar_permiso', 'revocar_permiso', 'asignar_rol')),
+    id_rol INT,
+    id_permiso INT,
+    id_usuario_afectado INT,                  -- en asignar_rol
+    id_rol_anterior INT,                      -- en asignar_rol
+    fecha TIMESTAMP NOT NULL,
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_rol) REFERENCES Rol(id_rol),
+    FOREIGN KEY (id_permiso) REFERENCES Permiso(id_permiso),
+    FOREIGN KEY (id_usuario_afectado) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_rol_anterior) REFERENCES Rol(id_rol)
+);
+
+CREATE INDEX IF NOT EXISTS idx_auditoria_rol_fecha ON AuditoriaRol(fecha);
+
+-- 3. Permisos para administrar roles
+INSERT INTO Permiso (nombre, descripcion, recurso, accion)
+SELECT v.nombre, v.descripcion, 'roles', v.accion
+FROM (VALUES
+    ('roles_read', 'Ver roles, permisos y su auditoría', 'read'),
+    ('roles_update', 'Crear y desactivar roles, otorgar permisos y asignar roles', 'update')
+) AS v(nombre, descripcion, accion)
+WHERE NOT EXISTS (SELECT 1 FROM Permiso p WHERE p.nombre = v.nombre);
+
+-- 4. Solo admin administra roles
+INSERT INTO RolPermiso (id_rol, id_permiso)
+SELECT r.id_rol, p.id_permiso
+FROM Rol r, Permiso p
+WHERE r.nombre = 'admin' AND p.nombre IN ('roles_read', 'roles_update')
+AND NOT EXISTS (
+    SELECT 1 FROM RolPermiso rp WHERE rp.id_rol = r.id_rol AND rp.id_permiso = p.id_permiso
+);
@@ -0,0 +1,55 @@
+-- Script para agregar la tabla de interacciones entre medicamentos y su revisión al recetar
+-- Ejecutar este script en PostgreSQL después de add_medicamentos.sql y luego cargar las
+-- interacciones con:
+--   go run ./cmd/importar_interacciones -archivo interacciones.csv
+
+-- 1. Crear la tabla de interacciones entre nombres genéricos del catálogo
+CREATE TABLE IF NOT EXISTS InteraccionMedicamento (
+    id_interaccion SERIAL PRIMARY KEY,
+    medicamento_a VARCHAR(150) NOT NULL,
+    medicamento_b VARCHAR(150) NOT NULL,
+    nombre_a VARCHAR(150) NOT NULL,             -- palabras del nombre, en minúsculas y sin acentos
+    nombre_b VARCHAR(150) NOT NULL,             -- el importador guarda el par ordenado
+    severidad VARCHAR(20) NOT NULL CHECK (severidad IN ('leve', 'moderada', 'grave')),
+    descripcion TEXT NOT NULL DEFAULT '',
+    activa BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (nombre_a, nombre_b)
+);
+
+-- 2. Crear la tabla de omisiones: recetas emitidas a pesar de una interacción grave
+CREATE TABLE IF NOT EXISTS RecetaInteraccionOmision (
+    id_omision SERIAL PRIMARY KEY,
+    id_receta INT NOT NULL,
+    id_interaccion INT NOT NULL,
+    id_receta_relacionada INT,                  -- NULL si ambos medicamentos son de la misma receta
+    medicamento VARCHAR(255) NOT NULL,
+    con_medicamento VARCHAR(255) NOT NULL,
+    id_medico INT NOT NULL,
+    motivo TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (id_receta) REFERENCES Receta(id_receta) ON DELETE CASCADE,
+    FOREIGN KEY (id_interaccion) REFERENCES InteraccionMedicamento(id_interaccion),
+    FOREIGN KEY (id_receta_relacionada) REFERENCES Receta(id_receta) ON DELETE SET NULL,
+    FOREIGN KEY (id_medico) REFERENCES Usuario(id_usuario)
+);
+
+CREATE INDEX IF NOT EXISTS idx_receta_interaccion_omision_receta ON RecetaInteraccionOmision(id_receta);
+
+-- 3. Índice para buscar las recetas recientes del paciente
+CREATE INDEX IF NOT EXISTS idx_receta_paciente_fecha ON Receta(id_paciente, fecha);
+
+-- 4. Referenciar los medicamentos del catálogo de cada interacción: las recetas con medicamentos
+--    del catálogo se comparan por id y el nombre solo se usa con medicamentos en texto libre
+ALTER TABLE InteraccionMedicamento ADD COLUMN IF NOT EXISTS id_medicamento_a INT REFERENCES Medicamento(id_medicamento);
+ALTER TABLE InteraccionMedicamento ADD COLUMN IF NOT EXISTS id_medicamento_b INT REFERENCES Medicamento(id_medicamento);
+
+UPDATE InteraccionMedicamento i SET id_medicamento_a = (
+    SELECT MIN(m.id_medicamento) FROM Medicamento m
+    WHERE btrim(regexp_replace(m.nombre_busqueda, '[^[:alnum:]]+', ' ', 'g')) = i.nombre_a)
+WHERE i.id_medicamento_a IS NULL;
+
+UPDATE InteraccionMedicamento i SET id_medicamento_b = (
+    SELECT MIN(m.id_medicamento) FROM Medicamento m
+    WHERE btrim(regexp_replace(m.nombre_busqueda, '[^[:alnum:]]+', ' ', 'g')) = i.nombre_b)
+WHERE i.id_medicamento_b IS NULL;
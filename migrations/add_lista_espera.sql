@@ -0,0 +1,32 @@
+-- Script para agregar la lista de espera de pacientes por médico
+-- Ejecutar este script en PostgreSQL (requiere la migración add_plantillas_horario.sql)
+
+-- 1. Crear la tabla de lista de espera
+CREATE TABLE IF NOT EXISTS ListaEspera (
+    id_lista_espera SERIAL PRIMARY KEY,
+    id_paciente INT NOT NULL,
+    id_medico INT NOT NULL,
+    fecha_desde DATE,
+    fecha_hasta DATE,
+    motivo TEXT,
+    estado VARCHAR(20) NOT NULL DEFAULT 'en_espera'
+        CHECK (estado IN ('en_espera', 'ofrecida', 'confirmada', 'expirada', 'cancelada')),
+    id_horario INT,                       -- horario retenido mientras la inscripción está ofrecida
+    retencion_expira_at TIMESTAMP,
+    id_consulta INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (fecha_hasta IS NULL OR fecha_desde IS NULL OR fecha_hasta >= fecha_desde),
+    FOREIGN KEY (id_paciente) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_medico) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_horario) REFERENCES Horario(id_horario) ON DELETE SET NULL,
+    FOREIGN KEY (id_consulta) REFERENCES Consulta(id_consulta) ON DELETE SET NULL
+);
+
+-- 2. Un paciente solo puede tener una inscripción activa por médico
+CREATE UNIQUE INDEX IF NOT EXISTS idx_lista_espera_activa
+    ON ListaEspera(id_paciente, id_medico) WHERE estado IN ('en_espera', 'ofrecida');
+
+-- 3. Índices para buscar al siguiente paciente y las retenciones vencidas
+CREATE INDEX IF NOT EXISTS idx_lista_espera_medico ON ListaEspera(id_medico, estado, created_at);
+CREATE INDEX IF NOT EXISTS idx_lista_espera_retencion ON ListaEspera(retencion_expira_at) WHERE estado = 'ofrecida';
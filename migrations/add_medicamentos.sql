@@ -0,0 +1,49 @@
+-- Script para agregar el catálogo de medicamentos y las recetas con varios medicamentos
+-- Ejecutar este script en PostgreSQL y luego cargar el catálogo con:
+--   go run ./cmd/importar_medicamentos -archivo medicamentos.csv
+
+-- 1. Crear el catálogo de medicamentos
+CREATE TABLE IF NOT EXISTS Medicamento (
+    id_medicamento SERIAL PRIMARY KEY,
+    clave VARCHAR(30),                          -- clave del cuadro básico, opcional
+    nombre_generico VARCHAR(150) NOT NULL,
+    nombre_busqueda VARCHAR(150) NOT NULL,      -- nombre en minúsculas y sin acentos
+    presentacion VARCHAR(100) NOT NULL DEFAULT '',
+    concentracion VARCHAR(100) NOT NULL DEFAULT '',
+    via VARCHAR(50) NOT NULL DEFAULT '',
+    activo BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (nombre_busqueda, presentacion, concentracion, via)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_medicamento_clave ON Medicamento(clave) WHERE clave IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_medicamento_busqueda ON Medicamento(nombre_busqueda varchar_pattern_ops);
+
+-- 2. Instrucciones generales de la receta
+ALTER TABLE Receta ADD COLUMN IF NOT EXISTS instrucciones TEXT;
+
+-- 3. Crear los renglones de la receta; Receta.medicamento y Receta.dosis quedan como resumen
+CREATE TABLE IF NOT EXISTS RecetaItem (
+    id_item SERIAL PRIMARY KEY,
+    id_receta INT NOT NULL,
+    id_medicamento INT,                         -- NULL para medicamentos fuera del catálogo
+    medicamento VARCHAR(255) NOT NULL,
+    dosis VARCHAR(100) NOT NULL,
+    frecuencia VARCHAR(100) NOT NULL DEFAULT '',
+    duracion VARCHAR(100) NOT NULL DEFAULT '',
+    cantidad INT CHECK (cantidad > 0),
+    via VARCHAR(50) NOT NULL DEFAULT '',
+    indicaciones TEXT NOT NULL DEFAULT '',
+    orden INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (id_receta) REFERENCES Receta(id_receta) ON DELETE CASCADE,
+    FOREIGN KEY (id_medicamento) REFERENCES Medicamento(id_medicamento)
+);
+
+CREATE INDEX IF NOT EXISTS idx_receta_item_receta ON RecetaItem(id_receta, orden);
+
+-- 4. Convertir las recetas existentes en recetas de un solo renglón
+INSERT INTO RecetaItem (id_receta, medicamento, dosis, orden)
+SELECT r.id_receta, r.medicamento, r.dosis, 1
+FROM Receta r
+WHERE NOT EXISTS (SELECT 1 FROM RecetaItem i WHERE i.id_receta = r.id_receta);
@@ -0,0 +1,43 @@
+-- Script para agregar las notas clínicas SOAP de las consultas
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Crear la tabla de notas (la nota original y sus enmiendas)
+CREATE TABLE IF NOT EXISTS NotaClinica (
+    id_nota SERIAL PRIMARY KEY,
+    id_consulta INT NOT NULL,
+    id_paciente INT NOT NULL,
+    id_medico INT NOT NULL,                   -- médico que firma la nota o la enmienda
+    id_nota_original INT,                     -- NULL en la nota original
+    tipo VARCHAR(20) NOT NULL CHECK (tipo IN ('original', 'enmienda')),
+    subjetivo TEXT NOT NULL DEFAULT '',
+    objetivo TEXT NOT NULL DEFAULT '',
+    evaluacion TEXT NOT NULL DEFAULT '',
+    plan TEXT NOT NULL DEFAULT '',
+    motivo_enmienda TEXT,
+    firma VARCHAR(64) NOT NULL,               -- HMAC-SHA256 del contenido, el autor y la fecha
+    created_at TIMESTAMP NOT NULL,
+    CHECK ((tipo = 'original' AND id_nota_original IS NULL)
+        OR (tipo = 'enmienda' AND id_nota_original IS NOT NULL AND motivo_enmienda IS NOT NULL)),
+    FOREIGN KEY (id_consulta) REFERENCES Consulta(id_consulta),
+    FOREIGN KEY (id_paciente) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_medico) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_nota_original) REFERENCES NotaClinica(id_nota)
+);
+
+-- 2. Una sola nota original por consulta
+CREATE UNIQUE INDEX IF NOT EXISTS idx_nota_clinica_original
+    ON NotaClinica(id_consulta) WHERE tipo = 'original';
+
+CREATE INDEX IF NOT EXISTS idx_nota_clinica_paciente ON NotaClinica(id_paciente, created_at);
+
+-- 3. Las notas son de solo agregar: no se permiten UPDATE ni DELETE
+CREATE OR REPLACE FUNCTION impedir_modificar_nota_clinica() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'Las notas clínicas no se pueden modificar ni eliminar; registre una enmienda';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_nota_clinica_solo_agregar ON NotaClinica;
+CREATE TRIGGER trg_nota_clinica_solo_agregar
+    BEFORE UPDATE OR DELETE ON NotaClinica
+    FOR EACH ROW EXECUTE FUNCTION impedir_modificar_nota_clinica();
@@ -0,0 +1,53 @@
+-- Script para agregar el sistema de notificaciones (bandeja de salida y bandeja de entrada)
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Teléfono del usuario para el canal SMS
+ALTER TABLE Usuario ADD COLUMN IF NOT EXISTS telefono VARCHAR(20);
+
+-- 2. Bandeja de salida: un envío por destinatario y canal, con reintentos
+CREATE TABLE IF NOT EXISTS NotificacionSalida (
+    id_notificacion_salida SERIAL PRIMARY KEY,
+    id_usuario INT NOT NULL,
+    canal VARCHAR(20) NOT NULL,
+    destino VARCHAR(150) NOT NULL,           -- correo, teléfono o id de usuario según el canal
+    tipo VARCHAR(50) NOT NULL,
+    asunto VARCHAR(200) NOT NULL,
+    cuerpo TEXT NOT NULL,
+    estado VARCHAR(20) NOT NULL DEFAULT 'pendiente' CHECK (estado IN ('pendiente', 'enviada', 'fallida')),
+    intentos INT NOT NULL DEFAULT 0,
+    max_intentos INT NOT NULL DEFAULT 5,
+    proximo_intento_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ultimo_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    enviada_at TIMESTAMP,
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notificacion_salida_pendiente
+    ON NotificacionSalida(proximo_intento_at) WHERE estado = 'pendiente';
+
+-- 3. Bandeja de entrada dentro de la aplicación
+CREATE TABLE IF NOT EXISTS Notificacion (
+    id_notificacion SERIAL PRIMARY KEY,
+    id_usuario INT NOT NULL,
+    tipo VARCHAR(50) NOT NULL,
+    asunto VARCHAR(200) NOT NULL,
+    cuerpo TEXT NOT NULL,
+    leida BOOLEAN NOT NULL DEFAULT FALSE,
+    id_notificacion_salida INT UNIQUE,       -- evita duplicados si un envío se reintenta
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    leida_at TIMESTAMP,
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario) ON DELETE CASCADE,
+    FOREIGN KEY (id_notificacion_salida) REFERENCES NotificacionSalida(id_notificacion_salida) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_notificacion_usuario ON Notificacion(id_usuario, leida, created_at);
+
+-- 4. Estado 'enviando': el despachador reclama los mensajes antes de enviarlos y registra el
+-- resultado después, fuera de la transacción que los bloquea
+ALTER TABLE NotificacionSalida DROP CONSTRAINT IF EXISTS notificacionsalida_estado_check;
+ALTER TABLE NotificacionSalida ADD CONSTRAINT notificacionsalida_estado_check
+    CHECK (estado IN ('pendiente', 'enviando', 'enviada', 'fallida'));
+
+CREATE INDEX IF NOT EXISTS idx_notificacion_salida_enviando
+    ON NotificacionSalida(proximo_intento_at) WHERE estado = 'enviando';
@@ -0,0 +1,42 @@
+-- Script para agregar plantillas de horario recurrentes
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Agregar a Horario el intervalo concreto (inicio y fin) y la plantilla de origen
+ALTER TABLE Horario ADD COLUMN IF NOT EXISTS fecha_hora TIMESTAMP;
+ALTER TABLE Horario ADD COLUMN IF NOT EXISTS fecha_hora_fin TIMESTAMP;
+ALTER TABLE Horario ADD COLUMN IF NOT EXISTS id_plantilla INT;
+ALTER TABLE Horario ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE Horario ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+-- 2. Los horarios existentes con fecha se consideran de 30 minutos
+UPDATE Horario SET fecha_hora_fin = fecha_hora + INTERVAL '30 minutes'
+WHERE fecha_hora IS NOT NULL AND fecha_hora_fin IS NULL;
+
+-- 3. Crear la tabla de plantillas
+CREATE TABLE IF NOT EXISTS PlantillaHorario (
+    id_plantilla SERIAL PRIMARY KEY,
+    id_medico INT NOT NULL,
+    id_consultorio INT NOT NULL,
+    dias_semana VARCHAR(20) NOT NULL,          -- días separados por coma, 0 = domingo ... 6 = sábado
+    hora_inicio TIME NOT NULL,
+    hora_fin TIME NOT NULL,
+    duracion_minutos INT NOT NULL CHECK (duracion_minutos > 0),
+    turno VARCHAR(50),
+    vigente_desde DATE NOT NULL,
+    vigente_hasta DATE,
+    activo BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (hora_fin > hora_inicio),
+    CHECK (vigente_hasta IS NULL OR vigente_hasta >= vigente_desde),
+    FOREIGN KEY (id_medico) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_consultorio) REFERENCES Consultorio(id_consultorio)
+);
+
+-- 4. Relacionar los horarios generados con su plantilla
+ALTER TABLE Horario DROP CONSTRAINT IF EXISTS fk_horario_plantilla;
+ALTER TABLE Horario ADD CONSTRAINT fk_horario_plantilla
+    FOREIGN KEY (id_plantilla) REFERENCES PlantillaHorario(id_plantilla) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_horario_medico_fecha ON Horario(id_medico, fecha_hora);
+CREATE INDEX IF NOT EXISTS idx_horario_consultorio_fecha ON Horario(id_consultorio, fecha_hora);
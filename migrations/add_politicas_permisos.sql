@@ -0,0 +1,156 @@
+-- Script para expresar con permisos las reglas de acceso que los handlers decidían por nombre de rol
+-- Ejecutar este script en PostgreSQL después de add_gestion_roles.sql
+--
+-- Cada acción tiene dos variantes de alcance: <permiso>_own (sobre los registros propios: el
+-- médico de la consulta, el paciente de la receta, etc.) y <permiso>_any (sobre cualquiera). Las
+-- asignaciones reproducen el comportamiento que tenían los roles admin, medico, enfermera y paciente.
+
+-- 1. Permisos de alcance y de las nuevas acciones
+INSERT INTO Permiso (nombre, descripcion, recurso, accion)
+SELECT v.nombre, v.descripcion, v.recurso, v.accion
+FROM (VALUES
+    ('consultas_read_own', 'Ver las consultas en las que es médico o paciente', 'consultas', 'read_own'),
+    ('consultas_read_any', 'Ver cualquier consulta', 'consultas', 'read_any'),
+    ('consultas_create_own', 'Crear consultas como su médico', 'consultas', 'create_own'),
+    ('consultas_create_any', 'Crear consultas para cualquier médico', 'consultas', 'create_any'),
+    ('consultas_update_own', 'Actualizar y diagnosticar sus consultas', 'consultas', 'update_own'),
+    ('consultas_update_any', 'Actualizar y diagnosticar cualquier consulta', 'consultas', 'update_any'),
+    ('consultas_delete_any', 'Eliminar cualquier consulta', 'consultas', 'delete_any'),
+    ('consultas_confirmar_own', 'Confirmar sus consultas', 'consultas', 'confirmar_own'),
+    ('consultas_confirmar_any', 'Confirmar cualquier consulta', 'consultas', 'confirmar_any'),
+    ('consultas_atender_own', 'Iniciar y completar sus consultas como médico', 'consultas', 'atender_own'),
+    ('consultas_atender_any', 'Iniciar y completar cualquier consulta', 'consultas', 'atender_any'),
+    ('consultas_cancelar_own', 'Cancelar sus consultas', 'consultas', 'cancelar_own'),
+    ('consultas_cancelar_any', 'Cancelar cualquier consulta', 'consultas', 'cancelar_any'),
+    ('consultas_no_asistio_own', 'Registrar la inasistencia en sus consultas', 'consultas', 'no_asistio_own'),
+    ('consultas_no_asistio_any', 'Registrar la inasistencia en cualquier consulta', 'consultas', 'no_asistio_any'),
+    ('consultas_nota_own', 'Registrar la nota clínica de sus consultas', 'consultas', 'nota_own'),
+    ('consultas_nota_any', 'Registrar la nota clínica de cualquier consulta', 'consultas', 'nota_any'),
+    ('expedientes_create_any', 'Crear el expediente de cualquier paciente', 'expedientes', 'create_any'),
+    ('expedientes_read_own', 'Ver su expediente o el de sus pacientes', 'expedientes', 'read_own'),
+    ('expedientes_read_any', 'Ver cualquier expediente', 'expedientes', 'read_any'),
+    ('expedientes_update_own', 'Actualizar el expediente de sus pacientes', 'expedientes', 'update_own'),
+    ('expedientes_update_any', 'Actualizar cualquier expediente', 'expedientes', 'update_any'),
+    ('expedientes_delete_any', 'Eliminar cualquier expediente', 'expedientes', 'delete_any'),
+    ('recetas_create_any', 'Recetar a cualquier paciente', 'recetas', 'create_any'),
+    ('recetas_read_own', 'Ver las recetas en las que es médico o paciente', 'recetas', 'read_own'),
+    ('recetas_read_any', 'Ver cualquier receta', 'recetas', 'read_any'),
+    ('recetas_update_own', 'Actualizar las recetas que emitió', 'recetas', 'update_own'),
+    ('recetas_update_any', 'Actualizar cualquier receta', 'recetas', 'update_any'),
+    ('recetas_delete_own', 'Eliminar las recetas que emitió', 'recetas', 'delete_own'),
+    ('recetas_delete_any', 'Eliminar cualquier receta', 'recetas', 'delete_any'),
+    ('signos_vitales_read_own', 'Ver sus signos vitales o los de sus pacientes', 'signos_vitales', 'read_own'),
+    ('signos_vitales_read_any', 'Ver los signos vitales de cualquier paciente', 'signos_vitales', 'read_any'),
+    ('signos_vitales_create_any', 'Registrar signos vitales de cualquier paciente', 'signos_vitales', 'create_any'),
+    ('alergias_read_own', 'Ver sus alergias o las de sus pacientes', 'alergias', 'read_own'),
+    ('alergias_read_any', 'Ver las alergias de cualquier paciente', 'alergias', 'read_any'),
+    ('alergias_create_any', 'Registrar alergias de cualquier paciente', 'alergias', 'create_any'),
+    ('alergias_update_any', 'Actualizar alergias de cualquier paciente', 'alergias', 'update_any'),
+    ('alergias_verificar_any', 'Verificar alergias de cualquier paciente', 'alergias', 'verificar_any'),
+    ('lista_espera_create_own', 'Inscribirse en la lista de espera', 'lista_espera', 'create_own'),
+    ('lista_espera_read_own', 'Ver sus inscripciones o las de su agenda', 'lista_espera', 'read_own'),
+    ('lista_espera_read_any', 'Ver cualquier inscripción', 'lista_espera', 'read_any'),
+    ('lista_espera_update_own', 'Cancelar sus inscripciones', 'lista_espera', 'update_own'),
+    ('lista_espera_update_any', 'Cancelar cualquier inscripción', 'lista_espera', 'update_any'),
+    ('lista_espera_confirmar_own', 'Confirmar el horario que se le ofreció', 'lista_espera', 'confirmar_own'),
+    ('notificaciones_salida_read_any', 'Ver la bandeja de salida de notificaciones', 'notificaciones', 'salida_read_any'),
+    ('consultorios_create_any', 'Crear consultorios', 'consultorios', 'create_any'),
+    ('consultorios_update_any', 'Actualizar consultorios', 'consultorios', 'update_any'),
+    ('consultorios_delete_any', 'Eliminar consultorios', 'consultorios', 'delete_any'),
+    ('horarios_read_own', 'Ver sus horarios y plantillas', 'horarios', 'read_own'),
+    ('horarios_read_any', 'Ver todos los horarios y plantillas', 'horarios', 'read_any'),
+    ('horarios_create_any', 'Crear horarios y plantillas para cualquier médico', 'horarios', 'create_any'),
+    ('horarios_update_any', 'Actualizar cualquier horario', 'horarios', 'update_any'),
+    ('horarios_delete_any', 'Eliminar horarios y desactivar plantillas', 'horarios', 'delete_any'),
+    ('horarios_disponibilidad_own', 'Cambiar la disponibilidad de sus horarios', 'horarios', 'disponibilidad_own'),
+    ('horarios_disponibilidad_any', 'Cambiar la disponibilidad de cualquier horario', 'horarios', 'disponibilidad_any'),
+    ('reportes_read_own', 'Ver reportes de consultas y diagnósticos de sus consultas', 'reportes', 'read_own'),
+    ('reportes_read_any', 'Ver reportes de consultas y diagnósticos de todas las consultas', 'reportes', 'read_any'),
+    ('reportes_pacientes_own', 'Ver el reporte de sus pacientes', 'reportes', 'pacientes_own'),
+    ('reportes_pacientes_any', 'Ver el reporte de pacientes de todos los médicos', 'reportes', 'pacientes_any'),
+    ('reportes_expedientes_own', 'Ver el reporte de los expedientes que creó', 'reportes', 'expedientes_own'),
+    ('reportes_expedientes_any', 'Ver el reporte de todos los expedientes', 'reportes', 'expedientes_any'),
+    ('reportes_estadisticas_any', 'Ver las estadísticas generales', 'reportes', 'estadisticas_any'),
+    ('reportes_ingresos_any', 'Ver el reporte de ingresos', 'reportes', 'ingresos_any'),
+    ('reportes_usuarios_any', 'Ver el reporte de usuarios', 'reportes', 'usuarios_any'),
+    ('citas_create', 'Reservar citas en línea', 'citas', 'create'),
+    ('citas_read', 'Ver sus próximas citas', 'citas', 'read'),
+    ('citas_update', 'Reprogramar sus citas', 'citas', 'update'),
+    ('citas_delete', 'Cancelar sus citas', 'citas', 'delete'),
+    ('eliminados_read', 'Ver los registros eliminados', 'eliminados', 'read'),
+    ('eliminados_update', 'Restaurar registros eliminados', 'eliminados', 'update')
+) AS v(nombre, descripcion, recurso, accion)
+WHERE NOT EXISTS (SELECT 1 FROM Permiso p WHERE p.nombre = v.nombre);
+
+-- 2. Asignar los permisos a los roles existentes
+INSERT INTO RolPermiso (id_rol, id_permiso)
+SELECT r.id_rol, p.id_permiso
+FROM (VALUES
+    ('admin', 'consultas_read_any'), ('enfermera', 'consultas_read_any'),
+    ('medico', 'consultas_read_own'), ('paciente', 'consultas_read_own'),
+    ('admin', 'consultas_create_any'), ('medico', 'consultas_create_own'),
+    ('admin', 'consultas_update_any'), ('medico', 'consultas_update_own'),
+    ('admin', 'consultas_delete_any'),
+    ('admin', 'consultas_confirmar_any'), ('enfermera', 'consultas_confirmar_any'),
+    ('medico', 'consultas_confirmar_own'), ('paciente', 'consultas_confirmar_own'),
+    ('medico', 'consultas_atender_own'),
+    ('admin', 'consultas_cancelar_any'), ('enfermera', 'consultas_cancelar_any'),
+    ('medico', 'consultas_cancelar_own'), ('paciente', 'consultas_cancelar_own'),
+    ('admin', 'consultas_no_asistio_any'), ('enfermera', 'consultas_no_asistio_any'),
+    ('medico', 'consultas_no_asistio_own'),
+    ('medico', 'consultas_nota_own'),
+    ('admin', 'expedientes_create_any'), ('medico', 'expedientes_create_any'),
+    ('admin', 'expedientes_read_any'), ('medico', 'expedientes_read_own'), ('paciente', 'expedientes_read_own'),
+    ('admin', 'expedientes_update_any'), ('medico', 'expedientes_update_own'),
+    ('admin', 'expedientes_delete_any'),
+    ('medico', 'recetas_create_any'),
+    ('admin', 'recetas_read_any'), ('enfermera', 'recetas_read_any'),
+    ('medico', 'recetas_read_own'), ('paciente', 'recetas_read_own'),
+    ('medico', 'recetas_update_own'),
+    ('admin', 'recetas_delete_any'), ('medico', 'recetas_delete_own'),
+    ('admin', 'signos_vitales_read_any'), ('enfermera', 'signos_vitales_read_any'),
+    ('medico', 'signos_vitales_read_own'), ('paciente', 'signos_vitales_read_own'),
+    ('admin', 'signos_vitales_create_any'), ('enfermera', 'signos_vitales_create_any'),
+    ('medico', 'signos_vitales_create_any'),
+    ('admin', 'alergias_read_any'), ('enfermera', 'alergias_read_any'),
+    ('medico', 'alergias_read_own'), ('paciente', 'alergias_read_own'),
+    ('admin', 'alergias_create_any'), ('enfermera', 'alergias_create_any'), ('medico', 'alergias_create_any'),
+    ('admin', 'alergias_update_any'), ('enfermera', 'alergias_update_any'), ('medico', 'alergias_update_any'),
+    ('medico', 'alergias_verificar_any'),
+    ('paciente', 'lista_espera_create_own'),
+    ('admin', 'lista_espera_read_any'), ('enfermera', 'lista_espera_read_any'),
+    ('medico', 'lista_espera_read_own'), ('paciente', 'lista_espera_read_own'),
+    ('admin', 'lista_espera_update_any'), ('enfermera', 'lista_espera_update_any'),
+    ('paciente', 'lista_espera_update_own'),
+    ('paciente', 'lista_espera_confirmar_own'),
+    ('admin', 'notificaciones_salida_read_any'),
+    ('admin', 'consultorios_create_any'), ('admin', 'consultorios_update_any'), ('admin', 'consultorios_delete_any'),
+    ('admin', 'horarios_read_any'), ('enfermera', 'horarios_read_any'), ('medico', 'horarios_read_own'),
+    ('admin', 'horarios_create_any'), ('admin', 'horarios_update_any'), ('admin', 'horarios_delete_any'),
+    ('admin', 'horarios_disponibilidad_any'), ('medico', 'horarios_disponibilidad_own'),
+    ('medico', 'reportes_read_own'),
+    ('admin', 'reportes_pacientes_any'), ('medico', 'reportes_pacientes_own'),
+    ('admin', 'reportes_expedientes_any'), ('enfermera', 'reportes_expedientes_any'),
+    ('medico', 'reportes_expedientes_own'),
+    ('admin', 'reportes_estadisticas_any'), ('admin', 'reportes_ingresos_any'), ('admin', 'reportes_usuarios_any'),
+    ('paciente', 'citas_create'), ('paciente', 'citas_read'), ('paciente', 'citas_update'), ('paciente', 'citas_delete'),
+    ('admin', 'eliminados_read'), ('admin', 'eliminados_update')
+) AS v(rol, permiso)
+JOIN Rol r ON r.nombre = v.rol
+JOIN Permiso p ON p.nombre = v.permiso
+WHERE NOT EXISTS (
+    SELECT 1 FROM RolPermiso rp WHERE rp.id_rol = r.id_rol AND rp.id_permiso = p.id_permiso
+);
+
+-- 3. Los reportes de consultas solo se filtraban para los médicos: el resto de los roles con
+--    reportes_read los sigue viendo completos
+INSERT INTO RolPermiso (id_rol, id_permiso)
+SELECT rp.id_rol, p.id_permiso
+FROM RolPermiso rp
+JOIN Rol r ON rp.id_rol = r.id_rol
+JOIN Permiso base ON rp.id_permiso = base.id_permiso
+JOIN Permiso p ON p.nombre = 'reportes_read_any'
+WHERE base.nombre = 'reportes_read' AND r.nombre <> 'medico'
+AND NOT EXISTS (
+    SELECT 1 FROM RolPermiso x WHERE x.id_rol = rp.id_rol AND x.id_permiso = p.id_permiso
+);
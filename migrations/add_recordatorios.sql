@@ -0,0 +1,14 @@
+-- Script para registrar los recordatorios de citas enviados
+-- Ejecutar este script en PostgreSQL (requiere la migración add_notificaciones.sql)
+
+-- 1. Un recordatorio por consulta y offset (minutos antes de la cita); evita duplicados al reiniciar
+CREATE TABLE IF NOT EXISTS RecordatorioConsulta (
+    id_recordatorio SERIAL PRIMARY KEY,
+    id_consulta INT NOT NULL,
+    minutos_antes INT NOT NULL,
+    enviado_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    usado_at TIMESTAMP,                       -- el enlace del recordatorio es de un solo uso
+    accion_usada VARCHAR(20),
+    UNIQUE (id_consulta, minutos_antes),
+    FOREIGN KEY (id_consulta) REFERENCES Consulta(id_consulta) ON DELETE CASCADE
+);
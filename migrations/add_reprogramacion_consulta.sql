@@ -0,0 +1,21 @@
+-- Script para registrar las reprogramaciones de consultas
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Crear la tabla de reprogramaciones (quién, cuándo, de qué horario a cuál y por qué)
+CREATE TABLE IF NOT EXISTS ConsultaReprogramacion (
+    id_reprogramacion SERIAL PRIMARY KEY,
+    id_consulta INT NOT NULL,
+    id_horario_anterior INT,
+    id_horario_nuevo INT,
+    hora_anterior TIMESTAMP,
+    hora_nueva TIMESTAMP,
+    id_usuario INT,
+    motivo TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (id_consulta) REFERENCES Consulta(id_consulta) ON DELETE CASCADE,
+    FOREIGN KEY (id_horario_anterior) REFERENCES Horario(id_horario) ON DELETE SET NULL,
+    FOREIGN KEY (id_horario_nuevo) REFERENCES Horario(id_horario) ON DELETE SET NULL,
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario)
+);
+
+CREATE INDEX IF NOT EXISTS idx_consulta_reprogramacion_consulta ON ConsultaReprogramacion(id_consulta);
@@ -0,0 +1,14 @@
+-- Script para impedir que un horario tenga más de una consulta activa
+-- Ejecutar este script en PostgreSQL (requiere add_estado_consulta.sql)
+
+-- 1. Verificar que no existan horarios con más de una consulta activa antes de crear el índice
+SELECT id_horario, COUNT(*) AS consultas_activas
+FROM Consulta
+WHERE estado <> 'cancelada'
+GROUP BY id_horario
+HAVING COUNT(*) > 1;
+
+-- 2. Índice único parcial: un horario solo puede tener una consulta no cancelada
+CREATE UNIQUE INDEX IF NOT EXISTS idx_consulta_horario_activa
+    ON Consulta(id_horario)
+    WHERE estado <> 'cancelada';
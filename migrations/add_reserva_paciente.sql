@@ -0,0 +1,18 @@
+-- Script para la reserva de citas en línea por parte del paciente
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Agregar el motivo de la cita indicado por el paciente
+ALTER TABLE Consulta ADD COLUMN IF NOT EXISTS motivo TEXT;
+
+-- 2. Permitir a los pacientes consultar los horarios disponibles
+INSERT INTO RolPermiso (id_rol, id_permiso)
+SELECT r.id_rol, p.id_permiso
+FROM Rol r, Permiso p
+WHERE r.nombre = 'paciente'
+AND p.nombre = 'horarios_read'
+AND NOT EXISTS (
+    SELECT 1 FROM RolPermiso rp WHERE rp.id_rol = r.id_rol AND rp.id_permiso = p.id_permiso
+);
+
+-- 3. Índice para contar las citas activas de cada paciente
+CREATE INDEX IF NOT EXISTS idx_consulta_paciente_estado ON Consulta(id_paciente, estado);
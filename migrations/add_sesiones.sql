@@ -0,0 +1,33 @@
+-- Script para agregar las sesiones por dispositivo y la rotación de refresh tokens
+-- Ejecutar este script en PostgreSQL (requiere add_borrado_logico.sql)
+
+-- 1. Una sesión por inicio de sesión en un dispositivo
+CREATE TABLE IF NOT EXISTS Sesion (
+    id_sesion SERIAL PRIMARY KEY,
+    id_usuario INT NOT NULL,
+    dispositivo VARCHAR(100) NOT NULL,
+    user_agent TEXT,
+    ip VARCHAR(45),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    motivo_revocacion VARCHAR(30)
+        CHECK (motivo_revocacion IN ('cierre', 'revocada', 'reutilizacion', 'usuario_eliminado')),
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sesion_usuario_activa ON Sesion(id_usuario) WHERE revoked_at IS NULL;
+
+-- 2. Cada refresh token pertenece a una sesión, se guarda solo su hash SHA-256 y se marca al rotarlo
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS id_sesion INT REFERENCES Sesion(id_sesion) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash CHAR(64);
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+ALTER TABLE refresh_tokens ALTER COLUMN token DROP NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_hash ON refresh_tokens(token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_sesion ON refresh_tokens(id_sesion);
+
+-- 3. Los refresh tokens anteriores no tienen sesión: se revocan y se borra el token en claro.
+-- Los usuarios deben iniciar sesión de nuevo una vez.
+UPDATE refresh_tokens SET is_revoked = true, token = NULL WHERE token_hash IS NULL;
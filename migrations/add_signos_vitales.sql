@@ -0,0 +1,48 @@
+-- Script para agregar el registro de signos vitales
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Crear la tabla de signos vitales
+CREATE TABLE IF NOT EXISTS SignosVitales (
+    id_signos SERIAL PRIMARY KEY,
+    id_paciente INT NOT NULL,
+    id_consulta INT,                          -- opcional: toma realizada durante una consulta
+    id_usuario INT NOT NULL,                  -- quién registró la toma
+    fecha_medicion TIMESTAMP NOT NULL,
+    presion_sistolica NUMERIC(5,1) CHECK (presion_sistolica BETWEEN 50 AND 300),
+    presion_diastolica NUMERIC(5,1) CHECK (presion_diastolica BETWEEN 30 AND 200),
+    frecuencia_cardiaca NUMERIC(5,1) CHECK (frecuencia_cardiaca BETWEEN 20 AND 250),
+    temperatura NUMERIC(4,1) CHECK (temperatura BETWEEN 30 AND 45),
+    saturacion_oxigeno NUMERIC(4,1) CHECK (saturacion_oxigeno BETWEEN 50 AND 100),
+    peso NUMERIC(6,2) CHECK (peso BETWEEN 0.5 AND 500),              -- kg
+    talla NUMERIC(5,1) CHECK (talla BETWEEN 30 AND 250),             -- cm
+    imc NUMERIC(5,1),                                                -- calculado por la aplicación
+    anormal BOOLEAN NOT NULL DEFAULT FALSE,
+    alertas JSONB NOT NULL DEFAULT '[]',
+    notas TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (presion_sistolica IS NULL OR presion_diastolica IS NULL OR presion_sistolica > presion_diastolica),
+    FOREIGN KEY (id_paciente) REFERENCES Usuario(id_usuario),
+    FOREIGN KEY (id_consulta) REFERENCES Consulta(id_consulta),
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario)
+);
+
+CREATE INDEX IF NOT EXISTS idx_signos_vitales_paciente ON SignosVitales(id_paciente, fecha_medicion);
+
+-- 2. Permisos de signos vitales
+INSERT INTO Permiso (nombre, descripcion, recurso, accion)
+SELECT v.nombre, v.descripcion, 'signos_vitales', v.accion
+FROM (VALUES
+    ('signos_vitales_read', 'Ver signos vitales', 'read'),
+    ('signos_vitales_create', 'Registrar signos vitales', 'create')
+) AS v(nombre, descripcion, accion)
+WHERE NOT EXISTS (SELECT 1 FROM Permiso p WHERE p.nombre = v.nombre);
+
+-- 3. Enfermeras, médicos y admin registran y consultan; los pacientes consultan los suyos
+INSERT INTO RolPermiso (id_rol, id_permiso)
+SELECT r.id_rol, p.id_permiso
+FROM Rol r, Permiso p
+WHERE ((r.nombre IN ('admin', 'medico', 'enfermera') AND p.nombre IN ('signos_vitales_read', 'signos_vitales_create'))
+    OR (r.nombre = 'paciente' AND p.nombre = 'signos_vitales_read'))
+AND NOT EXISTS (
+    SELECT 1 FROM RolPermiso rp WHERE rp.id_rol = r.id_rol AND rp.id_permiso = p.id_permiso
+);
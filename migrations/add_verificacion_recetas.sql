@@ -0,0 +1,13 @@
+-- Script para agregar el código de verificación de las recetas impresas
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Agregar el código de verificación (12 caracteres, se imprime en grupos de 4)
+ALTER TABLE Receta ADD COLUMN IF NOT EXISTS codigo_verificacion VARCHAR(12);
+
+-- 2. Asignar un código a las recetas existentes
+UPDATE Receta
+SET codigo_verificacion = upper(substr(md5(random()::text || clock_timestamp()::text || id_receta::text), 1, 12))
+WHERE codigo_verificacion IS NULL;
+
+-- 3. El código identifica a la receta en la verificación pública
+CREATE UNIQUE INDEX IF NOT EXISTS idx_receta_codigo_verificacion ON Receta(codigo_verificacion);
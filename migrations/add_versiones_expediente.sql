@@ -0,0 +1,43 @@
+-- Script para agregar el historial de versiones del expediente
+-- Ejecutar este script en PostgreSQL
+
+-- 1. Crear la tabla de versiones (una fila por cada cambio del expediente)
+CREATE TABLE IF NOT EXISTS ExpedienteVersion (
+    id_version SERIAL PRIMARY KEY,
+    id_expediente INT NOT NULL,
+    numero INT NOT NULL,                      -- 1 es el contenido con el que se creó
+    antecedentes TEXT NOT NULL DEFAULT '',
+    historial_clinico TEXT NOT NULL DEFAULT '',
+    seguro TEXT NOT NULL DEFAULT '',
+    campos_modificados VARCHAR(100) NOT NULL DEFAULT '',  -- campos que cambiaron, separados por comas
+    id_usuario INT,                           -- quién hizo el cambio; NULL en las versiones importadas
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (id_expediente, numero),
+    FOREIGN KEY (id_expediente) REFERENCES Expediente(id_expediente) ON DELETE CASCADE,
+    FOREIGN KEY (id_usuario) REFERENCES Usuario(id_usuario)
+);
+
+-- 2. Versión inicial con el contenido actual de los expedientes existentes
+INSERT INTO ExpedienteVersion (id_expediente, numero, antecedentes, historial_clinico, seguro,
+                               campos_modificados, id_usuario, created_at)
+SELECT e.id_expediente, 1, COALESCE(e.antecedentes, ''), COALESCE(e.historial_clinico, ''),
+       COALESCE(e.seguro, ''),
+       CONCAT_WS(',',
+           CASE WHEN COALESCE(e.antecedentes, '') <> '' THEN 'antecedentes' END,
+           CASE WHEN COALESCE(e.historial_clinico, '') <> '' THEN 'historial_clinico' END,
+           CASE WHEN COALESCE(e.seguro, '') <> '' THEN 'seguro' END),
+       NULL, COALESCE(e.updated_at, e.created_at, CURRENT_TIMESTAMP)
+FROM Expediente e
+WHERE NOT EXISTS (SELECT 1 FROM ExpedienteVersion v WHERE v.id_expediente = e.id_expediente);
+
+-- 3. Las versiones no se modifican; solo se eliminan junto con su expediente
+CREATE OR REPLACE FUNCTION impedir_modificar_version_expediente() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'Las versiones del expediente no se pueden modificar';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_expediente_version_inmutable ON ExpedienteVersion;
+CREATE TRIGGER trg_expediente_version_inmutable
+    BEFORE UPDATE ON ExpedienteVersion
+    FOR EACH ROW EXECUTE FUNCTION impedir_modificar_version_expediente();
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+)
+
+// Severidades de una alergia
+const (
+	SeveridadLeve        = "leve"
+	SeveridadModerada    = "moderada"
+	SeveridadGrave       = "grave"
+	SeveridadDesconocida = "desconocida"
+)
+
+// Tipos de alergia
+const (
+	TipoAlergiaMedicamento = "medicamento"
+	TipoAlergiaAlimento    = "alimento"
+	TipoAlergiaAmbiental   = "ambiental"
+	TipoAlergiaOtro        = "otro"
+)
+
+// Alergia representa la tabla Alergia: una alergia registrada del paciente. Las alergias no se
+// eliminan; se desactivan cuando se descartan.
+type Alergia struct {
+	IDAlergia     int        `json:"id_alergia" db:"id_alergia"`
+	IDPaciente    int        `json:"id_paciente" db:"id_paciente"`
+	Sustancia     string     `json:"sustancia" db:"sustancia"`
+	Tipo          string     `json:"tipo" db:"tipo"`
+	Reaccion      *string    `json:"reaccion" db:"reaccion"`
+	Severidad     string     `json:"severidad" db:"severidad"`
+	Activa        bool       `json:"activa" db:"activa"`
+	VerificadaPor *int       `json:"verificada_por" db:"verificada_por"`
+	VerificadaAt  *time.Time `json:"verificada_at" db:"verificada_at"`
+	IDUsuario     int        `json:"id_usuario" db:"id_usuario"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// AlergiaRequest representa los datos para registrar o actualizar una alergia
+type AlergiaRequest struct {
+	Sustancia string `json:"sustancia"`
+	Tipo      string `json:"tipo"`
+	Reaccion  string `json:"reaccion"`
+	Severidad string `json:"severidad"`
+	Activa    *bool  `json:"activa"`
+}
+
+// RecetaAlergiaOmision representa la tabla RecetaAlergiaOmision: la decisión del médico de
+// recetar un medicamento que coincide con una alergia registrada, con su justificación
+type RecetaAlergiaOmision struct {
+	IDOmision int       `json:"id_omision" db:"id_omision"`
+	IDReceta  int       `json:"id_receta" db:"id_receta"`
+	IDAlergia int       `json:"id_alergia" db:"id_alergia"`
+	IDMedico  int       `json:"id_medico" db:"id_medico"`
+	Motivo    string    `json:"motivo" db:"motivo"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// OmisionAlergiasRequest acompaña a una receta cuyo medicamento coincide con una alergia
+type OmisionAlergiasRequest struct {
+	OmitirAlergias bool   `json:"omitir_alergias"`
+	MotivoOmision  string `json:"motivo_omision"`
+}
+
+// SeveridadAlergiaValida indica si la severidad es una de las reconocidas
+func SeveridadAlergiaValida(severidad string) bool {
+	switch severidad {
+	case SeveridadLeve, SeveridadModerada, SeveridadGrave, SeveridadDesconocida:
+		return true
+	}
+	return false
+}
+
+// TipoAlergiaValido indica si el tipo de alergia es uno de los reconocidos
+func TipoAlergiaValido(tipo string) bool {
+	switch tipo {
+	case TipoAlergiaMedicamento, TipoAlergiaAlimento, TipoAlergiaAmbiental, TipoAlergiaOtro:
+		return true
+	}
+	return false
+}
+
+// CoincideAlergia indica si el medicamento recetado menciona la sustancia de la alergia
+func CoincideAlergia(medicamento, sustancia string) bool {
+	return ContienePalabras(medicamento, sustancia)
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sinAcentos reemplaza las vocales acentuadas y la ñ para comparar textos en español
+var sinAcentos = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+)
+
+// NormalizarBusqueda convierte un texto a minúsculas y sin acentos, la forma en que se guardan
+// las columnas de búsqueda (descripciones del catálogo CIE-10, sustancias de las alergias)
+func NormalizarBusqueda(texto string) string {
+	return sinAcentos.Replace(strings.ToLower(strings.TrimSpace(texto)))
+}
+
+// palabrasBusqueda separa el texto normalizado en palabras de letras y números
+func palabrasBusqueda(texto string) []string {
+	return strings.FieldsFunc(NormalizarBusqueda(texto), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ContienePalabras indica si las palabras de frase aparecen seguidas dentro de texto, sin
+// distinguir mayúsculas ni acentos ("Amoxicilina 500 mg" contiene "amoxicilina", pero
+// "Dicloxacilina" no contiene "cilina")
+func ContienePalabras(texto, frase string) bool {
+	palabras := palabrasBusqueda(texto)
+	buscadas := palabrasBusqueda(frase)
+	if len(buscadas) == 0 {
+		return false
+	}
+	for i := 0; i+len(buscadas) <= len(palabras); i++ {
+		coincide := true
+		for j, buscada := range buscadas {
+			if palabras[i+j] != buscada {
+				coincide = false
+				break
+			}
+		}
+		if coincide {
+			return true
+		}
+	}
+	return false
+}
+
+// TerminoBusqueda deja solo las palabras normalizadas del texto separadas por un espacio
+// ("Ácido acetilsalicílico, 100 mg" queda "acido acetilsalicilico 100 mg"), la forma en que se
+// guardan los nombres de las interacciones para compararlos con LIKE
+func TerminoBusqueda(texto string) string {
+	return strings.Join(palabrasBusqueda(texto), " ")
+}
@@ -0,0 +1,26 @@
+package models
+
+import "testing"
+
+func TestContienePalabras(t *testing.T) {
+	casos := []struct {
+		texto, frase string
+		contiene     bool
+	}{
+		{"Amoxicilina 500 mg", "amoxicilina", true},
+		{"AMOXICILINA", "Amoxicilina", true},
+		{"Ácido acetilsalicílico 100 mg", "acido acetilsalicilico", true},
+		{"Dicloxacilina", "cilina", false},
+		{"Amoxicilina con ácido clavulánico", "amoxicilina clavulanico", false},
+		{"Amoxicilina/ácido clavulánico", "acido clavulanico", true},
+		{"Paracetamol", "paracetamol 500", false},
+		{"Paracetamol", "", false},
+		{"Paracetamol", "  -- ", false},
+		{"", "paracetamol", false},
+	}
+	for _, caso := range casos {
+		if got := ContienePalabras(caso.texto, caso.frase); got != caso.contiene {
+			t.Errorf("ContienePalabras(%q, %q) = %v, se esperaba %v", caso.texto, caso.frase, got, caso.contiene)
+		}
+	}
+}
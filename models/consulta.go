@@ -4,16 +4,100 @@ import (
 	"time"
 )
 
+// Estados del ciclo de vida de una consulta
+const (
+	EstadoProgramada = "programada"
+	EstadoConfirmada = "confirmada"
+	EstadoEnCurso    = "en_curso"
+	EstadoCompletada = "completada"
+	EstadoCancelada  = "cancelada"
+	EstadoNoAsistio  = "no_asistio"
+)
+
+// TransicionesConsulta define a qué estados puede pasar una consulta desde cada estado.
+// Los estados finales (completada, cancelada, no_asistio) no tienen transiciones. Una consulta
+// confirmada puede completarse sin pasar por en_curso, como antes de existir el ciclo de vida.
+var TransicionesConsulta = map[string][]string{
+	EstadoProgramada: {EstadoConfirmada, EstadoCancelada, EstadoNoAsistio},
+	EstadoConfirmada: {EstadoEnCurso, EstadoCompletada, EstadoCancelada, EstadoNoAsistio},
+	EstadoEnCurso:    {EstadoCompletada},
+}
+
+// EstadoConsultaValido indica si el estado pertenece al ciclo de vida de la consulta
+func EstadoConsultaValido(estado string) bool {
+	switch estado {
+	case EstadoProgramada, EstadoConfirmada, EstadoEnCurso,
+		EstadoCompletada, EstadoCancelada, EstadoNoAsistio:
+		return true
+	}
+	return false
+}
+
+// TransicionConsultaPermitida indica si una consulta puede pasar del estado actual al nuevo
+func TransicionConsultaPermitida(actual, nuevo string) bool {
+	for _, estado := range TransicionesConsulta[actual] {
+		if estado == nuevo {
+			return true
+		}
+	}
+	return false
+}
+
 // Consulta representa la tabla Consulta en la base de datos
 type Consulta struct {
-	ID         int       `json:"id_consulta" db:"id_consulta"`
-	Tipo       string    `json:"tipo" db:"tipo"`
-	Diagnostico string   `json:"diagnostico" db:"diagnostico"`
-	Costo      float64   `json:"costo" db:"costo"`
-	IDPaciente int       `json:"id_paciente" db:"id_paciente"`
-	IDMedico   int       `json:"id_medico" db:"id_medico"`
-	IDHorario  int       `json:"id_horario" db:"id_horario"`
-	Hora       time.Time `json:"hora" db:"hora"`
+	ID          int       `json:"id_consulta" db:"id_consulta"`
+	Tipo        string    `json:"tipo" db:"tipo"`
+	Diagnostico string    `json:"diagnostico" db:"diagnostico"`
+	Costo       float64   `json:"costo" db:"costo"`
+	IDPaciente  int       `json:"id_paciente" db:"id_paciente"`
+	IDMedico    int       `json:"id_medico" db:"id_medico"`
+	IDHorario   int       `json:"id_horario" db:"id_horario"`
+	Hora        time.Time `json:"hora" db:"hora"`
+	Estado      string    `json:"estado" db:"estado"`
+	Motivo      string    `json:"motivo" db:"motivo"`
+}
+
+// ConsultaEstadoHistorial representa la tabla ConsultaEstadoHistorial (una fila por transición)
+type ConsultaEstadoHistorial struct {
+	IDHistorial    int       `json:"id_historial" db:"id_historial"`
+	IDConsulta     int       `json:"id_consulta" db:"id_consulta"`
+	EstadoAnterior *string   `json:"estado_anterior" db:"estado_anterior"`
+	EstadoNuevo    string    `json:"estado_nuevo" db:"estado_nuevo"`
+	IDUsuario      *int      `json:"id_usuario" db:"id_usuario"`
+	Motivo         *string   `json:"motivo" db:"motivo"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// ConsultaReprogramacion representa la tabla ConsultaReprogramacion (un cambio de horario de la consulta)
+type ConsultaReprogramacion struct {
+	IDReprogramacion  int        `json:"id_reprogramacion" db:"id_reprogramacion"`
+	IDConsulta        int        `json:"id_consulta" db:"id_consulta"`
+	IDHorarioAnterior *int       `json:"id_horario_anterior" db:"id_horario_anterior"`
+	IDHorarioNuevo    *int       `json:"id_horario_nuevo" db:"id_horario_nuevo"`
+	HoraAnterior      *time.Time `json:"hora_anterior" db:"hora_anterior"`
+	HoraNueva         *time.Time `json:"hora_nueva" db:"hora_nueva"`
+	IDUsuario         *int       `json:"id_usuario" db:"id_usuario"`
+	Motivo            *string    `json:"motivo" db:"motivo"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ReprogramarConsultaRequest representa una solicitud para mover una consulta a otro horario
+type ReprogramarConsultaRequest struct {
+	IDHorario int    `json:"id_horario" validate:"required"`
+	Motivo    string `json:"motivo"`
+}
+
+// CambioEstadoRequest representa una solicitud para cambiar el estado de una consulta
+type CambioEstadoRequest struct {
+	Estado string `json:"estado" validate:"required"`
+	Motivo string `json:"motivo"`
+}
+
+// ReservaCitaRequest representa la solicitud de un paciente para reservar un horario disponible
+type ReservaCitaRequest struct {
+	IDHorario int    `json:"id_horario" validate:"required"`
+	Tipo      string `json:"tipo" validate:"max=50"`
+	Motivo    string `json:"motivo"`
 }
 
 // CitaRequest representa una solicitud para crear una cita
@@ -0,0 +1,31 @@
+package models
+
+import "testing"
+
+func TestTransicionConsultaPermitida(t *testing.T) {
+	casos := []struct {
+		actual, nuevo string
+		permitida     bool
+	}{
+		{EstadoProgramada, EstadoConfirmada, true},
+		{EstadoProgramada, EstadoCancelada, true},
+		{EstadoProgramada, EstadoNoAsistio, true},
+		{EstadoProgramada, EstadoEnCurso, false},
+		{EstadoProgramada, EstadoCompletada, false},
+		{EstadoConfirmada, EstadoEnCurso, true},
+		{EstadoConfirmada, EstadoCompletada, true},
+		{EstadoConfirmada, EstadoProgramada, false},
+		{EstadoEnCurso, EstadoCompletada, true},
+		{EstadoEnCurso, EstadoCancelada, false},
+		{EstadoCompletada, EstadoCancelada, false},
+		{EstadoCancelada, EstadoProgramada, false},
+		{EstadoNoAsistio, EstadoConfirmada, false},
+		{EstadoProgramada, EstadoProgramada, false},
+		{"desconocido", EstadoConfirmada, false},
+	}
+	for _, caso := range casos {
+		if got := TransicionConsultaPermitida(caso.actual, caso.nuevo); got != caso.permitida {
+			t.Errorf("TransicionConsultaPermitida(%q, %q) = %v, se esperaba %v", caso.actual, caso.nuevo, got, caso.permitida)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CodigoCIE10 representa la tabla CatalogoCIE10 (catálogo de diagnósticos CIE-10)
+type CodigoCIE10 struct {
+	Codigo      string `json:"codigo" db:"codigo"`
+	Descripcion string `json:"descripcion" db:"descripcion"`
+	Activo      bool   `json:"activo" db:"activo"`
+}
+
+// ConsultaDiagnostico representa la tabla ConsultaDiagnostico: un diagnóstico codificado de la consulta
+type ConsultaDiagnostico struct {
+	IDConsultaDiagnostico int       `json:"id_consulta_diagnostico" db:"id_consulta_diagnostico"`
+	IDConsulta            int       `json:"id_consulta" db:"id_consulta"`
+	Codigo                string    `json:"codigo" db:"codigo"`
+	Descripcion           string    `json:"descripcion" db:"descripcion"`
+	Principal             bool      `json:"principal" db:"principal"`
+	Notas                 *string   `json:"notas" db:"notas"`
+	IDUsuario             int       `json:"id_usuario" db:"id_usuario"`
+	CreatedAt             time.Time `json:"created_at" db:"created_at"`
+}
+
+// DiagnosticoRequest es un diagnóstico enviado para una consulta
+type DiagnosticoRequest struct {
+	Codigo    string `json:"codigo"`
+	Principal bool   `json:"principal"`
+	Notas     string `json:"notas"`
+}
+
+// DiagnosticosConsultaRequest reemplaza los diagnósticos de una consulta
+type DiagnosticosConsultaRequest struct {
+	Diagnosticos []DiagnosticoRequest `json:"diagnosticos"`
+}
+
+// formatoCodigoCIE10 acepta la categoría (A00) y la subcategoría opcional (A00.0, S72.001)
+var formatoCodigoCIE10 = regexp.MustCompile(`^[A-Z][0-9][0-9A-Z](\.[0-9A-Z]{1,4})?$`)
+
+// NormalizarCodigoCIE10 pasa el código a mayúsculas y agrega el punto después de la categoría
+// cuando viene sin él (A000 -> A00.0). Devuelve false si el código no tiene formato CIE-10.
+func NormalizarCodigoCIE10(codigo string) (string, bool) {
+	codigo = strings.ToUpper(strings.TrimSpace(codigo))
+	if len(codigo) > 3 && !strings.Contains(codigo, ".") {
+		codigo = codigo[:3] + "." + codigo[3:]
+	}
+	return codigo, formatoCodigoCIE10.MatchString(codigo)
+}
+
+// NormalizarBusquedaCIE10 convierte una descripción a la forma en que se guarda la columna de
+// búsqueda del catálogo
+func NormalizarBusquedaCIE10(texto string) string {
+	return NormalizarBusqueda(texto)
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+)
+
+// Estados de surtido de una receta
+const (
+	EstadoSurtidoDisponible = "disponible" // quedan surtidos y la receta está vigente
+	EstadoSurtidoSurtida    = "surtida"    // se usaron el surtido original y todos los resurtidos
+	EstadoSurtidoVencida    = "vencida"
+)
+
+// Dispensacion representa la tabla Dispensacion: un surtido de la receta en farmacia. Numero 1
+// es el surtido original y los siguientes son resurtidos.
+type Dispensacion struct {
+	IDDispensacion      int                `json:"id_dispensacion" db:"id_dispensacion"`
+	IDReceta            int                `json:"id_receta" db:"id_receta"`
+	IDFarmaceutico      int                `json:"id_farmaceutico" db:"id_farmaceutico"`
+	FarmaceuticoNombre  string             `json:"farmaceutico_nombre,omitempty"`
+	Numero              int                `json:"numero" db:"numero"`
+	ResurtidosRestantes int                `json:"resurtidos_restantes" db:"resurtidos_restantes"`
+	Observaciones       string             `json:"observaciones" db:"observaciones"`
+	Fecha               time.Time          `json:"fecha" db:"fecha"`
+	Items               []DispensacionItem `json:"items"`
+}
+
+// DispensacionItem representa la tabla DispensacionItem: la cantidad entregada de un medicamento
+type DispensacionItem struct {
+	IDItem      int    `json:"id_item" db:"id_item"`
+	Medicamento string `json:"medicamento" db:"medicamento"`
+	Cantidad    *int   `json:"cantidad" db:"cantidad"`
+}
+
+// DispensacionRequest es el cuerpo para surtir una receta. Sin items se entregan todos los
+// medicamentos con la cantidad recetada.
+type DispensacionRequest struct {
+	Items         []DispensacionItemRequest `json:"items"`
+	Observaciones string                    `json:"observaciones"`
+}
+
+// DispensacionItemRequest es la cantidad entregada de un renglón de la receta
+type DispensacionItemRequest struct {
+	IDItem   int  `json:"id_item"`
+	Cantidad *int `json:"cantidad"`
+}
+
+// SurtidoReceta resume los surtidos permitidos y realizados de una receta
+type SurtidoReceta struct {
+	Resurtidos        int       `json:"resurtidos"`
+	VigenteHasta      time.Time `json:"vigente_hasta"`
+	Surtidos          int       `json:"surtidos"`
+	SurtidosRestantes int       `json:"surtidos_restantes"`
+	Estado            string    `json:"estado"`
+}
+
+// SurtidoRecetaRequest acompaña a una receta al crearla o actualizarla: resurtidos permitidos
+// además del surtido original y días de vigencia desde su fecha
+type SurtidoRecetaRequest struct {
+	Resurtidos   *int `json:"resurtidos"`
+	VigenciaDias *int `json:"vigencia_dias"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// Tipos de registro con borrado lógico
+const (
+	EliminadoExpediente = "expediente"
+	EliminadoReceta     = "receta"
+	EliminadoConsulta   = "consulta"
+	EliminadoUsuario    = "usuario"
+)
+
+// EliminacionRequest representa el motivo obligatorio al eliminar un registro clínico
+type EliminacionRequest struct {
+	Motivo string `json:"motivo"`
+}
+
+// RegistroEliminado es un expediente, receta, consulta o usuario eliminado, con quién lo
+// eliminó, cuándo y por qué. Descripcion resume el registro para reconocerlo en el listado.
+type RegistroEliminado struct {
+	Tipo              string    `json:"tipo"`
+	ID                int       `json:"id"`
+	Descripcion       string    `json:"descripcion"`
+	DeletedAt         time.Time `json:"deleted_at" db:"deleted_at"`
+	DeletedBy         *int      `json:"deleted_by" db:"deleted_by"`
+	EliminadoPor      *string   `json:"eliminado_por"`
+	MotivoEliminacion string    `json:"motivo_eliminacion" db:"motivo_eliminacion"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+)
+
+// CamposExpedienteVersionados son los campos del expediente que se guardan en cada versión
+var CamposExpedienteVersionados = []string{"antecedentes", "historial_clinico", "seguro"}
+
+// ExpedienteVersion representa la tabla ExpedienteVersion: una copia inmutable del contenido
+// del expediente tras cada cambio, con quién lo hizo y qué campos modificó
+type ExpedienteVersion struct {
+	IDVersion         int       `json:"id_version" db:"id_version"`
+	IDExpediente      int       `json:"id_expediente" db:"id_expediente"`
+	Numero            int       `json:"numero" db:"numero"`
+	Antecedentes      string    `json:"antecedentes" db:"antecedentes"`
+	HistorialClinico  string    `json:"historial_clinico" db:"historial_clinico"`
+	Seguro            string    `json:"seguro" db:"seguro"`
+	CamposModificados []string  `json:"campos_modificados" db:"campos_modificados"`
+	IDUsuario         *int      `json:"id_usuario" db:"id_usuario"`
+	UsuarioNombre     *string   `json:"usuario_nombre,omitempty"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// Campos devuelve el valor de cada campo versionado
+func (v ExpedienteVersion) Campos() map[string]string {
+	return map[string]string{
+		"antecedentes":      v.Antecedentes,
+		"historial_clinico": v.HistorialClinico,
+		"seguro":            v.Seguro,
+	}
+}
+
+// CambioCampoExpediente es el valor de un campo en dos versiones del expediente
+type CambioCampoExpediente struct {
+	Campo      string `json:"campo"`
+	Anterior   string `json:"anterior"`
+	Nuevo      string `json:"nuevo"`
+	Modificado bool   `json:"modificado"`
+}
+
+// CompararCamposExpediente compara campo por campo dos versiones del expediente, en el orden
+// de CamposExpedienteVersionados
+func CompararCamposExpediente(anterior, nueva ExpedienteVersion) []CambioCampoExpediente {
+	antes, despues := anterior.Campos(), nueva.Campos()
+	cambios := make([]CambioCampoExpediente, 0, len(CamposExpedienteVersionados))
+	for _, campo := range CamposExpedienteVersionados {
+		cambios = append(cambios, CambioCampoExpediente{
+			Campo:      campo,
+			Anterior:   antes[campo],
+			Nuevo:      despues[campo],
+			Modificado: antes[campo] != despues[campo],
+		})
+	}
+	return cambios
+}
@@ -6,12 +6,48 @@ import (
 
 // Horario representa la tabla Horario en la base de datos
 type Horario struct {
-	IDHorario          int  `json:"id_horario" db:"id_horario"`
-	Turno              string `json:"turno" db:"turno" validate:"required,max=50"`
-	IDMedico           int  `json:"id_medico" db:"id_medico"`
-	IDConsultorio      int  `json:"id_consultorio" db:"id_consultorio"`
-	ConsultaDisponible bool `json:"consulta_disponible" db:"consulta_disponible"`
+	IDHorario          int       `json:"id_horario" db:"id_horario"`
+	Turno              string    `json:"turno" db:"turno" validate:"required,max=50"`
+	IDMedico           int       `json:"id_medico" db:"id_medico"`
+	IDConsultorio      int       `json:"id_consultorio" db:"id_consultorio"`
+	ConsultaDisponible bool      `json:"consulta_disponible" db:"consulta_disponible"`
 	FechaHora          time.Time `json:"fecha_hora" db:"fecha_hora"`
+	FechaHoraFin       time.Time `json:"fecha_hora_fin" db:"fecha_hora_fin"`
+	IDPlantilla        *int      `json:"id_plantilla,omitempty" db:"id_plantilla"`
 	CreatedAt          time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
-}
\ No newline at end of file
+}
+
+// PlantillaHorario representa la tabla PlantillaHorario: la agenda recurrente de un médico
+// en un consultorio a partir de la cual se generan horarios con fecha concreta
+type PlantillaHorario struct {
+	IDPlantilla     int       `json:"id_plantilla" db:"id_plantilla"`
+	IDMedico        int       `json:"id_medico" db:"id_medico"`
+	IDConsultorio   int       `json:"id_consultorio" db:"id_consultorio"`
+	DiasSemana      []int     `json:"dias_semana" db:"dias_semana"` // 0 = domingo ... 6 = sábado
+	HoraInicio      string    `json:"hora_inicio" db:"hora_inicio"` // HH:MM
+	HoraFin         string    `json:"hora_fin" db:"hora_fin"`       // HH:MM
+	DuracionMinutos int       `json:"duracion_minutos" db:"duracion_minutos"`
+	Turno           string    `json:"turno" db:"turno"`
+	VigenteDesde    string    `json:"vigente_desde" db:"vigente_desde"` // YYYY-MM-DD
+	VigenteHasta    string    `json:"vigente_hasta" db:"vigente_hasta"` // YYYY-MM-DD, vacío = sin fin
+	Activo          bool      `json:"activo" db:"activo"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// GenerarHorariosRequest representa el periodo para el cual se generan horarios desde una plantilla
+type GenerarHorariosRequest struct {
+	Desde string `json:"desde" validate:"required"` // YYYY-MM-DD
+	Hasta string `json:"hasta" validate:"required"` // YYYY-MM-DD
+}
+
+// ConflictoHorario describe un horario existente que se traslapa con otro intervalo
+type ConflictoHorario struct {
+	IDHorario     int       `json:"id_horario"`
+	IDMedico      int       `json:"id_medico"`
+	IDConsultorio int       `json:"id_consultorio"`
+	FechaHora     time.Time `json:"fecha_hora"`
+	FechaHoraFin  time.Time `json:"fecha_hora_fin"`
+	Tipo          string    `json:"tipo"` // "medico" o "consultorio"
+}
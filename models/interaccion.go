@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+)
+
+// Severidades de una interacción entre medicamentos
+const (
+	InteraccionLeve     = "leve"
+	InteraccionModerada = "moderada"
+	InteraccionGrave    = "grave"
+)
+
+// InteraccionMedicamento representa la tabla InteraccionMedicamento: una interacción entre dos
+// medicamentos del catálogo, que aplica a todas las presentaciones de sus nombres genéricos
+type InteraccionMedicamento struct {
+	IDInteraccion  int       `json:"id_interaccion" db:"id_interaccion"`
+	IDMedicamentoA *int      `json:"id_medicamento_a" db:"id_medicamento_a"`
+	IDMedicamentoB *int      `json:"id_medicamento_b" db:"id_medicamento_b"`
+	MedicamentoA   string    `json:"medicamento_a" db:"medicamento_a"`
+	MedicamentoB   string    `json:"medicamento_b" db:"medicamento_b"`
+	Severidad      string    `json:"severidad" db:"severidad"`
+	Descripcion    string    `json:"descripcion" db:"descripcion"`
+	Activa         bool      `json:"activa" db:"activa"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AlertaInteraccion es una interacción encontrada al recetar: entre dos medicamentos de la misma
+// receta (IDRecetaRelacionada nulo) o con uno de otra receta activa del paciente
+type AlertaInteraccion struct {
+	IDInteraccion       int    `json:"id_interaccion"`
+	Severidad           string `json:"severidad"`
+	Descripcion         string `json:"descripcion"`
+	Medicamento         string `json:"medicamento"`
+	ConMedicamento      string `json:"con_medicamento"`
+	IDRecetaRelacionada *int   `json:"id_receta_relacionada"`
+}
+
+// RecetaInteraccionOmision representa la tabla RecetaInteraccionOmision: la decisión del médico
+// de recetar a pesar de una interacción grave, con su justificación
+type RecetaInteraccionOmision struct {
+	IDOmision           int       `json:"id_omision" db:"id_omision"`
+	IDReceta            int       `json:"id_receta" db:"id_receta"`
+	IDInteraccion       int       `json:"id_interaccion" db:"id_interaccion"`
+	IDRecetaRelacionada *int      `json:"id_receta_relacionada" db:"id_receta_relacionada"`
+	Medicamento         string    `json:"medicamento" db:"medicamento"`
+	ConMedicamento      string    `json:"con_medicamento" db:"con_medicamento"`
+	IDMedico            int       `json:"id_medico" db:"id_medico"`
+	Motivo              string    `json:"motivo" db:"motivo"`
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+}
+
+// OmisionInteraccionesRequest acompaña a una receta con interacciones graves
+type OmisionInteraccionesRequest struct {
+	OmitirInteracciones bool   `json:"omitir_interacciones"`
+	MotivoInteracciones string `json:"motivo_interacciones"`
+}
+
+// SeveridadInteraccionValida indica si la severidad es una de las reconocidas
+func SeveridadInteraccionValida(severidad string) bool {
+	switch severidad {
+	case InteraccionLeve, InteraccionModerada, InteraccionGrave:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// Estados de una inscripción en la lista de espera
+const (
+	EstadoListaEsperaEnEspera   = "en_espera"
+	EstadoListaEsperaOfrecida   = "ofrecida"
+	EstadoListaEsperaConfirmada = "confirmada"
+	EstadoListaEsperaExpirada   = "expirada"
+	EstadoListaEsperaCancelada  = "cancelada"
+)
+
+// ListaEspera representa la tabla ListaEspera: un paciente esperando un horario con un médico.
+// Cuando se libera un horario que coincide, la inscripción pasa a "ofrecida" y el horario queda
+// retenido para el paciente hasta RetencionExpiraAt.
+type ListaEspera struct {
+	IDListaEspera     int        `json:"id_lista_espera" db:"id_lista_espera"`
+	IDPaciente        int        `json:"id_paciente" db:"id_paciente"`
+	IDMedico          int        `json:"id_medico" db:"id_medico"`
+	FechaDesde        string     `json:"fecha_desde" db:"fecha_desde"` // YYYY-MM-DD, vacío = sin límite
+	FechaHasta        string     `json:"fecha_hasta" db:"fecha_hasta"` // YYYY-MM-DD, vacío = sin límite
+	Motivo            string     `json:"motivo" db:"motivo"`
+	Estado            string     `json:"estado" db:"estado"`
+	IDHorario         *int       `json:"id_horario" db:"id_horario"` // horario retenido
+	RetencionExpiraAt *time.Time `json:"retencion_expira_at" db:"retencion_expira_at"`
+	IDConsulta        *int       `json:"id_consulta" db:"id_consulta"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// ListaEsperaRequest representa la solicitud de un paciente para inscribirse en la lista de espera
+type ListaEsperaRequest struct {
+	IDMedico   int    `json:"id_medico" validate:"required"`
+	FechaDesde string `json:"fecha_desde"` // YYYY-MM-DD
+	FechaHasta string `json:"fecha_hasta"` // YYYY-MM-DD
+	Motivo     string `json:"motivo"`
+}
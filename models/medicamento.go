@@ -0,0 +1,63 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Medicamento representa la tabla Medicamento (catálogo de medicamentos)
+type Medicamento struct {
+	IDMedicamento  int    `json:"id_medicamento" db:"id_medicamento"`
+	Clave          string `json:"clave" db:"clave"`
+	NombreGenerico string `json:"nombre_generico" db:"nombre_generico"`
+	Presentacion   string `json:"presentacion" db:"presentacion"`
+	Concentracion  string `json:"concentracion" db:"concentracion"`
+	Via            string `json:"via" db:"via"`
+	Activo         bool   `json:"activo" db:"activo"`
+}
+
+// Descripcion arma el texto con el que el medicamento aparece en la receta
+// ("Paracetamol 500 mg tableta")
+func (m Medicamento) Descripcion() string {
+	partes := []string{m.NombreGenerico}
+	for _, parte := range []string{m.Concentracion, m.Presentacion} {
+		if parte != "" {
+			partes = append(partes, parte)
+		}
+	}
+	return strings.Join(partes, " ")
+}
+
+// RecetaItem representa la tabla RecetaItem: un medicamento de la receta con su posología
+type RecetaItem struct {
+	IDItem        int       `json:"id_item" db:"id_item"`
+	IDReceta      int       `json:"id_receta" db:"id_receta"`
+	IDMedicamento *int      `json:"id_medicamento" db:"id_medicamento"`
+	Medicamento   string    `json:"medicamento" db:"medicamento"`
+	Dosis         string    `json:"dosis" db:"dosis"`
+	Frecuencia    string    `json:"frecuencia" db:"frecuencia"`
+	Duracion      string    `json:"duracion" db:"duracion"`
+	Cantidad      *int      `json:"cantidad" db:"cantidad"`
+	Via           string    `json:"via" db:"via"`
+	Indicaciones  string    `json:"indicaciones" db:"indicaciones"`
+	Orden         int       `json:"orden" db:"orden"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// RecetaItemRequest es un medicamento enviado al crear o actualizar una receta. Se indica
+// id_medicamento del catálogo o, para medicamentos fuera del catálogo, el texto en medicamento.
+type RecetaItemRequest struct {
+	IDMedicamento *int   `json:"id_medicamento"`
+	Medicamento   string `json:"medicamento"`
+	Dosis         string `json:"dosis"`
+	Frecuencia    string `json:"frecuencia"`
+	Duracion      string `json:"duracion"`
+	Cantidad      *int   `json:"cantidad"`
+	Via           string `json:"via"`
+	Indicaciones  string `json:"indicaciones"`
+}
+
+// RecetaItemsRequest contiene los medicamentos de una receta con varios renglones
+type RecetaItemsRequest struct {
+	Items []RecetaItemRequest `json:"items"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// Tipos de nota clínica: la nota original de la consulta y sus enmiendas
+const (
+	TipoNotaOriginal = "original"
+	TipoNotaEnmienda = "enmienda"
+)
+
+// NotaClinica representa la tabla NotaClinica: la nota de la consulta en formato SOAP
+// (subjetivo, objetivo, evaluación y plan). Las notas no se modifican; los cambios se
+// registran como enmiendas firmadas por el médico autor.
+type NotaClinica struct {
+	IDNota         int       `json:"id_nota" db:"id_nota"`
+	IDConsulta     int       `json:"id_consulta" db:"id_consulta"`
+	IDPaciente     int       `json:"id_paciente" db:"id_paciente"`
+	IDMedico       int       `json:"id_medico" db:"id_medico"`
+	IDNotaOriginal *int      `json:"id_nota_original" db:"id_nota_original"`
+	Tipo           string    `json:"tipo" db:"tipo"`
+	Subjetivo      string    `json:"subjetivo" db:"subjetivo"`
+	Objetivo       string    `json:"objetivo" db:"objetivo"`
+	Evaluacion     string    `json:"evaluacion" db:"evaluacion"`
+	Plan           string    `json:"plan" db:"plan"`
+	MotivoEnmienda *string   `json:"motivo_enmienda" db:"motivo_enmienda"`
+	Firma          string    `json:"firma" db:"firma"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// NotaClinicaRequest representa una nota SOAP o una enmienda. En las enmiendas solo se envían
+// las secciones que cambian y el motivo es obligatorio.
+type NotaClinicaRequest struct {
+	Subjetivo  string `json:"subjetivo"`
+	Objetivo   string `json:"objetivo"`
+	Evaluacion string `json:"evaluacion"`
+	Plan       string `json:"plan"`
+	Motivo     string `json:"motivo"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Notificacion representa la tabla Notificacion: la bandeja de entrada del usuario en la aplicación
+type Notificacion struct {
+	IDNotificacion int        `json:"id_notificacion" db:"id_notificacion"`
+	IDUsuario      int        `json:"id_usuario" db:"id_usuario"`
+	Tipo           string     `json:"tipo" db:"tipo"`
+	Asunto         string     `json:"asunto" db:"asunto"`
+	Cuerpo         string     `json:"cuerpo" db:"cuerpo"`
+	Leida          bool       `json:"leida" db:"leida"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	LeidaAt        *time.Time `json:"leida_at" db:"leida_at"`
+}
+
+// NotificacionSalida representa la tabla NotificacionSalida: un envío pendiente o realizado
+// por un canal (email, sms, inapp, archivo) con sus reintentos
+type NotificacionSalida struct {
+	IDNotificacionSalida int        `json:"id_notificacion_salida" db:"id_notificacion_salida"`
+	IDUsuario            int        `json:"id_usuario" db:"id_usuario"`
+	Canal                string     `json:"canal" db:"canal"`
+	Destino              string     `json:"destino" db:"destino"`
+	Tipo                 string     `json:"tipo" db:"tipo"`
+	Asunto               string     `json:"asunto" db:"asunto"`
+	Cuerpo               string     `json:"cuerpo" db:"cuerpo"`
+	Estado               string     `json:"estado" db:"estado"` // pendiente, enviada o fallida
+	Intentos             int        `json:"intentos" db:"intentos"`
+	MaxIntentos          int        `json:"max_intentos" db:"max_intentos"`
+	ProximoIntentoAt     time.Time  `json:"proximo_intento_at" db:"proximo_intento_at"`
+	UltimoError          *string    `json:"ultimo_error" db:"ultimo_error"`
+	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
+	EnviadaAt            *time.Time `json:"enviada_at" db:"enviada_at"`
+}
@@ -6,10 +6,21 @@ import (
 
 // ReporteConsultas representa un reporte de consultas
 type ReporteConsultas struct {
-	TotalConsultas     int     `json:"total_consultas"`
-	ConsultasHoy       int     `json:"consultas_hoy"`
-	ConsultasSemana    int     `json:"consultas_semana"`
-	IngresosTotales    float64 `json:"ingresos_totales"`
-	PromedioConsultas  float64 `json:"promedio_consultas"`
-	FechaGeneracion    time.Time `json:"fecha_generacion"`
-}
\ No newline at end of file
+	TotalConsultas     int            `json:"total_consultas"`
+	ConsultasHoy       int            `json:"consultas_hoy"`
+	ConsultasSemana    int            `json:"consultas_semana"`
+	IngresosTotales    float64        `json:"ingresos_totales"`
+	PromedioConsultas  float64        `json:"promedio_consultas"`
+	ConsultasPorEstado map[string]int `json:"consultas_por_estado"`
+	FechaGeneracion    time.Time      `json:"fecha_generacion"`
+}
+
+// ReporteDiagnostico representa las consultas con un código CIE-10 en un periodo. En los
+// totales por código Periodo va vacío y no se cuentan pacientes.
+type ReporteDiagnostico struct {
+	Periodo        string `json:"periodo,omitempty"`
+	Codigo         string `json:"codigo"`
+	Descripcion    string `json:"descripcion"`
+	TotalConsultas int    `json:"total_consultas"`
+	TotalPacientes int    `json:"total_pacientes,omitempty"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+)
+
+// PermisosProtegidos son los permisos que siempre debe conservar al menos un usuario activo;
+// sin ellos nadie podría volver a administrar usuarios ni roles desde la API
+var PermisosProtegidos = []string{"usuarios_update", "roles_update"}
+
+// Acciones registradas en la auditoría de roles y permisos
+const (
+	AuditoriaCrearRol       = "crear_rol"
+	AuditoriaDesactivarRol  = "desactivar_rol"
+	AuditoriaActivarRol     = "activar_rol"
+	AuditoriaCrearPermiso   = "crear_permiso"
+	AuditoriaOtorgarPermiso = "otorgar_permiso"
+	AuditoriaRevocarPermiso = "revocar_permiso"
+	AuditoriaAsignarRol     = "asignar_rol"
+)
+
+// Rol representa la tabla Rol con el número de usuarios que lo tienen asignado
+type Rol struct {
+	IDRol         int    `json:"id_rol" db:"id_rol"`
+	Nombre        string `json:"nombre" db:"nombre"`
+	Descripcion   string `json:"descripcion" db:"descripcion"`
+	Activo        bool   `json:"activo" db:"activo"`
+	TotalUsuarios int    `json:"total_usuarios"`
+}
+
+// Permiso representa la tabla Permiso
+type Permiso struct {
+	IDPermiso   int    `json:"id_permiso" db:"id_permiso"`
+	Nombre      string `json:"nombre" db:"nombre"`
+	Descripcion string `json:"descripcion" db:"descripcion"`
+	Recurso     string `json:"recurso" db:"recurso"`
+	Accion      string `json:"accion" db:"accion"`
+}
+
+// RolRequest representa la creación de un rol
+type RolRequest struct {
+	Nombre      string `json:"nombre"`
+	Descripcion string `json:"descripcion"`
+}
+
+// PermisoRequest representa la creación de un permiso. Si no se envía el nombre se forma
+// como recurso_accion, igual que los permisos existentes.
+type PermisoRequest struct {
+	Nombre      string `json:"nombre"`
+	Descripcion string `json:"descripcion"`
+	Recurso     string `json:"recurso"`
+	Accion      string `json:"accion"`
+}
+
+// OtorgarPermisoRequest indica el permiso que se agrega a un rol, por id o por nombre
+type OtorgarPermisoRequest struct {
+	IDPermiso int    `json:"id_permiso"`
+	Nombre    string `json:"nombre"`
+}
+
+// AsignarRolRequest indica el rol que se asigna a un usuario
+type AsignarRolRequest struct {
+	IDRol int `json:"id_rol"`
+}
+
+// AuditoriaRol representa la tabla AuditoriaRol: un cambio en roles, permisos o en el rol de
+// un usuario, con quién lo hizo y cuándo
+type AuditoriaRol struct {
+	IDAuditoria       int       `json:"id_auditoria" db:"id_auditoria"`
+	IDUsuario         int       `json:"id_usuario" db:"id_usuario"`
+	UsuarioNombre     string    `json:"usuario_nombre"`
+	Accion            string    `json:"accion" db:"accion"`
+	IDRol             *int      `json:"id_rol" db:"id_rol"`
+	RolNombre         *string   `json:"rol_nombre"`
+	IDPermiso         *int      `json:"id_permiso" db:"id_permiso"`
+	PermisoNombre     *string   `json:"permiso_nombre"`
+	IDUsuarioAfectado *int      `json:"id_usuario_afectado" db:"id_usuario_afectado"`
+	IDRolAnterior     *int      `json:"id_rol_anterior" db:"id_rol_anterior"`
+	Fecha             time.Time `json:"fecha" db:"fecha"`
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+)
+
+// SignosVitales representa la tabla SignosVitales: una toma de signos vitales del paciente,
+// opcionalmente ligada a una consulta. Las mediciones no registradas quedan en nil.
+type SignosVitales struct {
+	IDSignos           int            `json:"id_signos" db:"id_signos"`
+	IDPaciente         int            `json:"id_paciente" db:"id_paciente"`
+	IDConsulta         *int           `json:"id_consulta" db:"id_consulta"`
+	IDUsuario          int            `json:"id_usuario" db:"id_usuario"`
+	FechaMedicion      time.Time      `json:"fecha_medicion" db:"fecha_medicion"`
+	PresionSistolica   *float64       `json:"presion_sistolica" db:"presion_sistolica"`     // mmHg
+	PresionDiastolica  *float64       `json:"presion_diastolica" db:"presion_diastolica"`   // mmHg
+	FrecuenciaCardiaca *float64       `json:"frecuencia_cardiaca" db:"frecuencia_cardiaca"` // latidos por minuto
+	Temperatura        *float64       `json:"temperatura" db:"temperatura"`                 // °C
+	SaturacionOxigeno  *float64       `json:"saturacion_oxigeno" db:"saturacion_oxigeno"`   // % SpO2
+	Peso               *float64       `json:"peso" db:"peso"`                               // kg
+	Talla              *float64       `json:"talla" db:"talla"`                             // cm
+	IMC                *float64       `json:"imc" db:"imc"`                                 // calculado
+	Anormal            bool           `json:"anormal" db:"anormal"`
+	Alertas            []AlertaSignos `json:"alertas" db:"alertas"`
+	Notas              *string        `json:"notas" db:"notas"`
+	CreatedAt          time.Time      `json:"created_at" db:"created_at"`
+}
+
+// SignosVitalesRequest representa una toma de signos vitales enviada por el personal
+type SignosVitalesRequest struct {
+	IDPaciente         int       `json:"id_paciente"`
+	IDConsulta         *int      `json:"id_consulta"`
+	FechaMedicion      time.Time `json:"fecha_medicion"`
+	PresionSistolica   *float64  `json:"presion_sistolica"`
+	PresionDiastolica  *float64  `json:"presion_diastolica"`
+	FrecuenciaCardiaca *float64  `json:"frecuencia_cardiaca"`
+	Temperatura        *float64  `json:"temperatura"`
+	SaturacionOxigeno  *float64  `json:"saturacion_oxigeno"`
+	Peso               *float64  `json:"peso"`
+	Talla              *float64  `json:"talla"`
+	Notas              string    `json:"notas"`
+}
+
+// AlertaSignos señala una medición fuera del rango normal
+type AlertaSignos struct {
+	Campo  string  `json:"campo"`
+	Valor  float64 `json:"valor"`
+	Nivel  string  `json:"nivel"` // "bajo" o "alto"
+	Minimo float64 `json:"minimo"`
+	Maximo float64 `json:"maximo"`
+}
+
+// RangoSigno define los límites de una medición: fuera de [MinValido, MaxValido] el valor se
+// rechaza por improbable; fuera de [MinNormal, MaxNormal] se registra con una alerta. Las
+// mediciones sin rango normal (peso y talla) no generan alertas.
+type RangoSigno struct {
+	Campo     string  `json:"campo"`
+	Unidad    string  `json:"unidad"`
+	MinValido float64 `json:"min_valido"`
+	MaxValido float64 `json:"max_valido"`
+	MinNormal float64 `json:"min_normal,omitempty"`
+	MaxNormal float64 `json:"max_normal,omitempty"`
+}
+
+// RangosSignosVitales contiene los rangos de referencia para adultos, en el orden de presentación
+var RangosSignosVitales = []RangoSigno{
+	{Campo: "presion_sistolica", Unidad: "mmHg", MinValido: 50, MaxValido: 300, MinNormal: 90, MaxNormal: 139},
+	{Campo: "presion_diastolica", Unidad: "mmHg", MinValido: 30, MaxValido: 200, MinNormal: 60, MaxNormal: 89},
+	{Campo: "frecuencia_cardiaca", Unidad: "lpm", MinValido: 20, MaxValido: 250, MinNormal: 60, MaxNormal: 100},
+	{Campo: "temperatura", Unidad: "°C", MinValido: 30, MaxValido: 45, MinNormal: 36, MaxNormal: 37.5},
+	{Campo: "saturacion_oxigeno", Unidad: "%", MinValido: 50, MaxValido: 100, MinNormal: 95, MaxNormal: 100},
+	{Campo: "peso", Unidad: "kg", MinValido: 0.5, MaxValido: 500},
+	{Campo: "talla", Unidad: "cm", MinValido: 30, MaxValido: 250},
+	{Campo: "imc", Unidad: "kg/m²", MinValido: 5, MaxValido: 150, MinNormal: 18.5, MaxNormal: 24.9},
+}
+
+// Mediciones devuelve las mediciones de la toma por nombre de campo (el de RangosSignosVitales)
+func (s *SignosVitales) Mediciones() map[string]*float64 {
+	return map[string]*float64{
+		"presion_sistolica":   s.PresionSistolica,
+		"presion_diastolica":  s.PresionDiastolica,
+		"frecuencia_cardiaca": s.FrecuenciaCardiaca,
+		"temperatura":         s.Temperatura,
+		"saturacion_oxigeno":  s.SaturacionOxigeno,
+		"peso":                s.Peso,
+		"talla":               s.Talla,
+		"imc":                 s.IMC,
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Tipos de evento de la línea de tiempo del paciente
+const (
+	TimelineConsulta      = "consulta"
+	TimelineReceta        = "receta"
+	TimelineExpediente    = "expediente"
+	TimelineSignosVitales = "signos_vitales"
+)
+
+// TiposTimeline son los tipos de evento en el orden en que se documentan
+var TiposTimeline = []string{TimelineConsulta, TimelineReceta, TimelineExpediente, TimelineSignosVitales}
+
+// EventoTimeline es una actividad clínica del paciente: una consulta, una receta, un cambio del
+// expediente o una toma de signos vitales. ID es el id del registro en su propia tabla (para el
+// expediente, el id de la versión) y Detalle trae los campos propios de cada tipo.
+type EventoTimeline struct {
+	Tipo          string          `json:"tipo"`
+	ID            int             `json:"id"`
+	Fecha         *time.Time      `json:"fecha"`
+	Resumen       string          `json:"resumen"`
+	IDUsuario     *int            `json:"id_usuario"`
+	UsuarioNombre *string         `json:"usuario_nombre"`
+	Detalle       json.RawMessage `json:"detalle"`
+}
@@ -12,6 +12,7 @@ type Usuario struct {
 	Email           string    `json:"email" db:"email"`
 	Password        string    `json:"password,omitempty" db:"password"`
 	FechaNacimiento string    `json:"fecha_nacimiento" db:"fecha_nacimiento"`
+	Telefono        string    `json:"telefono" db:"telefono"`
 	IDRol           int       `json:"id_rol" db:"id_rol"`
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
@@ -38,14 +39,16 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
-// RefreshToken representa un token de actualización
+// RefreshToken representa un token de actualización de una sesión; solo se guarda su hash
 type RefreshToken struct {
-	ID        int       `json:"id" db:"id"`
-	UserID    int       `json:"user_id" db:"user_id"`
-	Token     string    `json:"token" db:"token"`
-	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	IsRevoked bool      `json:"is_revoked" db:"is_revoked"`
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	IDSesion  int        `json:"id_sesion" db:"id_sesion"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+	IsRevoked bool       `json:"is_revoked" db:"is_revoked"`
 }
 
 // LoginResponse representa la respuesta del login con tokens
@@ -87,6 +90,8 @@ type LoginMFARequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
 	MFACode  string `json:"mfa_code,omitempty"` // Opcional en el primer paso
+	// Nombre del dispositivo para la lista de sesiones; si no se envía se usa el User-Agent
+	Dispositivo string `json:"dispositivo,omitempty"`
 }
 
 // LoginMFAResponse representa la respuesta del login con MFA obligatorio
@@ -98,5 +103,27 @@ type LoginMFAResponse struct {
 	AccessToken  string          `json:"access_token,omitempty"`
 	RefreshToken string          `json:"refresh_token,omitempty"`
 	ExpiresIn    int             `json:"expires_in,omitempty"`
+	IDSesion     int             `json:"id_sesion,omitempty"`
 	Usuario      UsuarioResponse `json:"usuario,omitempty"`
 }
+
+// Motivos por los que se cierra una sesión
+const (
+	SesionCerrada          = "cierre"
+	SesionRevocada         = "revocada"
+	SesionReutilizada      = "reutilizacion"
+	SesionUsuarioEliminado = "usuario_eliminado"
+)
+
+// Sesion representa el inicio de sesión de un usuario en un dispositivo. Sus refresh tokens
+// rotan en cada uso; Actual indica la sesión del access token con que se consulta.
+type Sesion struct {
+	IDSesion    int       `json:"id_sesion" db:"id_sesion"`
+	Dispositivo string    `json:"dispositivo" db:"dispositivo"`
+	UserAgent   *string   `json:"user_agent,omitempty" db:"user_agent"`
+	IP          *string   `json:"ip,omitempty" db:"ip"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	Actual      bool      `json:"actual"`
+}
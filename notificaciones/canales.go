@@ -0,0 +1,255 @@
+package notificaciones
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lizet96/hospital-backend/database"
+)
+
+// Nombres de los canales disponibles
+const (
+	CanalEmail   = "email"
+	CanalSMS     = "sms"
+	CanalInApp   = "inapp"
+	CanalArchivo = "archivo"
+)
+
+// Mensaje es una notificación ya renderizada lista para enviarse por un canal
+type Mensaje struct {
+	IDNotificacion int
+	IDUsuario      int
+	Destino        string // correo, teléfono o id de usuario según el canal
+	Tipo           string
+	Asunto         string
+	Cuerpo         string
+}
+
+// Canal entrega mensajes por un medio concreto. Un error hace que el envío se reintente.
+type Canal interface {
+	Enviar(ctx context.Context, m Mensaje) error
+}
+
+// nuevoCanal crea el canal indicado a partir de sus variables de entorno
+func nuevoCanal(nombre string) (Canal, error) {
+	switch nombre {
+	case CanalEmail:
+		canal := &CanalCorreo{
+			Host:      os.Getenv("SMTP_HOST"),
+			Puerto:    os.Getenv("SMTP_PORT"),
+			Usuario:   os.Getenv("SMTP_USUARIO"),
+			Password:  os.Getenv("SMTP_PASSWORD"),
+			Remitente: os.Getenv("SMTP_REMITENTE"),
+		}
+		if canal.Host == "" || canal.Remitente == "" {
+			return nil, fmt.Errorf("SMTP_HOST y SMTP_REMITENTE son requeridos")
+		}
+		if canal.Puerto == "" {
+			canal.Puerto = "587"
+		}
+		return canal, nil
+	case CanalSMS:
+		canal := &CanalPasarelaSMS{
+			URL:     os.Getenv("SMS_GATEWAY_URL"),
+			Token:   os.Getenv("SMS_GATEWAY_TOKEN"),
+			Cliente: &http.Client{Timeout: 10 * time.Second},
+		}
+		if canal.URL == "" {
+			return nil, fmt.Errorf("SMS_GATEWAY_URL es requerido")
+		}
+		return canal, nil
+	case CanalInApp:
+		return &CanalBandeja{}, nil
+	case CanalArchivo:
+		return &CanalRegistro{Ruta: os.Getenv("NOTIFICACIONES_ARCHIVO")}, nil
+	}
+	return nil, fmt.Errorf("canal desconocido")
+}
+
+// CanalCorreo envía la notificación por correo electrónico usando SMTP
+type CanalCorreo struct {
+	Host      string
+	Puerto    string
+	Usuario   string
+	Password  string
+	Remitente string
+}
+
+// Enviar implementa Canal
+func (c *CanalCorreo) Enviar(ctx context.Context, m Mensaje) error {
+	var auth smtp.Auth
+	if c.Usuario != "" {
+		auth = smtp.PlainAuth("", c.Usuario, c.Password, c.Host)
+	}
+
+	var cuerpo strings.Builder
+	cuerpo.WriteString("From: " + c.Remitente + "\r\n")
+	cuerpo.WriteString("To: " + m.Destino + "\r\n")
+	cuerpo.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Asunto) + "\r\n")
+	cuerpo.WriteString("MIME-Version: 1.0\r\n")
+	cuerpo.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	cuerpo.WriteString("\r\n")
+	cuerpo.WriteString(m.Cuerpo)
+
+	return c.enviarSMTP(ctx, auth, m.Destino, []byte(cuerpo.String()))
+}
+
+// enviarSMTP hace lo mismo que smtp.SendMail, pero la conexión y toda la conversación con el
+// servidor terminan a más tardar en el plazo del contexto (o en tiempoMaximoEnvio si no tiene)
+func (c *CanalCorreo) enviarSMTP(ctx context.Context, auth smtp.Auth, destino string, mensaje []byte) error {
+	limite, ok := ctx.Deadline()
+	if !ok {
+		limite = time.Now().Add(tiempoMaximoEnvio)
+	}
+
+	dialer := net.Dialer{Timeout: time.Until(limite)}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.Host, c.Puerto))
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(limite); err != nil {
+		conn.Close()
+		return err
+	}
+
+	cliente, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer cliente.Close()
+
+	if ok, _ := cliente.Extension("STARTTLS"); ok {
+		if err := cliente.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := cliente.Extension("AUTH"); !ok {
+			return fmt.Errorf("el servidor SMTP no admite AUTH")
+		}
+		if err := cliente.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := cliente.Mail(c.Remitente); err != nil {
+		return err
+	}
+	if err := cliente.Rcpt(destino); err != nil {
+		return err
+	}
+	w, err := cliente.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mensaje); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return cliente.Quit()
+}
+
+// CanalPasarelaSMS envía la notificación como SMS mediante una pasarela HTTP que recibe
+// {"to": "...", "message": "..."} en JSON
+type CanalPasarelaSMS struct {
+	URL     string
+	Token   string
+	Cliente *http.Client
+}
+
+// Enviar implementa Canal
+func (c *CanalPasarelaSMS) Enviar(ctx context.Context, m Mensaje) error {
+	carga, err := json.Marshal(map[string]string{
+		"to":      m.Destino,
+		"message": m.Asunto + ": " + m.Cuerpo,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(carga))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.Cliente.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("la pasarela SMS respondió %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CanalBandeja guarda la notificación en la bandeja de entrada del usuario dentro de la aplicación
+type CanalBandeja struct{}
+
+// Enviar implementa Canal
+func (c *CanalBandeja) Enviar(ctx context.Context, m Mensaje) error {
+	_, err := database.GetDB().Exec(ctx,
+		`INSERT INTO Notificacion (id_usuario, tipo, asunto, cuerpo, id_notificacion_salida)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (id_notificacion_salida) DO NOTHING`,
+		m.IDUsuario, m.Tipo, m.Asunto, m.Cuerpo, m.IDNotificacion)
+	return err
+}
+
+// CanalRegistro escribe la notificación en un archivo (una línea JSON por mensaje) o, si no
+// se configura ruta, en el log del servidor. Sirve para probar sin proveedores reales.
+type CanalRegistro struct {
+	Ruta string
+	mu   sync.Mutex
+}
+
+// Enviar implementa Canal
+func (c *CanalRegistro) Enviar(ctx context.Context, m Mensaje) error {
+	if c.Ruta == "" {
+		log.Printf("Notificación %d (%s) para %s: %s - %s", m.IDNotificacion, m.Tipo, m.Destino, m.Asunto, m.Cuerpo)
+		return nil
+	}
+
+	linea, err := json.Marshal(map[string]interface{}{
+		"id_notificacion": m.IDNotificacion,
+		"id_usuario":      m.IDUsuario,
+		"tipo":            m.Tipo,
+		"asunto":          m.Asunto,
+		"cuerpo":          m.Cuerpo,
+		"fecha":           time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	archivo, err := os.OpenFile(c.Ruta, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer archivo.Close()
+
+	_, err = archivo.Write(append(linea, '\n'))
+	return err
+}
@@ -0,0 +1,183 @@
+package notificaciones
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/lizet96/hospital-backend/database"
+)
+
+// Parámetros del despachador de la bandeja de salida
+const (
+	intervaloDespacho   = 15 * time.Second
+	loteDespacho        = 50
+	esperaBaseReintento = time.Minute
+	// maxExponenteReintento limita la espera entre reintentos (2^10 minutos)
+	maxExponenteReintento = 10
+	// reservaDespacho es cuánto tiempo un lote queda reclamado; si el proceso se detiene antes de
+	// registrar los envíos, los mensajes vuelven a intentarse al vencer
+	reservaDespacho = 10 * time.Minute
+	// tiempoMaximoEnvio limita cada envío a un proveedor externo
+	tiempoMaximoEnvio = 30 * time.Second
+)
+
+// pendienteDespacho es un mensaje reclamado de la bandeja de salida
+type pendienteDespacho struct {
+	Mensaje
+	canal       string
+	intentos    int
+	maxIntentos int
+}
+
+// ProcesarPendientes envía los mensajes pendientes cuyo próximo intento ya venció. Los envíos
+// fallidos se reintentan con espera exponencial hasta agotar max_intentos, y entonces quedan
+// como "fallida". Devuelve cuántos mensajes se enviaron.
+//
+// Los mensajes se reclaman primero (estado "enviando") en una transacción corta y se envían
+// fuera de ella, de modo que un proveedor lento no retiene bloqueos ni conexiones y un error al
+// confirmar no provoca reenvíos. El resultado de cada envío se registra por separado.
+func ProcesarPendientes(ctx context.Context) (int, error) {
+	configurar()
+
+	inicio := time.Now()
+	pendientes, err := reclamarPendientes(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	enviados := 0
+	for i, p := range pendientes {
+		// No empezar un envío que podría terminar después de que venza la reserva del lote
+		if time.Since(inicio) > reservaDespacho-tiempoMaximoEnvio {
+			return enviados, liberarPendientes(ctx, pendientes[i:])
+		}
+
+		canal, ok := canalesActivos[p.canal]
+		var errEnvio error
+		if !ok {
+			errEnvio = errCanalNoConfigurado(p.canal)
+		} else {
+			ctxEnvio, cancelar := context.WithTimeout(ctx, tiempoMaximoEnvio)
+			errEnvio = canal.Enviar(ctxEnvio, p.Mensaje)
+			cancelar()
+		}
+
+		if err := registrarEnvio(ctx, p, errEnvio); err != nil {
+			// El mensaje sigue reclamado y se reintentará al vencer la reserva
+			log.Printf("Error al registrar el envío de la notificación %d: %v", p.IDNotificacion, err)
+			continue
+		}
+		if errEnvio == nil {
+			enviados++
+		}
+	}
+	return enviados, nil
+}
+
+// reclamarPendientes marca como "enviando" un lote de mensajes listos para enviarse. También
+// recupera los que quedaron reclamados por un proceso que se detuvo antes de registrarlos.
+func reclamarPendientes(ctx context.Context) ([]pendienteDespacho, error) {
+	tx, err := database.GetDB().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`UPDATE NotificacionSalida SET estado = 'enviando',
+		 proximo_intento_at = CURRENT_TIMESTAMP + make_interval(secs => $1)
+		 WHERE id_notificacion_salida IN (
+		     SELECT id_notificacion_salida FROM NotificacionSalida
+		     WHERE estado IN ('pendiente', 'enviando') AND proximo_intento_at <= CURRENT_TIMESTAMP
+		     ORDER BY id_notificacion_salida
+		     LIMIT $2
+		     FOR UPDATE SKIP LOCKED)
+		 RETURNING id_notificacion_salida, id_usuario, canal, destino, tipo, asunto, cuerpo, intentos, max_intentos`,
+		int(reservaDespacho.Seconds()), loteDespacho)
+	if err != nil {
+		return nil, err
+	}
+
+	var pendientes []pendienteDespacho
+	for rows.Next() {
+		var p pendienteDespacho
+		if err := rows.Scan(&p.IDNotificacion, &p.IDUsuario, &p.canal, &p.Destino, &p.Tipo,
+			&p.Asunto, &p.Cuerpo, &p.intentos, &p.maxIntentos); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pendientes = append(pendientes, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	sort.Slice(pendientes, func(i, j int) bool { return pendientes[i].IDNotificacion < pendientes[j].IDNotificacion })
+	return pendientes, nil
+}
+
+// registrarEnvio guarda el resultado del envío de un mensaje reclamado: enviado, o pendiente de
+// reintento con espera exponencial, o fallido si se agotaron los intentos
+func registrarEnvio(ctx context.Context, p pendienteDespacho, errEnvio error) error {
+	if errEnvio == nil {
+		_, err := database.GetDB().Exec(ctx,
+			`UPDATE NotificacionSalida SET estado = 'enviada', intentos = intentos + 1,
+			 ultimo_error = NULL, enviada_at = CURRENT_TIMESTAMP
+			 WHERE id_notificacion_salida = $1 AND estado = 'enviando'`, p.IDNotificacion)
+		return err
+	}
+
+	intentos := p.intentos + 1
+	estado := "pendiente"
+	if intentos >= p.maxIntentos {
+		estado = "fallida"
+	}
+	espera := esperaBaseReintento * time.Duration(1<<uint(min(intentos-1, maxExponenteReintento)))
+	_, err := database.GetDB().Exec(ctx,
+		`UPDATE NotificacionSalida SET estado = $1, intentos = $2, ultimo_error = $3,
+		 proximo_intento_at = CURRENT_TIMESTAMP + make_interval(secs => $4)
+		 WHERE id_notificacion_salida = $5 AND estado = 'enviando'`,
+		estado, intentos, errEnvio.Error(), int(espera.Seconds()), p.IDNotificacion)
+	return err
+}
+
+// liberarPendientes devuelve a la cola, sin contar un intento, los mensajes reclamados que no
+// alcanzaron a enviarse
+func liberarPendientes(ctx context.Context, pendientes []pendienteDespacho) error {
+	ids := make([]int, len(pendientes))
+	for i, p := range pendientes {
+		ids[i] = p.IDNotificacion
+	}
+	_, err := database.GetDB().Exec(ctx,
+		`UPDATE NotificacionSalida SET estado = 'pendiente', proximo_intento_at = CURRENT_TIMESTAMP
+		 WHERE id_notificacion_salida = ANY($1) AND estado = 'enviando'`, ids)
+	return err
+}
+
+// IniciarDespachador procesa periódicamente la bandeja de salida de notificaciones
+func IniciarDespachador() {
+	configurar()
+	log.Printf("Notificaciones: canales habilitados %v", nombresCanales)
+
+	ticker := time.NewTicker(intervaloDespacho)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := ProcesarPendientes(context.Background()); err != nil {
+			log.Printf("Error al despachar notificaciones: %v", err)
+		}
+	}
+}
+
+// errCanalNoConfigurado se registra cuando un mensaje pertenece a un canal que ya no está habilitado
+type errCanalNoConfigurado string
+
+func (e errCanalNoConfigurado) Error() string {
+	return "canal no configurado: " + string(e)
+}
@@ -0,0 +1,247 @@
+package notificaciones
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Tipos de notificación (cada uno tiene su plantilla)
+const (
+	ConsultaCreada       = "consulta_creada"
+	ConsultaCancelada    = "consulta_cancelada"
+	ConsultaReprogramada = "consulta_reprogramada"
+	RecetaEmitida        = "receta_emitida"
+	ConsultaRecordatorio = "consulta_recordatorio"
+	ListaEsperaOferta    = "lista_espera_oferta"
+)
+
+// formatoFecha es el formato con el que se muestran las fechas en los mensajes
+const formatoFecha = "02/01/2006 15:04"
+
+// Ejecutor es lo que se necesita para encolar: lo cumplen tanto el pool como una transacción,
+// de modo que la notificación se guarde en la misma transacción que el cambio que la origina
+type Ejecutor interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// Evento describe algo que debe notificarse a uno o varios usuarios
+type Evento struct {
+	Tipo          string
+	Destinatarios []int
+	Datos         map[string]interface{}
+}
+
+var (
+	configuracion     sync.Once
+	canalesActivos    map[string]Canal
+	nombresCanales    []string
+	maxIntentosEnvios int
+)
+
+// configurar crea los canales habilitados en NOTIFICACIONES_CANALES (por defecto "inapp,archivo")
+func configurar() {
+	configuracion.Do(func() {
+		lista := os.Getenv("NOTIFICACIONES_CANALES")
+		if lista == "" {
+			lista = CanalInApp + "," + CanalArchivo
+		}
+
+		canalesActivos = map[string]Canal{}
+		for _, nombre := range strings.Split(lista, ",") {
+			nombre = strings.TrimSpace(nombre)
+			if nombre == "" {
+				continue
+			}
+			canal, err := nuevoCanal(nombre)
+			if err != nil {
+				log.Printf("Notificaciones: canal '%s' deshabilitado: %v", nombre, err)
+				continue
+			}
+			canalesActivos[nombre] = canal
+			nombresCanales = append(nombresCanales, nombre)
+		}
+
+		maxIntentosEnvios = enteroDeEntorno("NOTIFICACIONES_MAX_INTENTOS", 5)
+	})
+}
+
+// Encolar renderiza la plantilla del evento para cada destinatario y guarda un envío pendiente
+// por cada canal habilitado en la bandeja de salida. Los canales que requieren un dato del
+// usuario que no existe (correo o teléfono) se omiten.
+func Encolar(ctx context.Context, db Ejecutor, evento Evento) error {
+	configurar()
+
+	plantilla, ok := plantillas[evento.Tipo]
+	if !ok {
+		return fmt.Errorf("tipo de notificación desconocido: %s", evento.Tipo)
+	}
+
+	vistos := map[int]bool{}
+	for _, idUsuario := range evento.Destinatarios {
+		if idUsuario == 0 || vistos[idUsuario] {
+			continue
+		}
+		vistos[idUsuario] = true
+
+		var nombre, email, telefono string
+		err := db.QueryRow(ctx,
+			`SELECT nombre, COALESCE(email, ''), COALESCE(telefono, '') FROM Usuario WHERE id_usuario = $1`,
+			idUsuario).Scan(&nombre, &email, &telefono)
+		if err != nil {
+			return fmt.Errorf("destinatario %d: %w", idUsuario, err)
+		}
+
+		datos := map[string]interface{}{"Nombre": nombre}
+		for clave, valor := range evento.Datos {
+			datos[clave] = valor
+		}
+		asunto, cuerpo, err := plantilla.renderizar(datos)
+		if err != nil {
+			return err
+		}
+
+		for _, canal := range nombresCanales {
+			destino := ""
+			switch canal {
+			case CanalEmail:
+				destino = email
+			case CanalSMS:
+				destino = telefono
+			default:
+				destino = fmt.Sprint(idUsuario)
+			}
+			if destino == "" {
+				continue
+			}
+
+			_, err := db.Exec(ctx,
+				`INSERT INTO NotificacionSalida (id_usuario, canal, destino, tipo, asunto, cuerpo, max_intentos)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				idUsuario, canal, destino, evento.Tipo, asunto, cuerpo, maxIntentosEnvios)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EncolarConsulta notifica al paciente y al médico de la consulta. datos puede agregar o
+// reemplazar valores de la plantilla (por ejemplo el Motivo de una cancelación o la FechaAnterior).
+func EncolarConsulta(ctx context.Context, db Ejecutor, tipo string, idConsulta int, datos map[string]interface{}) error {
+	valores, idPaciente, idMedico, err := datosConsulta(ctx, db, idConsulta, datos)
+	if err != nil {
+		return err
+	}
+
+	return Encolar(ctx, db, Evento{
+		Tipo:          tipo,
+		Destinatarios: []int{idPaciente, idMedico},
+		Datos:         valores,
+	})
+}
+
+// EncolarConsultaPaciente es como EncolarConsulta pero solo notifica al paciente
+func EncolarConsultaPaciente(ctx context.Context, db Ejecutor, tipo string, idConsulta int, datos map[string]interface{}) error {
+	valores, idPaciente, _, err := datosConsulta(ctx, db, idConsulta, datos)
+	if err != nil {
+		return err
+	}
+
+	return Encolar(ctx, db, Evento{
+		Tipo:          tipo,
+		Destinatarios: []int{idPaciente},
+		Datos:         valores,
+	})
+}
+
+// datosConsulta obtiene los valores de plantilla de una consulta y sus participantes
+func datosConsulta(ctx context.Context, db Ejecutor, idConsulta int, datos map[string]interface{}) (map[string]interface{}, int, int, error) {
+	var idPaciente, idMedico int
+	var paciente, medico, consultorio, motivoConsulta string
+	var fecha *time.Time
+	err := db.QueryRow(ctx,
+		`SELECT c.id_paciente, c.id_medico, p.nombre, m.nombre, COALESCE(co.nombre_numero, ''),
+		        COALESCE(c.motivo, ''), COALESCE(h.fecha_hora, c.hora)
+		 FROM Consulta c
+		 JOIN Usuario p ON c.id_paciente = p.id_usuario
+		 JOIN Usuario m ON c.id_medico = m.id_usuario
+		 LEFT JOIN Horario h ON c.id_horario = h.id_horario
+		 LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
+		 WHERE c.id_consulta = $1`, idConsulta).Scan(
+		&idPaciente, &idMedico, &paciente, &medico, &consultorio, &motivoConsulta, &fecha)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("consulta %d: %w", idConsulta, err)
+	}
+
+	valores := map[string]interface{}{
+		"IDConsulta":     idConsulta,
+		"Paciente":       paciente,
+		"Medico":         medico,
+		"Consultorio":    consultorio,
+		"MotivoConsulta": motivoConsulta,
+		"Motivo":         "",
+		"Fecha":          FormatearFecha(fecha),
+	}
+	for clave, valor := range datos {
+		valores[clave] = valor
+	}
+	return valores, idPaciente, idMedico, nil
+}
+
+// EncolarOfertaListaEspera avisa al paciente de la lista de espera que se le retuvo un horario
+// y hasta cuándo puede confirmarlo
+func EncolarOfertaListaEspera(ctx context.Context, db Ejecutor, idListaEspera int) error {
+	var idPaciente int
+	var medico, consultorio string
+	var fecha, expira *time.Time
+	err := db.QueryRow(ctx,
+		`SELECT le.id_paciente, m.nombre, COALESCE(co.nombre_numero, ''), h.fecha_hora, le.retencion_expira_at
+		 FROM ListaEspera le
+		 JOIN Horario h ON le.id_horario = h.id_horario
+		 JOIN Usuario m ON le.id_medico = m.id_usuario
+		 LEFT JOIN Consultorio co ON h.id_consultorio = co.id_consultorio
+		 WHERE le.id_lista_espera = $1`, idListaEspera).Scan(&idPaciente, &medico, &consultorio, &fecha, &expira)
+	if err != nil {
+		return fmt.Errorf("lista de espera %d: %w", idListaEspera, err)
+	}
+
+	return Encolar(ctx, db, Evento{
+		Tipo:          ListaEsperaOferta,
+		Destinatarios: []int{idPaciente},
+		Datos: map[string]interface{}{
+			"IDListaEspera": idListaEspera,
+			"Medico":        medico,
+			"Consultorio":   consultorio,
+			"Fecha":         FormatearFecha(fecha),
+			"Expira":        FormatearFecha(expira),
+		},
+	})
+}
+
+// FormatearFecha da formato a una fecha para los mensajes ("por confirmar" si no tiene)
+func FormatearFecha(fecha *time.Time) string {
+	if fecha == nil || fecha.IsZero() {
+		return "fecha por confirmar"
+	}
+	return fecha.Format(formatoFecha)
+}
+
+// enteroDeEntorno lee un entero positivo de una variable de entorno o devuelve el valor por defecto
+func enteroDeEntorno(nombre string, porDefecto int) int {
+	valor, err := strconv.Atoi(os.Getenv(nombre))
+	if err != nil || valor <= 0 {
+		return porDefecto
+	}
+	return valor
+}
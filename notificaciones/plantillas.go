@@ -0,0 +1,87 @@
+package notificaciones
+
+import (
+	"strings"
+	"text/template"
+)
+
+// plantilla es el asunto y el cuerpo de un tipo de notificación
+type plantilla struct {
+	asunto *template.Template
+	cuerpo *template.Template
+}
+
+// nuevaPlantilla compila una plantilla; los errores de sintaxis se detectan al iniciar
+func nuevaPlantilla(tipo, asunto, cuerpo string) plantilla {
+	return plantilla{
+		asunto: template.Must(template.New(tipo + "_asunto").Parse(asunto)),
+		cuerpo: template.Must(template.New(tipo + "_cuerpo").Parse(cuerpo)),
+	}
+}
+
+// renderizar aplica los datos al asunto y al cuerpo
+func (p plantilla) renderizar(datos map[string]interface{}) (string, string, error) {
+	var asunto, cuerpo strings.Builder
+	if err := p.asunto.Execute(&asunto, datos); err != nil {
+		return "", "", err
+	}
+	if err := p.cuerpo.Execute(&cuerpo, datos); err != nil {
+		return "", "", err
+	}
+	return asunto.String(), cuerpo.String(), nil
+}
+
+// plantillas contiene los mensajes de cada tipo de notificación
+var plantillas = map[string]plantilla{
+	ConsultaCreada: nuevaPlantilla(ConsultaCreada,
+		"Cita programada para el {{.Fecha}}",
+		`Hola {{.Nombre}}:
+
+Se programó la consulta #{{.IDConsulta}} del paciente {{.Paciente}} con {{.Medico}} para el {{.Fecha}}{{if .Consultorio}} en el consultorio {{.Consultorio}}{{end}}.
+{{if .MotivoConsulta}}Motivo de la consulta: {{.MotivoConsulta}}
+{{end}}
+Si no puedes asistir, cancela o reprograma tu cita con anticipación.`),
+
+	ConsultaCancelada: nuevaPlantilla(ConsultaCancelada,
+		"Cita cancelada del {{.Fecha}}",
+		`Hola {{.Nombre}}:
+
+La consulta #{{.IDConsulta}} del paciente {{.Paciente}} con {{.Medico}} programada para el {{.Fecha}} fue cancelada.
+{{if .Motivo}}Motivo de la cancelación: {{.Motivo}}
+{{end}}`),
+
+	ConsultaReprogramada: nuevaPlantilla(ConsultaReprogramada,
+		"Cita reprogramada para el {{.Fecha}}",
+		`Hola {{.Nombre}}:
+
+La consulta #{{.IDConsulta}} del paciente {{.Paciente}} con {{.Medico}} se movió del {{.FechaAnterior}} al {{.Fecha}}{{if .Consultorio}} en el consultorio {{.Consultorio}}{{end}}.
+{{if .Motivo}}Motivo del cambio: {{.Motivo}}
+{{end}}`),
+
+	ConsultaRecordatorio: nuevaPlantilla(ConsultaRecordatorio,
+		"Recordatorio: tu cita es el {{.Fecha}}",
+		`Hola {{.Nombre}}:
+
+Te recordamos tu consulta #{{.IDConsulta}} con {{.Medico}} el {{.Fecha}}{{if .Consultorio}} en el consultorio {{.Consultorio}}{{end}}.
+
+{{if .EnlaceConfirmar}}Confirmar asistencia: {{.EnlaceConfirmar}}
+{{end}}{{if .EnlaceCancelar}}Cancelar la cita: {{.EnlaceCancelar}}
+{{end}}{{if or .EnlaceConfirmar .EnlaceCancelar}}
+Los enlaces solo pueden usarse una vez.{{end}}`),
+
+	ListaEsperaOferta: nuevaPlantilla(ListaEsperaOferta,
+		"Horario disponible con {{.Medico}} el {{.Fecha}}",
+		`Hola {{.Nombre}}:
+
+Se liberó un horario con {{.Medico}} el {{.Fecha}}{{if .Consultorio}} en el consultorio {{.Consultorio}}{{end}} y lo apartamos para ti por estar en la lista de espera.
+
+Confírmalo antes del {{.Expira}} (inscripción #{{.IDListaEspera}}); si no, se ofrecerá al siguiente paciente.`),
+
+	RecetaEmitida: nuevaPlantilla(RecetaEmitida,
+		"Nueva receta médica",
+		`Hola {{.Nombre}}:
+
+{{.Medico}} emitió la receta #{{.IDReceta}} el {{.Fecha}}.
+Medicamento: {{.Medicamento}}
+Dosis: {{.Dosis}}`),
+}
@@ -0,0 +1,165 @@
+// Package pdf genera documentos PDF sencillos (texto, líneas y rectángulos) sin dependencias
+// externas. Usa las fuentes estándar Helvetica y Helvetica-Bold con codificación WinAnsi, que
+// cubre los acentos y la ñ del español, por lo que no es necesario incrustar fuentes.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Tamaño carta en puntos (1/72 de pulgada)
+const (
+	AnchoCarta = 612.0
+	AltoCarta  = 792.0
+)
+
+// Documento es un PDF en construcción. Las coordenadas se miden en puntos desde la esquina
+// superior izquierda de la página; y crece hacia abajo.
+type Documento struct {
+	ancho   float64
+	alto    float64
+	titulo  string
+	autor   string
+	paginas []*bytes.Buffer
+}
+
+// Nuevo crea un documento vacío con páginas del tamaño indicado
+func Nuevo(ancho, alto float64) *Documento {
+	return &Documento{ancho: ancho, alto: alto}
+}
+
+// Metadatos define el título y el autor que muestran los visores de PDF
+func (d *Documento) Metadatos(titulo, autor string) {
+	d.titulo = titulo
+	d.autor = autor
+}
+
+// NuevaPagina agrega una página; los trazos siguientes se dibujan en ella
+func (d *Documento) NuevaPagina() {
+	d.paginas = append(d.paginas, &bytes.Buffer{})
+}
+
+// Paginas devuelve el número de páginas del documento
+func (d *Documento) Paginas() int {
+	return len(d.paginas)
+}
+
+// pagina devuelve el contenido de la página actual, creándola si no existe
+func (d *Documento) pagina() *bytes.Buffer {
+	if len(d.paginas) == 0 {
+		d.NuevaPagina()
+	}
+	return d.paginas[len(d.paginas)-1]
+}
+
+// Texto escribe una línea de texto con su línea base en (x, y)
+func (d *Documento) Texto(x, y, tamano float64, negrita bool, texto string) {
+	fuente := "F1"
+	if negrita {
+		fuente = "F2"
+	}
+	fmt.Fprintf(d.pagina(), "BT /%s %s Tf %s %s Td (%s) Tj ET\n",
+		fuente, numero(tamano), numero(x), numero(d.alto-y), escaparTexto(texto))
+}
+
+// TextoDerecha escribe una línea de texto que termina en x
+func (d *Documento) TextoDerecha(x, y, tamano float64, negrita bool, texto string) {
+	d.Texto(x-AnchoTexto(texto, tamano, negrita), y, tamano, negrita, texto)
+}
+
+// Linea traza una línea recta del grosor indicado
+func (d *Documento) Linea(x1, y1, x2, y2, grosor float64) {
+	fmt.Fprintf(d.pagina(), "%s w %s %s m %s %s l S\n",
+		numero(grosor), numero(x1), numero(d.alto-y1), numero(x2), numero(d.alto-y2))
+}
+
+// Rectangulo dibuja un rectángulo negro relleno con su esquina superior izquierda en (x, y)
+func (d *Documento) Rectangulo(x, y, ancho, alto float64) {
+	fmt.Fprintf(d.pagina(), "%s %s %s %s re f\n",
+		numero(x), numero(d.alto-y-alto), numero(ancho), numero(alto))
+}
+
+// Bytes arma el archivo PDF completo
+func (d *Documento) Bytes() []byte {
+	if len(d.paginas) == 0 {
+		d.NuevaPagina()
+	}
+
+	var salida bytes.Buffer
+	var posiciones []int
+	objeto := func(contenido string) {
+		posiciones = append(posiciones, salida.Len())
+		fmt.Fprintf(&salida, "%d 0 obj\n%s\nendobj\n", len(posiciones), contenido)
+	}
+
+	salida.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objetos fijos: 1 catálogo, 2 árbol de páginas, 3 y 4 fuentes, 5 metadatos; después, por
+	// cada página, la página y su contenido
+	const primeraPagina = 6
+	hijos := make([]string, len(d.paginas))
+	for i := range d.paginas {
+		hijos[i] = fmt.Sprintf("%d 0 R", primeraPagina+2*i)
+	}
+	objeto("<< /Type /Catalog /Pages 2 0 R >>")
+	objeto(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(hijos, " "), len(d.paginas)))
+	objeto("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	objeto("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	objeto(fmt.Sprintf("<< /Title (%s) /Author (%s) /Producer (hospital-backend) /CreationDate (D:%s) >>",
+		escaparTexto(d.titulo), escaparTexto(d.autor), time.Now().UTC().Format("20060102150405Z")))
+
+	for i, contenido := range d.paginas {
+		objeto(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			numero(d.ancho), numero(d.alto), primeraPagina+2*i+1))
+		objeto(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", contenido.Len(), contenido.String()))
+	}
+
+	inicioXref := salida.Len()
+	fmt.Fprintf(&salida, "xref\n0 %d\n0000000000 65535 f \n", len(posiciones)+1)
+	for _, posicion := range posiciones {
+		fmt.Fprintf(&salida, "%010d 00000 n \n", posicion)
+	}
+	fmt.Fprintf(&salida, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(posiciones)+1, inicioXref)
+	return salida.Bytes()
+}
+
+// numero da formato a una coordenada sin ceros de más
+func numero(valor float64) string {
+	texto := strings.TrimRight(fmt.Sprintf("%.2f", valor), "0")
+	return strings.TrimSuffix(texto, ".")
+}
+
+// winAnsi son los caracteres fuera de Latin-1 que existen en la codificación WinAnsi
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97,
+}
+
+// escaparTexto convierte el texto a WinAnsi y escapa los caracteres especiales de las cadenas
+// PDF. Los caracteres que no existen en WinAnsi se reemplazan por "?".
+func escaparTexto(texto string) string {
+	var b strings.Builder
+	for _, r := range texto {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			if c, ok := winAnsi[r]; ok {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
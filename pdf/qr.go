@@ -0,0 +1,48 @@
+package pdf
+
+import (
+	"image/color"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+// margenQR es la zona en blanco alrededor del código, en módulos, que piden los lectores
+const margenQR = 4
+
+// CodigoQR dibuja un código QR cuadrado de lado puntos con su esquina superior izquierda en
+// (x, y), incluyendo el margen en blanco. Los módulos se trazan como rectángulos, sin imágenes.
+func (d *Documento) CodigoQR(x, y, lado float64, contenido string) error {
+	codigo, err := qr.Encode(contenido, qr.M, qr.Auto)
+	if err != nil {
+		return err
+	}
+
+	limites := codigo.Bounds()
+	n := limites.Dx()
+	modulo := lado / float64(n+2*margenQR)
+	origenX := x + margenQR*modulo
+	origenY := y + margenQR*modulo
+
+	// Los módulos oscuros contiguos de cada fila se dibujan como un solo rectángulo
+	for fila := 0; fila < n; fila++ {
+		for col := 0; col < n; {
+			if !moduloOscuro(codigo.At(limites.Min.X+col, limites.Min.Y+fila)) {
+				col++
+				continue
+			}
+			inicio := col
+			for col < n && moduloOscuro(codigo.At(limites.Min.X+col, limites.Min.Y+fila)) {
+				col++
+			}
+			d.Rectangulo(origenX+float64(inicio)*modulo, origenY+float64(fila)*modulo,
+				float64(col-inicio)*modulo, modulo)
+		}
+	}
+	return nil
+}
+
+// moduloOscuro indica si el color del módulo es negro
+func moduloOscuro(c color.Color) bool {
+	r, _, _, _ := c.RGBA()
+	return r < 0x8000
+}
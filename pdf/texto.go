@@ -0,0 +1,78 @@
+package pdf
+
+import (
+	"strings"
+	"unicode"
+)
+
+// anchoCaracter aproxima el ancho de un carácter de Helvetica en milésimas del tamaño de la
+// fuente. Basta para acomodar párrafos; no pretende reproducir las métricas exactas.
+func anchoCaracter(r rune) float64 {
+	switch {
+	case r == ' ':
+		return 278
+	case strings.ContainsRune("iljfrt.,;:'!|()[]", r):
+		return 300
+	case r == 'm' || r == 'w' || r == 'M' || r == 'W':
+		return 850
+	case unicode.IsDigit(r):
+		return 556
+	case unicode.IsUpper(r):
+		return 680
+	default:
+		return 530
+	}
+}
+
+// AnchoTexto aproxima el ancho en puntos del texto escrito con el tamaño indicado
+func AnchoTexto(texto string, tamano float64, negrita bool) float64 {
+	total := 0.0
+	for _, r := range texto {
+		total += anchoCaracter(r)
+	}
+	if negrita {
+		total *= 1.06
+	}
+	return total * tamano / 1000
+}
+
+// Dividir parte el texto en líneas que caben en el ancho indicado, respetando los saltos de
+// línea. Las palabras más largas que el ancho se cortan.
+func Dividir(texto string, ancho, tamano float64, negrita bool) []string {
+	var lineas []string
+	for _, parrafo := range strings.Split(texto, "\n") {
+		actual := ""
+		for _, palabra := range strings.Fields(parrafo) {
+			for AnchoTexto(palabra, tamano, negrita) > ancho {
+				if actual != "" {
+					lineas = append(lineas, actual)
+					actual = ""
+				}
+				corte := cabenEn(palabra, ancho, tamano, negrita)
+				lineas = append(lineas, string([]rune(palabra)[:corte]))
+				palabra = string([]rune(palabra)[corte:])
+			}
+			candidata := palabra
+			if actual != "" {
+				candidata = actual + " " + palabra
+			}
+			if AnchoTexto(candidata, tamano, negrita) > ancho {
+				lineas = append(lineas, actual)
+				candidata = palabra
+			}
+			actual = candidata
+		}
+		lineas = append(lineas, actual)
+	}
+	return lineas
+}
+
+// cabenEn devuelve cuántos caracteres de la palabra caben en el ancho (al menos uno)
+func cabenEn(palabra string, ancho, tamano float64, negrita bool) int {
+	runas := []rune(palabra)
+	n := 1
+	for n < len(runas) && AnchoTexto(string(runas[:n+1]), tamano, negrita) <= ancho {
+		n++
+	}
+	return n
+}
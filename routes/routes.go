@@ -29,6 +29,9 @@ func SetupRoutes(app *fiber.App) {
 		})
 	})
 
+	// Claves públicas para verificar los access tokens (JWKS)
+	app.Get("/.well-known/jwks.json", handlers.ObtenerJWKS)
+
 	// Grupo de API
 	api := app.Group("/api/v1")
 
@@ -38,6 +41,15 @@ func SetupRoutes(app *fiber.App) {
 	auth.Post("/login", handlers.Login) // ← Cambiar de LoginWithMFA a Login
 	auth.Post("/refresh", handlers.RefreshToken)
 	auth.Post("/logout", middleware.JWTMiddleware(), handlers.Logout)
+	auth.Get("/jwks", handlers.ObtenerJWKS)
+
+	// Enlaces firmados de los recordatorios de citas (de un solo uso)
+	recordatorios := api.Group("/recordatorios")
+	recordatorios.Get("/:token", handlers.VerRecordatorio)
+	recordatorios.Post("/:token", handlers.UsarRecordatorio)
+
+	// Verificación pública de recetas impresas (código QR)
+	api.Get("/recetas/verificar/:codigo", handlers.VerificarReceta)
 
 	// === RUTAS PROTEGIDAS (Requieren autenticación) ===
 	protected := api.Group("/", middleware.JWTMiddleware())
@@ -50,15 +62,42 @@ func SetupRoutes(app *fiber.App) {
 	usuarios.Get("/:id", middleware.RequirePermission("usuarios_read"), handlers.ObtenerUsuarioPorID)
 	usuarios.Put("/:id", middleware.RequirePermission("usuarios_update"), handlers.ActualizarUsuario)
 	usuarios.Delete("/:id", middleware.RequirePermission("usuarios_delete"), handlers.EliminarUsuario)
+	usuarios.Put("/:id/rol", middleware.RequirePermission("roles_update"), handlers.AsignarRolUsuario)
 	usuarios.Get("/role/:id", middleware.RequirePermission("usuarios_read"), handlers.ObtenerUsuariosPorRol)
 
 	// --- RUTAS DE PACIENTES ---
 	pacientes := protected.Group("/pacientes")
 	pacientes.Get("/", middleware.RequirePermission("usuarios_read"), handlers.ObtenerPacientes)
+	pacientes.Get("/:id/timeline", handlers.ObtenerTimelinePaciente)
+	pacientes.Get("/:id/signos-vitales", middleware.RequirePermission("signos_vitales_read"), handlers.ObtenerSignosVitalesPaciente)
+	pacientes.Get("/:id/signos-vitales/serie", middleware.RequirePermission("signos_vitales_read"), handlers.ObtenerSerieSignosVitales)
+	pacientes.Get("/:id/alergias", handlers.ObtenerAlergiasPaciente)
+	pacientes.Post("/:id/alergias", handlers.RegistrarAlergia)
+
+	// --- RUTAS DE ALERGIAS ---
+	// Los permisos alergias_* tienen alcance propio o total; los handlers los verifican por paciente
+	alergias := protected.Group("/alergias")
+	alergias.Put("/:id", handlers.ActualizarAlergia)
+	alergias.Put("/:id/verificar", handlers.VerificarAlergia)
+
+	// --- RUTAS DE SIGNOS VITALES ---
+	signosVitales := protected.Group("/signos-vitales")
+	signosVitales.Post("/", middleware.RequirePermission("signos_vitales_create"), handlers.RegistrarSignosVitales)
 
 	// --- RUTAS DE ROLES Y PERMISOS ---
 	roles := protected.Group("/roles")
+	roles.Get("/", middleware.RequirePermission("roles_read"), handlers.ObtenerRoles)
+	roles.Post("/", middleware.RequirePermission("roles_update"), handlers.CrearRol)
+	roles.Get("/auditoria", middleware.RequirePermission("roles_read"), handlers.ObtenerAuditoriaRoles)
 	roles.Get("/:id/permisos", handlers.ObtenerPermisosPorRol)
+	roles.Post("/:id/permisos", middleware.RequirePermission("roles_update"), handlers.OtorgarPermisoRol)
+	roles.Delete("/:id/permisos/:id_permiso", middleware.RequirePermission("roles_update"), handlers.RevocarPermisoRol)
+	roles.Put("/:id/desactivar", middleware.RequirePermission("roles_update"), handlers.DesactivarRol)
+	roles.Put("/:id/activar", middleware.RequirePermission("roles_update"), handlers.ActivarRol)
+
+	permisos := protected.Group("/permisos")
+	permisos.Get("/", middleware.RequirePermission("roles_read"), handlers.ObtenerPermisos)
+	permisos.Post("/", middleware.RequirePermission("roles_update"), handlers.CrearPermiso)
 
 	// --- RUTAS MFA ---
 	mfa := protected.Group("/mfa")
@@ -66,6 +105,11 @@ func SetupRoutes(app *fiber.App) {
 	mfa.Post("/verify", handlers.VerifyMFA)
 	mfa.Post("/disable", handlers.DisableMFA)
 
+	// --- RUTAS DE SESIONES (del usuario autenticado) ---
+	sesiones := protected.Group("/sesiones")
+	sesiones.Get("/", handlers.ObtenerMisSesiones)
+	sesiones.Delete("/:id", handlers.RevocarMiSesion)
+
 	// --- RUTAS DE EXPEDIENTES ---
 	expedientes := protected.Group("/expedientes")
 	expedientes.Post("/", middleware.RequirePermission("expedientes_create"), handlers.CrearExpediente)
@@ -73,6 +117,9 @@ func SetupRoutes(app *fiber.App) {
 	expedientes.Get("/:id", middleware.RequirePermission("expedientes_read"), handlers.ObtenerExpedientePorID)
 	expedientes.Put("/:id", middleware.RequirePermission("expedientes_update"), handlers.ActualizarExpediente)
 	expedientes.Delete("/:id", middleware.RequirePermission("expedientes_delete"), handlers.EliminarExpediente)
+	expedientes.Get("/:id/versiones", middleware.RequirePermission("expedientes_read"), handlers.ObtenerVersionesExpediente)
+	expedientes.Get("/:id/versiones/comparar", middleware.RequirePermission("expedientes_read"), handlers.CompararVersionesExpediente)
+	expedientes.Get("/:id/versiones/:numero", middleware.RequirePermission("expedientes_read"), handlers.ObtenerVersionExpediente)
 	expedientes.Get("/paciente/:paciente_id", middleware.RequirePermission("expedientes_read"), handlers.ObtenerExpedientePorPaciente)
 
 	// --- RUTAS DE CONSULTAS ---
@@ -82,19 +129,65 @@ func SetupRoutes(app *fiber.App) {
 	consultas.Get("/:id", middleware.RequirePermission("consultas_read"), handlers.ObtenerConsultaPorID)
 	consultas.Put("/:id", middleware.RequirePermission("consultas_update"), handlers.ActualizarConsulta)
 	consultas.Delete("/:id", middleware.RequirePermission("consultas_delete"), handlers.CancelarConsulta)
+	consultas.Delete("/:id/registro", middleware.RequirePermission("consultas_delete"), handlers.EliminarConsulta)
 	consultas.Get("/paciente/:paciente_id", middleware.RequirePermission("consultas_read"), handlers.ObtenerConsultasPorPaciente)
 	consultas.Get("/medico/:medico_id", middleware.RequirePermission("consultas_read"), handlers.ObtenerConsultasPorMedico)
 	consultas.Put("/:id/completar", middleware.RequirePermission("consultas_update"), handlers.CompletarConsulta)
+	consultas.Put("/:id/estado", middleware.RequirePermission("consultas_update"), handlers.CambiarEstadoConsulta)
+	consultas.Get("/:id/historial", middleware.RequirePermission("consultas_read"), handlers.ObtenerHistorialEstadosConsulta)
+	consultas.Put("/:id/reprogramar", middleware.RequirePermission("consultas_update"), handlers.ReprogramarConsulta)
+	consultas.Get("/:id/reprogramaciones", middleware.RequirePermission("consultas_read"), handlers.ObtenerReprogramacionesConsulta)
+	consultas.Post("/:id/nota", middleware.RequirePermission("consultas_update"), handlers.CrearNotaClinica)
+	consultas.Post("/:id/nota/enmiendas", middleware.RequirePermission("consultas_update"), handlers.EnmendarNotaClinica)
+	consultas.Get("/:id/nota", middleware.RequirePermission("consultas_read"), handlers.ObtenerNotaClinica)
+	consultas.Get("/:id/diagnosticos", middleware.RequirePermission("consultas_read"), handlers.ObtenerDiagnosticosConsulta)
+	consultas.Put("/:id/diagnosticos", middleware.RequirePermission("consultas_update"), handlers.ActualizarDiagnosticosConsulta)
+
+	// --- RUTAS DEL CATÁLOGO CIE-10 ---
+	cie10 := protected.Group("/cie10")
+	cie10.Get("/", middleware.RequirePermission("consultas_read"), handlers.BuscarCIE10)
+	cie10.Get("/:codigo", middleware.RequirePermission("consultas_read"), handlers.ObtenerCIE10)
+
+	// --- RUTAS DE CITAS (reserva en línea del paciente) ---
+	citas := protected.Group("/citas")
+	citas.Post("/", middleware.RequirePermission("citas_create"), handlers.ReservarCitaPaciente)
+	citas.Get("/", middleware.RequirePermission("citas_read"), handlers.ObtenerMisCitas)
+	citas.Delete("/:id", middleware.RequirePermission("citas_delete"), handlers.CancelarCitaPaciente)
+	citas.Put("/:id/reprogramar", middleware.RequirePermission("citas_update"), handlers.ReprogramarCitaPaciente)
+
+	// --- RUTAS DE LISTA DE ESPERA ---
+	listaEspera := protected.Group("/lista-espera")
+	listaEspera.Post("/", handlers.InscribirListaEspera)
+	listaEspera.Get("/", handlers.ObtenerListaEspera)
+	listaEspera.Delete("/:id", handlers.CancelarListaEspera)
+	listaEspera.Post("/:id/confirmar", handlers.ConfirmarListaEspera)
+
+	// --- RUTAS DE NOTIFICACIONES ---
+	notificaciones := protected.Group("/notificaciones")
+	notificaciones.Get("/", handlers.ObtenerMisNotificaciones)
+	notificaciones.Get("/salida", handlers.ObtenerNotificacionesSalida)
+	notificaciones.Put("/:id/leida", handlers.MarcarNotificacionLeida)
 
 	// --- RUTAS DE RECETAS ---
 	recetas := protected.Group("/recetas")
 	recetas.Post("/", middleware.RequirePermission("recetas_create"), handlers.CrearReceta)
 	recetas.Get("/", middleware.RequirePermission("recetas_read"), handlers.ObtenerRecetas)
 	recetas.Get("/:id", middleware.RequirePermission("recetas_read"), handlers.ObtenerRecetaPorID)
+	recetas.Get("/:id/pdf", middleware.RequirePermission("recetas_read"), handlers.ObtenerRecetaPDF)
 	recetas.Put("/:id", middleware.RequirePermission("recetas_update"), handlers.ActualizarReceta)
 	recetas.Delete("/:id", middleware.RequirePermission("recetas_delete"), handlers.EliminarReceta)
 	recetas.Get("/paciente/:paciente_id", middleware.RequirePermission("recetas_read"), handlers.ObtenerRecetasPorPaciente)
 
+	// --- RUTAS DE FARMACIA (surtido de recetas por código de verificación) ---
+	farmacia := protected.Group("/farmacia")
+	farmacia.Get("/recetas/:codigo", middleware.RequirePermission("dispensaciones_read"), handlers.BuscarRecetaFarmacia)
+	farmacia.Post("/recetas/:codigo/dispensar", middleware.RequirePermission("dispensaciones_create"), handlers.DispensarReceta)
+
+	// --- RUTAS DEL CATÁLOGO DE MEDICAMENTOS ---
+	medicamentos := protected.Group("/medicamentos")
+	medicamentos.Get("/", middleware.RequirePermission("recetas_read"), handlers.BuscarMedicamentos)
+	medicamentos.Get("/:id", middleware.RequirePermission("recetas_read"), handlers.ObtenerMedicamento)
+
 	// --- RUTAS DE CONSULTORIOS ---
 	consultorios := protected.Group("/consultorios")
 	consultorios.Post("/", middleware.RequirePermission("consultorios_create"), handlers.CrearConsultorio)
@@ -108,15 +201,26 @@ func SetupRoutes(app *fiber.App) {
 	reportes.Get("/consultas", middleware.RequirePermission("reportes_read"), handlers.GenerarReporteConsultas)
 	reportes.Get("/usuarios", middleware.RequirePermission("reportes_read"), handlers.GenerarReporteUsuarios)
 	reportes.Get("/expedientes", middleware.RequirePermission("reportes_read"), handlers.GenerarReporteExpedientes)
+	reportes.Get("/diagnosticos", middleware.RequirePermission("reportes_read"), handlers.GenerarReporteDiagnosticos)
+
+	// --- RUTAS DE REGISTROS ELIMINADOS (solo admin) ---
+	eliminados := protected.Group("/admin/eliminados")
+	eliminados.Get("/", middleware.RequirePermission("eliminados_read"), handlers.ObtenerEliminados)
+	eliminados.Post("/:tipo/:id/restaurar", middleware.RequirePermission("eliminados_update"), handlers.RestaurarEliminado)
 
 	// --- RUTAS DE HORARIOS ---
 	horarios := protected.Group("/horarios")
 	horarios.Post("/", middleware.RequirePermission("horarios_create"), handlers.CrearHorario)
 	horarios.Get("/", middleware.RequirePermission("horarios_read"), handlers.ObtenerHorarios)
+	horarios.Post("/plantillas", middleware.RequirePermission("horarios_create"), handlers.CrearPlantillaHorario)
+	horarios.Get("/plantillas", middleware.RequirePermission("horarios_read"), handlers.ObtenerPlantillasHorario)
+	horarios.Get("/plantillas/:id", middleware.RequirePermission("horarios_read"), handlers.ObtenerPlantillaHorarioPorID)
+	horarios.Delete("/plantillas/:id", middleware.RequirePermission("horarios_delete"), handlers.DesactivarPlantillaHorario)
+	horarios.Post("/plantillas/:id/generar", middleware.RequirePermission("horarios_create"), handlers.GenerarHorariosDesdePlantilla)
+	horarios.Get("/disponibles", middleware.RequirePermission("horarios_read"), handlers.ObtenerHorariosDisponibles)
 	horarios.Get("/:id", middleware.RequirePermission("horarios_read"), handlers.ObtenerHorarioPorID)
 	horarios.Put("/:id", middleware.RequirePermission("horarios_update"), handlers.ActualizarHorario)
 	horarios.Delete("/:id", middleware.RequirePermission("horarios_delete"), handlers.EliminarHorario)
 	horarios.Get("/medico/:medico_id", middleware.RequirePermission("horarios_read"), handlers.ObtenerHorariosPorMedico)
 	horarios.Put("/:id/disponibilidad", middleware.RequirePermission("horarios_update"), handlers.CambiarDisponibilidadHorario)
-	horarios.Get("/disponibles", middleware.RequirePermission("horarios_read"), handlers.ObtenerHorariosDisponibles)
 }